@@ -0,0 +1,195 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package mogusocket
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors used by TokenAuthenticator.
+var (
+	ErrHandshakeUnauthorized = RejectConnectionError(
+		RejectionStatus(http.StatusUnauthorized),
+		RejectionReason("handshake error: unauthorized"),
+		RejectionHeader(HandshakeHeaderString("WWW-Authenticate: Bearer\r\n")),
+	)
+	ErrHandshakeForbidden = RejectConnectionError(
+		RejectionStatus(http.StatusForbidden),
+		RejectionReason("handshake error: forbidden"),
+	)
+)
+
+// Principal is the identity of the authenticated client.
+type Principal struct {
+	// Subject identifies the client, e.g. the user id.
+	Subject string
+
+	// Expires is the time the credentials expire. The session is closed with
+	// StatusPolicyViolation when it comes. Zero means no expiry.
+	Expires time.Time
+
+	// Claims holds application data, such as the tenant or the scopes.
+	Claims interface{}
+}
+
+// Authenticator authenticates the handshake requests. It is called by
+// Upgrader and HTTPUpgrader after all request headers are read and the
+// origin is checked.
+//
+// Returned error rejects the handshake. Use RejectConnectionError to control
+// the response, e.g. ErrHandshakeUnauthorized or ErrHandshakeForbidden.
+// Other errors are answered with 401 status.
+type Authenticator interface {
+	Authenticate(r *HandshakeRequest) (*Principal, error)
+}
+
+// AuthenticatorFunc is an adapter to use ordinary functions as
+// Authenticator.
+type AuthenticatorFunc func(r *HandshakeRequest) (*Principal, error)
+
+// Authenticate calls f(r).
+func (f AuthenticatorFunc) Authenticate(r *HandshakeRequest) (*Principal, error) {
+	return f(r)
+}
+
+// TokenSource extracts the token from the request. It returns an empty string
+// if there is no token.
+type TokenSource func(r *HandshakeRequest) string
+
+// BearerToken takes the token from the "Authorization: Bearer" header.
+func BearerToken(r *HandshakeRequest) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// QueryToken returns TokenSource which takes the token from the query
+// parameter.
+func QueryToken(name string) TokenSource {
+	return func(r *HandshakeRequest) string {
+		return r.Query().Get(name)
+	}
+}
+
+// CookieToken returns TokenSource which takes the token from the cookie.
+func CookieToken(name string) TokenSource {
+	return func(r *HandshakeRequest) string {
+		req := http.Request{Header: r.Header}
+		c, err := req.Cookie(name)
+		if err != nil {
+			return ""
+		}
+		return c.Value
+	}
+}
+
+// ProtocolToken returns TokenSource which takes the token from the
+// subprotocol with given prefix, e.g. "access_token." for the offered
+// "access_token.abc" subprotocol. Browsers can not set headers of the
+// handshake request, so the token is smuggled this way.
+//
+// Such subprotocols must never be selected, so the client must also offer
+// the subprotocol the server selects.
+func ProtocolToken(prefix string) TokenSource {
+	return func(r *HandshakeRequest) string {
+		for _, v := range r.Header.Values(headerSecProtocolCanonical) {
+			for _, p := range strings.Split(v, ",") {
+				if token, ok := strings.CutPrefix(strings.TrimSpace(p), prefix); ok {
+					return token
+				}
+			}
+		}
+		return ""
+	}
+}
+
+// TokenAuthenticator authenticates requests by the token taken from the first
+// source which has it.
+type TokenAuthenticator struct {
+	// Sources are tried in order. If it is empty, BearerToken is used.
+	Sources []TokenSource
+
+	// Verify returns the principal of the token.
+	Verify func(token string) (*Principal, error)
+
+	// Realm is sent in the WWW-Authenticate header of 401 responses.
+	Realm string
+}
+
+// Authenticate implements Authenticator.
+func (a *TokenAuthenticator) Authenticate(r *HandshakeRequest) (*Principal, error) {
+	sources := a.Sources
+	if len(sources) == 0 {
+		sources = []TokenSource{BearerToken}
+	}
+	var token string
+	for _, src := range sources {
+		if token = src(r); token != "" {
+			break
+		}
+	}
+	if token == "" {
+		return nil, a.unauthorized("")
+	}
+	p, err := a.Verify(token)
+	if err != nil {
+		var rej *ConnectionRejectedError
+		if errors.As(err, &rej) {
+			return nil, err
+		}
+		return nil, a.unauthorized("invalid_token")
+	}
+	if p == nil {
+		return nil, a.unauthorized("invalid_token")
+	}
+	if !p.Expires.IsZero() && !p.Expires.After(time.Now()) {
+		return nil, a.unauthorized("invalid_token")
+	}
+	return p, nil
+}
+
+// unauthorized returns 401 rejection with the bearer challenge.
+func (a *TokenAuthenticator) unauthorized(code string) error {
+	challenge := "Bearer"
+	sep := " "
+	if a.Realm != "" {
+		challenge += sep + "realm=" + strconv.Quote(a.Realm)
+		sep = ", "
+	}
+	if code != "" {
+		challenge += sep + "error=" + strconv.Quote(code)
+	}
+	return RejectConnectionError(
+		RejectionStatus(http.StatusUnauthorized),
+		RejectionReason("handshake error: unauthorized"),
+		RejectionHeader(HandshakeHeaderString("WWW-Authenticate: "+challenge+"\r\n")),
+	)
+}
+
+// authenticate calls a with r and converts its error to the rejection.
+func authenticate(a Authenticator, r *HandshakeRequest) (*Principal, error) {
+	p, err := a.Authenticate(r)
+	if err != nil {
+		var rej *ConnectionRejectedError
+		if errors.As(err, &rej) {
+			return nil, rej
+		}
+		return nil, RejectConnectionError(
+			RejectionStatus(http.StatusUnauthorized),
+			RejectionReason("handshake error: "+err.Error()),
+			RejectionHeader(HandshakeHeaderString("WWW-Authenticate: Bearer\r\n")),
+		)
+	}
+	if p == nil {
+		return nil, ErrHandshakeUnauthorized
+	}
+	return p, nil
+}
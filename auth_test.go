@@ -0,0 +1,159 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package mogusocket
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func testAuthenticator() *TokenAuthenticator {
+	return &TokenAuthenticator{
+		Sources: []TokenSource{
+			BearerToken,
+			QueryToken("access_token"),
+			CookieToken("session"),
+			ProtocolToken("access_token."),
+		},
+		Verify: func(token string) (*Principal, error) {
+			switch token {
+			case "valid":
+				return &Principal{Subject: "alice"}, nil
+			case "expired":
+				return &Principal{Subject: "bob", Expires: time.Now().Add(-time.Second)}, nil
+			case "banned":
+				return nil, ErrHandshakeForbidden
+			case "unknown":
+				return nil, nil
+			}
+			return nil, errors.New("unknown token")
+		},
+		Realm: "chat",
+	}
+}
+
+func TestUpgraderAuthenticator(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		url       string
+		header    http.Header
+		status    int
+		challenge string
+	}{
+		{
+			name:   "bearer",
+			header: http.Header{"Authorization": {"Bearer valid"}},
+			status: http.StatusSwitchingProtocols,
+		},
+		{
+			name:   "query",
+			url:    "?access_token=valid",
+			status: http.StatusSwitchingProtocols,
+		},
+		{
+			name:   "cookie",
+			header: http.Header{"Cookie": {"theme=dark; session=valid"}},
+			status: http.StatusSwitchingProtocols,
+		},
+		{
+			name:   "protocol",
+			header: http.Header{"Sec-Websocket-Protocol": {"chat, access_token.valid"}},
+			status: http.StatusSwitchingProtocols,
+		},
+		{
+			name:      "missing",
+			status:    http.StatusUnauthorized,
+			challenge: `Bearer realm="chat"`,
+		},
+		{
+			name:      "invalid",
+			header:    http.Header{"Authorization": {"Bearer forged"}},
+			status:    http.StatusUnauthorized,
+			challenge: `Bearer realm="chat", error="invalid_token"`,
+		},
+		{
+			name:      "no principal",
+			header:    http.Header{"Authorization": {"Bearer unknown"}},
+			status:    http.StatusUnauthorized,
+			challenge: `Bearer realm="chat", error="invalid_token"`,
+		},
+		{
+			name:      "expired",
+			header:    http.Header{"Authorization": {"Bearer expired"}},
+			status:    http.StatusUnauthorized,
+			challenge: `Bearer realm="chat", error="invalid_token"`,
+		},
+		{
+			name:   "forbidden",
+			header: http.Header{"Authorization": {"Bearer banned"}},
+			status: http.StatusForbidden,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			h := make(http.Header)
+			h.Set(headerUpgrade, "websocket")
+			h.Set(headerConnection, "Upgrade")
+			h.Set(headerSecVersion, "13")
+			h.Set(headerSecKey, string(mustMakeNonce()))
+			for k, vs := range test.header {
+				h[k] = vs
+			}
+			req := mustMakeRequest("GET", "ws://example.org/"+test.url, h)
+
+			check := func(t *testing.T, hs Handshake, p []byte) {
+				resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(p)), nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if resp.StatusCode != test.status {
+					t.Fatalf("unexpected status: %s", resp.Status)
+				}
+				if act := resp.Header.Get("WWW-Authenticate"); act != test.challenge {
+					t.Errorf("unexpected challenge: %q; want %q", act, test.challenge)
+				}
+				if test.status != http.StatusSwitchingProtocols {
+					return
+				}
+				if hs.Principal == nil || hs.Principal.Subject != "alice" {
+					t.Errorf("unexpected principal: %+v", hs.Principal)
+				}
+				if hs.Protocol == "access_token.valid" {
+					t.Errorf("token protocol is selected")
+				}
+			}
+
+			t.Run("Upgrader", func(t *testing.T) {
+				var out bytes.Buffer
+				conn := struct {
+					io.Reader
+					io.Writer
+				}{bytes.NewReader(dumpRequest(req)), &out}
+				u := Upgrader{
+					Authenticator: testAuthenticator(),
+					Protocol:      func(p []byte) bool { return string(p) == "chat" },
+				}
+				hs, _ := u.Upgrade(conn)
+				check(t, hs, out.Bytes())
+				if hs.Request != nil {
+					t.Errorf("request is kept without RecordRequest")
+				}
+			})
+			t.Run("HTTPUpgrader", func(t *testing.T) {
+				res := newRecorder()
+				u := HTTPUpgrader{
+					Authenticator: testAuthenticator(),
+					Protocol:      func(p string) bool { return p == "chat" },
+				}
+				_, _, hs, _ := u.Upgrade(req, res)
+				check(t, hs, res.Bytes())
+			})
+		})
+	}
+}
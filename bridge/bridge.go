@@ -0,0 +1,168 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+// Package bridge tunnels TCP and unix socket byte streams over WebSocket
+// connections. Bridge is the server side which dials a backend for every
+// session and Tunnel is the client side which accepts local connections and
+// tunnels each of them through its own WebSocket connection.
+//
+// Bytes are carried by binary messages in both directions. Text messages are
+// rejected with StatusUnsupportedData.
+package bridge
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	ms "github.com/cmacro/mogusocket"
+	"github.com/cmacro/mogusocket/msutil"
+)
+
+var (
+	ErrNoBackend = errors.New("bridge: no backend for the session")
+)
+
+// DefaultDialTimeout is used when Bridge.DialTimeout is zero.
+const DefaultDialTimeout = 10 * time.Second
+
+// DialFunc dials the backend at address addr of given network.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// Bridge is the SessionsHandler which connects every session with its own
+// backend connection. Backend addresses have the same form as server
+// addresses, e.g. "tcp://127.0.0.1:5900" or "unix:///run/admin.sock".
+//
+// The backend is selected by the negotiated subprotocol first, then by the
+// path of the handshake request and Default is used at last. Sessions served
+// without the handshake always use Default.
+//
+// Sessions which have no backend are closed with StatusPolicyViolation and
+// sessions which backend could not be dialed or failed are closed with
+// StatusInternalServerError. When the backend closes its side of the stream
+// the session is closed with StatusNormalClosure. When the session is closed,
+// the backend connection is closed too.
+type Bridge struct {
+	log    ms.Logger
+	nextID int64
+
+	// Protocols maps subprotocols to backend addresses. Bridge.AcceptProtocol
+	// could be used as Upgrader.Protocol to negotiate one of them.
+	Protocols map[string]string
+
+	// Paths maps paths of handshake requests to backend addresses.
+	Paths map[string]string
+
+	// Default is the address of the backend used when no other matches. If
+	// it is empty, such sessions are refused.
+	Default string
+
+	// DialTimeout limits the time of dialing the backend. If it is zero,
+	// DefaultDialTimeout is used.
+	DialTimeout time.Duration
+
+	// Dial, if set, is used to dial backends instead of net.Dialer.
+	Dial DialFunc
+}
+
+// NewBridge returns Bridge which uses backend at addr for all sessions. The
+// addr could be empty if backends are selected with Protocols or Paths.
+func NewBridge(addr string, log ms.Logger) *Bridge {
+	return &Bridge{
+		log:     log,
+		Default: addr,
+	}
+}
+
+// NewConnecter returns Connecter serving sessions of b, which makes the
+// handshake negotiating one of the Protocols.
+func (b *Bridge) NewConnecter() *msutil.Connecter {
+	c := msutil.NewConnecter(b, b.log)
+	c.Upgrader = &ms.Upgrader{Protocol: b.AcceptProtocol}
+	return c
+}
+
+// AcceptProtocol reports whether p is one of the Protocols. It could be used
+// as Upgrader.Protocol.
+func (b *Bridge) AcceptProtocol(p []byte) bool {
+	_, ok := b.Protocols[string(p)]
+	return ok
+}
+
+// Backend returns the backend address for the session with context ctx.
+func (b *Bridge) Backend(ctx context.Context) (string, bool) {
+	if hs, ok := msutil.HandshakeFromContext(ctx); ok && hs.Protocol != "" {
+		if addr, ok := b.Protocols[hs.Protocol]; ok {
+			return addr, true
+		}
+	}
+	if uri, ok := msutil.RequestURIFromContext(ctx); ok {
+		path, _, _ := strings.Cut(uri, "?")
+		if addr, ok := b.Paths[path]; ok {
+			return addr, true
+		}
+	}
+	return b.Default, b.Default != ""
+}
+
+func (b *Bridge) Connect(ctx context.Context, w ms.SendFunc, c func()) (ms.SessionHandler, error) {
+	s := &session{
+		id:   atomic.AddInt64(&b.nextID, 1),
+		pipe: newPipe(ctx, w, c),
+	}
+
+	addr, ok := b.Backend(ctx)
+	if !ok {
+		b.log.Info("bridge", s.id, ErrNoBackend)
+		go s.fail(ms.StatusPolicyViolation, "no backend")
+		return s, nil
+	}
+	conn, err := b.dial(ctx, addr)
+	if err != nil {
+		b.log.Error("bridge dial", s.id, addr, err)
+		go s.fail(ms.StatusInternalServerError, "backend unavailable")
+		return s, nil
+	}
+	b.log.Debug("bridge", s.id, addr)
+	s.conn = conn
+	go s.writePump("backend closed", b.log)
+	return s, nil
+}
+
+func (b *Bridge) Close(s ms.SessionHandler) error {
+	s.Close()
+	return nil
+}
+
+func (b *Bridge) dial(ctx context.Context, addr string) (net.Conn, error) {
+	u, err := ms.ParserAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	timeout := b.DialTimeout
+	if timeout == 0 {
+		timeout = DefaultDialTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	network, address := u.Data()
+	if b.Dial != nil {
+		return b.Dial(ctx, network, address)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, network, address)
+}
+
+// session is the bridged session. Its conn is nil if the backend was not
+// connected.
+type session struct {
+	id int64
+	*pipe
+}
+
+func (s *session) GetId() int64 { return s.id }
@@ -0,0 +1,193 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package bridge
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	ms "github.com/cmacro/mogusocket"
+	"github.com/cmacro/mogusocket/internal/mstest"
+	"github.com/cmacro/mogusocket/msutil"
+)
+
+// newBackend starts TCP server which serves connections with fn and returns
+// its address.
+func newBackend(t *testing.T, fn func(conn net.Conn)) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				fn(conn)
+			}()
+		}
+	}()
+	return "tcp://" + ln.Addr().String()
+}
+
+// echoBackend copies received bytes back and reports when the connection is
+// closed by the peer.
+func echoBackend(closed chan<- struct{}) func(conn net.Conn) {
+	return func(conn net.Conn) {
+		io.Copy(conn, conn)
+		if closed != nil {
+			closed <- struct{}{}
+		}
+	}
+}
+
+// nameBackend writes name and waits for the peer to close the connection.
+func nameBackend(name string) func(conn net.Conn) {
+	return func(conn net.Conn) {
+		conn.Write([]byte(name))
+		io.Copy(io.Discard, conn)
+	}
+}
+
+func serve(t *testing.T, c *msutil.Connecter) net.Conn {
+	server, client := net.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		client.Close()
+		server.Close()
+	})
+	go c.Run(ctx, server)
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	return client
+}
+
+func TestBridgeEcho(t *testing.T) {
+	closed := make(chan struct{}, 1)
+	b := NewBridge(newBackend(t, echoBackend(closed)), ms.Noop)
+	conn := serve(t, msutil.NewConnecter(b, ms.Noop))
+
+	for _, msg := range []string{"hello", "bridge"} {
+		go msutil.WriteClientBinary(conn, []byte(msg))
+		h, p := mstest.ReadFrame(t, conn)
+		if h.OpCode != ms.OpBinary || string(p) != msg {
+			t.Fatalf("unexpected echo: %+v %q; want %q", h, p, msg)
+		}
+	}
+
+	go msutil.WriteClientMessage(conn, ms.OpClose, ms.NewCloseFrameBody(ms.StatusNormalClosure, ""))
+	mstest.ExpectClose(t, conn, ms.StatusNormalClosure)
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatalf("backend connection is not closed")
+	}
+}
+
+func TestBridgeSelect(t *testing.T) {
+	b := NewBridge("", ms.Noop)
+	b.Protocols = map[string]string{
+		"vnc": newBackend(t, nameBackend("vnc")),
+	}
+	b.Paths = map[string]string{
+		"/admin": newBackend(t, nameBackend("admin")),
+	}
+
+	for _, test := range []struct {
+		name     string
+		uri      string
+		protocol string
+		def      bool
+		exp      string
+		code     ms.StatusCode
+	}{
+		{name: "protocol", uri: "/admin", protocol: "vnc", exp: "vnc"},
+		{name: "path", uri: "/admin?token=1", exp: "admin"},
+		{name: "default", uri: "/other", def: true, exp: "default"},
+		{name: "none", uri: "/other", code: ms.StatusPolicyViolation},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			b.Default = ""
+			if test.def {
+				b.Default = newBackend(t, nameBackend("default"))
+			}
+			conn := serve(t, b.NewConnecter())
+
+			u, _ := url.Parse("ws://example.org" + test.uri)
+			var d ms.Dialer
+			if test.protocol != "" {
+				d.Protocols = []string{test.protocol}
+			}
+			br, hs, err := d.Upgrade(conn, u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hs.Protocol != test.protocol {
+				t.Fatalf("unexpected protocol: %q", hs.Protocol)
+			}
+			var r io.Reader = conn
+			if br != nil {
+				r = br
+			}
+			if test.code != 0 {
+				mstest.ExpectClose(t, r, test.code)
+				return
+			}
+			h, p := mstest.ReadFrame(t, r)
+			if h.OpCode != ms.OpBinary || string(p) != test.exp {
+				t.Fatalf("unexpected backend: %+v %q; want %q", h, p, test.exp)
+			}
+		})
+	}
+}
+
+func TestBridgeClose(t *testing.T) {
+	t.Run("backend", func(t *testing.T) {
+		b := NewBridge(newBackend(t, func(conn net.Conn) {
+			conn.Write([]byte("bye"))
+		}), ms.Noop)
+		conn := serve(t, msutil.NewConnecter(b, ms.Noop))
+
+		h, p := mstest.ReadFrame(t, conn)
+		if h.OpCode != ms.OpBinary || string(p) != "bye" {
+			t.Fatalf("unexpected frame: %+v %q", h, p)
+		}
+		mstest.ExpectClose(t, conn, ms.StatusNormalClosure)
+	})
+	t.Run("dial", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := ln.Addr().String()
+		ln.Close()
+
+		b := NewBridge("tcp://"+addr, ms.Noop)
+		conn := serve(t, msutil.NewConnecter(b, ms.Noop))
+		mstest.ExpectClose(t, conn, ms.StatusInternalServerError)
+	})
+	t.Run("text", func(t *testing.T) {
+		closed := make(chan struct{}, 1)
+		b := NewBridge(newBackend(t, echoBackend(closed)), ms.Noop)
+		conn := serve(t, msutil.NewConnecter(b, ms.Noop))
+
+		go msutil.WriteClientText(conn, []byte("text"))
+		mstest.ExpectClose(t, conn, ms.StatusUnsupportedData)
+		conn.Close()
+		select {
+		case <-closed:
+		case <-time.After(time.Second):
+			t.Fatalf("backend connection is not closed")
+		}
+	})
+}
@@ -0,0 +1,101 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"sync"
+
+	ms "github.com/cmacro/mogusocket"
+	"github.com/cmacro/mogusocket/msutil"
+)
+
+// bufferSize is the maximum size of the message sent from the byte stream.
+const bufferSize = 32 << 10
+
+// pipe copies bytes between WebSocket session and the stream conn. Writes to
+// either side block the copying, so the slow side holds back the other one.
+type pipe struct {
+	ctx    context.Context
+	send   ms.SendFunc
+	cancel func()
+	conn   net.Conn
+
+	once   sync.Once
+	closed chan struct{}
+}
+
+func newPipe(ctx context.Context, send ms.SendFunc, cancel func()) *pipe {
+	return &pipe{
+		ctx:    ctx,
+		send:   send,
+		cancel: cancel,
+		closed: make(chan struct{}),
+	}
+}
+
+// ReadPump writes the message to the stream.
+func (p *pipe) ReadPump(r io.Reader, len int64, isText bool) error {
+	if isText {
+		return msutil.CloseWithError{Code: ms.StatusUnsupportedData, Reason: "text message"}
+	}
+	if p.conn == nil {
+		return nil
+	}
+	if _, err := io.Copy(p.conn, r); err != nil {
+		return msutil.CloseWithError{Code: ms.StatusInternalServerError, Reason: "stream write failed", Err: err}
+	}
+	return nil
+}
+
+// writePump sends bytes read from the stream as binary messages until the
+// stream or the session is closed. The session is closed with reason when
+// the stream ends.
+func (p *pipe) writePump(reason string, log ms.Logger) {
+	buf := make([]byte, bufferSize)
+	for {
+		n, err := p.conn.Read(buf)
+		if n > 0 {
+			if err := p.send(bytes.NewReader(buf[:n]), false); err != nil {
+				return
+			}
+		}
+		if err == nil {
+			continue
+		}
+		select {
+		case <-p.closed:
+			return
+		default:
+		}
+		if err == io.EOF {
+			p.fail(ms.StatusNormalClosure, reason)
+		} else {
+			log.Error("stream read", err)
+			p.fail(ms.StatusInternalServerError, "stream read failed")
+		}
+		return
+	}
+}
+
+// fail closes the session with code and reason.
+func (p *pipe) fail(code ms.StatusCode, reason string) {
+	if err := msutil.CloseSession(p.ctx, code, reason); err != nil {
+		p.cancel()
+	}
+}
+
+// Close closes the stream.
+func (p *pipe) Close() {
+	p.once.Do(func() {
+		close(p.closed)
+		if p.conn != nil {
+			p.conn.Close()
+		}
+	})
+}
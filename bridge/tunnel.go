@@ -0,0 +1,113 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package bridge
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+
+	ms "github.com/cmacro/mogusocket"
+	"github.com/cmacro/mogusocket/msutil"
+)
+
+// Tunnel is the client side of the bridge. It accepts local connections and
+// tunnels each of them through its own WebSocket connection to the bridge
+// server. The local connection is closed when the server closes the session
+// and the session is closed with StatusNormalClosure when the local peer
+// closes its side of the stream.
+type Tunnel struct {
+	log ms.Logger
+	url string
+
+	// Dialer connects to the bridge server. Its Protocols could be set to
+	// select the backend by the subprotocol, see Bridge.Protocols.
+	Dialer ms.Dialer
+
+	// BufferPool, if set, is used by the client connections.
+	BufferPool *ms.BufferPool
+}
+
+// NewTunnel returns Tunnel to the bridge server at urlstr, e.g.
+// "ws://example.org/vnc". The path of urlstr could select the backend, see
+// Bridge.Paths. Connections are dialed with ms.DefaultDialer.
+func NewTunnel(urlstr string, log ms.Logger) *Tunnel {
+	return &Tunnel{
+		log:    log,
+		url:    urlstr,
+		Dialer: ms.DefaultDialer,
+	}
+}
+
+// ListenAndServe listens on laddr, e.g. "tcp://127.0.0.1:5900", and serves
+// accepted connections until ctx is done.
+func (t *Tunnel) ListenAndServe(ctx context.Context, laddr string) error {
+	u, err := ms.ParserAddr(laddr)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen(u.Data())
+	if err != nil {
+		return err
+	}
+	return t.Serve(ctx, ln)
+}
+
+// Serve accepts connections on ln and tunnels them until ctx is done. It
+// closes ln and waits for the tunneled connections before return.
+func (t *Tunnel) Serve(ctx context.Context, ln net.Listener) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			t.serveConn(ctx, conn)
+		}()
+	}
+}
+
+func (t *Tunnel) serveConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	if t.BufferPool != nil {
+		ctx = ms.WithBufferPool(ctx, t.BufferPool)
+	}
+	up, br, _, err := t.Dialer.Dial(ctx, t.url)
+	if err != nil {
+		t.log.Error("tunnel dial", err)
+		return
+	}
+	defer up.Close()
+	msutil.ConnectClientBuffered(ctx, up, br, &tunnelConn{conn: conn, log: t.log}, t.log)
+}
+
+// tunnelConn is the client session of a tunneled local connection.
+type tunnelConn struct {
+	*pipe
+	conn net.Conn
+	log  ms.Logger
+}
+
+func (c *tunnelConn) Connect(ctx context.Context, w ms.SendFunc, cancel func()) error {
+	c.pipe = newPipe(ctx, w, cancel)
+	c.pipe.conn = c.conn
+	go c.writePump("local closed", c.log)
+	return nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package bridge
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	ms "github.com/cmacro/mogusocket"
+	"github.com/cmacro/mogusocket/msutil"
+)
+
+func TestTunnel(t *testing.T) {
+	closed := make(chan struct{}, 1)
+	b := NewBridge("", ms.Noop)
+	b.Paths = map[string]string{"/echo": newBackend(t, echoBackend(closed))}
+	b.Protocols = map[string]string{"name": newBackend(t, nameBackend("by protocol"))}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addr := serveListener(t, ctx, b.NewConnecter())
+
+	conn := dialTunnel(t, ctx, NewTunnel("ws://"+addr+"/echo", ms.Noop))
+	for _, msg := range []string{"ping", "through the tunnel"} {
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		p := make([]byte, len(msg))
+		if _, err := io.ReadFull(conn, p); err != nil {
+			t.Fatal(err)
+		}
+		if string(p) != msg {
+			t.Fatalf("unexpected echo: %q; want %q", p, msg)
+		}
+	}
+	conn.Close()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatalf("backend connection is not closed")
+	}
+
+	tun := NewTunnel("ws://"+addr+"/", ms.Noop)
+	tun.Dialer.Protocols = []string{"name"}
+	conn = dialTunnel(t, ctx, tun)
+	p := make([]byte, len("by protocol"))
+	if _, err := io.ReadFull(conn, p); err != nil {
+		t.Fatal(err)
+	}
+	if string(p) != "by protocol" {
+		t.Fatalf("unexpected backend: %q", p)
+	}
+}
+
+// serveListener serves connections accepted on a local TCP address with c
+// until ctx is done and returns the address.
+func serveListener(t *testing.T, ctx context.Context, c *msutil.Connecter) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go c.Run(ctx, conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// dialTunnel serves tun until ctx is done and returns a local connection
+// tunneled by it.
+func dialTunnel(t *testing.T, ctx context.Context, tun *Tunnel) net.Conn {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() {
+		done <- tun.Serve(ctx, ln)
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	t.Cleanup(func() {
+		conn.Close()
+		cancel()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Error(err)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Serve() did not return")
+		}
+	})
+	return conn
+}
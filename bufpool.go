@@ -0,0 +1,216 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package mogusocket
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Constants used by DefaultBufferPool.
+const (
+	DefaultBufferPoolMin         = 128
+	DefaultBufferPoolMax         = 65536
+	DefaultBufferPoolMaxRetained = 32 << 20
+)
+
+// DefaultBufferPool is the BufferPool used when no pool is configured
+// explicitly.
+var DefaultBufferPool = NewBufferPool(
+	DefaultBufferPoolMin,
+	DefaultBufferPoolMax,
+	DefaultBufferPoolMaxRetained,
+)
+
+// BufferPoolStats describes memory accounted by a BufferPool.
+type BufferPoolStats struct {
+	// Held is the number of bytes of buffers which are currently taken from
+	// the pool and not returned yet.
+	Held int64
+
+	// Retained is the number of bytes of free buffers kept by the pool for
+	// reuse.
+	Retained int64
+
+	// Gets and Puts are the total number of Get() and Put() calls.
+	Gets, Puts int64
+
+	// Misses is the number of Get() calls that had to allocate.
+	Misses int64
+}
+
+// BufferPool contains free lists of byte slices organized by power of two
+// size classes in range [min, max]. Slices of other sizes are allocated and
+// dropped without pooling but are still accounted.
+//
+// Unlike sync.Pool it does not release memory on GC, but it never retains
+// more than maxRetained bytes of free buffers.
+type BufferPool struct {
+	min, max    int
+	maxRetained int64
+
+	mu       sync.Mutex
+	free     map[int][][]byte
+	retained int64
+
+	held, gets, puts, misses int64
+}
+
+// NewBufferPool creates new BufferPool with size classes in range [min, max].
+// If maxRetained is zero, free buffers are never retained.
+func NewBufferPool(min, max int, maxRetained int64) *BufferPool {
+	return &BufferPool{
+		min:         ceilToPowerOfTwo(min),
+		max:         max,
+		maxRetained: maxRetained,
+		free:        make(map[int][][]byte),
+	}
+}
+
+// Get returns a slice of length n. Its capacity is ceiled to the size class
+// if n fits the pool range.
+func (p *BufferPool) Get(n int) []byte {
+	atomic.AddInt64(&p.gets, 1)
+
+	size := p.class(n)
+	if size == 0 {
+		atomic.AddInt64(&p.misses, 1)
+		atomic.AddInt64(&p.held, int64(n))
+		return make([]byte, n)
+	}
+
+	p.mu.Lock()
+	if list := p.free[size]; len(list) > 0 {
+		bts := list[len(list)-1]
+		list[len(list)-1] = nil
+		p.free[size] = list[:len(list)-1]
+		p.retained -= int64(size)
+		p.mu.Unlock()
+
+		atomic.AddInt64(&p.held, int64(size))
+		return bts[:n]
+	}
+	p.mu.Unlock()
+
+	atomic.AddInt64(&p.misses, 1)
+	atomic.AddInt64(&p.held, int64(size))
+	return make([]byte, n, size)
+}
+
+// Put returns bts obtained by Get() to the pool. It must not be used after.
+func (p *BufferPool) Put(bts []byte) {
+	atomic.AddInt64(&p.puts, 1)
+
+	size := cap(bts)
+	atomic.AddInt64(&p.held, -int64(size))
+	if p.class(size) != size {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.retained+int64(size) > p.maxRetained {
+		return
+	}
+	p.free[size] = append(p.free[size], bts[:0])
+	p.retained += int64(size)
+}
+
+// Stats returns current pool memory usage.
+func (p *BufferPool) Stats() BufferPoolStats {
+	p.mu.Lock()
+	retained := p.retained
+	p.mu.Unlock()
+
+	return BufferPoolStats{
+		Held:     atomic.LoadInt64(&p.held),
+		Retained: retained,
+		Gets:     atomic.LoadInt64(&p.gets),
+		Puts:     atomic.LoadInt64(&p.puts),
+		Misses:   atomic.LoadInt64(&p.misses),
+	}
+}
+
+// NewAccount returns BufferAccount which takes buffers from p.
+func (p *BufferPool) NewAccount() *BufferAccount {
+	return &BufferAccount{pool: p}
+}
+
+// class returns the size class for n bytes or 0 if n is out of pool range.
+func (p *BufferPool) class(n int) int {
+	if n <= 0 || n > p.max {
+		return 0
+	}
+	if n < p.min {
+		return p.min
+	}
+	size := ceilToPowerOfTwo(n)
+	if size > p.max {
+		return 0
+	}
+	return size
+}
+
+// BufferAccount takes buffers from a BufferPool and counts bytes currently
+// held through it. It is used to account memory of a single connection.
+type BufferAccount struct {
+	pool *BufferPool
+	held int64
+}
+
+// Get is like BufferPool.Get().
+func (a *BufferAccount) Get(n int) []byte {
+	bts := a.pool.Get(n)
+	atomic.AddInt64(&a.held, int64(cap(bts)))
+	return bts
+}
+
+// Put is like BufferPool.Put().
+func (a *BufferAccount) Put(bts []byte) {
+	atomic.AddInt64(&a.held, -int64(cap(bts)))
+	a.pool.Put(bts)
+}
+
+// Held returns the number of bytes currently held through a.
+func (a *BufferAccount) Held() int64 {
+	return atomic.LoadInt64(&a.held)
+}
+
+// Pool returns the BufferPool a takes buffers from.
+func (a *BufferAccount) Pool() *BufferPool {
+	return a.pool
+}
+
+type bufferPoolKey struct{}
+
+// WithBufferPool returns a copy of ctx that carries p. It is used by Server
+// to pass its BufferPool to the connection handlers.
+func WithBufferPool(ctx context.Context, p *BufferPool) context.Context {
+	return context.WithValue(ctx, bufferPoolKey{}, p)
+}
+
+// BufferPoolFromContext returns the BufferPool carried by ctx or
+// DefaultBufferPool if there is no one.
+func BufferPoolFromContext(ctx context.Context) *BufferPool {
+	if p, ok := ctx.Value(bufferPoolKey{}).(*BufferPool); ok && p != nil {
+		return p
+	}
+	return DefaultBufferPool
+}
+
+func ceilToPowerOfTwo(n int) int {
+	if n <= 1 {
+		return 1
+	}
+	n--
+	n |= n >> 1
+	n |= n >> 2
+	n |= n >> 4
+	n |= n >> 8
+	n |= n >> 16
+	n |= n >> 32
+	return n + 1
+}
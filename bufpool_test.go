@@ -0,0 +1,100 @@
+package mogusocket
+
+import (
+	"context"
+	"testing"
+)
+
+func TestBufferPoolClasses(t *testing.T) {
+	p := NewBufferPool(128, 1024, 1<<20)
+	for _, test := range []struct {
+		n   int
+		cap int
+	}{
+		{1, 128},
+		{128, 128},
+		{129, 256},
+		{1000, 1024},
+		{1025, 1025},
+	} {
+		bts := p.Get(test.n)
+		if len(bts) != test.n {
+			t.Errorf("Get(%d): len is %d", test.n, len(bts))
+		}
+		if cap(bts) != test.cap {
+			t.Errorf("Get(%d): cap is %d; want %d", test.n, cap(bts), test.cap)
+		}
+		p.Put(bts)
+	}
+	if s := p.Stats(); s.Held != 0 {
+		t.Errorf("unexpected held bytes: %d", s.Held)
+	}
+}
+
+func TestBufferPoolReuse(t *testing.T) {
+	p := NewBufferPool(128, 1024, 1<<20)
+
+	a := p.Get(200)
+	if s := p.Stats(); s.Held != 256 || s.Misses != 1 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+	p.Put(a)
+	if s := p.Stats(); s.Held != 0 || s.Retained != 256 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+	b := p.Get(256)
+	if &a[:1][0] != &b[:1][0] {
+		t.Errorf("buffer was not reused")
+	}
+	if s := p.Stats(); s.Retained != 0 || s.Misses != 1 || s.Gets != 2 || s.Puts != 1 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+}
+
+func TestBufferPoolMaxRetained(t *testing.T) {
+	p := NewBufferPool(128, 1024, 1024)
+
+	bufs := [][]byte{p.Get(1024), p.Get(1024)}
+	for _, bts := range bufs {
+		p.Put(bts)
+	}
+	if s := p.Stats(); s.Retained != 1024 {
+		t.Errorf("retained %d bytes; want %d", s.Retained, 1024)
+	}
+
+	p = NewBufferPool(128, 1024, 0)
+	p.Put(p.Get(128))
+	if s := p.Stats(); s.Retained != 0 {
+		t.Errorf("retained %d bytes; want nothing", s.Retained)
+	}
+}
+
+func TestBufferAccount(t *testing.T) {
+	p := NewBufferPool(128, 1024, 1<<20)
+	a1 := p.NewAccount()
+	a2 := p.NewAccount()
+
+	b1 := a1.Get(100)
+	b2 := a2.Get(300)
+	if a1.Held() != 128 || a2.Held() != 512 {
+		t.Errorf("unexpected held bytes: %d and %d", a1.Held(), a2.Held())
+	}
+	if s := p.Stats(); s.Held != 640 {
+		t.Errorf("unexpected pool held bytes: %d", s.Held)
+	}
+	a1.Put(b1)
+	a2.Put(b2)
+	if a1.Held() != 0 || a2.Held() != 0 {
+		t.Errorf("unexpected held bytes: %d and %d", a1.Held(), a2.Held())
+	}
+}
+
+func TestBufferPoolFromContext(t *testing.T) {
+	if p := BufferPoolFromContext(context.Background()); p != DefaultBufferPool {
+		t.Errorf("expected default pool")
+	}
+	p := NewBufferPool(128, 1024, 0)
+	if act := BufferPoolFromContext(WithBufferPool(context.Background(), p)); act != p {
+		t.Errorf("unexpected pool")
+	}
+}
@@ -35,6 +35,31 @@ type Handshake struct {
 
 	// Extensions is the list of negotiated extensions.
 	Extensions []httphead.Option
+
+	// Request is the recorded request on the server side. It is nil unless
+	// Upgrader.RecordRequest or HTTPUpgrader.RecordRequest is set.
+	Request *HandshakeRequest
+
+	// Response is the recorded response on the client side. It is nil
+	// unless Dialer.RecordResponse is set.
+	Response *HandshakeResponse
+
+	// Principal is the client identity on the server side. It is nil unless
+	// Upgrader.Authenticator or HTTPUpgrader.Authenticator is set.
+	Principal *Principal
+
+	// RemoteAddr is the client address on the server side, if it is known.
+	// It is taken from the PROXY protocol header and the trusted
+	// X-Forwarded-For header, if any.
+	RemoteAddr net.Addr
+
+	// Proxy is the PROXY protocol header of the connection on the server
+	// side, if any.
+	Proxy *ProxyHeader
+
+	// Peer is the credentials of the client process on the server side, if
+	// it is connected with the unix socket on Linux.
+	Peer *PeerCred
 }
 
 // Errors used by the websocket client.
@@ -42,12 +67,18 @@ var (
 	ErrHandshakeBadStatus      = fmt.Errorf("unexpected http status")
 	ErrHandshakeBadSubProtocol = fmt.Errorf("unexpected protocol in %q header", headerSecProtocol)
 	ErrHandshakeBadExtensions  = fmt.Errorf("unexpected extensions in %q header", headerSecProtocol)
+
+	ErrHandshakeTooManyRedirects = fmt.Errorf("too many redirects")
+	ErrHandshakeInsecureRedirect = fmt.Errorf("redirect from secure to insecure url")
+	ErrHandshakeBadRedirect      = fmt.Errorf("bad redirect location")
 )
 
-// DefaultDialer is dialer that holds no options and is used by Dial function.
-var DefaultDialer Dialer
+// DefaultDialer is dialer that is used by Dial function. Like
+// http.DefaultTransport, it uses proxies configured by the environment, see
+// ProxyFromEnvironment.
+var DefaultDialer = Dialer{Proxy: ProxyFromEnvironment}
 
-// Dial is like Dialer{}.Dial().
+// Dial is like DefaultDialer.Dial().
 func Dial(ctx context.Context, urlstr string) (net.Conn, *bufio.Reader, Handshake, error) {
 	return DefaultDialer.Dial(ctx, urlstr)
 }
@@ -114,6 +145,44 @@ type Dialer struct {
 	// If it is not nil, then it is used instead of net.Dialer.
 	NetDial func(ctx context.Context, network, addr string) (net.Conn, error)
 
+	// RecordResponse enables recording of the status line and headers of
+	// the successful handshake response in Handshake.Response.
+	RecordResponse bool
+
+	// RecordHeaders limits headers recorded with RecordResponse. If it is
+	// nil, all headers are recorded.
+	RecordHeaders []string
+
+	// MaxRedirects is the maximum number of redirects followed by Dial(). If
+	// it is zero, redirects are not followed and 3xx status is returned as
+	// StatusError.
+	//
+	// Redirects from "wss" to "ws" URLs are refused unless InsecureRedirect
+	// is set. URLs with "http" and "https" schemes are followed as "ws" and
+	// "wss" ones. OnStatusError is not called for followed redirects.
+	// Authorization and Cookie lines of Header are not sent to other hosts.
+	MaxRedirects int
+
+	// InsecureRedirect allows redirects from "wss" to "ws" URLs.
+	InsecureRedirect bool
+
+	// Jar, if set, is used to send cookies with the handshake request and to
+	// store cookies received in the handshake and redirect responses. Jar
+	// sees "ws" and "wss" URLs as "http" and "https" ones.
+	Jar http.CookieJar
+
+	// Proxy specifies a function to return a proxy for a given url. If the
+	// function returns a nil URL or Proxy is nil, no proxy is used.
+	//
+	// Supported proxy schemes are "http" and "https", which are used with
+	// the CONNECT method, and "socks5" and "socks5h". The host name of the
+	// url is resolved locally for "socks5" and by the proxy for "socks5h".
+	// Credentials of the proxy URL are sent with basic authorization or the
+	// SOCKS5 username/password method.
+	//
+	// See ProxyFromEnvironment and ProxyURL.
+	Proxy func(*url.URL) (*url.URL, error)
+
 	// TLSClient is the callback that will be called after successful dial with
 	// received connection and its remote host name. If it is nil, then the
 	// default tls.Client() will be used.
@@ -167,8 +236,33 @@ func (d Dialer) Dial(ctx context.Context, urlstr string) (conn net.Conn, br *buf
 			defer cancel()
 		}
 	}
+	for redirects := 0; ; redirects++ {
+		var location string
+		conn, br, hs, location, err = d.dialURL(ctx, dialctx, deadline, u)
+		if location == "" {
+			return conn, br, hs, err
+		}
+		if redirects == d.MaxRedirects {
+			return nil, nil, hs, ErrHandshakeTooManyRedirects
+		}
+		var next *url.URL
+		if next, err = d.redirect(u, location); err != nil {
+			return nil, nil, hs, err
+		}
+		if next.Host != u.Host {
+			d.Header = stripSensitiveHeader(d.Header)
+		}
+		u = next
+	}
+}
+
+// dialURL connects to the url u and upgrades connection to WebSocket. It
+// returns the redirect location if the redirect must be followed.
+func (d Dialer) dialURL(ctx, dialctx context.Context, deadline time.Time, u *url.URL) (
+	conn net.Conn, br *bufio.Reader, hs Handshake, location string, err error,
+) {
 	if conn, err = d.dial(dialctx, u); err != nil {
-		return conn, nil, hs, err
+		return conn, nil, hs, "", err
 	}
 	defer func() {
 		if err != nil {
@@ -192,9 +286,78 @@ func (d Dialer) Dial(ctx context.Context, urlstr string) (conn net.Conn, br *buf
 		}()
 	}
 
-	br, hs, err = d.Upgrade(conn, u)
+	br, hs, location, err = d.upgrade(conn, u, d.MaxRedirects > 0)
+
+	return conn, br, hs, location, err
+}
+
+// redirect returns the url to follow the redirect from u to location.
+func (d Dialer) redirect(u *url.URL, location string) (*url.URL, error) {
+	next, err := u.Parse(location)
+	if err != nil {
+		return nil, ErrHandshakeBadRedirect
+	}
+	switch next.Scheme {
+	case "http", "ws":
+		next.Scheme = "ws"
+	case "https", "wss":
+		next.Scheme = "wss"
+	default:
+		return nil, ErrHandshakeBadRedirect
+	}
+	if u.Scheme == "wss" && next.Scheme == "ws" && !d.InsecureRedirect {
+		return nil, ErrHandshakeInsecureRedirect
+	}
+	// Credentials are kept only for the same host.
+	if next.User == nil && next.Host == u.Host {
+		next.User = u.User
+	}
+	return next, nil
+}
+
+// sensitiveHeaders are not sent to other hosts on redirects.
+var sensitiveHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Www-Authenticate",
+	"Cookie",
+	"Cookie2",
+}
+
+// stripSensitiveHeader returns header lines written by h except the
+// sensitiveHeaders ones. If h fails to write, no lines are returned.
+func stripSensitiveHeader(h HandshakeHeader) HandshakeHeader {
+	if h == nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	if _, err := h.WriteTo(&buf); err != nil {
+		return nil
+	}
+	var ret []byte
+	for p := buf.Bytes(); len(p) > 0; {
+		var line []byte
+		if i := bytes.IndexByte(p, '\n'); i != -1 {
+			line, p = p[:i+1], p[i+1:]
+		} else {
+			line, p = p, nil
+		}
+		k, _, ok := httpParseHeaderLine(append([]byte(nil), line...))
+		if ok && isSensitiveHeader(string(k)) {
+			continue
+		}
+		ret = append(ret, line...)
+	}
+	return HandshakeHeaderBytes(ret)
+}
 
-	return conn, br, hs, err
+func isSensitiveHeader(key string) bool {
+	for _, h := range sensitiveHeaders {
+		if key == h {
+			return true
+		}
+	}
+	return false
 }
 
 var (
@@ -228,10 +391,10 @@ func (d Dialer) dial(ctx context.Context, u *url.URL) (conn net.Conn, err error)
 	switch u.Scheme {
 	case "ws":
 		_, addr := hostport(u.Host, ":80")
-		conn, err = dial(ctx, "tcp", addr)
+		conn, err = d.dialAddr(ctx, dial, u, addr)
 	case "wss":
 		hostname, addr := hostport(u.Host, ":443")
-		conn, err = dial(ctx, "tcp", addr)
+		conn, err = d.dialAddr(ctx, dial, u, addr)
 		if err != nil {
 			return nil, err
 		}
@@ -279,7 +442,17 @@ var (
 //
 // It returns handshake info and some bytes which could be written by the peer
 // right after response and be caught by us during buffered read.
+//
+// Redirects are not followed by Upgrade(), but cookies are sent and stored
+// if d.Jar is set.
 func (d Dialer) Upgrade(conn io.ReadWriter, u *url.URL) (br *bufio.Reader, hs Handshake, err error) {
+	br, hs, _, err = d.upgrade(conn, u, false)
+	return br, hs, err
+}
+
+// upgrade is like Upgrade(). If follow is true, it also returns the location
+// of the redirect response.
+func (d Dialer) upgrade(conn io.ReadWriter, u *url.URL, follow bool) (br *bufio.Reader, hs Handshake, location string, err error) {
 	// headerSeen constants helps to report whether or not some header was seen
 	// during reading request bytes.
 	const (
@@ -314,31 +487,42 @@ func (d Dialer) Upgrade(conn io.ReadWriter, u *url.URL) (br *bufio.Reader, hs Ha
 	nonce := make([]byte, nonceSize)
 	initNonce(nonce)
 
-	httpWriteUpgradeRequest(bw, u, nonce, d.Protocols, d.Extensions, d.Header)
+	header := d.Header
+	if h := d.requestHeader(u); h != nil {
+		header = handshakeHeader{HandshakeHeaderHTTP(h), d.Header}
+	}
+	httpWriteUpgradeRequest(bw, u, nonce, d.Protocols, d.Extensions, header)
 	if err := bw.Flush(); err != nil {
-		return br, hs, err
+		return br, hs, location, err
 	}
 
 	// Read HTTP status line like "HTTP/1.1 101 Switching Protocols".
 	sl, err := readLine(br)
 	if err != nil {
-		return br, hs, err
+		return br, hs, location, err
 	}
 	// Begin validation of the response.
 	// See https://tools.ietf.org/html/rfc6455#section-4.2.2
 	// Parse request line data like HTTP version, uri and method.
 	resp, err := httpParseResponseLine(sl)
 	if err != nil {
-		return br, hs, err
+		return br, hs, location, err
 	}
 	// Even if RFC says "1.1 or higher" without mentioning the part of the
 	// version, we apply it only to minor part.
 	if resp.major != 1 || resp.minor < 1 {
 		err = ErrHandshakeBadProtocol
-		return br, hs, err
+		return br, hs, location, err
 	}
 	if resp.status != http.StatusSwitchingProtocols {
 		err = StatusError(resp.status)
+		if follow && isRedirect(resp.status) {
+			location, err = d.readRedirect(br, u)
+			if err == nil {
+				err = StatusError(resp.status)
+			}
+			return br, hs, location, err
+		}
 		if onStatusError := d.OnStatusError; onStatusError != nil {
 			// Invoke callback with multireader of status-line bytes br.
 			onStatusError(resp.status, resp.reason,
@@ -349,18 +533,28 @@ func (d Dialer) Upgrade(conn io.ReadWriter, u *url.URL) (br *bufio.Reader, hs Ha
 				),
 			)
 		}
-		return br, hs, err
+		return br, hs, location, err
+	}
+	if d.RecordResponse {
+		hs.Response = &HandshakeResponse{
+			Status: resp.status,
+			Reason: string(resp.reason),
+			Header: make(http.Header),
+		}
 	}
 	// If response status is 101 then we expect all technical headers to be
 	// valid. If not, then we stop processing response without giving user
 	// ability to read non-technical headers. That is, we do not distinguish
 	// technical errors (such as parsing error) and protocol errors.
-	var headerSeen byte
+	var (
+		headerSeen byte
+		cookies    []string
+	)
 	for {
 		line, e := readLine(br)
 		if e != nil {
 			err = e
-			return br, hs, err
+			return br, hs, location, err
 		}
 		if len(line) == 0 {
 			// Blank line, no more lines to read.
@@ -370,7 +564,10 @@ func (d Dialer) Upgrade(conn io.ReadWriter, u *url.URL) (br *bufio.Reader, hs Ha
 		k, v, ok := httpParseHeaderLine(line)
 		if !ok {
 			err = ErrMalformedResponse
-			return br, hs, err
+			return br, hs, location, err
+		}
+		if r := hs.Response; r != nil {
+			recordHeader(r.Header, d.RecordHeaders, string(k), string(v))
 		}
 
 		switch btsToString(k) {
@@ -378,7 +575,7 @@ func (d Dialer) Upgrade(conn io.ReadWriter, u *url.URL) (br *bufio.Reader, hs Ha
 			headerSeen |= headerSeenUpgrade
 			if !bytes.Equal(v, specHeaderValueUpgrade) && !bytes.EqualFold(v, specHeaderValueUpgrade) {
 				err = ErrHandshakeBadUpgrade
-				return br, hs, err
+				return br, hs, location, err
 			}
 
 		case headerConnectionCanonical:
@@ -389,14 +586,14 @@ func (d Dialer) Upgrade(conn io.ReadWriter, u *url.URL) (br *bufio.Reader, hs Ha
 			// multiple token. But in response it must contains exactly one.
 			if !bytes.Equal(v, specHeaderValueConnection) && !bytes.EqualFold(v, specHeaderValueConnection) {
 				err = ErrHandshakeBadConnection
-				return br, hs, err
+				return br, hs, location, err
 			}
 
 		case headerSecAcceptCanonical:
 			headerSeen |= headerSeenSecAccept
 			if !checkAcceptFromNonce(v, nonce) {
 				err = ErrHandshakeBadSecAccept
-				return br, hs, err
+				return br, hs, location, err
 			}
 
 		case headerSecProtocolCanonical:
@@ -414,20 +611,23 @@ func (d Dialer) Upgrade(conn io.ReadWriter, u *url.URL) (br *bufio.Reader, hs Ha
 				// Server echoed subprotocol that is not present in client
 				// requested protocols.
 				err = ErrHandshakeBadSubProtocol
-				return br, hs, err
+				return br, hs, location, err
 			}
 
 		case headerSecExtensionsCanonical:
 			hs.Extensions, err = matchSelectedExtensions(v, d.Extensions, hs.Extensions)
 			if err != nil {
-				return br, hs, err
+				return br, hs, location, err
 			}
 
 		default:
+			if d.Jar != nil && btsToString(k) == headerSetCookieCanonical {
+				cookies = append(cookies, string(v))
+			}
 			if onHeader := d.OnHeader; onHeader != nil {
 				if e := onHeader(k, v); e != nil {
 					err = e
-					return br, hs, err
+					return br, hs, location, err
 				}
 			}
 		}
@@ -444,7 +644,10 @@ func (d Dialer) Upgrade(conn io.ReadWriter, u *url.URL) (br *bufio.Reader, hs Ha
 			panic("unknown headers state")
 		}
 	}
-	return br, hs, err
+	if err == nil && len(cookies) > 0 {
+		d.setCookies(u, cookies)
+	}
+	return br, hs, location, err
 }
 
 // PutReader returns bufio.Reader instance to the inner reuse pool.
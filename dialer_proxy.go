@@ -0,0 +1,417 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package mogusocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Errors used by Dialer when connecting through a proxy.
+var (
+	ErrProxyScheme        = errors.New("proxy: unsupported scheme")
+	ErrProxyConnectFailed = errors.New("proxy: connect failed")
+	ErrProxyAuthFailed    = errors.New("proxy: authentication failed")
+	ErrProxyMalformed     = errors.New("proxy: malformed response")
+)
+
+// ProxyURL returns a proxy function for Dialer.Proxy that always returns the
+// same URL.
+func ProxyURL(fixed *url.URL) func(*url.URL) (*url.URL, error) {
+	return func(*url.URL) (*url.URL, error) {
+		return fixed, nil
+	}
+}
+
+var (
+	envProxyOnce   sync.Once
+	envProxyConfig *proxyConfig
+)
+
+// ProxyFromEnvironment returns the URL of the proxy to use for the WebSocket
+// URL u, as indicated by the environment variables HTTP_PROXY, HTTPS_PROXY
+// and NO_PROXY (or the lowercase versions thereof). HTTP_PROXY is used for
+// "ws" URLs and HTTPS_PROXY for "wss" ones.
+//
+// Like in net/http, the environment is read once, requests to localhost and
+// loopback addresses are never proxied and the proxy value without a scheme
+// is treated as "http".
+func ProxyFromEnvironment(u *url.URL) (*url.URL, error) {
+	envProxyOnce.Do(func() {
+		envProxyConfig = newProxyConfig(os.Getenv)
+	})
+	return envProxyConfig.proxy(u)
+}
+
+// proxyConfig is the proxy configuration read from the environment.
+type proxyConfig struct {
+	http, https string
+	noProxyAll  bool
+	noProxy     []noProxyEntry
+}
+
+// noProxyEntry is the element of NO_PROXY list.
+type noProxyEntry struct {
+	ipnet  *net.IPNet
+	ip     net.IP
+	host   string
+	suffix bool
+	port   string
+}
+
+func newProxyConfig(getenv func(string) string) *proxyConfig {
+	env := func(keys ...string) string {
+		for _, k := range keys {
+			if v := getenv(k); v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	c := &proxyConfig{
+		http:  env("HTTP_PROXY", "http_proxy"),
+		https: env("HTTPS_PROXY", "https_proxy"),
+	}
+	for _, s := range strings.Split(env("NO_PROXY", "no_proxy"), ",") {
+		s = strings.ToLower(strings.TrimSpace(s))
+		switch {
+		case s == "":
+			continue
+		case s == "*":
+			c.noProxyAll = true
+			continue
+		}
+		if _, ipnet, err := net.ParseCIDR(s); err == nil {
+			c.noProxy = append(c.noProxy, noProxyEntry{ipnet: ipnet})
+			continue
+		}
+		var e noProxyEntry
+		if host, port, err := net.SplitHostPort(s); err == nil {
+			s, e.port = host, port
+		}
+		if e.ip = net.ParseIP(s); e.ip == nil {
+			if strings.HasPrefix(s, "*.") {
+				s = s[1:]
+			}
+			e.suffix = strings.HasPrefix(s, ".")
+			e.host = strings.TrimPrefix(s, ".")
+		}
+		c.noProxy = append(c.noProxy, e)
+	}
+	return c
+}
+
+func (c *proxyConfig) proxy(u *url.URL) (*url.URL, error) {
+	var (
+		raw  string
+		port string
+	)
+	switch u.Scheme {
+	case "ws":
+		raw, port = c.http, "80"
+	case "wss":
+		raw, port = c.https, "443"
+	}
+	if raw == "" || !c.useProxy(u.Hostname(), nonZeroString(u.Port(), port)) {
+		return nil, nil
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	p, err := url.Parse(raw)
+	if err != nil || p.Host == "" {
+		return nil, fmt.Errorf("proxy: invalid proxy address %q", raw)
+	}
+	return p, nil
+}
+
+// useProxy reports whether the host must be reached through the proxy.
+func (c *proxyConfig) useProxy(host, port string) bool {
+	host = strings.ToLower(host)
+	if host == "localhost" {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip != nil && ip.IsLoopback() {
+		return false
+	}
+	if c.noProxyAll {
+		return false
+	}
+	for _, e := range c.noProxy {
+		switch {
+		case e.ipnet != nil:
+			if ip != nil && e.ipnet.Contains(ip) {
+				return false
+			}
+		case e.port != "" && e.port != port:
+		case e.ip != nil:
+			if ip != nil && e.ip.Equal(ip) {
+				return false
+			}
+		case host == e.host && !e.suffix:
+			return false
+		case strings.HasSuffix(host, "."+e.host):
+			return false
+		}
+	}
+	return true
+}
+
+func nonZeroString(s, def string) string {
+	if s != "" {
+		return s
+	}
+	return def
+}
+
+// dialAddr dials addr of the WebSocket URL u directly or through the proxy.
+func (d Dialer) dialAddr(
+	ctx context.Context,
+	dial func(ctx context.Context, network, addr string) (net.Conn, error),
+	u *url.URL, addr string,
+) (net.Conn, error) {
+	var proxy *url.URL
+	if d.Proxy != nil {
+		var err error
+		if proxy, err = d.Proxy(u); err != nil {
+			return nil, err
+		}
+	}
+	if proxy == nil {
+		return dial(ctx, "tcp", addr)
+	}
+
+	var port string
+	switch proxy.Scheme {
+	case "http":
+		port = ":80"
+	case "https":
+		port = ":443"
+	case "socks5", "socks5h":
+		port = ":1080"
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrProxyScheme, proxy.Scheme)
+	}
+	if proxy.Scheme == "socks5" {
+		// Unlike "socks5h", the host name is resolved locally.
+		var err error
+		if addr, err = resolveAddr(ctx, addr); err != nil {
+			return nil, err
+		}
+	}
+	hostname, paddr := hostport(proxy.Host, port)
+	conn, err := dial(ctx, "tcp", paddr)
+	if err != nil {
+		return nil, err
+	}
+	if proxy.Scheme == "https" {
+		conn = tls.Client(conn, &tls.Config{ServerName: hostname})
+	}
+
+	done := setupContextDeadliner(ctx, conn)
+	if proxy.Scheme == "socks5" || proxy.Scheme == "socks5h" {
+		err = socksConnect(conn, proxy.User, addr)
+	} else {
+		err = httpConnect(conn, proxy.User, addr)
+	}
+	done(&err)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// resolveAddr replaces the host name of addr with its IP address. IPv4
+// addresses are preferred.
+func resolveAddr(ctx context.Context, addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if net.ParseIP(host) != nil {
+		return addr, nil
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return "", err
+	}
+	ip := ips[0].IP
+	for _, a := range ips {
+		if a.IP.To4() != nil {
+			ip = a.IP
+			break
+		}
+	}
+	return net.JoinHostPort(ip.String(), port), nil
+}
+
+// httpConnect asks the HTTP proxy on conn to connect addr.
+func httpConnect(conn net.Conn, user *url.Userinfo, addr string) error {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user != nil {
+		password, _ := user.Password()
+		auth := user.Username() + ":" + password
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(auth)))
+	}
+	if err := req.Write(conn); err != nil {
+		return err
+	}
+	// The proxy sends nothing after the response until the peer does, so no
+	// bytes are lost in the reader buffer.
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s", ErrProxyConnectFailed, resp.Status)
+	}
+	return nil
+}
+
+// SOCKS5 protocol constants, see RFC1928 and RFC1929.
+const (
+	socksVersion    = 5
+	socksAuthNone   = 0
+	socksAuthPass   = 2
+	socksCmdConnect = 1
+	socksAtypIPv4   = 1
+	socksAtypDomain = 3
+	socksAtypIPv6   = 4
+)
+
+var socksReplies = [...]string{
+	1: "general SOCKS server failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// socksConnect asks the SOCKS5 proxy on conn to connect addr. If the host of
+// addr is a name, it is resolved by the proxy.
+func socksConnect(conn net.Conn, user *url.Userinfo, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return err
+	}
+
+	methods := []byte{socksAuthNone}
+	if user != nil {
+		methods = append(methods, socksAuthPass)
+	}
+	b := append([]byte{socksVersion, byte(len(methods))}, methods...)
+	if _, err := conn.Write(b); err != nil {
+		return err
+	}
+	var p [2]byte
+	if _, err := io.ReadFull(conn, p[:]); err != nil {
+		return err
+	}
+	switch {
+	case p[0] != socksVersion:
+		return ErrProxyMalformed
+	case p[1] == socksAuthNone:
+	case p[1] == socksAuthPass && user != nil:
+		password, _ := user.Password()
+		name := user.Username()
+		if len(name) > 255 || len(password) > 255 {
+			return fmt.Errorf("%w: credentials are too long", ErrProxyAuthFailed)
+		}
+		b = append(b[:0], 1, byte(len(name)))
+		b = append(b, name...)
+		b = append(b, byte(len(password)))
+		b = append(b, password...)
+		if _, err := conn.Write(b); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, p[:]); err != nil {
+			return err
+		}
+		if p[1] != 0 {
+			return ErrProxyAuthFailed
+		}
+	default:
+		return fmt.Errorf("%w: no acceptable authentication method", ErrProxyAuthFailed)
+	}
+
+	b = append(b[:0], socksVersion, socksCmdConnect, 0)
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("%w: host name is too long", ErrProxyConnectFailed)
+		}
+		b = append(b, socksAtypDomain, byte(len(host)))
+		b = append(b, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		b = append(b, socksAtypIPv4)
+		b = append(b, ip4...)
+	} else {
+		b = append(b, socksAtypIPv6)
+		b = append(b, ip.To16()...)
+	}
+	b = binary.BigEndian.AppendUint16(b, uint16(port))
+	if _, err := conn.Write(b); err != nil {
+		return err
+	}
+
+	var h [4]byte
+	if _, err := io.ReadFull(conn, h[:]); err != nil {
+		return err
+	}
+	if h[0] != socksVersion {
+		return ErrProxyMalformed
+	}
+	if rep := h[1]; rep != 0 {
+		msg := "unknown error"
+		if int(rep) < len(socksReplies) {
+			msg = socksReplies[rep]
+		}
+		return fmt.Errorf("%w: %s", ErrProxyConnectFailed, msg)
+	}
+	// Skip the bound address and port.
+	var n int
+	switch h[3] {
+	case socksAtypIPv4:
+		n = net.IPv4len
+	case socksAtypIPv6:
+		n = net.IPv6len
+	case socksAtypDomain:
+		if _, err := io.ReadFull(conn, p[:1]); err != nil {
+			return err
+		}
+		n = int(p[0])
+	default:
+		return ErrProxyMalformed
+	}
+	_, err = io.CopyN(io.Discard, conn, int64(n+2))
+	return err
+}
@@ -0,0 +1,109 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package mogusocket
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+)
+
+// isRedirect reports whether the status is the redirect which could be
+// followed with the same GET request.
+func isRedirect(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently,
+		http.StatusFound,
+		http.StatusSeeOther,
+		http.StatusTemporaryRedirect,
+		http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// httpURL returns the copy of the WebSocket url u with corresponding HTTP
+// scheme. It is used with http.CookieJar.
+func httpURL(u *url.URL) *url.URL {
+	hu := *u
+	switch u.Scheme {
+	case "ws":
+		hu.Scheme = "http"
+	case "wss":
+		hu.Scheme = "https"
+	}
+	return &hu
+}
+
+// requestHeader returns additional headers of the handshake request to u. It
+// returns nil if there are no such headers.
+func (d Dialer) requestHeader(u *url.URL) http.Header {
+	var h http.Header
+	if u.User != nil {
+		password, _ := u.User.Password()
+		auth := u.User.Username() + ":" + password
+		h = http.Header{
+			"Authorization": {"Basic " + base64.StdEncoding.EncodeToString([]byte(auth))},
+		}
+	}
+	if d.Jar != nil {
+		cookies := d.Jar.Cookies(httpURL(u))
+		if len(cookies) > 0 {
+			if h == nil {
+				h = make(http.Header)
+			}
+			// Cookie pairs are sent in the single header as
+			// http.Request.AddCookie() does.
+			req := http.Request{Header: h}
+			for _, c := range cookies {
+				req.AddCookie(c)
+			}
+		}
+	}
+	return h
+}
+
+// setCookies stores Set-Cookie header values received from u in d.Jar.
+func (d Dialer) setCookies(u *url.URL, values []string) {
+	resp := http.Response{Header: http.Header{headerSetCookieCanonical: values}}
+	if cookies := resp.Cookies(); len(cookies) > 0 {
+		d.Jar.SetCookies(httpURL(u), cookies)
+	}
+}
+
+// readRedirect reads headers of the redirect response to u and returns its
+// location.
+func (d Dialer) readRedirect(br *bufio.Reader, u *url.URL) (location string, err error) {
+	var cookies []string
+	for {
+		line, err := readLine(br)
+		if err != nil {
+			return "", err
+		}
+		if len(line) == 0 {
+			break
+		}
+		k, v, ok := httpParseHeaderLine(line)
+		if !ok {
+			return "", ErrMalformedResponse
+		}
+		switch btsToString(k) {
+		case headerLocationCanonical:
+			location = string(v)
+		case headerSetCookieCanonical:
+			if d.Jar != nil {
+				cookies = append(cookies, string(v))
+			}
+		}
+	}
+	if len(cookies) > 0 {
+		d.setCookies(u, cookies)
+	}
+	if location == "" {
+		return "", ErrHandshakeBadRedirect
+	}
+	return location, nil
+}
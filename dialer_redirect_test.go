@@ -0,0 +1,166 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package mogusocket
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// redirectHandler redirects "/loop" to itself and "/old" to "/new" setting
+// a cookie. It upgrades other requests reporting their headers to reqs.
+func redirectHandler(reqs chan<- http.Header, target string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/old":
+			http.SetCookie(w, &http.Cookie{Name: "redirect", Value: "1"})
+			http.Redirect(w, r, target, http.StatusTemporaryRedirect)
+		default:
+			reqs <- r.Header.Clone()
+			u := HTTPUpgrader{Header: http.Header{
+				"Set-Cookie": {"session=abc; Path=/"},
+			}}
+			conn, _, _, err := u.Upgrade(r, w)
+			if err == nil {
+				conn.Close()
+			}
+		}
+	})
+}
+
+func wsURL(srv *httptest.Server, path string) string {
+	return "ws" + strings.TrimPrefix(srv.URL, "http") + path
+}
+
+func TestDialerRedirect(t *testing.T) {
+	reqs := make(chan http.Header, 1)
+	srv := httptest.NewServer(redirectHandler(reqs, "/new"))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	jar, _ := cookiejar.New(nil)
+	d := Dialer{MaxRedirects: 3, Jar: jar}
+
+	u, _ := url.Parse(wsURL(srv, "/old"))
+	u.User = url.UserPassword("user", "secret")
+	conn, _, _, err := d.Dial(ctx, u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	h := <-reqs
+	r := http.Request{Header: h}
+	if user, password, ok := r.BasicAuth(); !ok || user != "user" || password != "secret" {
+		t.Errorf("unexpected credentials: %q %q", user, password)
+	}
+	if c, err := r.Cookie("redirect"); err != nil || c.Value != "1" {
+		t.Errorf("redirect cookie is not sent: %v", h["Cookie"])
+	}
+
+	hu, _ := url.Parse(srv.URL)
+	var names []string
+	for _, c := range jar.Cookies(hu) {
+		names = append(names, c.Name+"="+c.Value)
+	}
+	if act := strings.Join(names, " "); act != "redirect=1 session=abc" && act != "session=abc redirect=1" {
+		t.Errorf("unexpected stored cookies: %q", act)
+	}
+}
+
+func TestDialerRedirectErrors(t *testing.T) {
+	reqs := make(chan http.Header, 1)
+	srv := httptest.NewServer(redirectHandler(reqs, "/new"))
+	defer srv.Close()
+	other := httptest.NewServer(redirectHandler(reqs, ""))
+	defer other.Close()
+	hop := httptest.NewServer(redirectHandler(reqs, other.URL+"/new"))
+	defer hop.Close()
+	secure := httptest.NewTLSServer(redirectHandler(reqs, srv.URL+"/new"))
+	defer secure.Close()
+	tlsConfig := secure.Client().Transport.(*http.Transport).TLSClientConfig
+
+	for _, test := range []struct {
+		name   string
+		dialer Dialer
+		url    string
+		err    error
+		auth   bool
+	}{
+		{
+			name: "not followed",
+			url:  wsURL(srv, "/old"),
+			err:  StatusError(http.StatusTemporaryRedirect),
+		},
+		{
+			name:   "too many",
+			dialer: Dialer{MaxRedirects: 2},
+			url:    wsURL(srv, "/loop"),
+			err:    ErrHandshakeTooManyRedirects,
+		},
+		{
+			name:   "insecure",
+			dialer: Dialer{MaxRedirects: 1, TLSConfig: tlsConfig},
+			url:    "wss" + strings.TrimPrefix(secure.URL, "https") + "/old",
+			err:    ErrHandshakeInsecureRedirect,
+		},
+		{
+			name:   "insecure allowed",
+			dialer: Dialer{MaxRedirects: 1, TLSConfig: tlsConfig, InsecureRedirect: true},
+			url:    "wss" + strings.TrimPrefix(secure.URL, "https") + "/old",
+		},
+		{
+			name:   "other host",
+			dialer: Dialer{MaxRedirects: 1},
+			url:    strings.Replace(wsURL(hop, "/old"), "ws://", "ws://user:secret@", 1),
+			auth:   true,
+		},
+		{
+			name: "other host header",
+			dialer: Dialer{MaxRedirects: 1, Header: HandshakeHeaderHTTP(http.Header{
+				"Authorization": {"Bearer secret"},
+				"Cookie":        {"session=secret"},
+				"X-Trace":       {"1"},
+			})},
+			url:  wsURL(hop, "/old"),
+			auth: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			conn, _, _, err := test.dialer.Dial(ctx, test.url)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("unexpected error: %v; want %v", err, test.err)
+			}
+			if err != nil {
+				return
+			}
+			conn.Close()
+			h := <-reqs
+			// Redirect to the other host drops the credentials.
+			if test.auth && h.Get("Authorization") != "" {
+				t.Errorf("credentials are sent to other host")
+			}
+			if test.auth && h.Get("Cookie") != "" {
+				t.Errorf("cookies are sent to other host: %q", h["Cookie"])
+			}
+			if test.dialer.Header != nil && h.Get("X-Trace") != "1" {
+				t.Errorf("other headers are not sent: %v", h)
+			}
+		})
+	}
+}
@@ -21,7 +21,10 @@ import (
 	"github.com/cmacro/mogusocket/msutil"
 )
 
-var addr = flag.String("listen", "unix:///tmp/ws_testsocket.tmp", "addr to listen")
+var (
+	addr = flag.String("listen", "unix:///tmp/ws_testsocket.tmp", "addr to listen")
+	poll = flag.Bool("poll", false, "serve connections with event loop")
+)
 
 var mainLog ms.Logger
 
@@ -107,15 +110,20 @@ func main() {
 	mainLog = ms.Stdout("Main", "DEBUG", true)
 
 	svrLog := ms.Stdout("Server", "DEBUG", true)
-	connecter := msutil.NewConnecter(NewTestSections(ms.Stdout("Sections", "DEBUG", true)), svrLog)
-	ms := ms.NewServer(*addr, connecter, svrLog)
+	sessions := NewTestSections(ms.Stdout("Sections", "DEBUG", true))
+	var srv *ms.Server
+	if *poll {
+		srv = ms.NewPollServer(*addr, msutil.NewPollConnecter(sessions, svrLog), svrLog)
+	} else {
+		srv = ms.NewServer(*addr, msutil.NewConnecter(sessions, svrLog), svrLog)
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		ms.Run(ctx)
+		srv.Run(ctx)
 		cancel()
 	}()
 
@@ -0,0 +1,125 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+// Package graphqlws implements the graphql-transport-ws protocol, GraphQL
+// over WebSocket, on mogusocket sessions. GraphQL execution is left to the
+// Resolver.
+//
+// See https://github.com/enisdenjo/graphql-ws/blob/master/PROTOCOL.md
+package graphqlws
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	ms "github.com/cmacro/mogusocket"
+)
+
+// Protocol is the WebSocket subprotocol name.
+const Protocol = "graphql-transport-ws"
+
+// AcceptProtocol reports whether p is the graphql-transport-ws subprotocol.
+// It could be used as ms.Upgrader.Protocol.
+func AcceptProtocol(p []byte) bool {
+	return string(p) == Protocol
+}
+
+// Message types.
+const (
+	TypeConnectionInit = "connection_init"
+	TypeConnectionAck  = "connection_ack"
+	TypePing           = "ping"
+	TypePong           = "pong"
+	TypeSubscribe      = "subscribe"
+	TypeNext           = "next"
+	TypeError          = "error"
+	TypeComplete       = "complete"
+)
+
+// Close codes defined by the protocol. All of them are in the private range
+// of status codes, see ms.StatusCode.IsPrivateSpec().
+const (
+	StatusBadRequest          ms.StatusCode = 4400
+	StatusUnauthorized        ms.StatusCode = 4401
+	StatusForbidden           ms.StatusCode = 4403
+	StatusInitTimeout         ms.StatusCode = 4408
+	StatusSubscriberExists    ms.StatusCode = 4409
+	StatusTooManyInitRequests ms.StatusCode = 4429
+	StatusInternalError       ms.StatusCode = 4500
+)
+
+// Message is the protocol message.
+type Message struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Request is the payload of subscribe message.
+type Request struct {
+	OperationName string                 `json:"operationName,omitempty"`
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+	Extensions    map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// Location is the location of GraphQL error in the query.
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// GraphQLError is the GraphQL error object.
+type GraphQLError struct {
+	Message    string                 `json:"message"`
+	Locations  []Location             `json:"locations,omitempty"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+func (e *GraphQLError) Error() string {
+	return e.Message
+}
+
+// Errors is the list of GraphQL errors. Resolver could return it to send the
+// errors to the client as is.
+type Errors []*GraphQLError
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, x := range e {
+		msgs[i] = x.Message
+	}
+	return "graphql: " + strings.Join(msgs, "; ")
+}
+
+// Result is the GraphQL execution result sent with next message.
+type Result struct {
+	Data       interface{}            `json:"data,omitempty"`
+	Errors     Errors                 `json:"errors,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// Resolver executes GraphQL operations.
+type Resolver interface {
+	// Subscribe starts the operation. Results sent to the returned channel
+	// are delivered with next messages; the operation is completed when the
+	// channel is closed. Queries and mutations send a single result.
+	//
+	// The ctx is canceled when the client completes the operation or the
+	// connection is closed. Resolver must close the channel then.
+	//
+	// The error is sent with error message. It is sent as is if it is
+	// Errors or *GraphQLError.
+	Subscribe(ctx context.Context, req *Request) (<-chan *Result, error)
+}
+
+// ResolverFunc is an adapter to use ordinary functions as Resolver.
+type ResolverFunc func(ctx context.Context, req *Request) (<-chan *Result, error)
+
+// Subscribe implements Resolver.
+func (f ResolverFunc) Subscribe(ctx context.Context, req *Request) (<-chan *Result, error) {
+	return f(ctx, req)
+}
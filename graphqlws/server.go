@@ -0,0 +1,278 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package graphqlws
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ms "github.com/cmacro/mogusocket"
+	"github.com/cmacro/mogusocket/msutil"
+)
+
+// DefaultInitTimeout is the timeout of connection_init used when
+// Server.InitTimeout is zero.
+const DefaultInitTimeout = 3 * time.Second
+
+// InitFunc handles connection_init message. It returns the context of the
+// connection operations, usually ctx carrying the authenticated principal,
+// and the optional payload of connection_ack message. The error closes the
+// connection with StatusForbidden.
+type InitFunc func(ctx context.Context, payload json.RawMessage) (context.Context, interface{}, error)
+
+// Server is the ms.SessionsHandler of graphql-transport-ws connections.
+type Server struct {
+	log      ms.Logger
+	resolver Resolver
+
+	// OnInit, if set, authenticates connections.
+	OnInit InitFunc
+
+	// InitTimeout is the time the client has to send connection_init. Zero
+	// means DefaultInitTimeout, negative disables the timeout.
+	InitTimeout time.Duration
+
+	lastID int64
+}
+
+// NewServer returns Server executing operations with r.
+func NewServer(r Resolver, log ms.Logger) *Server {
+	return &Server{
+		log:      log,
+		resolver: r,
+	}
+}
+
+// Connect implements ms.SessionsHandler. If the connection has made a
+// handshake, the graphql-transport-ws subprotocol must be negotiated.
+func (s *Server) Connect(ctx context.Context, w ms.SendFunc, c func()) (ms.SessionHandler, error) {
+	if hs, ok := msutil.HandshakeFromContext(ctx); ok && hs.Protocol != Protocol {
+		return nil, errors.New("graphqlws: subprotocol is not negotiated")
+	}
+	sess := &session{
+		id:     atomic.AddInt64(&s.lastID, 1),
+		srv:    s,
+		ctx:    ctx,
+		send:   w,
+		cancel: c,
+		ops:    make(map[string]context.CancelFunc),
+		acked:  make(chan struct{}),
+	}
+	if d := s.InitTimeout; d >= 0 {
+		if d == 0 {
+			d = DefaultInitTimeout
+		}
+		go sess.initTimeout(d)
+	}
+	return sess, nil
+}
+
+// Close implements ms.SessionsHandler.
+func (s *Server) Close(session ms.SessionHandler) error {
+	session.Close()
+	return nil
+}
+
+type session struct {
+	id     int64
+	srv    *Server
+	ctx    context.Context
+	send   ms.SendFunc
+	cancel func()
+
+	// opCtx is the parent context of operations. It is set by
+	// connection_init before acked is closed.
+	opCtx context.Context
+	init  bool
+	acked chan struct{}
+
+	mu  sync.Mutex
+	ops map[string]context.CancelFunc
+}
+
+func (s *session) GetId() int64 { return s.id }
+
+func (s *session) Close() {
+	s.mu.Lock()
+	for id, cancel := range s.ops {
+		cancel()
+		delete(s.ops, id)
+	}
+	s.mu.Unlock()
+	s.cancel()
+}
+
+func closeError(code ms.StatusCode, reason string) error {
+	return msutil.CloseWithError{Code: code, Reason: reason}
+}
+
+func (s *session) isAcked() bool {
+	select {
+	case <-s.acked:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *session) ReadPump(r io.Reader, len int64, isText bool) error {
+	p, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	var m Message
+	if err := json.Unmarshal(p, &m); err != nil {
+		return closeError(StatusBadRequest, "Invalid message received")
+	}
+
+	switch m.Type {
+	case TypeConnectionInit:
+		return s.connectionInit(&m)
+	case TypePing:
+		return s.write(&Message{Type: TypePong})
+	case TypePong:
+		return nil
+	case TypeSubscribe:
+		return s.subscribe(&m)
+	case TypeComplete:
+		s.mu.Lock()
+		if cancel, ok := s.ops[m.ID]; ok {
+			cancel()
+			delete(s.ops, m.ID)
+		}
+		s.mu.Unlock()
+		return nil
+	default:
+		return closeError(StatusBadRequest, "Invalid message received")
+	}
+}
+
+func (s *session) connectionInit(m *Message) error {
+	if s.init {
+		return closeError(StatusTooManyInitRequests, "Too many initialisation requests")
+	}
+	s.init = true
+
+	ctx, ack := s.ctx, interface{}(nil)
+	if h := s.srv.OnInit; h != nil {
+		var err error
+		if ctx, ack, err = h(s.ctx, m.Payload); err != nil {
+			s.srv.log.Info("graphqlws init", s.id, err)
+			return closeError(StatusForbidden, "Forbidden")
+		}
+	}
+	resp := &Message{Type: TypeConnectionAck}
+	if ack != nil {
+		p, err := json.Marshal(ack)
+		if err != nil {
+			return closeError(StatusInternalError, "Internal server error")
+		}
+		resp.Payload = p
+	}
+	s.opCtx = ctx
+	if err := s.write(resp); err != nil {
+		return err
+	}
+	close(s.acked)
+	return nil
+}
+
+func (s *session) subscribe(m *Message) error {
+	if !s.isAcked() {
+		return closeError(StatusUnauthorized, "Unauthorized")
+	}
+	var req Request
+	if m.ID == "" || json.Unmarshal(m.Payload, &req) != nil || req.Query == "" {
+		return closeError(StatusBadRequest, "Invalid message received")
+	}
+
+	s.mu.Lock()
+	if _, ok := s.ops[m.ID]; ok {
+		s.mu.Unlock()
+		return closeError(StatusSubscriberExists, "Subscriber for "+m.ID+" already exists")
+	}
+	ctx, cancel := context.WithCancel(s.opCtx)
+	s.ops[m.ID] = cancel
+	s.mu.Unlock()
+
+	go s.run(ctx, m.ID, &req)
+	return nil
+}
+
+// run executes the operation and sends its results.
+func (s *session) run(ctx context.Context, id string, req *Request) {
+	defer s.done(id, ctx)
+
+	results, err := s.srv.resolver.Subscribe(ctx, req)
+	if err != nil {
+		errs, ok := err.(Errors)
+		if !ok {
+			var gerr *GraphQLError
+			if !errors.As(err, &gerr) {
+				gerr = &GraphQLError{Message: err.Error()}
+			}
+			errs = Errors{gerr}
+		}
+		s.writePayload(TypeError, id, errs)
+		return
+	}
+	for res := range results {
+		if ctx.Err() != nil {
+			// Drain the results until the resolver closes the channel.
+			continue
+		}
+		if err := s.writePayload(TypeNext, id, res); err != nil {
+			s.srv.log.Info("graphqlws next", s.id, err)
+		}
+	}
+	if ctx.Err() == nil {
+		s.write(&Message{ID: id, Type: TypeComplete})
+	}
+}
+
+// done forgets the operation. The id could be reused by the client then.
+func (s *session) done(id string, ctx context.Context) {
+	s.mu.Lock()
+	if ctx.Err() == nil {
+		// The operation is not completed by the client.
+		s.ops[id]()
+		delete(s.ops, id)
+	}
+	s.mu.Unlock()
+}
+
+func (s *session) initTimeout(d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		s.srv.log.Info("graphqlws init timeout", s.id)
+		msutil.CloseSession(s.ctx, StatusInitTimeout, "Connection initialisation timeout")
+	case <-s.acked:
+	case <-s.ctx.Done():
+	}
+}
+
+func (s *session) writePayload(typ, id string, v interface{}) error {
+	p, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.write(&Message{ID: id, Type: typ, Payload: p})
+}
+
+func (s *session) write(m *Message) error {
+	p, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return s.send(bytes.NewReader(p), true)
+}
@@ -0,0 +1,243 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package graphqlws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	ms "github.com/cmacro/mogusocket"
+	"github.com/cmacro/mogusocket/internal/mstest"
+)
+
+type userKey struct{}
+
+// testResolver serves "count" streaming three results, "fail" failing and
+// "wait" waiting to be completed by the client.
+type testResolver struct {
+	canceled chan string
+}
+
+func (r *testResolver) Subscribe(ctx context.Context, req *Request) (<-chan *Result, error) {
+	switch req.Query {
+	case "fail":
+		return nil, errors.New("failed")
+	case "invalid":
+		return nil, Errors{{Message: "syntax", Locations: []Location{{1, 2}}}}
+	}
+	ch := make(chan *Result)
+	go func() {
+		defer close(ch)
+		if req.Query == "wait" {
+			<-ctx.Done()
+			r.canceled <- req.OperationName
+			return
+		}
+		for i := 0; i < 3; i++ {
+			ch <- &Result{Data: map[string]interface{}{
+				"n":    i,
+				"user": ctx.Value(userKey{}),
+			}}
+		}
+	}()
+	return ch, nil
+}
+
+func newTestServer() (*Server, *testResolver) {
+	r := &testResolver{canceled: make(chan string, 1)}
+	s := NewServer(r, ms.Noop)
+	s.OnInit = func(ctx context.Context, payload json.RawMessage) (context.Context, interface{}, error) {
+		var p struct{ Token string }
+		json.Unmarshal(payload, &p)
+		if p.Token != "secret" {
+			return nil, nil, errors.New("bad token")
+		}
+		return context.WithValue(ctx, userKey{}, "gopher"), map[string]string{"hello": "gopher"}, nil
+	}
+	return s, r
+}
+
+type rawClient struct {
+	*mstest.Conn
+	t *testing.T
+}
+
+func serveRaw(t *testing.T, s *Server) *rawClient {
+	t.Helper()
+	return &rawClient{Conn: mstest.Serve(t, s), t: t}
+}
+
+func (c *rawClient) write(s string) {
+	c.t.Helper()
+	c.WriteText([]byte(s))
+}
+
+// read returns the next message or the close code.
+func (c *rawClient) read() (Message, ms.StatusCode) {
+	c.t.Helper()
+	h, p := c.ReadFrame()
+	var m Message
+	if h.OpCode == ms.OpClose {
+		code, _ := ms.ParseCloseFrameData(p)
+		return m, code
+	}
+	if err := json.Unmarshal(p, &m); err != nil {
+		c.t.Fatal(err)
+	}
+	return m, 0
+}
+
+func (c *rawClient) expect(typ, id, payload string) {
+	c.t.Helper()
+	m, code := c.read()
+	if code != 0 {
+		c.t.Fatalf("unexpected close: %d", code)
+	}
+	if m.Type != typ || m.ID != id || payload != "" && string(m.Payload) != payload {
+		c.t.Fatalf("unexpected message: %+v %s; want %s %s %s", m, m.Payload, typ, id, payload)
+	}
+}
+
+func (c *rawClient) expectClose(code ms.StatusCode) {
+	c.t.Helper()
+	if m, act := c.read(); act != code {
+		c.t.Fatalf("unexpected message: %+v %d; want close %d", m, act, code)
+	}
+}
+
+func (c *rawClient) init() {
+	c.t.Helper()
+	c.write(`{"type":"connection_init","payload":{"token":"secret"}}`)
+	c.expect(TypeConnectionAck, "", `{"hello":"gopher"}`)
+}
+
+func TestStatusCodes(t *testing.T) {
+	for _, code := range []ms.StatusCode{
+		StatusBadRequest,
+		StatusUnauthorized,
+		StatusForbidden,
+		StatusInitTimeout,
+		StatusSubscriberExists,
+		StatusTooManyInitRequests,
+		StatusInternalError,
+	} {
+		if !code.IsPrivateSpec() || ms.CheckCloseFrameData(code, "") != nil {
+			t.Errorf("invalid close code %d", code)
+		}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	s, _ := newTestServer()
+	c := serveRaw(t, s)
+	c.init()
+
+	c.write(`{"type":"ping"}`)
+	c.expect(TypePong, "", "")
+
+	c.write(`{"id":"1","type":"subscribe","payload":{"query":"count"}}`)
+	for i := 0; i < 3; i++ {
+		c.expect(TypeNext, "1", `{"data":{"n":`+strconv.Itoa(i)+`,"user":"gopher"}}`)
+	}
+	c.expect(TypeComplete, "1", "")
+
+	c.write(`{"id":"2","type":"subscribe","payload":{"query":"fail"}}`)
+	c.expect(TypeError, "2", `[{"message":"failed"}]`)
+	c.write(`{"id":"2","type":"subscribe","payload":{"query":"invalid"}}`)
+	c.expect(TypeError, "2", `[{"message":"syntax","locations":[{"line":1,"column":2}]}]`)
+}
+
+func TestComplete(t *testing.T) {
+	s, r := newTestServer()
+	c := serveRaw(t, s)
+	c.init()
+
+	c.write(`{"id":"1","type":"subscribe","payload":{"query":"wait","operationName":"a"}}`)
+	c.write(`{"id":"1","type":"complete"}`)
+	select {
+	case name := <-r.canceled:
+		if name != "a" {
+			t.Fatalf("unexpected operation canceled: %q", name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("operation is not canceled")
+	}
+
+	// The id could be reused after the operation is completed.
+	c.write(`{"id":"1","type":"subscribe","payload":{"query":"count"}}`)
+	c.expect(TypeNext, "1", "")
+}
+
+func TestCloseCodes(t *testing.T) {
+	init := `{"type":"connection_init","payload":{"token":"secret"}}`
+	for _, test := range []struct {
+		name string
+		msgs []string
+		code ms.StatusCode
+	}{
+		{
+			name: "invalid json",
+			msgs: []string{`{"type":`},
+			code: StatusBadRequest,
+		},
+		{
+			name: "unknown type",
+			msgs: []string{`{"type":"start"}`},
+			code: StatusBadRequest,
+		},
+		{
+			name: "unauthorized",
+			msgs: []string{`{"id":"1","type":"subscribe","payload":{"query":"count"}}`},
+			code: StatusUnauthorized,
+		},
+		{
+			name: "forbidden",
+			msgs: []string{`{"type":"connection_init","payload":{"token":"x"}}`},
+			code: StatusForbidden,
+		},
+		{
+			name: "too many init",
+			msgs: []string{init, init},
+			code: StatusTooManyInitRequests,
+		},
+		{
+			name: "subscriber exists",
+			msgs: []string{
+				init,
+				`{"id":"1","type":"subscribe","payload":{"query":"wait"}}`,
+				`{"id":"1","type":"subscribe","payload":{"query":"wait"}}`,
+			},
+			code: StatusSubscriberExists,
+		},
+		{
+			name: "missing query",
+			msgs: []string{init, `{"id":"1","type":"subscribe","payload":{}}`},
+			code: StatusBadRequest,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s, _ := newTestServer()
+			c := serveRaw(t, s)
+			for i, m := range test.msgs {
+				c.write(m)
+				if m == init && i == 0 {
+					c.expect(TypeConnectionAck, "", "")
+				}
+			}
+			c.expectClose(test.code)
+		})
+	}
+}
+
+func TestInitTimeout(t *testing.T) {
+	s, _ := newTestServer()
+	s.InitTimeout = 20 * time.Millisecond
+	c := serveRaw(t, s)
+	c.expectClose(StatusInitTimeout)
+}
@@ -0,0 +1,228 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package mogusocket
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// EnvListenFDs is the environment variable which lists files passed to the
+// process restarted by Restart(). Entries are separated by new lines and
+// each one describes the file descriptor starting from 3 in order.
+const EnvListenFDs = "MOGUSOCKET_LISTEN_FDS"
+
+// ErrServerNotRunning is returned by Restart for servers which do not
+// listen.
+var ErrServerNotRunning = errors.New("server is not running")
+
+// Kinds of inherited files.
+const (
+	// inheritListen is the listener of the server.
+	inheritListen = "listen"
+	// inheritActivated is the listener created by the service manager. Its
+	// socket file is never removed.
+	inheritActivated = "activated"
+	// inheritLock is the lock file of the unix socket.
+	inheritLock = "lock"
+)
+
+type inheritedFile struct {
+	kind string
+	name string
+	f    *os.File
+	ln   net.Listener
+}
+
+var inherited struct {
+	once  sync.Once
+	mu    sync.Mutex
+	files []*inheritedFile
+}
+
+// loadInherited reads the files passed by the parent process or the
+// systemd socket activation. Environment variables are unset, so they are
+// not passed to child processes.
+func loadInherited() {
+	if v, ok := os.LookupEnv(EnvListenFDs); ok {
+		os.Unsetenv(EnvListenFDs)
+		for i, e := range strings.Split(v, "\n") {
+			kind, name, _ := strings.Cut(e, "=")
+			inherited.files = append(inherited.files, &inheritedFile{
+				kind: kind,
+				name: name,
+				f:    os.NewFile(uintptr(3+i), name),
+			})
+		}
+		return
+	}
+
+	// See sd_listen_fds(3).
+	pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	n, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if pid != os.Getpid() {
+		return
+	}
+	for i := 0; i < n; i++ {
+		var name string
+		if i < len(names) {
+			name = names[i]
+		}
+		inherited.files = append(inherited.files, &inheritedFile{
+			kind: inheritActivated,
+			name: name,
+			f:    os.NewFile(uintptr(3+i), name),
+		})
+	}
+}
+
+// inheritedListener returns the inherited listener of the server with
+// address addr and its lock file, if any. The activated reports whether the
+// listener is owned by the service manager.
+func inheritedListener(addr string, u *Addr) (ln net.Listener, lock *os.File, activated bool, err error) {
+	inherited.once.Do(loadInherited)
+	inherited.mu.Lock()
+	defer inherited.mu.Unlock()
+
+	files := inherited.files[:0]
+	for _, inh := range inherited.files {
+		if inh.kind == inheritLock {
+			if lock == nil && inh.name == addr {
+				lock = inh.f
+				continue
+			}
+			files = append(files, inh)
+			continue
+		}
+		if inh.ln == nil {
+			// Listeners are created to match them by the address, so they
+			// are kept even if they do not match.
+			l, e := net.FileListener(inh.f)
+			inh.f.Close()
+			if e != nil {
+				err = e
+				continue
+			}
+			inh.ln = l
+		}
+		if ln == nil && (inh.name == addr || (inh.kind == inheritActivated && sameAddr(inh.ln.Addr(), u))) {
+			ln, activated = inh.ln, inh.kind == inheritActivated
+			continue
+		}
+		files = append(files, inh)
+	}
+	inherited.files = files
+	return ln, lock, activated, err
+}
+
+// sameAddr reports whether a is the listening address u.
+func sameAddr(a net.Addr, u *Addr) bool {
+	network, address := u.Data()
+	switch a := a.(type) {
+	case *net.UnixAddr:
+		return network == "unix" && a.Name == address
+	case *net.TCPAddr:
+		b, err := net.ResolveTCPAddr(network, address)
+		if err != nil || a.Port != b.Port {
+			return false
+		}
+		if b.IP == nil || b.IP.IsUnspecified() {
+			return a.IP == nil || a.IP.IsUnspecified()
+		}
+		return a.IP.Equal(b.IP)
+	}
+	return false
+}
+
+// Restart makes a graceful restart of the running executable. It starts a
+// new process with the same arguments and passes it the listeners of
+// servers. The new process serves them with Server.Run() called with the
+// same addresses.
+//
+// Then servers are shut down: they stop accepting connections and drain
+// sessions with the close handshake until ctx is done. See Server.Shutdown.
+func Restart(ctx context.Context, servers ...*Server) (*os.Process, error) {
+	var (
+		entries []string
+		files   []*os.File
+		owned   []*os.File
+	)
+	defer func() {
+		for _, f := range owned {
+			f.Close()
+		}
+	}()
+	for _, s := range servers {
+		s.mu.Lock()
+		ln, lock, activated := s.listener, s.lock, s.activated
+		s.mu.Unlock()
+		if ln == nil {
+			return nil, ErrServerNotRunning
+		}
+		filer, ok := ln.(interface{ File() (*os.File, error) })
+		if !ok {
+			return nil, ErrServerNotRunning
+		}
+		f, err := filer.File()
+		if err != nil {
+			return nil, err
+		}
+		kind := inheritListen
+		if activated {
+			kind = inheritActivated
+		}
+		entries = append(entries, kind+"="+s.addr)
+		files = append(files, f)
+		owned = append(owned, f)
+		if lock != nil {
+			// The inherited descriptor shares the lock, so the lock is held
+			// while the new process is running.
+			entries = append(entries, inheritLock+"="+s.addr)
+			files = append(files, lock)
+		}
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	env := os.Environ()[:0:0]
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, EnvListenFDs+"=") {
+			env = append(env, kv)
+		}
+	}
+	cmd.Env = append(env, EnvListenFDs+"="+strings.Join(entries, "\n"))
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	errs := make(chan error, len(servers))
+	for _, s := range servers {
+		s.mu.Lock()
+		s.handedOff = true
+		s.mu.Unlock()
+		go func(s *Server) { errs <- s.Shutdown(ctx) }(s)
+	}
+	for range servers {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	return cmd.Process, err
+}
@@ -0,0 +1,149 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+//go:build linux
+
+package mogusocket
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// shutdownHandler serves connections until the server is shut down.
+type shutdownHandler chan net.Conn
+
+func (h shutdownHandler) Run(ctx context.Context, conn io.ReadWriter) {
+	h <- conn.(net.Conn)
+	<-ShutdownFromContext(ctx)
+}
+
+// inherit makes ln inherited by servers started later.
+func inherit(t *testing.T, kind, name string, ln net.Listener) {
+	f, err := ln.(interface{ File() (*os.File, error) }).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	inherited.once.Do(func() {})
+	inherited.mu.Lock()
+	inherited.files = append(inherited.files, &inheritedFile{kind: kind, name: name, f: f})
+	inherited.mu.Unlock()
+}
+
+func TestSameAddr(t *testing.T) {
+	for _, test := range []struct {
+		addr net.Addr
+		url  string
+		exp  bool
+	}{
+		{&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9001}, "tcp://127.0.0.1:9001", true},
+		{&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9001}, "tcp://127.0.0.1:9002", false},
+		{&net.TCPAddr{IP: net.IPv6unspecified, Port: 9001}, "tcp://:9001", true},
+		{&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9001}, "tcp://:9001", false},
+		{&net.UnixAddr{Name: "/run/agent.sock", Net: "unix"}, "unix:///run/agent.sock", true},
+		{&net.UnixAddr{Name: "/run/agent.sock", Net: "unix"}, "tcp://:9001", false},
+	} {
+		u, err := ParserAddr(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if act := sameAddr(test.addr, u); act != test.exp {
+			t.Errorf("sameAddr(%v, %q) = %v; want %v", test.addr, test.url, act, test.exp)
+		}
+	}
+}
+
+func TestServerInheritedListener(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	inherit(t, inheritActivated, "agent", ln)
+
+	conns := make(acceptHandler, 1)
+	srv := NewServer("unix://"+sock, conns, Noop)
+	stop := runServer(t, srv)
+
+	conn := mustDialUnix(t, sock)
+	(<-conns).Close()
+	conn.Close()
+	stop()
+
+	// The socket of the service manager is kept.
+	if _, err := os.Stat(sock); err != nil {
+		t.Fatalf("socket is removed: %v", err)
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	for _, handedOff := range []bool{false, true} {
+		sock := filepath.Join(t.TempDir(), "agent.sock")
+		conns := make(shutdownHandler, 1)
+		srv := NewServer("unix://"+sock, conns, Noop)
+		done := make(chan struct{})
+		go func() {
+			srv.Run(context.Background())
+			close(done)
+		}()
+
+		conn := mustDialUnix(t, sock)
+		defer conn.Close()
+		<-conns
+
+		srv.mu.Lock()
+		srv.handedOff = handedOff
+		srv.mu.Unlock()
+		if err := srv.Shutdown(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		<-done
+
+		if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("unexpected read error: %v", err)
+		}
+		_, err := os.Stat(sock)
+		if handedOff && err != nil {
+			t.Errorf("socket is removed after handoff: %v", err)
+		}
+		if !handedOff && !os.IsNotExist(err) {
+			t.Errorf("socket is not removed: %v", err)
+		}
+		if _, err := net.Dial("unix", sock); err == nil {
+			t.Errorf("connection accepted after shutdown")
+		}
+	}
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	conns := make(acceptHandler, 1)
+	srv := NewServer("unix://"+sock, conns, Noop)
+	done := make(chan struct{})
+	go func() {
+		srv.Run(context.Background())
+		close(done)
+	}()
+
+	conn := mustDialUnix(t, sock)
+	defer conn.Close()
+	<-conns
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	<-done
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("unexpected read error: %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package mogusocket
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// HandshakeRequest is the handshake request recorded by the server when
+// Upgrader.RecordRequest or HTTPUpgrader.RecordRequest is set.
+type HandshakeRequest struct {
+	// Method and Proto are parts of the request line, e.g. "GET" and
+	// "HTTP/1.1".
+	Method string
+	Proto  string
+
+	// URI is the request target as it was sent, e.g. "/chat?room=1". Path
+	// and RawQuery are its parts.
+	URI      string
+	Path     string
+	RawQuery string
+
+	// Host is the value of the Host header.
+	Host string
+
+	// Header contains recorded request headers except Host.
+	Header http.Header
+
+	// RemoteAddr and Peer are the same as Handshake ones. They allow
+	// Authenticator to check the client connection.
+	RemoteAddr net.Addr
+	Peer       *PeerCred
+}
+
+// Query parses RawQuery and returns the values. Malformed pairs are dropped.
+func (r *HandshakeRequest) Query() url.Values {
+	q, _ := url.ParseQuery(r.RawQuery)
+	return q
+}
+
+// HandshakeResponse is the successful handshake response recorded by the
+// client when Dialer.RecordResponse is set.
+type HandshakeResponse struct {
+	// Status and Reason are parts of the status line, e.g. 101 and
+	// "Switching Protocols".
+	Status int
+	Reason string
+
+	// Header contains recorded response headers.
+	Header http.Header
+}
+
+func newHandshakeRequest(method, uri []byte, major, minor int) *HandshakeRequest {
+	r := &HandshakeRequest{
+		Method: string(method),
+		Proto:  "HTTP/" + strconv.Itoa(major) + "." + strconv.Itoa(minor),
+		URI:    string(uri),
+		Header: make(http.Header),
+	}
+	r.Path, r.RawQuery, _ = strings.Cut(r.URI, "?")
+	if u, err := url.ParseRequestURI(r.URI); err == nil {
+		// Absolute form of the request target carries the path in URL.
+		r.Path = u.Path
+	}
+	return r
+}
+
+// recordHeader adds header k with value v to h if k is one of keys. All
+// headers are recorded if keys is nil. The k must be canonical.
+func recordHeader(h http.Header, keys []string, k, v string) {
+	if keys != nil && !headerListed(keys, k) {
+		return
+	}
+	h[k] = append(h[k], v)
+}
+
+func headerListed(keys []string, k string) bool {
+	for _, key := range keys {
+		if strings.EqualFold(key, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordHTTPRequest returns the record of the request r.
+func recordHTTPRequest(r *http.Request, keys []string) *HandshakeRequest {
+	uri := r.RequestURI
+	if uri == "" {
+		uri = r.URL.RequestURI()
+	}
+	hr := &HandshakeRequest{
+		Method:   r.Method,
+		Proto:    r.Proto,
+		URI:      uri,
+		Path:     r.URL.Path,
+		RawQuery: r.URL.RawQuery,
+		Host:     r.Host,
+		Header:   make(http.Header),
+	}
+	for k, vs := range r.Header {
+		for _, v := range vs {
+			recordHeader(hr.Header, keys, k, v)
+		}
+	}
+	return hr
+}
+
+// recordRequestWithConn returns the record of the request r which also
+// carries RemoteAddr and Peer of the client connection hs was made on. Such
+// record is passed to Authenticator, which may check the connection too.
+func (hs Handshake) recordRequestWithConn(r *http.Request, keys []string) *HandshakeRequest {
+	req := recordHTTPRequest(r, keys)
+	req.RemoteAddr, req.Peer = hs.RemoteAddr, hs.Peer
+	return req
+}
@@ -0,0 +1,154 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package mogusocket
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func recordTestRequest() *http.Request {
+	h := make(http.Header)
+	h.Set(headerUpgrade, "websocket")
+	h.Set(headerConnection, "Upgrade")
+	h.Set(headerSecVersion, "13")
+	h.Set(headerSecKey, string(mustMakeNonce()))
+	h.Set("X-Tenant", "7")
+	h.Set("Authorization", "Bearer abc")
+	return mustMakeRequest("GET", "ws://example.org/chat?token=abc&tenant=7", h)
+}
+
+func checkRecordedRequest(t *testing.T, r *HandshakeRequest, keys []string) {
+	t.Helper()
+	if r == nil {
+		t.Fatal("request is not recorded")
+	}
+	if r.Method != "GET" || r.Proto != "HTTP/1.1" {
+		t.Errorf("unexpected request line: %q %q", r.Method, r.Proto)
+	}
+	if r.URI != "/chat?token=abc&tenant=7" || r.Path != "/chat" {
+		t.Errorf("unexpected uri: %q path %q", r.URI, r.Path)
+	}
+	if q := r.Query(); q.Get("token") != "abc" || q.Get("tenant") != "7" {
+		t.Errorf("unexpected query: %v", q)
+	}
+	if r.Host != "example.org" {
+		t.Errorf("unexpected host: %q", r.Host)
+	}
+	if v := r.Header.Get("X-Tenant"); v != "7" {
+		t.Errorf("unexpected X-Tenant: %q", v)
+	}
+	if _, ok := r.Header["Host"]; ok {
+		t.Errorf("host is recorded in header")
+	}
+	if keys == nil {
+		if r.Header.Get("Authorization") != "Bearer abc" || r.Header.Get(headerSecVersion) != "13" {
+			t.Errorf("not all headers are recorded: %v", r.Header)
+		}
+	} else if len(r.Header) != len(keys) {
+		t.Errorf("unexpected recorded headers: %v; want %v", r.Header, keys)
+	}
+}
+
+func TestUpgraderRecordRequest(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		record bool
+		keys   []string
+	}{
+		{name: "disabled"},
+		{name: "all", record: true},
+		{name: "selected", record: true, keys: []string{"x-tenant"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			conn := struct {
+				io.Reader
+				io.Writer
+			}{bytes.NewReader(dumpRequest(recordTestRequest())), io.Discard}
+
+			u := Upgrader{RecordRequest: test.record, RecordHeaders: test.keys}
+			hs, err := u.Upgrade(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !test.record {
+				if hs.Request != nil {
+					t.Fatalf("unexpected recorded request: %+v", hs.Request)
+				}
+				return
+			}
+			checkRecordedRequest(t, hs.Request, test.keys)
+		})
+	}
+}
+
+func TestHTTPUpgraderRecordRequest(t *testing.T) {
+	for _, keys := range [][]string{nil, {"X-Tenant"}} {
+		u := HTTPUpgrader{RecordRequest: true, RecordHeaders: keys}
+		_, _, hs, err := u.Upgrade(recordTestRequest(), newRecorder())
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkRecordedRequest(t, hs.Request, keys)
+	}
+}
+
+func TestDialerRecordResponse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := HTTPUpgrader{Header: http.Header{"X-Server-Id": {"42"}}}
+		if conn, _, _, err := u.Upgrade(r, w); err == nil {
+			conn.Close()
+		}
+	}))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, test := range []struct {
+		name   string
+		dialer Dialer
+		exp    int
+	}{
+		{name: "disabled"},
+		{name: "all", dialer: Dialer{RecordResponse: true}, exp: 4},
+		{name: "selected", dialer: Dialer{RecordResponse: true, RecordHeaders: []string{"x-server-id"}}, exp: 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			conn, _, hs, err := test.dialer.Dial(ctx, url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn.Close()
+
+			r := hs.Response
+			if !test.dialer.RecordResponse {
+				if r != nil {
+					t.Fatalf("unexpected recorded response: %+v", r)
+				}
+				return
+			}
+			if r == nil {
+				t.Fatal("response is not recorded")
+			}
+			if r.Status != http.StatusSwitchingProtocols || r.Reason != "Switching Protocols" {
+				t.Errorf("unexpected status: %d %q", r.Status, r.Reason)
+			}
+			if v := r.Header.Get("X-Server-Id"); v != "42" {
+				t.Errorf("unexpected X-Server-Id: %q", v)
+			}
+			if len(r.Header) != test.exp {
+				t.Errorf("unexpected recorded headers: %v", r.Header)
+			}
+		})
+	}
+}
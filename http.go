@@ -60,6 +60,9 @@ const (
 	headerSecExtensionsCanonical = "Sec-Websocket-Extensions"
 	headerSecKeyCanonical        = "Sec-Websocket-Key"
 	headerSecAcceptCanonical     = "Sec-Websocket-Accept"
+	headerLocationCanonical      = "Location"
+	headerSetCookieCanonical     = "Set-Cookie"
+	headerXForwardedForCanonical = "X-Forwarded-For"
 )
 
 var (
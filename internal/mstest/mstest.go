@@ -0,0 +1,115 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+// Package mstest provides the client side fixtures shared by tests of the
+// packages serving WebSocket sessions.
+package mstest
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	ms "github.com/cmacro/mogusocket"
+	"github.com/cmacro/mogusocket/msutil"
+)
+
+// Timeout limits every read and write of Conn.
+const Timeout = time.Second
+
+// Conn is the client end of a pipe which other end is served by
+// msutil.Connecter. It speaks raw frames and fails the test on any error.
+type Conn struct {
+	net.Conn
+	t testing.TB
+}
+
+// Serve serves h on one end of a pipe and returns the client on the other
+// end. The pipe is closed and the connection is waited for when the test
+// finishes.
+func Serve(t testing.TB, h ms.SessionsHandler) *Conn {
+	t.Helper()
+	sc, cc := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		msutil.NewConnecter(h, ms.Noop).Run(context.Background(), sc)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cc.Close()
+		sc.Close()
+		<-done
+	})
+	return &Conn{Conn: cc, t: t}
+}
+
+// WriteText writes p as a text message.
+func (c *Conn) WriteText(p []byte) {
+	c.t.Helper()
+	c.SetDeadline(time.Now().Add(Timeout))
+	if err := msutil.WriteClientText(c.Conn, p); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// WriteBinary writes p as a binary message.
+func (c *Conn) WriteBinary(p []byte) {
+	c.t.Helper()
+	c.SetDeadline(time.Now().Add(Timeout))
+	if err := msutil.WriteClientBinary(c.Conn, p); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// ReadMessage reads the next data message. Control frames are handled as
+// msutil.ReadServerData() does.
+func (c *Conn) ReadMessage() ([]byte, ms.OpCode) {
+	c.t.Helper()
+	c.SetDeadline(time.Now().Add(Timeout))
+	p, op, err := msutil.ReadServerData(c.Conn)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return p, op
+}
+
+// ReadFrame reads the next frame, including control ones.
+func (c *Conn) ReadFrame() (ms.Header, []byte) {
+	c.t.Helper()
+	c.SetDeadline(time.Now().Add(Timeout))
+	return ReadFrame(c.t, c.Conn)
+}
+
+// ReadFrame reads a single frame from r. The payload of masked frames is
+// unmasked.
+func ReadFrame(t testing.TB, r io.Reader) (ms.Header, []byte) {
+	t.Helper()
+	h, err := ms.ReadHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := make([]byte, h.Length)
+	if _, err := io.ReadFull(r, p); err != nil {
+		t.Fatal(err)
+	}
+	if h.Masked {
+		ms.Cipher(p, h.Mask, 0)
+	}
+	return h, p
+}
+
+// ExpectClose reads a frame from r and fails the test unless it is the close
+// frame with given code.
+func ExpectClose(t testing.TB, r io.Reader, code ms.StatusCode) {
+	t.Helper()
+	h, p := ReadFrame(t, r)
+	if h.OpCode != ms.OpClose {
+		t.Fatalf("unexpected frame: %v %q; want close", h.OpCode, p)
+	}
+	if act, reason := ms.ParseCloseFrameData(p); act != code {
+		t.Fatalf("unexpected close code: %d %q; want %d", act, reason, code)
+	}
+}
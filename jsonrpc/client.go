@@ -0,0 +1,105 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package jsonrpc
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	ms "github.com/cmacro/mogusocket"
+)
+
+// Client is the ms.ClientHandler of JSON-RPC connections. It is used with
+// msutil.ConnectClient() or the msutil clients; methods registered with
+// Register() could be called by the server.
+//
+// The client could be reconnected; calls made while disconnected fail with
+// ErrNotConnected.
+type Client struct {
+	methods
+	log ms.Logger
+
+	// CallTimeout is the timeout of calls when the call context has no
+	// deadline. Zero means no timeout.
+	CallTimeout time.Duration
+
+	mu   sync.Mutex
+	conn *Conn
+}
+
+// NewClient returns Client without registered methods.
+func NewClient(log ms.Logger) *Client {
+	return &Client{log: log}
+}
+
+// Connect implements ms.ClientHandler.
+func (c *Client) Connect(ctx context.Context, w ms.SendFunc, cancel func()) error {
+	conn := newConn(ctx, w, cancel, &c.methods, c.CallTimeout, c.log)
+	c.mu.Lock()
+	c.conn = conn
+	c.mu.Unlock()
+	return nil
+}
+
+// ReadPump implements ms.ClientHandler.
+func (c *Client) ReadPump(r io.Reader, len int64, isText bool) error {
+	conn := c.Conn()
+	if conn == nil {
+		return ErrNotConnected
+	}
+	p, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	conn.handle(p)
+	return nil
+}
+
+// Close implements ms.ClientHandler. Pending calls fail with ErrClosed.
+func (c *Client) Close() {
+	c.mu.Lock()
+	conn := c.conn
+	c.conn = nil
+	c.mu.Unlock()
+	if conn != nil {
+		conn.shutdown()
+	}
+}
+
+// Conn returns the current connection or nil if the client is not connected.
+func (c *Client) Conn() *Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn
+}
+
+// Call calls the method of the server. See Conn.Call().
+func (c *Client) Call(ctx context.Context, method string, params, result interface{}) error {
+	conn := c.Conn()
+	if conn == nil {
+		return ErrNotConnected
+	}
+	return conn.Call(ctx, method, params, result)
+}
+
+// Notify sends a notification to the server. See Conn.Notify().
+func (c *Client) Notify(ctx context.Context, method string, params interface{}) error {
+	conn := c.Conn()
+	if conn == nil {
+		return ErrNotConnected
+	}
+	return conn.Notify(ctx, method, params)
+}
+
+// Batch sends a batch request to the server. See Conn.Batch().
+func (c *Client) Batch(ctx context.Context, b []BatchElem) error {
+	conn := c.Conn()
+	if conn == nil {
+		return ErrNotConnected
+	}
+	return conn.Batch(ctx, b)
+}
@@ -0,0 +1,483 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+// Package jsonrpc implements JSON-RPC 2.0 over mogusocket sessions.
+//
+// Both sides of a connection could register methods and call methods of the
+// peer. Server implements ms.SessionsHandler and Client implements
+// ms.ClientHandler, so they are served by msutil.Connecter and msutil
+// clients as any other session.
+//
+// See https://www.jsonrpc.org/specification
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	ms "github.com/cmacro/mogusocket"
+	"github.com/cmacro/mogusocket/msutil"
+)
+
+// Protocol is the WebSocket subprotocol name of JSON-RPC connections.
+const Protocol = "jsonrpc"
+
+func init() {
+	// Make msutil.CodecProtocol negotiate JSON-RPC connections.
+	msutil.RegisterCodec(Protocol, msutil.JSON)
+}
+
+// AcceptProtocol reports whether p is the JSON-RPC subprotocol. It could be
+// used as ms.Upgrader.Protocol.
+func AcceptProtocol(p []byte) bool {
+	return string(p) == Protocol
+}
+
+const version = "2.0"
+
+var (
+	ErrClosed       = errors.New("jsonrpc: connection closed")
+	ErrNotConnected = errors.New("jsonrpc: not connected")
+	ErrProtocol     = errors.New("jsonrpc: subprotocol is not negotiated")
+)
+
+// Error codes defined by the specification.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+)
+
+// Error is the JSON-RPC error object. Handlers could return it to control the
+// error sent to the caller; other errors are sent with CodeInternalError.
+type Error struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// NewError returns Error with given code and message.
+func NewError(code int, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Error implements error interface.
+func (e *Error) Error() string {
+	return "jsonrpc: " + strconv.Itoa(e.Code) + " " + e.Message
+}
+
+// DecodeParams decodes raw params into v. Its error is *Error with
+// CodeInvalidParams, so it could be returned from a handler as is.
+func DecodeParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 {
+		return NewError(CodeInvalidParams, "missing params")
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return &Error{Code: CodeInvalidParams, Message: "invalid params", Data: err.Error()}
+	}
+	return nil
+}
+
+// HandlerFunc handles a call of the registered method. The ctx carries the
+// Conn the call came from, see ConnFromContext(). The result is encoded with
+// encoding/json.
+type HandlerFunc func(ctx context.Context, params json.RawMessage) (result interface{}, err error)
+
+// methods is the registry of method handlers.
+type methods struct {
+	mu sync.RWMutex
+	m  map[string]HandlerFunc
+}
+
+// Register makes h to handle calls of the method.
+func (m *methods) Register(method string, h HandlerFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.m == nil {
+		m.m = make(map[string]HandlerFunc)
+	}
+	m.m[method] = h
+}
+
+func (m *methods) lookup(method string) (HandlerFunc, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	h, ok := m.m[method]
+	return h, ok
+}
+
+// message is any of request, notification or response objects.
+type message struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+func (m *message) isRequest() bool {
+	return m.Method != ""
+}
+
+func (m *message) isResponse() bool {
+	return m.Method == "" && m.ID != nil && (m.Result != nil || m.Error != nil)
+}
+
+var null = json.RawMessage("null")
+
+func newRequest(id uint64, notify bool, method string, params interface{}) (*message, error) {
+	m := &message{
+		Version: version,
+		Method:  method,
+	}
+	if !notify {
+		m.ID = json.RawMessage(strconv.FormatUint(id, 10))
+	}
+	if params != nil {
+		p, err := json.Marshal(params)
+		if err != nil {
+			return nil, err
+		}
+		m.Params = p
+	}
+	return m, nil
+}
+
+func newResponse(id json.RawMessage, result interface{}, err error) *message {
+	m := &message{
+		Version: version,
+		ID:      id,
+	}
+	if m.ID == nil {
+		m.ID = null
+	}
+	if err == nil {
+		p, e := json.Marshal(result)
+		if e == nil {
+			m.Result = p
+			return m
+		}
+		err = e
+	}
+	var rerr *Error
+	if !errors.As(err, &rerr) {
+		rerr = NewError(CodeInternalError, err.Error())
+	}
+	m.Error = rerr
+	return m
+}
+
+// BatchElem is an element of the batch request.
+type BatchElem struct {
+	Method string
+	Params interface{}
+
+	// Result is the value the call result is decoded into. It could be nil
+	// to drop the result.
+	Result interface{}
+
+	// Notify makes the element a notification, which has no response.
+	Notify bool
+
+	// Error is set after the batch call returns. It is *Error if the peer
+	// has answered the element with error object.
+	Error error
+}
+
+type connKey struct{}
+
+// ConnFromContext returns the Conn of the call handler context. It could be
+// used to call back the caller.
+func ConnFromContext(ctx context.Context) *Conn {
+	c, _ := ctx.Value(connKey{}).(*Conn)
+	return c
+}
+
+// Conn is one side of the JSON-RPC connection. It makes calls to the peer and
+// dispatches the peer's calls to registered methods.
+type Conn struct {
+	ctx     context.Context
+	cancel  func()
+	send    ms.SendFunc
+	methods *methods
+	log     ms.Logger
+	timeout time.Duration
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[string]chan *message
+	closed  bool
+	done    chan struct{}
+}
+
+func newConn(ctx context.Context, send ms.SendFunc, cancel func(), m *methods, timeout time.Duration, log ms.Logger) *Conn {
+	c := &Conn{
+		cancel:  cancel,
+		send:    send,
+		methods: m,
+		log:     log,
+		timeout: timeout,
+		pending: make(map[string]chan *message),
+		done:    make(chan struct{}),
+	}
+	c.ctx = context.WithValue(ctx, connKey{}, c)
+	return c
+}
+
+// Context returns the session context of the connection. It is done when the
+// connection is closed.
+func (c *Conn) Context() context.Context {
+	return c.ctx
+}
+
+// Close closes the underlying session.
+func (c *Conn) Close() {
+	c.cancel()
+}
+
+// Call calls the method of the peer and decodes its result into result, if
+// it is non-nil. The returned error is *Error if the peer has answered with
+// error object.
+//
+// If ctx has no deadline, the call timeout of Server or Client is applied.
+func (c *Conn) Call(ctx context.Context, method string, params, result interface{}) error {
+	b := []BatchElem{{Method: method, Params: params, Result: result}}
+	if err := c.call(ctx, b, false); err != nil {
+		return err
+	}
+	return b[0].Error
+}
+
+// Notify sends a notification to the peer. Notifications have no response.
+func (c *Conn) Notify(ctx context.Context, method string, params interface{}) error {
+	return c.call(ctx, []BatchElem{{Method: method, Params: params, Notify: true}}, false)
+}
+
+// Batch sends all elements with a single batch request and waits for the
+// responses. Errors of the elements are set to their Error fields; the
+// returned error is non-nil only if the batch could not be done as a whole.
+func (c *Conn) Batch(ctx context.Context, b []BatchElem) error {
+	if len(b) == 0 {
+		return nil
+	}
+	return c.call(ctx, b, true)
+}
+
+func (c *Conn) call(ctx context.Context, b []BatchElem, batch bool) error {
+	if _, ok := ctx.Deadline(); !ok && c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	var (
+		msgs    = make([]*message, len(b))
+		waiting = make(map[string]int, len(b))
+		replies = make(chan *message, len(b))
+	)
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	for i := range b {
+		c.nextID++
+		m, err := newRequest(c.nextID, b[i].Notify, b[i].Method, b[i].Params)
+		if err != nil {
+			c.mu.Unlock()
+			c.unregister(waiting)
+			return err
+		}
+		msgs[i] = m
+		if !b[i].Notify {
+			id := string(m.ID)
+			waiting[id] = i
+			c.pending[id] = replies
+		}
+	}
+	c.mu.Unlock()
+	defer c.unregister(waiting)
+
+	var (
+		p   []byte
+		err error
+	)
+	if batch {
+		p, err = json.Marshal(msgs)
+	} else {
+		p, err = json.Marshal(msgs[0])
+	}
+	if err != nil {
+		return err
+	}
+	if err = c.send(bytes.NewReader(p), true); err != nil {
+		return err
+	}
+
+	for n := len(waiting); n > 0; n-- {
+		select {
+		case m := <-replies:
+			i := waiting[string(m.ID)]
+			switch {
+			case m.Error != nil:
+				b[i].Error = m.Error
+			case b[i].Result != nil:
+				b[i].Error = json.Unmarshal(m.Result, b[i].Result)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.done:
+			return ErrClosed
+		}
+	}
+	return nil
+}
+
+func (c *Conn) unregister(waiting map[string]int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id := range waiting {
+		delete(c.pending, id)
+	}
+}
+
+// shutdown fails pending calls. It is called when the session is closed.
+func (c *Conn) shutdown() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.done)
+	}
+}
+
+// handle dispatches the message read from the peer.
+func (c *Conn) handle(p []byte) {
+	p = bytes.TrimSpace(p)
+	if len(p) > 0 && p[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(p, &batch); err != nil {
+			c.reply(newResponse(nil, nil, NewError(CodeParseError, "parse error")))
+			return
+		}
+		if len(batch) == 0 {
+			c.reply(newResponse(nil, nil, NewError(CodeInvalidRequest, "empty batch")))
+			return
+		}
+		go c.handleBatch(batch)
+		return
+	}
+
+	var m message
+	if err := json.Unmarshal(p, &m); err != nil {
+		c.reply(newResponse(nil, nil, NewError(CodeParseError, "parse error")))
+		return
+	}
+	if m.isResponse() {
+		c.deliver(&m)
+		return
+	}
+	go func() {
+		if resp := c.serve(&m); resp != nil {
+			c.reply(resp)
+		}
+	}()
+}
+
+func (c *Conn) handleBatch(batch []json.RawMessage) {
+	var (
+		wg    sync.WaitGroup
+		resps = make([]*message, len(batch))
+	)
+	for i, raw := range batch {
+		var m message
+		if err := json.Unmarshal(raw, &m); err != nil {
+			resps[i] = newResponse(nil, nil, NewError(CodeInvalidRequest, "invalid request"))
+			continue
+		}
+		if m.isResponse() {
+			c.deliver(&m)
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resps[i] = c.serve(&m)
+		}(i)
+	}
+	wg.Wait()
+
+	out := resps[:0]
+	for _, r := range resps {
+		if r != nil {
+			out = append(out, r)
+		}
+	}
+	if len(out) == 0 {
+		return
+	}
+	p, err := json.Marshal(out)
+	if err != nil {
+		c.log.Error("jsonrpc marshal batch", err)
+		return
+	}
+	if err := c.send(bytes.NewReader(p), true); err != nil {
+		c.log.Info("jsonrpc send batch", err)
+	}
+}
+
+// serve calls the handler of the request. It returns nil for notifications.
+func (c *Conn) serve(m *message) *message {
+	if m.Version != version || !m.isRequest() {
+		return newResponse(m.ID, nil, NewError(CodeInvalidRequest, "invalid request"))
+	}
+	h, ok := c.methods.lookup(m.Method)
+	var (
+		result interface{}
+		err    error
+	)
+	if ok {
+		result, err = h(c.ctx, m.Params)
+	} else {
+		err = NewError(CodeMethodNotFound, "method not found")
+	}
+	if m.ID == nil {
+		if err != nil {
+			c.log.Debug("jsonrpc notification", m.Method, err)
+		}
+		return nil
+	}
+	return newResponse(m.ID, result, err)
+}
+
+func (c *Conn) deliver(m *message) {
+	c.mu.Lock()
+	ch, ok := c.pending[string(m.ID)]
+	delete(c.pending, string(m.ID))
+	c.mu.Unlock()
+	if ok {
+		ch <- m
+	} else {
+		c.log.Debug("jsonrpc unexpected response", string(m.ID))
+	}
+}
+
+func (c *Conn) reply(m *message) {
+	p, err := json.Marshal(m)
+	if err != nil {
+		c.log.Error("jsonrpc marshal response", err)
+		return
+	}
+	if err := c.send(bytes.NewReader(p), true); err != nil {
+		c.log.Info("jsonrpc send response", err)
+	}
+}
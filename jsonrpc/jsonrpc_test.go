@@ -0,0 +1,340 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ms "github.com/cmacro/mogusocket"
+	"github.com/cmacro/mogusocket/msutil"
+)
+
+type addParams struct {
+	A, B int
+}
+
+func newTestServer() *Server {
+	s := NewServer(ms.Noop)
+	s.Register("add", func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+		var p addParams
+		if err := DecodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		return p.A + p.B, nil
+	})
+	s.Register("fail", func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+		return nil, &Error{Code: 42, Message: "failed", Data: "details"}
+	})
+	s.Register("panic", func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+		return nil, errors.New("oops")
+	})
+	s.Register("sleep", func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+		time.Sleep(200 * time.Millisecond)
+		return nil, nil
+	})
+	// greet calls back the client for the name.
+	s.Register("greet", func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+		var name string
+		if err := ConnFromContext(ctx).Call(ctx, "name", nil, &name); err != nil {
+			return nil, err
+		}
+		return "hello, " + name, nil
+	})
+	return s
+}
+
+// serve serves s on one end of a pipe and returns the other end.
+func serve(t *testing.T, s *Server) net.Conn {
+	t.Helper()
+	sc, cc := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		msutil.NewConnecter(s, ms.Noop).Run(context.Background(), sc)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cc.Close()
+		sc.Close()
+		<-done
+	})
+	return cc
+}
+
+// connect connects c to s and returns when the client is ready.
+func connect(t *testing.T, s *Server, c *Client) {
+	t.Helper()
+	conn := serve(t, s)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		msutil.ConnectClient(ctx, conn, c, ms.Noop)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	for c.Conn() == nil {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCall(t *testing.T) {
+	s := newTestServer()
+	c := NewClient(ms.Noop)
+	c.Register("name", func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+		return "gopher", nil
+	})
+	connect(t, s, c)
+	ctx := context.Background()
+
+	var sum int
+	if err := c.Call(ctx, "add", addParams{1, 2}, &sum); err != nil {
+		t.Fatal(err)
+	}
+	if sum != 3 {
+		t.Fatalf("unexpected result: %d", sum)
+	}
+
+	var greeting string
+	if err := c.Call(ctx, "greet", nil, &greeting); err != nil {
+		t.Fatal(err)
+	}
+	if greeting != "hello, gopher" {
+		t.Fatalf("unexpected result: %q", greeting)
+	}
+
+	for _, test := range []struct {
+		method string
+		params interface{}
+		code   int
+	}{
+		{method: "missing", code: CodeMethodNotFound},
+		{method: "add", code: CodeInvalidParams},
+		{method: "add", params: "str", code: CodeInvalidParams},
+		{method: "fail", code: 42},
+		{method: "panic", code: CodeInternalError},
+	} {
+		err := c.Call(ctx, test.method, test.params, nil)
+		var rerr *Error
+		if !errors.As(err, &rerr) {
+			t.Fatalf("%s: unexpected error: %v", test.method, err)
+		}
+		if rerr.Code != test.code {
+			t.Errorf("%s: unexpected code: %d; want %d", test.method, rerr.Code, test.code)
+		}
+	}
+}
+
+func TestCallTimeout(t *testing.T) {
+	s := newTestServer()
+	c := NewClient(ms.Noop)
+	c.CallTimeout = 50 * time.Millisecond
+	connect(t, s, c)
+
+	err := c.Call(context.Background(), "sleep", nil, nil)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Context deadline takes precedence over the call timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := c.Call(ctx, "sleep", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNotConnected(t *testing.T) {
+	c := NewClient(ms.Noop)
+	if err := c.Call(context.Background(), "add", nil, nil); err != ErrNotConnected {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestNotify(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		got   []string
+		conns = make(chan *Conn, 1)
+	)
+	s := newTestServer()
+	s.Register("log", func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+		var msg string
+		json.Unmarshal(params, &msg)
+		mu.Lock()
+		got = append(got, msg)
+		mu.Unlock()
+		return nil, nil
+	})
+	s.OnConnect = func(c *Conn) { conns <- c }
+
+	c := NewClient(ms.Noop)
+	events := make(chan string, 1)
+	c.Register("event", func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+		var msg string
+		json.Unmarshal(params, &msg)
+		events <- msg
+		return nil, nil
+	})
+	connect(t, s, c)
+	ctx := context.Background()
+
+	if err := c.Notify(ctx, "log", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	// Notification errors are not reported.
+	if err := c.Notify(ctx, "missing", nil); err != nil {
+		t.Fatal(err)
+	}
+	// Requests are handled concurrently, so wait for the notification.
+	if err := c.Call(ctx, "add", addParams{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		mu.Lock()
+		n := len(got)
+		mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if i == 100 {
+			t.Fatal("notification is not handled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Server notifies the client.
+	if err := (<-conns).Notify(ctx, "event", "tick"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-events:
+		if msg != "tick" {
+			t.Fatalf("unexpected event: %q", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no event")
+	}
+}
+
+func TestBatch(t *testing.T) {
+	s := newTestServer()
+	c := NewClient(ms.Noop)
+	connect(t, s, c)
+
+	var a, b int
+	batch := []BatchElem{
+		{Method: "add", Params: addParams{1, 1}, Result: &a},
+		{Method: "missing"},
+		{Method: "log", Notify: true},
+		{Method: "add", Params: []int{1}, Result: &b},
+		{Method: "add", Params: addParams{2, 3}, Result: &b},
+	}
+	if err := c.Batch(context.Background(), batch); err != nil {
+		t.Fatal(err)
+	}
+	if a != 2 || b != 5 {
+		t.Fatalf("unexpected results: %d, %d", a, b)
+	}
+	for i, code := range []int{0, CodeMethodNotFound, 0, CodeInvalidParams, 0} {
+		var rerr *Error
+		errors.As(batch[i].Error, &rerr)
+		switch {
+		case code == 0 && batch[i].Error != nil:
+			t.Errorf("#%d: unexpected error: %v", i, batch[i].Error)
+		case code != 0 && (rerr == nil || rerr.Code != code):
+			t.Errorf("#%d: unexpected error: %v; want code %d", i, batch[i].Error, code)
+		}
+	}
+}
+
+func TestClosePendingCalls(t *testing.T) {
+	s := newTestServer()
+	c := NewClient(ms.Noop)
+	connect(t, s, c)
+
+	conn := c.Conn()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- conn.Call(context.Background(), "sleep", nil, nil)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	c.Close()
+	if err := <-errc; err != ErrClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := conn.Call(context.Background(), "add", nil, nil); err != ErrClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestInvalidMessages(t *testing.T) {
+	conn := serve(t, newTestServer())
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{conn, conn}
+
+	for _, test := range []struct {
+		in  string
+		exp string
+	}{
+		{
+			in:  `{"jsonrpc":"2.0","method":"add","params":{"A":1,"B":2},"id":"x"}`,
+			exp: `{"jsonrpc":"2.0","id":"x","result":3}`,
+		},
+		{
+			in:  `{"jsonrpc":"2.0","method":`,
+			exp: `{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"parse error"}}`,
+		},
+		{
+			in:  `[]`,
+			exp: `{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"empty batch"}}`,
+		},
+		{
+			in:  `{"jsonrpc":"1.0","method":"add","id":1}`,
+			exp: `{"jsonrpc":"2.0","id":1,"error":{"code":-32600,"message":"invalid request"}}`,
+		},
+		{
+			in: `[1,{"jsonrpc":"2.0","method":"add","params":[]},` +
+				`{"jsonrpc":"2.0","method":"fail","id":2}]`,
+			exp: `[{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"invalid request"}},` +
+				`{"jsonrpc":"2.0","id":2,"error":{"code":42,"message":"failed","data":"details"}}]`,
+		},
+	} {
+		conn.SetDeadline(time.Now().Add(time.Second))
+		if err := msutil.WriteClientText(rw, []byte(test.in)); err != nil {
+			t.Fatal(err)
+		}
+		act, err := msutil.ReadServerText(rw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.TrimSpace(string(act)) != test.exp {
+			t.Errorf("%s:\n got %s\nwant %s", test.in, act, test.exp)
+		}
+	}
+}
+
+func TestProtocol(t *testing.T) {
+	if !AcceptProtocol([]byte(Protocol)) {
+		t.Fatal("protocol is not accepted")
+	}
+	if _, ok := msutil.LookupCodec(Protocol); !ok {
+		t.Fatal("codec is not registered")
+	}
+	if !msutil.CodecProtocol([]byte(Protocol)) {
+		t.Fatal("protocol is not negotiated by codecs")
+	}
+}
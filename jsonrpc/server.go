@@ -0,0 +1,112 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package jsonrpc
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ms "github.com/cmacro/mogusocket"
+	"github.com/cmacro/mogusocket/msutil"
+)
+
+// Server is the ms.SessionsHandler serving JSON-RPC connections. Methods are
+// registered with Register() and are available to all connections.
+type Server struct {
+	methods
+	log ms.Logger
+
+	// CallTimeout is the timeout of calls made to clients when the call
+	// context has no deadline. Zero means no timeout.
+	CallTimeout time.Duration
+
+	// OnConnect, if set, is called with each new connection. The connection
+	// could be used to call methods of the client.
+	OnConnect func(c *Conn)
+
+	// OnClose, if set, is called when the connection is closed.
+	OnClose func(c *Conn)
+
+	lastID int64
+	mu     sync.Mutex
+	conns  map[int64]*Conn
+}
+
+// NewServer returns Server without registered methods.
+func NewServer(log ms.Logger) *Server {
+	return &Server{
+		log:   log,
+		conns: make(map[int64]*Conn),
+	}
+}
+
+// Connect implements ms.SessionsHandler. If the session has made a
+// handshake, the "jsonrpc" subprotocol must be negotiated.
+func (s *Server) Connect(ctx context.Context, w ms.SendFunc, c func()) (ms.SessionHandler, error) {
+	if hs, ok := msutil.HandshakeFromContext(ctx); ok && hs.Protocol != Protocol {
+		return nil, ErrProtocol
+	}
+	sess := &serverSession{
+		id:   atomic.AddInt64(&s.lastID, 1),
+		conn: newConn(ctx, w, c, &s.methods, s.CallTimeout, s.log),
+	}
+	s.mu.Lock()
+	s.conns[sess.id] = sess.conn
+	s.mu.Unlock()
+
+	if s.OnConnect != nil {
+		s.OnConnect(sess.conn)
+	}
+	return sess, nil
+}
+
+// Close implements ms.SessionsHandler.
+func (s *Server) Close(session ms.SessionHandler) error {
+	sess, ok := session.(*serverSession)
+	if !ok {
+		return nil
+	}
+	s.mu.Lock()
+	delete(s.conns, sess.id)
+	s.mu.Unlock()
+
+	sess.conn.shutdown()
+	if s.OnClose != nil {
+		s.OnClose(sess.conn)
+	}
+	return nil
+}
+
+// Conns returns the connections currently served.
+func (s *Server) Conns() []*Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conns := make([]*Conn, 0, len(s.conns))
+	for _, c := range s.conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+type serverSession struct {
+	id   int64
+	conn *Conn
+}
+
+func (s *serverSession) GetId() int64 { return s.id }
+
+func (s *serverSession) Close() { s.conn.Close() }
+
+func (s *serverSession) ReadPump(r io.Reader, len int64, isText bool) error {
+	p, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.conn.handle(p)
+	return nil
+}
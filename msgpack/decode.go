@@ -0,0 +1,816 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package msgpack
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"time"
+)
+
+// ErrTrailingData is returned by Unmarshal() when data contains something
+// after the decoded value.
+var ErrTrailingData = errors.New("msgpack: trailing data after value")
+
+// ErrInvalidCode is returned when the decoded stream contains a byte which is
+// not a valid format code.
+var ErrInvalidCode = errors.New("msgpack: invalid format code")
+
+// InvalidUnmarshalError describes an invalid argument passed to Unmarshal() or
+// Decode(). The argument must be a non-nil pointer.
+type InvalidUnmarshalError struct {
+	Type reflect.Type
+}
+
+func (e *InvalidUnmarshalError) Error() string {
+	if e.Type == nil {
+		return "msgpack: Unmarshal(nil)"
+	}
+	if e.Type.Kind() != reflect.Ptr {
+		return "msgpack: Unmarshal(non-pointer " + e.Type.String() + ")"
+	}
+	return "msgpack: Unmarshal(nil " + e.Type.String() + ")"
+}
+
+// UnmarshalTypeError describes a MessagePack value that was not appropriate
+// for a value of a specific Go type.
+type UnmarshalTypeError struct {
+	Value string       // description of MessagePack value
+	Type  reflect.Type // type of Go value it could not be assigned to
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	return "msgpack: cannot unmarshal " + e.Value + " into Go value of type " + e.Type.String()
+}
+
+// Unmarshal decodes MessagePack value from data and stores the result in the
+// value pointed to by v.
+func Unmarshal(data []byte, v interface{}) error {
+	d := NewDecoder(bytes.NewReader(data))
+	if err := d.Decode(v); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if err := d.Skip(); err != io.EOF {
+		if err == nil {
+			err = ErrTrailingData
+		}
+		return err
+	}
+	return nil
+}
+
+// allocLimit limits the size of slices allocated upfront by the length read
+// from the stream. Longer slices grow as the data actually arrives.
+const allocLimit = 1 << 16
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// Decoder reads MessagePack values from an input stream.
+type Decoder struct {
+	r       byteReader
+	scratch [8]byte
+}
+
+// NewDecoder returns a new decoder that reads from r. If r does not implement
+// io.ByteReader, the decoder wraps it into bufio.Reader and may read more
+// bytes than needed for the decoded values.
+func NewDecoder(r io.Reader) *Decoder {
+	br, ok := r.(byteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &Decoder{r: br}
+}
+
+// Decode reads the next value from the stream and stores it in the value
+// pointed to by v. It returns io.EOF if there are no more values.
+func (d *Decoder) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return &InvalidUnmarshalError{reflect.TypeOf(v)}
+	}
+	code, err := d.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	return d.decode(code, rv.Elem())
+}
+
+// Skip reads the next value from the stream dropping it. It returns io.EOF if
+// there are no more values.
+func (d *Decoder) Skip() error {
+	code, err := d.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	return d.skip(code)
+}
+
+// readCode reads a format code inside of a value.
+func (d *Decoder) readCode() (byte, error) {
+	code, err := d.r.ReadByte()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return code, err
+}
+
+func (d *Decoder) readFull(p []byte) error {
+	_, err := io.ReadFull(d.r, p)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (d *Decoder) readUint(size int) (uint64, error) {
+	p := d.scratch[:size]
+	if err := d.readFull(p); err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return uint64(p[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(p)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(p)), nil
+	default:
+		return binary.BigEndian.Uint64(p), nil
+	}
+}
+
+func (d *Decoder) readBytes(n int) ([]byte, error) {
+	if n <= allocLimit {
+		p := make([]byte, n)
+		return p, d.readFull(p)
+	}
+	var buf bytes.Buffer
+	m, err := io.CopyN(&buf, d.r, int64(n))
+	if m < int64(n) && err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return buf.Bytes(), err
+}
+
+func (d *Decoder) discard(n int) error {
+	m, err := io.CopyN(io.Discard, d.r, int64(n))
+	if m < int64(n) && err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// kind is the family of MessagePack format.
+type kind int
+
+const (
+	kindInvalid kind = iota
+	kindNil
+	kindBool
+	kindInt
+	kindUint
+	kindFloat
+	kindStr
+	kindBin
+	kindArray
+	kindMap
+	kindExt
+)
+
+var kindNames = [...]string{
+	kindInvalid: "invalid",
+	kindNil:     "nil",
+	kindBool:    "bool",
+	kindInt:     "int",
+	kindUint:    "int",
+	kindFloat:   "float",
+	kindStr:     "str",
+	kindBin:     "bin",
+	kindArray:   "array",
+	kindMap:     "map",
+	kindExt:     "ext",
+}
+
+func (k kind) String() string {
+	return kindNames[k]
+}
+
+func kindOf(code byte) kind {
+	switch {
+	case code <= 0x7f:
+		return kindUint
+	case code <= 0x8f:
+		return kindMap
+	case code <= 0x9f:
+		return kindArray
+	case code <= 0xbf:
+		return kindStr
+	case code >= codeNegFix:
+		return kindInt
+	}
+	switch code {
+	case codeNil:
+		return kindNil
+	case codeFalse, codeTrue:
+		return kindBool
+	case codeBin8, codeBin16, codeBin32:
+		return kindBin
+	case codeExt8, codeExt16, codeExt32, codeFixExt1, codeFixExt2, codeFixExt4, codeFixExt8, codeFixExt16:
+		return kindExt
+	case codeFloat32, codeFloat64:
+		return kindFloat
+	case codeUint8, codeUint16, codeUint32, codeUint64:
+		return kindUint
+	case codeInt8, codeInt16, codeInt32, codeInt64:
+		return kindInt
+	case codeStr8, codeStr16, codeStr32:
+		return kindStr
+	case codeArray16, codeArray32:
+		return kindArray
+	case codeMap16, codeMap32:
+		return kindMap
+	}
+	return kindInvalid
+}
+
+// readLen reads the length of str, bin, array, map or ext value.
+func (d *Decoder) readLen(code byte) (int, error) {
+	var size int
+	switch code {
+	case codeStr8, codeBin8, codeExt8:
+		size = 1
+	case codeStr16, codeBin16, codeExt16, codeArray16, codeMap16:
+		size = 2
+	case codeStr32, codeBin32, codeExt32, codeArray32, codeMap32:
+		size = 4
+	case codeFixExt1:
+		return 1, nil
+	case codeFixExt2:
+		return 2, nil
+	case codeFixExt4:
+		return 4, nil
+	case codeFixExt8:
+		return 8, nil
+	case codeFixExt16:
+		return 16, nil
+	default:
+		switch kindOf(code) {
+		case kindMap:
+			return int(code & 0x0f), nil
+		case kindArray:
+			return int(code & 0x0f), nil
+		case kindStr:
+			return int(code & 0x1f), nil
+		}
+		return 0, ErrInvalidCode
+	}
+	n, err := d.readUint(size)
+	if n > math.MaxInt32 {
+		// Could not be a valid length on 32-bit platforms.
+		return 0, fmt.Errorf("msgpack: length %d is too large", n)
+	}
+	return int(n), err
+}
+
+// readInt reads integer value. The result is in u if the value is
+// non-negative, otherwise it is in i.
+func (d *Decoder) readInt(code byte) (i int64, u uint64, neg bool, err error) {
+	switch {
+	case code <= 0x7f:
+		return 0, uint64(code), false, nil
+	case code >= codeNegFix:
+		return int64(int8(code)), 0, true, nil
+	}
+	switch code {
+	case codeUint8:
+		u, err = d.readUint(1)
+	case codeUint16:
+		u, err = d.readUint(2)
+	case codeUint32:
+		u, err = d.readUint(4)
+	case codeUint64:
+		u, err = d.readUint(8)
+	default:
+		var x uint64
+		switch code {
+		case codeInt8:
+			x, err = d.readUint(1)
+			i = int64(int8(x))
+		case codeInt16:
+			x, err = d.readUint(2)
+			i = int64(int16(x))
+		case codeInt32:
+			x, err = d.readUint(4)
+			i = int64(int32(x))
+		case codeInt64:
+			x, err = d.readUint(8)
+			i = int64(x)
+		}
+		if i >= 0 {
+			return 0, uint64(i), false, err
+		}
+		return i, 0, true, err
+	}
+	return 0, u, false, err
+}
+
+func (d *Decoder) readFloat(code byte) (float64, error) {
+	if code == codeFloat32 {
+		x, err := d.readUint(4)
+		return float64(math.Float32frombits(uint32(x))), err
+	}
+	x, err := d.readUint(8)
+	return math.Float64frombits(x), err
+}
+
+// readExt reads extension type and data.
+func (d *Decoder) readExt(code byte) (int8, []byte, error) {
+	n, err := d.readLen(code)
+	if err != nil {
+		return 0, nil, err
+	}
+	typ, err := d.readCode()
+	if err != nil {
+		return 0, nil, err
+	}
+	p, err := d.readBytes(n)
+	return int8(typ), p, err
+}
+
+func parseTime(p []byte) (time.Time, error) {
+	switch len(p) {
+	case 4:
+		return time.Unix(int64(binary.BigEndian.Uint32(p)), 0), nil
+	case 8:
+		x := binary.BigEndian.Uint64(p)
+		return time.Unix(int64(x&(1<<34-1)), int64(x>>34)), nil
+	case 12:
+		nsec := binary.BigEndian.Uint32(p)
+		sec := binary.BigEndian.Uint64(p[4:])
+		return time.Unix(int64(sec), int64(nsec)), nil
+	}
+	return time.Time{}, fmt.Errorf("msgpack: invalid timestamp length %d", len(p))
+}
+
+func (d *Decoder) decode(code byte, v reflect.Value) error {
+	k := kindOf(code)
+	if k == kindInvalid {
+		return ErrInvalidCode
+	}
+	if k == kindNil {
+		switch v.Kind() {
+		case reflect.Interface, reflect.Ptr, reflect.Map, reflect.Slice:
+			v.Set(reflect.Zero(v.Type()))
+		}
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.decode(code, v.Elem())
+
+	case reflect.Interface:
+		if !v.IsNil() && v.Elem().Kind() == reflect.Ptr && !v.Elem().IsNil() {
+			return d.decode(code, v.Elem())
+		}
+		if v.NumMethod() != 0 {
+			return d.typeError(code, v.Type())
+		}
+		x, err := d.decodeInterface(code)
+		if err != nil {
+			return err
+		}
+		if x == nil {
+			v.Set(reflect.Zero(v.Type()))
+		} else {
+			v.Set(reflect.ValueOf(x))
+		}
+		return nil
+	}
+
+	if v.Type() == timeType {
+		if k != kindExt {
+			return d.typeError(code, v.Type())
+		}
+		typ, p, err := d.readExt(code)
+		if err != nil {
+			return err
+		}
+		if typ != extTimestamp {
+			return &UnmarshalTypeError{fmt.Sprintf("ext %d", typ), v.Type()}
+		}
+		t, err := parseTime(p)
+		if err == nil {
+			v.Set(reflect.ValueOf(t))
+		}
+		return err
+	}
+
+	switch k {
+	case kindBool:
+		if v.Kind() != reflect.Bool {
+			return d.typeError(code, v.Type())
+		}
+		v.SetBool(code == codeTrue)
+		return nil
+
+	case kindInt, kindUint:
+		i, u, neg, err := d.readInt(code)
+		if err != nil {
+			return err
+		}
+		return setInt(v, i, u, neg)
+
+	case kindFloat:
+		f, err := d.readFloat(code)
+		if err != nil {
+			return err
+		}
+		switch v.Kind() {
+		case reflect.Float32, reflect.Float64:
+			v.SetFloat(f)
+			return nil
+		}
+		return &UnmarshalTypeError{"float", v.Type()}
+
+	case kindStr, kindBin:
+		n, err := d.readLen(code)
+		if err != nil {
+			return err
+		}
+		switch {
+		case v.Kind() == reflect.String:
+			p, err := d.readBytes(n)
+			v.SetString(string(p))
+			return err
+		case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+			p, err := d.readBytes(n)
+			v.SetBytes(p)
+			return err
+		case v.Kind() == reflect.Array && v.Type().Elem().Kind() == reflect.Uint8:
+			p, err := d.readBytes(n)
+			reflect.Copy(v, reflect.ValueOf(p))
+			for i := len(p); i < v.Len(); i++ {
+				v.Index(i).SetUint(0)
+			}
+			return err
+		}
+		return &UnmarshalTypeError{k.String(), v.Type()}
+
+	case kindArray:
+		n, err := d.readLen(code)
+		if err != nil {
+			return err
+		}
+		return d.decodeArray(n, v)
+
+	case kindMap:
+		n, err := d.readLen(code)
+		if err != nil {
+			return err
+		}
+		switch v.Kind() {
+		case reflect.Map:
+			return d.decodeMap(n, v)
+		case reflect.Struct:
+			return d.decodeStruct(n, v)
+		}
+		return &UnmarshalTypeError{"map", v.Type()}
+
+	default:
+		return d.typeError(code, v.Type())
+	}
+}
+
+// typeError skips the value which could not be decoded into a value of type t
+// and returns UnmarshalTypeError.
+func (d *Decoder) typeError(code byte, t reflect.Type) error {
+	if err := d.skip(code); err != nil {
+		return err
+	}
+	return &UnmarshalTypeError{kindOf(code).String(), t}
+}
+
+func setInt(v reflect.Value, i int64, u uint64, neg bool) error {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if !neg {
+			if u > math.MaxInt64 {
+				break
+			}
+			i = int64(u)
+		}
+		if v.OverflowInt(i) {
+			break
+		}
+		v.SetInt(i)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if neg || v.OverflowUint(u) {
+			break
+		}
+		v.SetUint(u)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		if neg {
+			v.SetFloat(float64(i))
+		} else {
+			v.SetFloat(float64(u))
+		}
+		return nil
+	}
+	value := fmt.Sprintf("int %d", u)
+	if neg {
+		value = fmt.Sprintf("int %d", i)
+	}
+	return &UnmarshalTypeError{value, v.Type()}
+}
+
+func (d *Decoder) decodeArray(n int, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Slice:
+		c := n
+		if c > allocLimit {
+			c = allocLimit
+		}
+		s := reflect.MakeSlice(v.Type(), 0, c)
+		for i := 0; i < n; i++ {
+			code, err := d.readCode()
+			if err != nil {
+				return err
+			}
+			s = reflect.Append(s, reflect.Zero(v.Type().Elem()))
+			if err := d.decode(code, s.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+		return nil
+
+	case reflect.Array:
+		for i := 0; i < n; i++ {
+			code, err := d.readCode()
+			if err != nil {
+				return err
+			}
+			if i >= v.Len() {
+				err = d.skip(code)
+			} else {
+				err = d.decode(code, v.Index(i))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		for i := n; i < v.Len(); i++ {
+			v.Index(i).Set(reflect.Zero(v.Type().Elem()))
+		}
+		return nil
+	}
+
+	if err := d.skipN(n); err != nil {
+		return err
+	}
+	return &UnmarshalTypeError{"array", v.Type()}
+}
+
+func (d *Decoder) decodeMap(n int, v reflect.Value) error {
+	t := v.Type()
+	if v.IsNil() {
+		v.Set(reflect.MakeMap(t))
+	}
+	for i := 0; i < n; i++ {
+		key := reflect.New(t.Key()).Elem()
+		code, err := d.readCode()
+		if err != nil {
+			return err
+		}
+		if err := d.decode(code, key); err != nil {
+			return err
+		}
+		elem := reflect.New(t.Elem()).Elem()
+		if code, err = d.readCode(); err != nil {
+			return err
+		}
+		if err := d.decode(code, elem); err != nil {
+			return err
+		}
+		v.SetMapIndex(key, elem)
+	}
+	return nil
+}
+
+func (d *Decoder) decodeStruct(n int, v reflect.Value) error {
+	fs := structFields(v.Type())
+	for i := 0; i < n; i++ {
+		var name string
+		code, err := d.readCode()
+		if err != nil {
+			return err
+		}
+		if err := d.decode(code, reflect.ValueOf(&name).Elem()); err != nil {
+			return err
+		}
+		if code, err = d.readCode(); err != nil {
+			return err
+		}
+		f, ok := fieldByName(fs, name)
+		if !ok {
+			if err := d.skip(code); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := d.decode(code, allocFieldByIndex(v, f.index)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// allocFieldByIndex is like reflect.Value.FieldByIndex() but allocates nil
+// embedded pointers.
+func allocFieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+func (d *Decoder) decodeInterface(code byte) (interface{}, error) {
+	switch kindOf(code) {
+	case kindNil:
+		return nil, nil
+	case kindBool:
+		return code == codeTrue, nil
+	case kindInt, kindUint:
+		i, u, neg, err := d.readInt(code)
+		if neg {
+			return i, err
+		}
+		if u <= math.MaxInt64 {
+			return int64(u), err
+		}
+		return u, err
+	case kindFloat:
+		if code == codeFloat32 {
+			x, err := d.readUint(4)
+			return math.Float32frombits(uint32(x)), err
+		}
+		return d.readFloat(code)
+	case kindStr:
+		n, err := d.readLen(code)
+		if err != nil {
+			return nil, err
+		}
+		p, err := d.readBytes(n)
+		return string(p), err
+	case kindBin:
+		n, err := d.readLen(code)
+		if err != nil {
+			return nil, err
+		}
+		return d.readBytes(n)
+	case kindArray:
+		n, err := d.readLen(code)
+		if err != nil {
+			return nil, err
+		}
+		var s []interface{}
+		err = d.decodeArray(n, reflect.ValueOf(&s).Elem())
+		return s, err
+	case kindMap:
+		n, err := d.readLen(code)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeInterfaceMap(n)
+	case kindExt:
+		typ, p, err := d.readExt(code)
+		if err != nil {
+			return nil, err
+		}
+		if typ == extTimestamp {
+			return parseTime(p)
+		}
+		return nil, fmt.Errorf("msgpack: unsupported extension type %d", typ)
+	}
+	return nil, ErrInvalidCode
+}
+
+// decodeInterfaceMap decodes map with string keys into map[string]interface{}
+// and falls back to map[interface{}]interface{} when meets other key.
+func (d *Decoder) decodeInterfaceMap(n int) (interface{}, error) {
+	var (
+		m  = make(map[string]interface{})
+		mi map[interface{}]interface{}
+	)
+	for i := 0; i < n; i++ {
+		code, err := d.readCode()
+		if err != nil {
+			return nil, err
+		}
+		key, err := d.decodeInterface(code)
+		if err != nil {
+			return nil, err
+		}
+		if code, err = d.readCode(); err != nil {
+			return nil, err
+		}
+		val, err := d.decodeInterface(code)
+		if err != nil {
+			return nil, err
+		}
+		s, ok := key.(string)
+		if ok && mi == nil {
+			m[s] = val
+			continue
+		}
+		if !reflect.TypeOf(key).Comparable() {
+			return nil, &UnmarshalTypeError{"map key", reflect.TypeOf(key)}
+		}
+		if mi == nil {
+			mi = make(map[interface{}]interface{}, len(m)+1)
+			for k, v := range m {
+				mi[k] = v
+			}
+		}
+		mi[key] = val
+	}
+	if mi != nil {
+		return mi, nil
+	}
+	return m, nil
+}
+
+func (d *Decoder) skip(code byte) error {
+	switch k := kindOf(code); k {
+	case kindInvalid:
+		return ErrInvalidCode
+	case kindNil, kindBool:
+		return nil
+	case kindInt, kindUint, kindFloat:
+		switch code {
+		case codeUint8, codeInt8:
+			return d.discard(1)
+		case codeUint16, codeInt16:
+			return d.discard(2)
+		case codeUint32, codeInt32, codeFloat32:
+			return d.discard(4)
+		case codeUint64, codeInt64, codeFloat64:
+			return d.discard(8)
+		}
+		return nil
+	default:
+		n, err := d.readLen(code)
+		if err != nil {
+			return err
+		}
+		switch k {
+		case kindArray:
+			return d.skipN(n)
+		case kindMap:
+			return d.skipN(2 * n)
+		case kindExt:
+			return d.discard(n + 1)
+		}
+		return d.discard(n)
+	}
+}
+
+func (d *Decoder) skipN(n int) error {
+	for i := 0; i < n; i++ {
+		code, err := d.readCode()
+		if err != nil {
+			return err
+		}
+		if err := d.skip(code); err != nil {
+			return err
+		}
+	}
+	return nil
+}
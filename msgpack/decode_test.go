@@ -0,0 +1,189 @@
+package msgpack
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	type Embedded struct {
+		Tag string
+	}
+	type value struct {
+		*Embedded
+		Bool    bool
+		Int     int
+		Int8    int8
+		Uint    uint64
+		Float   float64
+		Float32 float32
+		String  string
+		Bytes   []byte
+		Array   [3]byte
+		Slice   []string
+		Map     map[string]int
+		Ptr     *int
+		Time    time.Time
+		Any     interface{}
+		Nested  []map[string][]int
+	}
+	n := 7
+	in := value{
+		Embedded: &Embedded{Tag: "t"},
+		Bool:     true,
+		Int:      -123456789,
+		Int8:     -5,
+		Uint:     math.MaxUint64,
+		Float:    math.Pi,
+		Float32:  1.25,
+		String:   strings.Repeat("s", 300),
+		Bytes:    bytes.Repeat([]byte{1}, 70000),
+		Array:    [3]byte{1, 2, 3},
+		Slice:    []string{"a", "b"},
+		Map:      map[string]int{"x": 1},
+		Ptr:      &n,
+		Time:     time.Unix(1700000000, 123456789),
+		Any:      "any",
+		Nested:   []map[string][]int{{"a": {1, 2}}},
+	}
+	p, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out value
+	if err := Unmarshal(p, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !out.Time.Equal(in.Time) {
+		t.Errorf("unexpected time: %v", out.Time)
+	}
+	out.Time = in.Time
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("unexpected value:\nact: %+v\nexp: %+v", out, in)
+	}
+}
+
+func TestUnmarshalInterface(t *testing.T) {
+	p, err := Marshal(map[string]interface{}{
+		"int":   -1,
+		"uint":  uint64(math.MaxUint64),
+		"small": uint8(3),
+		"str":   "s",
+		"bin":   []byte{1},
+		"arr":   []interface{}{true, nil, 1.5},
+		"map":   map[int]string{1: "a"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var act interface{}
+	if err := Unmarshal(p, &act); err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string]interface{}{
+		"int":   int64(-1),
+		"uint":  uint64(math.MaxUint64),
+		"small": int64(3),
+		"str":   "s",
+		"bin":   []byte{1},
+		"arr":   []interface{}{true, nil, 1.5},
+		"map":   map[interface{}]interface{}{int64(1): "a"},
+	}
+	if !reflect.DeepEqual(act, exp) {
+		t.Errorf("unexpected value:\nact: %#v\nexp: %#v", act, exp)
+	}
+}
+
+func TestUnmarshalUnknownFields(t *testing.T) {
+	p, err := Marshal(map[string]interface{}{
+		"skip": []interface{}{map[string]int{"a": 1}, "x", 1.5, time.Unix(1, 0)},
+		"name": "n",
+		"NUM":  2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var act struct {
+		Name string `msgpack:"name"`
+		Num  int
+	}
+	if err := Unmarshal(p, &act); err != nil {
+		t.Fatal(err)
+	}
+	if act.Name != "n" || act.Num != 2 {
+		t.Errorf("unexpected value: %+v", act)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		in   []byte
+		v    interface{}
+		err  error
+	}{
+		{"empty", nil, new(int), io.ErrUnexpectedEOF},
+		{"truncated", []byte{0xcd, 0x01}, new(int), io.ErrUnexpectedEOF},
+		{"truncated array", []byte{0x92, 0x01}, new([]int), io.ErrUnexpectedEOF},
+		{"trailing", []byte{0x01, 0x02}, new(int), ErrTrailingData},
+		{"invalid", []byte{0xc1}, new(int), ErrInvalidCode},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if err := Unmarshal(test.in, test.v); err != test.err {
+				t.Errorf("unexpected error: %v; want %v", err, test.err)
+			}
+		})
+	}
+	for _, test := range []struct {
+		name string
+		in   []byte
+		v    interface{}
+	}{
+		{"overflow", []byte{0xcd, 0x01, 0x00}, new(int8)},
+		{"negative", []byte{0xff}, new(uint)},
+		{"string", []byte{0xa1, 'a'}, new(int)},
+		{"map", []byte{0x80}, new([]int)},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var terr *UnmarshalTypeError
+			if err := Unmarshal(test.in, test.v); !errors.As(err, &terr) {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+
+	var iu *InvalidUnmarshalError
+	if err := Unmarshal([]byte{0x01}, 1); !errors.As(err, &iu) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.Encode("a")
+	enc.Encode([]int{1})
+	enc.Encode(2)
+
+	dec := NewDecoder(&buf)
+	var s string
+	if err := dec.Decode(&s); err != nil || s != "a" {
+		t.Fatalf("unexpected result: %q %v", s, err)
+	}
+	if err := dec.Skip(); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := dec.Decode(&n); err != nil || n != 2 {
+		t.Fatalf("unexpected result: %d %v", n, err)
+	}
+	if err := dec.Decode(&n); err != io.EOF {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
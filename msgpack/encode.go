@@ -0,0 +1,329 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package msgpack
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// UnsupportedTypeError is returned when encoding a value of unsupported type.
+type UnsupportedTypeError struct {
+	Type reflect.Type
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return "msgpack: unsupported type: " + e.Type.String()
+}
+
+// Marshal returns MessagePack encoding of v.
+func Marshal(v interface{}) ([]byte, error) {
+	var e encodeState
+	if err := e.encode(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+// Encoder writes MessagePack values to an output stream.
+type Encoder struct {
+	w io.Writer
+	e encodeState
+}
+
+// NewEncoder returns a new encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes MessagePack encoding of v to the stream. The value is written
+// with a single Write() call.
+func (enc *Encoder) Encode(v interface{}) error {
+	enc.e.buf = enc.e.buf[:0]
+	if err := enc.e.encode(reflect.ValueOf(v)); err != nil {
+		return err
+	}
+	_, err := enc.w.Write(enc.e.buf)
+	return err
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+type encodeState struct {
+	buf []byte
+}
+
+func (e *encodeState) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		e.buf = append(e.buf, codeNil)
+		return nil
+	}
+	if v.Type() == timeType {
+		e.encodeTime(v.Interface().(time.Time))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			e.buf = append(e.buf, codeTrue)
+		} else {
+			e.buf = append(e.buf, codeFalse)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.encodeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.encodeUint(v.Uint())
+	case reflect.Float32:
+		e.buf = append(e.buf, codeFloat32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		e.buf = append(e.buf, codeFloat64)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, math.Float64bits(v.Float()))
+	case reflect.String:
+		e.encodeString(v.String())
+	case reflect.Slice:
+		if v.IsNil() {
+			e.buf = append(e.buf, codeNil)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.encodeBytes(v.Bytes())
+			return nil
+		}
+		return e.encodeArray(v)
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			p := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(p), v)
+			e.encodeBytes(p)
+			return nil
+		}
+		return e.encodeArray(v)
+	case reflect.Map:
+		if v.IsNil() {
+			e.buf = append(e.buf, codeNil)
+			return nil
+		}
+		return e.encodeMap(v)
+	case reflect.Struct:
+		return e.encodeStruct(v)
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			e.buf = append(e.buf, codeNil)
+			return nil
+		}
+		return e.encode(v.Elem())
+	default:
+		return &UnsupportedTypeError{v.Type()}
+	}
+	return nil
+}
+
+func (e *encodeState) encodeInt(n int64) {
+	switch {
+	case n >= 0:
+		e.encodeUint(uint64(n))
+	case n >= -32:
+		e.buf = append(e.buf, byte(n))
+	case n >= math.MinInt8:
+		e.buf = append(e.buf, codeInt8, byte(n))
+	case n >= math.MinInt16:
+		e.buf = append(e.buf, codeInt16)
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(n))
+	case n >= math.MinInt32:
+		e.buf = append(e.buf, codeInt32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(n))
+	default:
+		e.buf = append(e.buf, codeInt64)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(n))
+	}
+}
+
+func (e *encodeState) encodeUint(n uint64) {
+	switch {
+	case n <= 0x7f:
+		e.buf = append(e.buf, byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, codeUint8, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = append(e.buf, codeUint16)
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(n))
+	case n <= math.MaxUint32:
+		e.buf = append(e.buf, codeUint32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(n))
+	default:
+		e.buf = append(e.buf, codeUint64)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, n)
+	}
+}
+
+func (e *encodeState) encodeString(s string) {
+	n := len(s)
+	switch {
+	case n < 32:
+		e.buf = append(e.buf, codeFixStr|byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, codeStr8, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = append(e.buf, codeStr16)
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(n))
+	default:
+		e.buf = append(e.buf, codeStr32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(n))
+	}
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encodeState) encodeBytes(p []byte) {
+	n := len(p)
+	switch {
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, codeBin8, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = append(e.buf, codeBin16)
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(n))
+	default:
+		e.buf = append(e.buf, codeBin32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(n))
+	}
+	e.buf = append(e.buf, p...)
+}
+
+func (e *encodeState) encodeArrayLen(n int) {
+	switch {
+	case n < 16:
+		e.buf = append(e.buf, codeFixArray|byte(n))
+	case n <= math.MaxUint16:
+		e.buf = append(e.buf, codeArray16)
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(n))
+	default:
+		e.buf = append(e.buf, codeArray32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(n))
+	}
+}
+
+func (e *encodeState) encodeMapLen(n int) {
+	switch {
+	case n < 16:
+		e.buf = append(e.buf, codeFixMap|byte(n))
+	case n <= math.MaxUint16:
+		e.buf = append(e.buf, codeMap16)
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(n))
+	default:
+		e.buf = append(e.buf, codeMap32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(n))
+	}
+}
+
+func (e *encodeState) encodeArray(v reflect.Value) error {
+	e.encodeArrayLen(v.Len())
+	for i := 0; i < v.Len(); i++ {
+		if err := e.encode(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *encodeState) encodeMap(v reflect.Value) error {
+	keys := v.MapKeys()
+	if v.Type().Key().Kind() == reflect.String {
+		// Make the encoding deterministic for the most common case.
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+	}
+	e.encodeMapLen(len(keys))
+	for _, k := range keys {
+		if err := e.encode(k); err != nil {
+			return err
+		}
+		if err := e.encode(v.MapIndex(k)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *encodeState) encodeStruct(v reflect.Value) error {
+	var (
+		fs = structFields(v.Type())
+		fv = make([]reflect.Value, 0, len(fs))
+		fn = make([]string, 0, len(fs))
+	)
+	for _, f := range fs {
+		x, ok := fieldByIndex(v, f.index)
+		if !ok || f.omitEmpty && isEmptyValue(x) {
+			continue
+		}
+		fv = append(fv, x)
+		fn = append(fn, f.name)
+	}
+	e.encodeMapLen(len(fv))
+	for i, x := range fv {
+		e.encodeString(fn[i])
+		if err := e.encode(x); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeTime encodes t using the smallest timestamp extension format.
+func (e *encodeState) encodeTime(t time.Time) {
+	var (
+		sec  = t.Unix()
+		nsec = int64(t.Nanosecond())
+	)
+	switch {
+	case sec>>34 == 0 && nsec == 0 && sec <= math.MaxUint32:
+		e.buf = append(e.buf, codeFixExt4, byte(0xff))
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(sec))
+	case sec>>34 == 0:
+		e.buf = append(e.buf, codeFixExt8, byte(0xff))
+		e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(nsec)<<34|uint64(sec))
+	default:
+		e.buf = append(e.buf, codeExt8, 12, byte(0xff))
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(nsec))
+		e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(sec))
+	}
+}
+
+// fieldByIndex is like reflect.Value.FieldByIndex() but reports false instead
+// of panic when meets nil embedded pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
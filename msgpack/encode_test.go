@@ -0,0 +1,95 @@
+package msgpack
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMarshal(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		in   interface{}
+		exp  []byte
+	}{
+		{"nil", nil, []byte{0xc0}},
+		{"false", false, []byte{0xc2}},
+		{"true", true, []byte{0xc3}},
+		{"fixint", 5, []byte{0x05}},
+		{"negfixint", -3, []byte{0xfd}},
+		{"uint8", 200, []byte{0xcc, 0xc8}},
+		{"uint16", 0x1234, []byte{0xcd, 0x12, 0x34}},
+		{"uint32", uint32(0x12345678), []byte{0xce, 0x12, 0x34, 0x56, 0x78}},
+		{"uint64", uint64(math.MaxUint64), []byte{0xcf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"int8", -100, []byte{0xd0, 0x9c}},
+		{"int16", -1000, []byte{0xd1, 0xfc, 0x18}},
+		{"int32", int32(-100000), []byte{0xd2, 0xff, 0xfe, 0x79, 0x60}},
+		{"float32", float32(1.5), []byte{0xca, 0x3f, 0xc0, 0x00, 0x00}},
+		{"float64", 1.5, []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{"fixstr", "abc", []byte{0xa3, 'a', 'b', 'c'}},
+		{"str8", strings.Repeat("a", 32), append([]byte{0xd9, 32}, strings.Repeat("a", 32)...)},
+		{"bin8", []byte{1, 2}, []byte{0xc4, 2, 1, 2}},
+		{"nil slice", []int(nil), []byte{0xc0}},
+		{"fixarray", []int{1, 2}, []byte{0x92, 1, 2}},
+		{"array", [2]string{"a", "b"}, []byte{0x92, 0xa1, 'a', 0xa1, 'b'}},
+		{"fixmap", map[string]int{"b": 2, "a": 1}, []byte{0x82, 0xa1, 'a', 1, 0xa1, 'b', 2}},
+		{"timestamp32", time.Unix(1, 0), []byte{0xd6, 0xff, 0, 0, 0, 1}},
+		{"timestamp64", time.Unix(1, 1), []byte{0xd7, 0xff, 0, 0, 0, 0x04, 0, 0, 0, 1}},
+		{
+			"timestamp96", time.Unix(-1, 0),
+			[]byte{0xc7, 12, 0xff, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			act, err := Marshal(test.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(act, test.exp) {
+				t.Errorf("unexpected encoding:\nact: % x\nexp: % x", act, test.exp)
+			}
+		})
+	}
+}
+
+func TestMarshalStruct(t *testing.T) {
+	type Inner struct {
+		ID int
+	}
+	type value struct {
+		Inner
+		Name    string `msgpack:"name"`
+		Skip    int    `msgpack:"-"`
+		Empty   string `msgpack:"empty,omitempty"`
+		private int
+	}
+	act, err := Marshal(value{Inner: Inner{ID: 1}, Name: "x", Skip: 2, private: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []byte{0x82, 0xa4, 'n', 'a', 'm', 'e', 0xa1, 'x', 0xa2, 'I', 'D', 1}
+	if !bytes.Equal(act, exp) {
+		t.Errorf("unexpected encoding:\nact: % x\nexp: % x", act, exp)
+	}
+}
+
+func TestMarshalUnsupported(t *testing.T) {
+	if _, err := Marshal(make(chan int)); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	for _, v := range []interface{}{1, "a"} {
+		if err := enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if exp := []byte{0x01, 0xa1, 'a'}; !bytes.Equal(buf.Bytes(), exp) {
+		t.Errorf("unexpected encoding: % x", buf.Bytes())
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+// Package msgpack implements encoding and decoding of MessagePack values.
+//
+// The mapping between MessagePack and Go values is similar to the one of
+// encoding/json. Structs are encoded as maps keyed by field names. Field
+// names could be changed by the "msgpack" struct tag, which has the same
+// format as the "json" one: `msgpack:"name,omitempty"` or `msgpack:"-"`.
+//
+// When decoding into an empty interface, values are stored as nil, bool,
+// int64, uint64 (only integers exceeding int64 range), float32, float64,
+// string, []byte, time.Time, []interface{} and map[string]interface{} (or
+// map[interface{}]interface{} if some key is not a string).
+//
+// See https://github.com/msgpack/msgpack/blob/master/spec.md
+package msgpack
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Format codes defined by the specification.
+const (
+	codeNil      byte = 0xc0
+	codeFalse    byte = 0xc2
+	codeTrue     byte = 0xc3
+	codeBin8     byte = 0xc4
+	codeBin16    byte = 0xc5
+	codeBin32    byte = 0xc6
+	codeExt8     byte = 0xc7
+	codeExt16    byte = 0xc8
+	codeExt32    byte = 0xc9
+	codeFloat32  byte = 0xca
+	codeFloat64  byte = 0xcb
+	codeUint8    byte = 0xcc
+	codeUint16   byte = 0xcd
+	codeUint32   byte = 0xce
+	codeUint64   byte = 0xcf
+	codeInt8     byte = 0xd0
+	codeInt16    byte = 0xd1
+	codeInt32    byte = 0xd2
+	codeInt64    byte = 0xd3
+	codeFixExt1  byte = 0xd4
+	codeFixExt2  byte = 0xd5
+	codeFixExt4  byte = 0xd6
+	codeFixExt8  byte = 0xd7
+	codeFixExt16 byte = 0xd8
+	codeStr8     byte = 0xd9
+	codeStr16    byte = 0xda
+	codeStr32    byte = 0xdb
+	codeArray16  byte = 0xdc
+	codeArray32  byte = 0xdd
+	codeMap16    byte = 0xde
+	codeMap32    byte = 0xdf
+
+	codeFixMap   byte = 0x80
+	codeFixArray byte = 0x90
+	codeFixStr   byte = 0xa0
+	codeNegFix   byte = 0xe0
+)
+
+// extTimestamp is the extension type of timestamps.
+const extTimestamp = -1
+
+// field describes encoded struct field.
+type field struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+var fieldCache sync.Map // map[reflect.Type][]field
+
+// structFields returns encoded fields of struct type t. Fields of embedded
+// structs without a tag are promoted unless hidden by outer fields.
+func structFields(t reflect.Type) []field {
+	if fs, ok := fieldCache.Load(t); ok {
+		return fs.([]field)
+	}
+	var (
+		fs   []field
+		seen = make(map[string]bool)
+	)
+	collectFields(t, nil, &fs, seen)
+	fieldCache.Store(t, fs)
+	return fs
+}
+
+func collectFields(t reflect.Type, index []int, fs *[]field, seen map[string]bool) {
+	var embedded []reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("msgpack")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if sf.Anonymous && name == "" {
+			ft := sf.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				embedded = append(embedded, sf)
+				continue
+			}
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		*fs = append(*fs, field{
+			name:      name,
+			index:     append(append([]int(nil), index...), i),
+			omitEmpty: opts == "omitempty",
+		})
+	}
+	// Fields of embedded structs are at a deeper level, so the outer ones
+	// take precedence.
+	for _, sf := range embedded {
+		ft := sf.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		collectFields(ft, append(append([]int(nil), index...), sf.Index...), fs, seen)
+	}
+}
+
+// fieldByName returns the field with given name. It prefers an exact match
+// but accepts case-insensitive one as encoding/json does.
+func fieldByName(fs []field, name string) (field, bool) {
+	for _, f := range fs {
+		if f.name == name {
+			return f, true
+		}
+	}
+	for _, f := range fs {
+		if strings.EqualFold(f.name, name) {
+			return f, true
+		}
+	}
+	return field{}, false
+}
@@ -0,0 +1,40 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package msutil
+
+import (
+	"context"
+	"time"
+
+	ms "github.com/cmacro/mogusocket"
+)
+
+// PrincipalFromContext returns the client identity authenticated during the
+// handshake made by the connection which session context is ctx. See
+// ms.Upgrader.Authenticator.
+func PrincipalFromContext(ctx context.Context) (*ms.Principal, bool) {
+	if c := sessionConnFromContext(ctx); c != nil && c.hs != nil && c.hs.Principal != nil {
+		return c.hs.Principal, true
+	}
+	return nil, false
+}
+
+// closeOnExpiry closes the connection with StatusPolicyViolation when the
+// credentials of the authenticated client expire. It stops watching when ctx
+// is done.
+func (c *sessionConn) closeOnExpiry(ctx context.Context) {
+	if c.hs == nil || c.hs.Principal == nil || c.hs.Principal.Expires.IsZero() {
+		return
+	}
+	t := time.NewTimer(time.Until(c.hs.Principal.Expires))
+	go func() {
+		defer t.Stop()
+		select {
+		case <-t.C:
+			c.closeWith(ms.StatusPolicyViolation, "credentials expired")
+		case <-ctx.Done():
+		}
+	}()
+}
@@ -5,6 +5,7 @@
 package msutil
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"io"
@@ -42,6 +43,19 @@ type Client struct {
 	addr    string
 	log     ms.Logger
 	session ms.ClientHandler
+
+	// BufferPool is the pool of read and write buffers of the connection. If
+	// it is nil, the pool carried by the Run() context is used.
+	BufferPool *ms.BufferPool
+
+	// FlushPolicy, if set, makes the connection coalesce sent messages. See
+	// WithFlushPolicy().
+	FlushPolicy *FlushPolicy
+
+	// TCP contains options of the connection dialed to the TCP address. If
+	// it is nil, defaults of the net package are kept. See
+	// ms.DefaultClientTCPOptions.
+	TCP *ms.TCPOptions
 }
 
 type AutoConnectClient struct {
@@ -52,12 +66,31 @@ type AutoConnectClient struct {
 	ctx                 context.Context
 	cancel              context.CancelFunc
 	AutoReconnectErrors int
+
+	// BufferPool is the pool of read and write buffers of the connection. If
+	// it is nil, the pool carried by the Run() context is used.
+	BufferPool *ms.BufferPool
+
+	// FlushPolicy, if set, makes the connection coalesce sent messages. See
+	// WithFlushPolicy().
+	FlushPolicy *FlushPolicy
+
+	// TCP contains options of the connection dialed to the TCP address. If
+	// it is nil, defaults of the net package are kept. See
+	// ms.DefaultClientTCPOptions.
+	TCP *ms.TCPOptions
 }
 
 func (c *AutoConnectClient) Run(ctx context.Context, cancel context.CancelFunc) {
+	if c.BufferPool != nil {
+		ctx = ms.WithBufferPool(ctx, c.BufferPool)
+	}
+	if c.FlushPolicy != nil {
+		ctx = WithFlushPolicy(ctx, c.FlushPolicy)
+	}
 	c.ctx = ctx
 	c.cancel = cancel
-	conn, err := DialServer(c.addr)
+	conn, err := DialServerTCP(c.addr, c.TCP)
 	if err != nil {
 		go c.autoReconnect()
 	} else {
@@ -79,7 +112,7 @@ func (c *AutoConnectClient) autoReconnect() {
 		c.AutoReconnectErrors++
 		time.Sleep(autoReconnectDelay)
 
-		conn, err := DialServer(c.addr)
+		conn, err := DialServerTCP(c.addr, c.TCP)
 		if err != nil {
 			if errors.Is(err, ErrNoURL) {
 				c.log.Debug("Connect() is no url config")
@@ -124,7 +157,7 @@ func (c *AutoConnectClient) connect(conn net.Conn) {
 			if err == ErrClientClosed {
 				c.log.Info("client request closed")
 				code = 1
-			} else if err == io.EOF {
+			} else if _, ok := err.(ClosedError); ok || err == io.EOF {
 				c.log.Info("server closed.")
 			} else {
 				c.log.Error("connect client", err)
@@ -134,7 +167,13 @@ func (c *AutoConnectClient) connect(conn net.Conn) {
 }
 
 func (c *Client) Run(ctx context.Context) {
-	conn, err := DialServer(c.addr)
+	if c.BufferPool != nil {
+		ctx = ms.WithBufferPool(ctx, c.BufferPool)
+	}
+	if c.FlushPolicy != nil {
+		ctx = WithFlushPolicy(ctx, c.FlushPolicy)
+	}
+	conn, err := DialServerTCP(c.addr, c.TCP)
 	if err != nil {
 		c.log.Error("connect", err)
 		return
@@ -161,31 +200,48 @@ func ConnectServer(ctx context.Context, addr string, session ms.ClientHandler, l
 }
 
 func DialServer(addr string) (net.Conn, error) {
-	u, _ := ms.ParserAddr(addr)
-	return net.Dial(u.Data())
+	return DialServerTCP(addr, nil)
+}
+
+// DialServerTCP connects to the server address, e.g. "tcp://host:port" or
+// "unix:///path", and applies tcp options to TCP connections.
+func DialServerTCP(addr string, tcp *ms.TCPOptions) (net.Conn, error) {
+	u, err := ms.ParserAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	return tcp.Dial(context.Background(), u.Network, u.Address)
 }
 
+// ConnectClient serves the client side of WebSocket connection conn with
+// session until the connection or ctx is closed. Buffers are taken from the
+// pool carried by ctx and sent messages are coalesced according to the
+// FlushPolicy carried by ctx.
 func ConnectClient(ctx context.Context, conn net.Conn, session ms.ClientHandler, log ms.Logger) error {
+	return ConnectClientBuffered(ctx, conn, nil, session, log)
+}
+
+// ConnectClientBuffered is like ConnectClient but serves the connection
+// upgraded by ms.Dialer, that is it reads the frames buffered in br, if it is
+// not nil, before reading from conn. The br is returned to the pool with
+// ms.PutReader().
+func ConnectClientBuffered(ctx context.Context, conn net.Conn, br *bufio.Reader, session ms.ClientHandler, log ms.Logger) error {
+	hsrc := &handshakeSource{r: conn, br: br}
+	defer hsrc.release()
+
+	sctx, scancel := context.WithCancel(ctx)
 
 	state := ms.StateClientSide
-	r := &Reader{Source: conn, State: state, CheckUTF8: true, OnIntermediate: ControlFrameHandler(conn, state)}
-	w := NewWriter(conn, state, 0)
+	sc := newSessionConn(conn, state, ms.BufferPoolFromContext(ctx), scancel)
+	sc.coalesce(FlushPolicyFromContext(ctx))
+	defer sc.release()
+	sctx = withSessionConn(sctx, sc)
 
-	writehandler := func(src io.Reader, isText bool) error {
-		opcode := ms.OpText
-		if !isText {
-			opcode = ms.OpBinary
-		}
-		w.Reset(conn, state, opcode)
-		_, err := io.Copy(w, src)
-		if err == nil {
-			err = w.Flush()
-		}
-		return err
-	}
+	src := NewPooledReader(hsrc, sc.buf, 0)
+	defer src.Release()
 
-	sctx, scancel := context.WithCancel(ctx)
-	if err := session.Connect(sctx, writehandler, scancel); err != nil {
+	r := &Reader{Source: src, State: state, CheckUTF8: true, OnIntermediate: sc.handleControl}
+	if err := session.Connect(sctx, sc.send, scancel); err != nil {
 		log.Error("failed open section", err)
 		return err
 	}
@@ -200,16 +256,7 @@ func ConnectClient(ctx context.Context, conn net.Conn, session ms.ClientHandler,
 	}()
 
 	for {
-		h, err := r.NextFrame()
-		if err != nil {
-			return err
-		}
-		if h.OpCode.IsControl() {
-			log.Info("is control", h.OpCode)
-			continue
-		}
-		if err := session.ReadPump(r, h.Length, h.OpCode == ms.OpText); err != nil {
-			log.Info("read dump", err)
+		if err := sc.nextMessage(r, session, log); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,254 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package msutil
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+
+	ms "github.com/cmacro/mogusocket"
+)
+
+// Defaults of FlushPolicy.
+const (
+	DefaultFlushDelay = time.Millisecond
+	DefaultFlushBytes = 16 << 10
+)
+
+// FlushPolicy enables coalescing of data messages sent by sessions. Instead
+// of a write per message, complete messages are buffered and written
+// together when MaxBytes are pending or MaxDelay has passed since the first
+// of them was buffered.
+//
+// Control frames are sent at once with pending messages. Latency sensitive
+// sessions could write pending messages with FlushSession().
+type FlushPolicy struct {
+	// MaxDelay is the latency budget of buffered messages. If it is zero,
+	// DefaultFlushDelay is used.
+	MaxDelay time.Duration
+
+	// MaxBytes is the number of pending bytes which are written without
+	// waiting for MaxDelay. Larger messages are not buffered. If it is zero,
+	// DefaultFlushBytes is used.
+	MaxBytes int
+
+	// Metrics, if set, collects batches written by connections using the
+	// policy.
+	Metrics *FlushMetrics
+}
+
+func (p *FlushPolicy) maxDelay() time.Duration {
+	if p.MaxDelay > 0 {
+		return p.MaxDelay
+	}
+	return DefaultFlushDelay
+}
+
+func (p *FlushPolicy) maxBytes() int {
+	if p.MaxBytes > 0 {
+		return p.MaxBytes
+	}
+	return DefaultFlushBytes
+}
+
+// flushBatchClasses is the number of FlushStats.Batches classes.
+const flushBatchClasses = 8
+
+// FlushStats describes writes made by coalescing connections.
+type FlushStats struct {
+	// Writes is the number of writes to connections. Messages and Bytes are
+	// the total number of data messages and bytes written.
+	Writes, Messages, Bytes int64
+
+	// Batches is the histogram of the number of complete messages carried
+	// by a write. Batches[i] counts writes with at most 1<<i messages and
+	// the last class counts larger batches.
+	Batches [flushBatchClasses]int64
+}
+
+// FlushMetrics collects FlushStats. It is safe for concurrent use.
+type FlushMetrics struct {
+	writes, messages, bytes int64
+	batches                 [flushBatchClasses]int64
+}
+
+// Stats returns batches collected so far.
+func (m *FlushMetrics) Stats() FlushStats {
+	s := FlushStats{
+		Writes:   atomic.LoadInt64(&m.writes),
+		Messages: atomic.LoadInt64(&m.messages),
+		Bytes:    atomic.LoadInt64(&m.bytes),
+	}
+	for i := range s.Batches {
+		s.Batches[i] = atomic.LoadInt64(&m.batches[i])
+	}
+	return s
+}
+
+// record counts the write of n bytes carrying msgs complete messages.
+func (m *FlushMetrics) record(n, msgs int) {
+	if m == nil {
+		return
+	}
+	atomic.AddInt64(&m.writes, 1)
+	atomic.AddInt64(&m.messages, int64(msgs))
+	atomic.AddInt64(&m.bytes, int64(n))
+	i := 0
+	for i < flushBatchClasses-1 && msgs > 1<<i {
+		i++
+	}
+	atomic.AddInt64(&m.batches[i], 1)
+}
+
+// addMessages counts messages which were written already.
+func (m *FlushMetrics) addMessages(msgs int) {
+	if m != nil {
+		atomic.AddInt64(&m.messages, int64(msgs))
+	}
+}
+
+// coalescer buffers frames written to dst. The buffer is taken from the
+// connection account only while there are pending bytes.
+//
+// It is not safe for concurrent use, sessionConn.mu guards it.
+type coalescer struct {
+	dst     io.Writer
+	buf     *ms.BufferAccount
+	policy  *FlushPolicy
+	onTimer func()
+
+	p     []byte
+	msgs  int
+	timer *time.Timer
+	armed bool
+	err   error
+}
+
+func newCoalescer(dst io.Writer, buf *ms.BufferAccount, policy *FlushPolicy, onTimer func()) *coalescer {
+	return &coalescer{
+		dst:     dst,
+		buf:     buf,
+		policy:  policy,
+		onTimer: onTimer,
+	}
+}
+
+// Write implements io.Writer. Bytes are buffered unless they exceed the
+// byte threshold.
+func (c *coalescer) Write(p []byte) (n int, err error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	limit := c.policy.maxBytes()
+	if len(c.p)+len(p) > limit {
+		if err = c.Flush(); err != nil {
+			return 0, err
+		}
+	}
+	if len(p) >= limit {
+		n, err = c.dst.Write(p)
+		c.policy.Metrics.record(n, 0)
+		c.err = err
+		return n, err
+	}
+	if c.p == nil {
+		c.p = c.buf.Get(limit)[:0]
+	}
+	c.p = append(c.p, p...)
+	return len(p), nil
+}
+
+// endMessage marks the end of a data message. Pending bytes are written
+// when the threshold is reached or after the latency budget.
+func (c *coalescer) endMessage() error {
+	if len(c.p) == 0 {
+		// The message was written through.
+		c.policy.Metrics.addMessages(1)
+		return c.err
+	}
+	c.msgs++
+	if len(c.p) >= c.policy.maxBytes() {
+		return c.Flush()
+	}
+	if !c.armed {
+		c.armed = true
+		if c.timer == nil {
+			c.timer = time.AfterFunc(c.policy.maxDelay(), c.onTimer)
+		} else {
+			c.timer.Reset(c.policy.maxDelay())
+		}
+	}
+	return nil
+}
+
+// Flush writes pending bytes.
+func (c *coalescer) Flush() error {
+	if c.armed {
+		c.armed = false
+		c.timer.Stop()
+	}
+	if c.err != nil || len(c.p) == 0 {
+		return c.err
+	}
+	n, err := c.dst.Write(c.p)
+	c.policy.Metrics.record(n, c.msgs)
+	c.err = err
+	c.msgs = 0
+	c.buf.Put(c.p)
+	c.p = nil
+	return err
+}
+
+// release stops the timer and returns the buffer. Pending bytes are lost.
+func (c *coalescer) release() {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.armed = false
+	}
+	if c.p != nil {
+		c.buf.Put(c.p)
+		c.p = nil
+	}
+}
+
+// FlushSession writes messages of the session which context is ctx pending
+// due to FlushPolicy. It does nothing if the connection does not coalesce
+// messages.
+func FlushSession(ctx context.Context) error {
+	c := sessionConnFromContext(ctx)
+	if c == nil {
+		return ErrNoSession
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.out == nil {
+		return nil
+	}
+	return c.out.Flush()
+}
+
+type flushPolicyKey struct{}
+
+// WithFlushPolicy returns a copy of ctx that carries p. Connections served
+// with such context coalesce messages unless the policy is set explicitly.
+func WithFlushPolicy(ctx context.Context, p *FlushPolicy) context.Context {
+	return context.WithValue(ctx, flushPolicyKey{}, p)
+}
+
+// FlushPolicyFromContext returns the FlushPolicy carried by ctx or nil.
+func FlushPolicyFromContext(ctx context.Context) *FlushPolicy {
+	p, _ := ctx.Value(flushPolicyKey{}).(*FlushPolicy)
+	return p
+}
+
+// flushPolicy returns p if it is non-nil or the policy carried by ctx.
+func flushPolicy(ctx context.Context, p *FlushPolicy) *FlushPolicy {
+	if p != nil {
+		return p
+	}
+	return FlushPolicyFromContext(ctx)
+}
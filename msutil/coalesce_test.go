@@ -0,0 +1,278 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package msutil
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ms "github.com/cmacro/mogusocket"
+)
+
+// writesConn records each write made to the connection.
+type writesConn struct {
+	mu     sync.Mutex
+	writes [][]byte
+	wrote  chan struct{}
+}
+
+func newWritesConn() *writesConn {
+	return &writesConn{wrote: make(chan struct{}, 16)}
+}
+
+func (c *writesConn) Read(p []byte) (int, error) { return 0, io.EOF }
+
+func (c *writesConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	c.writes = append(c.writes, append([]byte(nil), p...))
+	c.mu.Unlock()
+	select {
+	case c.wrote <- struct{}{}:
+	default:
+	}
+	return len(p), nil
+}
+
+// messages returns payloads of frames carried by each write.
+func (c *writesConn) messages(t *testing.T) [][]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ret := make([][]string, len(c.writes))
+	for i, w := range c.writes {
+		r := bytes.NewReader(w)
+		for r.Len() > 0 {
+			_, p := mustReadFrame(t, r)
+			ret[i] = append(ret[i], string(p))
+		}
+	}
+	return ret
+}
+
+func newCoalescingConn(conn io.ReadWriter, p *FlushPolicy) *sessionConn {
+	sc := newSessionConn(conn, ms.StateServerSide, ms.DefaultBufferPool, func() {})
+	sc.coalesce(p)
+	return sc
+}
+
+func mustSend(t *testing.T, sc *sessionConn, msg string) {
+	if err := sc.send(strings.NewReader(msg), true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkWrites(t *testing.T, conn *writesConn, exp ...[]string) {
+	t.Helper()
+	act := conn.messages(t)
+	if len(act) != len(exp) {
+		t.Fatalf("unexpected writes: %q; want %q", act, exp)
+	}
+	for i := range exp {
+		if strings.Join(act[i], ",") != strings.Join(exp[i], ",") {
+			t.Fatalf("unexpected writes: %q; want %q", act, exp)
+		}
+	}
+}
+
+func TestCoalesceFlushSession(t *testing.T) {
+	conn := newWritesConn()
+	metrics := &FlushMetrics{}
+	sc := newCoalescingConn(conn, &FlushPolicy{MaxDelay: time.Hour, Metrics: metrics})
+	defer sc.release()
+	ctx := withSessionConn(context.Background(), sc)
+
+	for _, msg := range []string{"a", "b", "c"} {
+		mustSend(t, sc, msg)
+	}
+	checkWrites(t, conn)
+	if err := FlushSession(ctx); err != nil {
+		t.Fatal(err)
+	}
+	checkWrites(t, conn, []string{"a", "b", "c"})
+
+	mustSend(t, sc, "d")
+	if err := FlushSession(ctx); err != nil {
+		t.Fatal(err)
+	}
+	checkWrites(t, conn, []string{"a", "b", "c"}, []string{"d"})
+
+	exp := FlushStats{Writes: 2, Messages: 4, Bytes: 12}
+	exp.Batches[0] = 1
+	exp.Batches[2] = 1
+	if act := metrics.Stats(); act != exp {
+		t.Errorf("unexpected stats: %+v; want %+v", act, exp)
+	}
+}
+
+func TestCoalesceMaxBytes(t *testing.T) {
+	conn := newWritesConn()
+	metrics := &FlushMetrics{}
+	sc := newCoalescingConn(conn, &FlushPolicy{MaxDelay: time.Hour, MaxBytes: 8, Metrics: metrics})
+	defer sc.release()
+
+	// Frames of 2 byte payloads take 4 bytes.
+	mustSend(t, sc, "aa")
+	checkWrites(t, conn)
+	mustSend(t, sc, "bb")
+	checkWrites(t, conn, []string{"aa", "bb"})
+
+	// Larger messages are written through after pending ones.
+	mustSend(t, sc, "cc")
+	mustSend(t, sc, "large message")
+	checkWrites(t, conn, []string{"aa", "bb"}, []string{"cc"}, []string{"large message"})
+
+	if act := metrics.Stats(); act.Writes != 3 || act.Messages != 4 {
+		t.Errorf("unexpected stats: %+v", act)
+	}
+}
+
+func TestCoalesceMaxDelay(t *testing.T) {
+	conn := newWritesConn()
+	sc := newCoalescingConn(conn, &FlushPolicy{MaxDelay: 10 * time.Millisecond})
+	defer sc.release()
+
+	mustSend(t, sc, "a")
+	mustSend(t, sc, "b")
+	select {
+	case <-conn.wrote:
+	case <-time.After(time.Second):
+		t.Fatal("pending messages are not written")
+	}
+	checkWrites(t, conn, []string{"a", "b"})
+
+	// The timer is armed again by the next message.
+	mustSend(t, sc, "c")
+	select {
+	case <-conn.wrote:
+	case <-time.After(time.Second):
+		t.Fatal("pending messages are not written")
+	}
+	checkWrites(t, conn, []string{"a", "b"}, []string{"c"})
+}
+
+func TestCoalesceControlFrame(t *testing.T) {
+	conn := newWritesConn()
+	sc := newCoalescingConn(conn, &FlushPolicy{MaxDelay: time.Hour})
+	defer sc.release()
+
+	mustSend(t, sc, "a")
+	ping := ms.Header{OpCode: ms.OpPing, Fin: true, Length: 4}
+	if err := sc.handleControl(ping, strings.NewReader("ping")); err != nil {
+		t.Fatal(err)
+	}
+	checkWrites(t, conn, []string{"a", "ping"})
+
+	// Pending messages are written before the close frame.
+	mustSend(t, sc, "b")
+	sc.closeWith(ms.StatusNormalClosure, "")
+	act := conn.messages(t)
+	if len(act) != 2 || len(act[1]) != 2 || act[1][0] != "b" {
+		t.Fatalf("unexpected writes: %q", act)
+	}
+}
+
+func TestCoalesceRelease(t *testing.T) {
+	conn := newWritesConn()
+	pool := ms.NewBufferPool(16, 1<<16, 1<<20)
+	sc := newSessionConn(conn, ms.StateServerSide, pool, func() {})
+	sc.coalesce(&FlushPolicy{MaxDelay: time.Hour})
+
+	mustSend(t, sc, "a")
+	if sc.buf.Held() == 0 {
+		t.Errorf("pending messages hold no buffer")
+	}
+	sc.release()
+	checkWrites(t, conn, []string{"a"})
+	if held := sc.buf.Held(); held != 0 {
+		t.Errorf("buffers are held after release: %d", held)
+	}
+}
+
+func TestCoalesceReleaseStalled(t *testing.T) {
+	sc, cc := net.Pipe()
+	defer cc.Close()
+	conn := newCoalescingConn(sc, &FlushPolicy{MaxDelay: time.Hour})
+	mustSend(t, conn, "a")
+
+	// The peer does not read, so the pending message could not be written.
+	done := make(chan struct{})
+	go func() {
+		conn.release()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("release is blocked by the peer")
+	}
+	if held := conn.buf.Held(); held != 0 {
+		t.Errorf("buffers are held after release: %d", held)
+	}
+}
+
+func TestFlushSessionNoCoalescing(t *testing.T) {
+	if err := FlushSession(context.Background()); err != ErrNoSession {
+		t.Errorf("unexpected error: %v", err)
+	}
+	sc := newSessionConn(newWritesConn(), ms.StateServerSide, ms.DefaultBufferPool, func() {})
+	if err := FlushSession(withSessionConn(context.Background(), sc)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestConnecterFlushPolicy(t *testing.T) {
+	metrics := &FlushMetrics{}
+	c := NewConnecter(newEchoSessions(), ms.Noop)
+	c.Upgrader = &ms.Upgrader{}
+	c.FlushPolicy = &FlushPolicy{Metrics: metrics}
+	addr := "unix://" + filepath.Join(t.TempDir(), "ws.sock")
+	srv := ms.NewServer(addr, c, ms.Noop)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		srv.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	conn := mustDialServer(t, addr)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	u, _ := url.Parse("ws://example.org/")
+	br, _, err := (ms.Dialer{}).Upgrade(conn, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := io.Reader(conn)
+	if br != nil {
+		r = br
+	}
+	for _, msg := range []string{"a", "b"} {
+		if err := WriteClientText(conn, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		if _, p := mustReadFrame(t, r); string(p) != msg {
+			t.Fatalf("unexpected echo: %q", p)
+		}
+	}
+	// Writes are counted after they are made.
+	for i := 0; i < 100 && metrics.Stats().Writes < 2; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if act := metrics.Stats(); act.Messages != 2 || act.Writes != 2 {
+		t.Errorf("unexpected stats: %+v", act)
+	}
+}
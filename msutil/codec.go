@@ -0,0 +1,279 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package msutil
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+
+	ms "github.com/cmacro/mogusocket"
+	"github.com/cmacro/mogusocket/msgpack"
+)
+
+// ErrTrailingData is returned by JSON codec when message contains something
+// after the decoded value.
+var ErrTrailingData = errors.New("trailing data after value")
+
+// Codec describes encoding of values carried by data messages. Each message
+// carries exactly one value, so no extra framing of values is needed.
+//
+// Codecs for generated message types, such as protobuf, could be made with
+// FuncCodec.
+type Codec interface {
+	// Encode writes encoded v to w.
+	Encode(w io.Writer, v interface{}) error
+
+	// Decode reads the whole message payload from r and decodes it into v.
+	Decode(r io.Reader, v interface{}) error
+
+	// IsText reports whether encoded messages must be sent as text frames.
+	IsText() bool
+}
+
+// JSON is the Codec that encodes values with encoding/json. Its messages are
+// sent as text frames.
+var JSON Codec = jsonCodec{}
+
+// MsgPack is the Codec that encodes values as MessagePack. Its messages are
+// sent as binary frames.
+var MsgPack Codec = msgpackCodec{}
+
+// DefaultCodec is the codec used when no other is selected.
+var DefaultCodec = JSON
+
+// FuncCodec is an adapter to allow the use of marshal functions as Codec. For
+// example, a protobuf codec could be made as:
+//
+//	msutil.FuncCodec{
+//		MarshalFunc: func(v interface{}) ([]byte, error) {
+//			return proto.Marshal(v.(proto.Message))
+//		},
+//		UnmarshalFunc: func(p []byte, v interface{}) error {
+//			return proto.Unmarshal(p, v.(proto.Message))
+//		},
+//	}
+type FuncCodec struct {
+	MarshalFunc   func(v interface{}) ([]byte, error)
+	UnmarshalFunc func(p []byte, v interface{}) error
+
+	// Text reports whether encoded messages must be sent as text frames.
+	Text bool
+}
+
+// Encode implements Codec.
+func (c FuncCodec) Encode(w io.Writer, v interface{}) error {
+	p, err := c.MarshalFunc(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(p)
+	return err
+}
+
+// Decode implements Codec.
+func (c FuncCodec) Decode(r io.Reader, v interface{}) error {
+	p, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return c.UnmarshalFunc(p, v)
+}
+
+// IsText implements Codec.
+func (c FuncCodec) IsText() bool { return c.Text }
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{
+		"json":    JSON,
+		"msgpack": MsgPack,
+	}
+)
+
+// RegisterCodec makes codec c available for connections negotiated the
+// subprotocol with given name. Codecs of "json" and "msgpack" subprotocols
+// are registered by default.
+func RegisterCodec(protocol string, c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[protocol] = c
+}
+
+// LookupCodec returns codec registered for the subprotocol.
+func LookupCodec(protocol string) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[protocol]
+	return c, ok
+}
+
+// CodecProtocol reports whether there is a codec registered for the
+// subprotocol. It could be used as ms.Upgrader.Protocol to negotiate one of
+// the registered subprotocols.
+func CodecProtocol(protocol []byte) bool {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	_, ok := codecs[string(protocol)]
+	return ok
+}
+
+// CodecFromContext returns the codec of the subprotocol negotiated by the
+// connection which session context is ctx. It returns DefaultCodec if there
+// is no such codec.
+func CodecFromContext(ctx context.Context) Codec {
+	if hs, ok := HandshakeFromContext(ctx); ok && hs.Protocol != "" {
+		if c, ok := LookupCodec(hs.Protocol); ok {
+			return c
+		}
+	}
+	return DefaultCodec
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+func (jsonCodec) Decode(r io.Reader, v interface{}) error {
+	dec := json.NewDecoder(r)
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		if err == nil {
+			err = ErrTrailingData
+		}
+		return err
+	}
+	return nil
+}
+
+func (jsonCodec) IsText() bool { return true }
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Encode(w io.Writer, v interface{}) error {
+	return msgpack.NewEncoder(w).Encode(v)
+}
+
+func (msgpackCodec) Decode(r io.Reader, v interface{}) error {
+	dec := msgpack.NewDecoder(r)
+	if err := dec.Decode(v); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if err := dec.Skip(); err != io.EOF {
+		if err == nil {
+			err = ErrTrailingData
+		}
+		return err
+	}
+	return nil
+}
+
+func (msgpackCodec) IsText() bool { return false }
+
+// SendValue sends v encoded by c as a single message of the session. The
+// value is encoded right into the message writer, so large values are sent
+// in fragments as they are encoded. If encoding fails, the message is not
+// completed: nothing is sent if the value did not fill the first fragment,
+// otherwise the connection is closed with ms.StatusInternalServerError. The
+// ctx must be derived from the context passed to the session's Connect().
+func SendValue(ctx context.Context, c Codec, v interface{}) error {
+	w, err := NextWriter(ctx, c.IsText())
+	if err != nil {
+		return err
+	}
+	if err = c.Encode(w, v); err != nil {
+		w.abort("encoding error")
+		return err
+	}
+	return w.Close()
+}
+
+// ReadValue decodes message payload read from r into v. It is intended to be
+// called from the session's ReadPump().
+//
+// Malformed payload, that is invalid UTF-8 or a syntax error, is returned as
+// CloseWithError with ms.StatusInvalidFramePayloadData code. Well-formed
+// value which does not fit into v is returned as CloseWithError with
+// ms.StatusUnsupportedData code. When such error is returned from ReadPump(),
+// the connection is closed with that code.
+func ReadValue(r io.Reader, c Codec, v interface{}) error {
+	src := &srcErrReader{r: r}
+	err := c.Decode(src, v)
+	if err == nil || src.err != nil && src.err != ErrInvalidUTF8 {
+		// Failures of reading the message are not payload errors.
+		return err
+	}
+	code := ms.StatusInvalidFramePayloadData
+	if src.err == nil && isTypeError(err) {
+		code = ms.StatusUnsupportedData
+	}
+	return CloseWithError{
+		Code:   code,
+		Reason: err.Error(),
+		Err:    err,
+	}
+}
+
+// isTypeError reports whether err is the decoding error of a well-formed value
+// which type does not match the destination.
+func isTypeError(err error) bool {
+	var (
+		jsonErr    *json.UnmarshalTypeError
+		msgpackErr *msgpack.UnmarshalTypeError
+	)
+	return errors.As(err, &jsonErr) || errors.As(err, &msgpackErr)
+}
+
+// srcErrReader remembers the read error other than io.EOF. It also keeps
+// returning io.EOF once the message is read, as Reader does not.
+type srcErrReader struct {
+	r   io.Reader
+	eof bool
+	err error
+}
+
+func (s *srcErrReader) Read(p []byte) (int, error) {
+	if s.eof {
+		return 0, io.EOF
+	}
+	n, err := s.r.Read(p)
+	if err == io.EOF {
+		s.eof = true
+	} else if err != nil {
+		s.err = err
+	}
+	return n, err
+}
+
+// SendJSON sends v as a JSON text message of the session.
+func SendJSON(ctx context.Context, v interface{}) error {
+	return SendValue(ctx, JSON, v)
+}
+
+// ReadJSON decodes JSON message payload read from r into v.
+func ReadJSON(r io.Reader, v interface{}) error {
+	return ReadValue(r, JSON, v)
+}
+
+// Send sends v as a message of the session encoded by the codec of the
+// negotiated subprotocol. See CodecFromContext().
+func Send(ctx context.Context, v interface{}) error {
+	return SendValue(ctx, CodecFromContext(ctx), v)
+}
+
+// Receive decodes message payload read from r into v with the codec of the
+// negotiated subprotocol. The ctx is the session context. See ReadValue().
+func Receive(ctx context.Context, r io.Reader, v interface{}) error {
+	return ReadValue(r, CodecFromContext(ctx), v)
+}
@@ -0,0 +1,334 @@
+package msutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+
+	ms "github.com/cmacro/mogusocket"
+	"github.com/cmacro/mogusocket/msgpack"
+)
+
+type codecValue struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func TestReadJSON(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		payload string
+		exp     codecValue
+		code    ms.StatusCode
+	}{
+		{
+			name:    "ok",
+			payload: `{"name":"a","count":1}`,
+			exp:     codecValue{"a", 1},
+		},
+		{
+			name:    "whitespace",
+			payload: " {\"name\":\"b\"}\n",
+			exp:     codecValue{Name: "b"},
+		},
+		{
+			name:    "syntax",
+			payload: `{"name":`,
+			code:    ms.StatusInvalidFramePayloadData,
+		},
+		{
+			name:    "type",
+			payload: `{"count":"1"}`,
+			code:    ms.StatusUnsupportedData,
+		},
+		{
+			name:    "trailing",
+			payload: `{"count":1} {}`,
+			code:    ms.StatusInvalidFramePayloadData,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var act codecValue
+			err := ReadJSON(strings.NewReader(test.payload), &act)
+			if test.code != 0 {
+				var ce CloseWithError
+				if !errors.As(err, &ce) || ce.Code != test.code {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if act != test.exp {
+				t.Errorf("unexpected value: %+v; want %+v", act, test.exp)
+			}
+		})
+	}
+}
+
+func TestReadMsgPackTypeError(t *testing.T) {
+	p, err := msgpack.Marshal("name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v codecValue
+	err = ReadValue(bytes.NewReader(p), MsgPack, &v)
+	var ce CloseWithError
+	if !errors.As(err, &ce) || ce.Code != ms.StatusUnsupportedData {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestReadJSONSourceError(t *testing.T) {
+	var v codecValue
+	err := ReadJSON(io.MultiReader(strings.NewReader(`{"na`), errReader{io.ErrClosedPipe}), &v)
+	if err != io.ErrClosedPipe {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestSendJSON(t *testing.T) {
+	ctx, client := serveSession(t)
+
+	exp := codecValue{"hello", 42}
+	done := make(chan error, 1)
+	go func() { done <- SendJSON(ctx, exp) }()
+
+	h, p := mustReadFrame(t, client)
+	if h.OpCode != ms.OpText || !h.Fin {
+		t.Errorf("unexpected header: %+v", h)
+	}
+	var act codecValue
+	if err := json.Unmarshal(p, &act); err != nil {
+		t.Fatal(err)
+	}
+	if act != exp {
+		t.Errorf("unexpected value: %+v; want %+v", act, exp)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSendValueEncodeError(t *testing.T) {
+	ctx, client := serveSession(t)
+
+	done := make(chan error, 1)
+	go func() {
+		for _, c := range []Codec{JSON, MsgPack} {
+			if err := SendValue(ctx, c, make(chan int)); err == nil {
+				done <- fmt.Errorf("no error for %T", c)
+				return
+			}
+		}
+		done <- SendJSON(ctx, "next")
+	}()
+
+	// Nothing is written for the values which failed to encode.
+	h, p := mustReadFrame(t, client)
+	if h.OpCode != ms.OpText || !h.Fin || strings.TrimSpace(string(p)) != `"next"` {
+		t.Errorf("unexpected frame: %+v %q", h, p)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSendValueEncodeErrorAfterFragment(t *testing.T) {
+	ctx, client := serveSession(t)
+
+	errEncode := errors.New("encode error")
+	c := FuncCodec{
+		MarshalFunc: func(v interface{}) ([]byte, error) {
+			return nil, errEncode
+		},
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- SendValue(ctx, partialCodec{c, 1 << 16}, nil)
+	}()
+
+	h, _ := mustReadFrame(t, client)
+	if h.OpCode != ms.OpBinary || h.Fin {
+		t.Fatalf("unexpected frame: %+v", h)
+	}
+	for h.OpCode != ms.OpClose {
+		var p []byte
+		h, p = mustReadFrame(t, client)
+		if h.OpCode == ms.OpClose {
+			if code, _ := ms.ParseCloseFrameData(p); code != ms.StatusInternalServerError {
+				t.Errorf("unexpected close code: %v", code)
+			}
+		} else if h.OpCode != ms.OpContinuation || h.Fin {
+			t.Fatalf("unexpected frame: %+v", h)
+		}
+	}
+	if err := <-done; err != errEncode {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// partialCodec writes n bytes before encoding with Codec.
+type partialCodec struct {
+	Codec
+	n int
+}
+
+func (c partialCodec) Encode(w io.Writer, v interface{}) error {
+	if _, err := w.Write(make([]byte, c.n)); err != nil {
+		return err
+	}
+	return c.Codec.Encode(w, v)
+}
+
+func TestReadJSONCloseConnection(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go NewConnecter(jsonSessions{}, ms.Noop).Run(ctx, server)
+
+	go WriteClientText(client, []byte(`{"name":`))
+
+	h, p := mustReadFrame(t, client)
+	if h.OpCode != ms.OpClose {
+		t.Fatalf("unexpected frame: %+v", h)
+	}
+	if code, _ := ms.ParseCloseFrameData(p); code != ms.StatusInvalidFramePayloadData {
+		t.Errorf("unexpected close code: %v", code)
+	}
+}
+
+type jsonSessions struct{}
+
+func (jsonSessions) Connect(ctx context.Context, w ms.SendFunc, c func()) (ms.SessionHandler, error) {
+	return jsonSession{cancel: c}, nil
+}
+
+func (jsonSessions) Close(session ms.SessionHandler) error {
+	session.Close()
+	return nil
+}
+
+type jsonSession struct {
+	cancel func()
+}
+
+func (jsonSession) GetId() int64 { return 1 }
+func (s jsonSession) Close()     { s.cancel() }
+func (jsonSession) ReadPump(r io.Reader, len int64, isText bool) error {
+	var v codecValue
+	return ReadJSON(r, &v)
+}
+
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+func TestReceiveNegotiatedCodec(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	c := NewConnecter(codecEchoSessions{}, ms.Noop)
+	c.Upgrader = &ms.Upgrader{Protocol: CodecProtocol}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx, server)
+
+	u, _ := url.Parse("ws://example.org/")
+	br, hs, err := ms.Dialer{Protocols: []string{"cbor", "msgpack"}}.Upgrade(client, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hs.Protocol != "msgpack" {
+		t.Fatalf("unexpected protocol: %q", hs.Protocol)
+	}
+	var r io.Reader = client
+	if br != nil {
+		r = br
+	}
+
+	exp := codecValue{"packed", 7}
+	p, err := msgpack.Marshal(exp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go WriteClientBinary(client, p)
+
+	h, p := mustReadFrame(t, r)
+	if h.OpCode != ms.OpBinary {
+		t.Fatalf("unexpected header: %+v", h)
+	}
+	var act codecValue
+	if err := msgpack.Unmarshal(p, &act); err != nil {
+		t.Fatal(err)
+	}
+	if act != exp {
+		t.Errorf("unexpected value: %+v; want %+v", act, exp)
+	}
+}
+
+func TestFuncCodec(t *testing.T) {
+	c := FuncCodec{
+		MarshalFunc: func(v interface{}) ([]byte, error) {
+			return []byte(v.(string)), nil
+		},
+		UnmarshalFunc: func(p []byte, v interface{}) error {
+			*v.(*string) = string(p)
+			return nil
+		},
+	}
+	RegisterCodec("test.raw", c)
+	if _, ok := LookupCodec("test.raw"); !ok || !CodecProtocol([]byte("test.raw")) {
+		t.Fatalf("codec is not registered")
+	}
+
+	var buf bytes.Buffer
+	if err := c.Encode(&buf, "raw"); err != nil {
+		t.Fatal(err)
+	}
+	var s string
+	if err := ReadValue(&buf, c, &s); err != nil || s != "raw" {
+		t.Errorf("unexpected result: %q %v", s, err)
+	}
+}
+
+type codecEchoSessions struct{}
+
+func (codecEchoSessions) Connect(ctx context.Context, w ms.SendFunc, c func()) (ms.SessionHandler, error) {
+	return codecEchoSession{ctx: ctx, cancel: c}, nil
+}
+
+func (codecEchoSessions) Close(session ms.SessionHandler) error {
+	session.Close()
+	return nil
+}
+
+type codecEchoSession struct {
+	ctx    context.Context
+	cancel func()
+}
+
+func (codecEchoSession) GetId() int64 { return 1 }
+func (s codecEchoSession) Close()     { s.cancel() }
+func (s codecEchoSession) ReadPump(r io.Reader, len int64, isText bool) error {
+	var v codecValue
+	if err := Receive(s.ctx, r, &v); err != nil {
+		return err
+	}
+	return Send(s.ctx, v)
+}
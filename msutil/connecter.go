@@ -21,30 +21,70 @@ func NewConnecter(sections ms.SessionsHandler, log ms.Logger) *Connecter {
 type Connecter struct {
 	log ms.Logger
 	ms.SessionsHandler
+
+	// BufferPool is the pool of read and write buffers of connections. If it
+	// is nil, the pool carried by the Run() context is used.
+	//
+	// Buffers are held by a connection only while a message is being read
+	// or written. Bytes held by a connection could be inspected with
+	// BufferAccountFromContext() called with the session context.
+	BufferPool *ms.BufferPool
+
+	// FlushPolicy, if set, makes connections coalesce sent messages into
+	// fewer writes. If it is nil, the policy carried by the Run() context is
+	// used, see WithFlushPolicy().
+	FlushPolicy *FlushPolicy
+
+	// Upgrader, if set, is used to make the WebSocket handshake before the
+	// session is connected. The result of the handshake is available from
+	// the session context with HandshakeFromContext(). Set
+	// Upgrader.RecordRequest to keep the request headers in it.
+	//
+	// The client identity authenticated by Upgrader.Authenticator is
+	// available with PrincipalFromContext(). The session is closed with
+	// StatusPolicyViolation when the identity expires.
+	//
+	// Upgrader.Protocol could be set to CodecProtocol to negotiate one of
+	// the registered codecs. See CodecFromContext().
+	Upgrader *ms.Upgrader
 }
 
 func (c *Connecter) Run(ctx context.Context, conn io.ReadWriter) {
+	hsrc, hs, err := upgrade(c.Upgrader, conn, c.SessionsHandler)
+	if err != nil {
+		c.log.Info("upgrade", err)
+		return
+	}
+	defer hsrc.release()
+	c.serve(ctx, conn, hsrc, hs, ms.ShutdownFromContext(ctx))
+}
+
+// serve runs the session over conn which handshake is already made. If
+// shutdown is non-nil, the connection is closed with StatusGoingAway when it
+// is closed.
+func (c *Connecter) serve(ctx context.Context, conn io.ReadWriter, hsrc *handshakeSource, hs *handshake, shutdown <-chan struct{}) {
 	sectionCtx, sectionCancel := context.WithCancel(ctx)
 
 	state := ms.StateServerSide
-	ch := ControlFrameHandler(conn, state)
+	sc := newSessionConn(conn, state, bufferPool(ctx, c.BufferPool), sectionCancel)
+	sc.accept(hs)
+	sc.coalesce(flushPolicy(ctx, c.FlushPolicy))
+	defer sc.release()
+	sectionCtx = withSessionConn(sectionCtx, sc)
+	sc.closeOnExpiry(sectionCtx)
+	sc.closeOnShutdown(sectionCtx, shutdown)
+
+	src := NewPooledReader(hsrc, sc.buf, 0)
+	defer src.Release()
+
 	r := &Reader{
-		Source:         conn,
+		Source:         src,
 		State:          state,
 		CheckUTF8:      true,
-		OnIntermediate: ch,
+		OnIntermediate: sc.handleControl,
 	}
-	w := NewWriter(conn, state, 0)
 	wh := func(src io.Reader, isText bool) error {
-		opcode := ms.OpText
-		if !isText {
-			opcode = ms.OpBinary
-		}
-		w.Reset(conn, state, opcode)
-		_, err := io.Copy(w, src)
-		if err == nil {
-			err = w.Flush()
-		}
+		err := sc.send(src, isText)
 		if err != nil {
 			c.log.Error("connect writer", err)
 			sectionCancel()
@@ -68,26 +108,7 @@ func (c *Connecter) Run(ctx context.Context, conn io.ReadWriter) {
 			return
 
 		default:
-			h, err := r.NextFrame()
-			if err != nil {
-				if err == io.EOF {
-					c.log.Info("closed", section.GetId())
-				} else {
-					c.log.Error("next frame error", err)
-				}
-				return
-			}
-			if h.OpCode.IsControl() {
-				c.log.Debug("is control", h.OpCode)
-				if err = ch(h, r); err != nil {
-					c.log.Error("handle control", err)
-					return
-				}
-				continue
-			}
-			err = section.ReadPump(r, h.Length, h.OpCode == ms.OpText)
-			if err != nil {
-				c.log.Info("read dump", err)
+			if err := sc.nextMessage(r, section, c.log); err != nil {
 				return
 			}
 		}
@@ -0,0 +1,378 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package msutil
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ms "github.com/cmacro/mogusocket"
+)
+
+func TestConnecterPipelinedHandshake(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		poll bool
+	}{
+		{name: "goroutine"},
+		{name: "poll", poll: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if test.poll {
+				if p, err := ms.NewPoller(); err != nil {
+					t.Skip(err)
+				} else {
+					p.Close()
+				}
+			}
+
+			sessions := newEchoSessions()
+			addr := "unix://" + filepath.Join(t.TempDir(), "ws.sock")
+			upgrader := &ms.Upgrader{}
+
+			var srv *ms.Server
+			if test.poll {
+				c := NewPollConnecter(sessions, ms.Noop)
+				c.Upgrader = upgrader
+				srv = ms.NewPollServer(addr, c, ms.Noop)
+			} else {
+				c := NewConnecter(sessions, ms.Noop)
+				c.Upgrader = upgrader
+				srv = ms.NewServer(addr, c, ms.Noop)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				srv.Run(ctx)
+				close(done)
+			}()
+			defer func() {
+				cancel()
+				<-done
+			}()
+
+			conn := mustDialServer(t, addr)
+			defer conn.Close()
+
+			// Send the request and the first message with a single write, so
+			// the server reads them together.
+			var buf bytes.Buffer
+			buf.WriteString("GET /chat HTTP/1.1\r\n" +
+				"Host: example.org\r\n" +
+				"Upgrade: websocket\r\n" +
+				"Connection: Upgrade\r\n" +
+				"Sec-WebSocket-Version: 13\r\n" +
+				"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+				"\r\n")
+			WriteClientText(&buf, []byte("first"))
+			WriteClientText(&buf, []byte("second"))
+			if _, err := conn.Write(buf.Bytes()); err != nil {
+				t.Fatal(err)
+			}
+
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			br := bufio.NewReader(conn)
+			resp, err := http.ReadResponse(br, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != http.StatusSwitchingProtocols {
+				t.Fatalf("unexpected status: %s", resp.Status)
+			}
+			for _, exp := range []string{"first", "second"} {
+				act, err := ReadServerText(struct {
+					io.Reader
+					io.Writer
+				}{br, conn})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(act) != exp {
+					t.Fatalf("unexpected echo: %q; want %q", act, exp)
+				}
+			}
+		})
+	}
+}
+
+// uriSessions reports the request URI of each connected session.
+type uriSessions chan string
+
+func (s uriSessions) Connect(ctx context.Context, w ms.SendFunc, c func()) (ms.SessionHandler, error) {
+	uri, ok := RequestURIFromContext(ctx)
+	if !ok {
+		uri = "<none>"
+	}
+	s <- uri
+	return &echoSession{id: 1, send: w, cancel: c}, nil
+}
+
+func (uriSessions) Close(ms.SessionHandler) error { return nil }
+
+func TestRequestURIFromContext(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	var requested string
+	sessions := make(uriSessions, 1)
+	c := NewConnecter(sessions, ms.Noop)
+	c.Upgrader = &ms.Upgrader{
+		OnRequest: func(uri []byte) error {
+			requested = string(uri)
+			return nil
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx, server)
+
+	u, _ := url.Parse("ws://example.org/tunnel/db?user=1")
+	if _, _, err := (ms.Dialer{}).Upgrade(client, u); err != nil {
+		t.Fatal(err)
+	}
+	const exp = "/tunnel/db?user=1"
+	if act := <-sessions; act != exp {
+		t.Errorf("unexpected uri: %q; want %q", act, exp)
+	}
+	if requested != exp {
+		t.Errorf("upgrader OnRequest got %q; want %q", requested, exp)
+	}
+	if _, ok := RequestURIFromContext(context.Background()); ok {
+		t.Errorf("uri reported for non-session context")
+	}
+}
+
+// principalSessions reports the principal of each connected session.
+type principalSessions chan *ms.Principal
+
+func (s principalSessions) Connect(ctx context.Context, w ms.SendFunc, c func()) (ms.SessionHandler, error) {
+	p, _ := PrincipalFromContext(ctx)
+	s <- p
+	return &echoSession{id: 1, send: w, cancel: c}, nil
+}
+
+func (principalSessions) Close(ms.SessionHandler) error { return nil }
+
+func TestPrincipalFromContext(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	sessions := make(principalSessions, 1)
+	c := NewConnecter(sessions, ms.Noop)
+	c.Upgrader = &ms.Upgrader{
+		Authenticator: &ms.TokenAuthenticator{
+			Verify: func(token string) (*ms.Principal, error) {
+				return &ms.Principal{
+					Subject: token,
+					Expires: time.Now().Add(50 * time.Millisecond),
+				}, nil
+			},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx, server)
+
+	u, _ := url.Parse("ws://example.org/")
+	d := ms.Dialer{Header: ms.HandshakeHeaderHTTP(http.Header{
+		"Authorization": {"Bearer alice"},
+	})}
+	br, _, err := d.Upgrade(client, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := <-sessions; p == nil || p.Subject != "alice" {
+		t.Fatalf("unexpected principal: %+v", p)
+	}
+	if _, ok := PrincipalFromContext(context.Background()); ok {
+		t.Errorf("principal reported for non-session context")
+	}
+
+	var r io.Reader = client
+	if br != nil {
+		r = br
+	}
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	h, p := mustReadFrame(t, r)
+	if h.OpCode != ms.OpClose {
+		t.Fatalf("unexpected frame: %+v", h)
+	}
+	if code, _ := ms.ParseCloseFrameData(p); code != ms.StatusPolicyViolation {
+		t.Errorf("unexpected close code: %v", code)
+	}
+}
+
+// addrSessions reports the client address of each connected session.
+type addrSessions chan net.Addr
+
+func (s addrSessions) Connect(ctx context.Context, w ms.SendFunc, c func()) (ms.SessionHandler, error) {
+	addr, _ := RemoteAddrFromContext(ctx)
+	s <- addr
+	return &echoSession{id: 1, send: w, cancel: c}, nil
+}
+
+func (addrSessions) Close(ms.SessionHandler) error { return nil }
+
+func TestRemoteAddrFromContext(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	sessions := make(addrSessions, 1)
+	c := NewConnecter(sessions, ms.Noop)
+	c.Upgrader = &ms.Upgrader{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src := &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 51234}
+	go c.Run(ctx, &ms.ProxyConn{Conn: server, Header: &ms.ProxyHeader{Version: 1, Source: src}})
+
+	u, _ := url.Parse("ws://example.org/")
+	if _, _, err := (ms.Dialer{}).Upgrade(client, u); err != nil {
+		t.Fatal(err)
+	}
+	if act := <-sessions; act.String() != src.String() {
+		t.Errorf("unexpected address: %v; want %v", act, src)
+	}
+	if _, ok := RemoteAddrFromContext(context.Background()); ok {
+		t.Errorf("address reported for non-session context")
+	}
+}
+
+// credSessions reports the peer credentials of each connected session.
+type credSessions chan *ms.PeerCred
+
+func (s credSessions) Connect(ctx context.Context, w ms.SendFunc, c func()) (ms.SessionHandler, error) {
+	cred, _ := PeerCredFromContext(ctx)
+	s <- cred
+	return &echoSession{id: 1, send: w, cancel: c}, nil
+}
+
+func (credSessions) Close(ms.SessionHandler) error { return nil }
+
+func TestPeerCredFromContext(t *testing.T) {
+	ln, err := net.Listen("unix", filepath.Join(t.TempDir(), "cred.sock"))
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+	client, err := net.Dial("unix", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	if _, err := ms.PeerCredentials(server); err != nil {
+		t.Skip(err)
+	}
+
+	sessions := make(credSessions, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go NewConnecter(sessions, ms.Noop).Run(ctx, server)
+
+	if cred := <-sessions; cred == nil || cred.PID != os.Getpid() {
+		t.Errorf("unexpected credentials: %+v", cred)
+	}
+	if _, ok := PeerCredFromContext(context.Background()); ok {
+		t.Errorf("credentials reported for non-session context")
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		poll bool
+	}{
+		{name: "goroutine"},
+		{name: "poll", poll: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if test.poll {
+				if p, err := ms.NewPoller(); err != nil {
+					t.Skip(err)
+				} else {
+					p.Close()
+				}
+			}
+
+			sessions := newEchoSessions()
+			addr := "unix://" + filepath.Join(t.TempDir(), "ws.sock")
+			var srv *ms.Server
+			if test.poll {
+				c := NewPollConnecter(sessions, ms.Noop)
+				c.Upgrader = &ms.Upgrader{}
+				srv = ms.NewPollServer(addr, c, ms.Noop)
+			} else {
+				c := NewConnecter(sessions, ms.Noop)
+				c.Upgrader = &ms.Upgrader{}
+				srv = ms.NewServer(addr, c, ms.Noop)
+			}
+			done := make(chan struct{})
+			go func() {
+				srv.Run(context.Background())
+				close(done)
+			}()
+
+			conn := mustDialServer(t, addr)
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(time.Second))
+			u, _ := url.Parse("ws://example.org/")
+			br, _, err := (ms.Dialer{}).Upgrade(conn, u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := io.Reader(conn)
+			if br != nil {
+				r = br
+			}
+			if err := WriteClientText(conn, []byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+			if _, p := mustReadFrame(t, r); string(p) != "hello" {
+				t.Fatalf("unexpected echo: %q", p)
+			}
+
+			shutdown := make(chan error, 1)
+			go func() { shutdown <- srv.Shutdown(context.Background()) }()
+
+			h, p := mustReadFrame(t, r)
+			if h.OpCode != ms.OpClose {
+				t.Fatalf("unexpected frame: %+v", h)
+			}
+			if code, _ := ms.ParseCloseFrameData(p); code != ms.StatusGoingAway {
+				t.Errorf("unexpected close code: %v", code)
+			}
+			// The poll session does not wait for the reply, so the write
+			// could fail.
+			WriteClientMessage(conn, ms.OpClose, ms.NewCloseFrameBody(ms.StatusGoingAway, ""))
+			if err := <-shutdown; err != nil {
+				t.Fatal(err)
+			}
+			<-done
+			if _, err := r.Read(make([]byte, 1)); err != io.EOF {
+				t.Errorf("unexpected read error: %v", err)
+			}
+		})
+	}
+}
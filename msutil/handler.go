@@ -21,6 +21,30 @@ func (err ClosedError) Error() string {
 	return "ws closed: " + strconv.FormatUint(uint64(err.Code), 10) + " " + err.Reason
 }
 
+// CloseWithError could be returned by a session's ReadPump() to close the
+// connection with given code and a textual reason.
+type CloseWithError struct {
+	Code   ms.StatusCode
+	Reason string
+
+	// Err is the cause of closure, if any.
+	Err error
+}
+
+// Error implements error interface.
+func (err CloseWithError) Error() string {
+	s := "ws close: " + strconv.FormatUint(uint64(err.Code), 10) + " " + err.Reason
+	if err.Err != nil {
+		s += ": " + err.Err.Error()
+	}
+	return s
+}
+
+// Unwrap returns the cause of closure.
+func (err CloseWithError) Unwrap() error {
+	return err.Err
+}
+
 // ControlHandler contains logic of handling control frames.
 //
 // The intentional way to use it is to read the next frame header from the
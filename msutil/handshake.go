@@ -0,0 +1,125 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package msutil
+
+import (
+	"bufio"
+	"context"
+	"io"
+
+	ms "github.com/cmacro/mogusocket"
+)
+
+// HandshakeFromContext returns the handshake made by the connection which
+// session context is ctx. It reports false if the connection was served
+// without the handshake.
+func HandshakeFromContext(ctx context.Context) (ms.Handshake, bool) {
+	if c := sessionConnFromContext(ctx); c != nil && c.hs != nil {
+		return c.hs.Handshake, true
+	}
+	return ms.Handshake{}, false
+}
+
+// RequestURIFromContext returns the request URI of the handshake made by the
+// connection which session context is ctx. It reports false if the
+// connection was served without the handshake.
+func RequestURIFromContext(ctx context.Context) (string, bool) {
+	if c := sessionConnFromContext(ctx); c != nil && c.hs != nil {
+		return c.hs.uri, true
+	}
+	return "", false
+}
+
+// handshakeSource reads the bytes caught by the handshake reader before
+// reading from the connection. It returns the handshake reader to the pool as
+// soon as it is drained.
+type handshakeSource struct {
+	r  io.Reader
+	br *bufio.Reader
+}
+
+func (s *handshakeSource) Read(p []byte) (int, error) {
+	if s.br == nil {
+		return s.r.Read(p)
+	}
+	// Read() does not touch the underlying connection while there are
+	// buffered bytes.
+	n, err := s.br.Read(p)
+	if s.br.Buffered() == 0 {
+		s.release()
+	}
+	return n, err
+}
+
+// buffered reports whether there are bytes caught by the handshake reader.
+func (s *handshakeSource) buffered() bool {
+	return s.br != nil
+}
+
+func (s *handshakeSource) release() {
+	if s.br != nil {
+		ms.PutReader(s.br)
+		s.br = nil
+	}
+}
+
+// handshake is the result of the handshake made by the server.
+type handshake struct {
+	ms.Handshake
+	uri string
+
+	// route is the route selected by Router.
+	route *routeMatch
+}
+
+// upgrade makes the handshake with u if it is non-nil. It returns the source
+// of frames which must be used instead of conn and the handshake, which is
+// nil if it was not made. If h is handshakeRouter, the route is selected
+// during the handshake.
+func upgrade(u *ms.Upgrader, conn io.ReadWriter, h ms.SessionsHandler) (src *handshakeSource, hs *handshake, err error) {
+	src = &handshakeSource{r: conn}
+	if u == nil {
+		return src, nil, nil
+	}
+	hs = new(handshake)
+	uu := *u
+	rt, _ := h.(handshakeRouter)
+	var routing *routing
+	uu.OnRequest = func(uri []byte) error {
+		hs.uri = string(uri)
+		if u.OnRequest != nil {
+			if err := u.OnRequest(uri); err != nil {
+				return err
+			}
+		}
+		if rt == nil {
+			return nil
+		}
+		var err error
+		routing, err = rt.routeHandshake(hs.uri)
+		return err
+	}
+	if rt != nil {
+		uu.ProtocolCustom = nil
+		uu.Protocol = func(p []byte) bool {
+			return routing != nil && routing.acceptProtocol(p)
+		}
+		uu.OnBeforeUpgrade = func() (ms.HandshakeHeader, error) {
+			var err error
+			if hs.route, err = routing.route(); err != nil {
+				return nil, err
+			}
+			if u.OnBeforeUpgrade != nil {
+				return u.OnBeforeUpgrade()
+			}
+			return ms.HandshakeHeaderString(""), nil
+		}
+	}
+	src.br, hs.Handshake, err = uu.UpgradeBuffered(conn)
+	if err != nil {
+		return src, nil, err
+	}
+	return src, hs, nil
+}
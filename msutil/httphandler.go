@@ -0,0 +1,146 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package msutil
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	ms "github.com/cmacro/mogusocket"
+)
+
+// NewHTTPHandler creates HTTPHandler that serves sessions of given
+// SessionsHandler.
+func NewHTTPHandler(sessions ms.SessionsHandler, log ms.Logger) *HTTPHandler {
+	return &HTTPHandler{
+		log:             log,
+		SessionsHandler: sessions,
+	}
+}
+
+// HTTPHandler is the http.Handler which upgrades requests to WebSocket and
+// serves sessions the same way as Connecter does. It allows to mount
+// WebSocket endpoints next to other handlers of http.Server. If the
+// SessionsHandler is Router, requests are routed before they are upgraded.
+//
+// Note that http.Server.Shutdown does not track hijacked connections. Use
+// RegisterOnShutdown to close sessions when the server is shut down.
+type HTTPHandler struct {
+	log ms.Logger
+	ms.SessionsHandler
+
+	// Upgrader is used to make the WebSocket handshake. The result of the
+	// handshake is available from the session context with
+	// HandshakeFromContext().
+	Upgrader ms.HTTPUpgrader
+
+	// BufferPool is the pool of read and write buffers of connections. If it
+	// is nil, the pool carried by the request context is used.
+	BufferPool *ms.BufferPool
+
+	// FlushPolicy, if set, makes connections coalesce sent messages. See
+	// Connecter.FlushPolicy.
+	FlushPolicy *FlushPolicy
+
+	init     sync.Once
+	stop     sync.Once
+	shutdown chan struct{}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-h.done():
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	default:
+	}
+
+	hs := &handshake{uri: r.RequestURI}
+	if hs.uri == "" {
+		hs.uri = r.URL.RequestURI()
+	}
+	u := h.Upgrader
+	if rt, ok := h.SessionsHandler.(handshakeRouter); ok {
+		route, protocol, found := h.route(rt, w, r, hs.uri)
+		if !found {
+			return
+		}
+		hs.route = route
+		// The upgrader negotiates the subprotocol the route is selected for.
+		u.Protocol = nil
+		if protocol != "" {
+			u.Protocol = func(p string) bool { return p == protocol }
+		}
+	}
+
+	conn, rw, uhs, err := u.Upgrade(r, w)
+	if conn != nil {
+		defer conn.Close()
+	}
+	if err != nil {
+		h.log.Info("upgrade", err)
+		return
+	}
+
+	// Frames sent by the client right after the request could be already
+	// read by the server.
+	src := &handshakeSource{r: conn}
+	if n := rw.Reader.Buffered(); n > 0 {
+		src.r = io.MultiReader(io.LimitReader(rw.Reader, int64(n)), conn)
+	}
+	c := Connecter{
+		log:             h.log,
+		SessionsHandler: h.SessionsHandler,
+		BufferPool:      h.BufferPool,
+		FlushPolicy:     h.FlushPolicy,
+	}
+	hs.Handshake = uhs
+	c.serve(r.Context(), conn, src, hs, h.done())
+}
+
+// route selects the route of the request r the same way Connecter does
+// during the handshake. If no route is found, the request is rejected and
+// false is returned.
+func (h *HTTPHandler) route(rt handshakeRouter, w http.ResponseWriter, r *http.Request, uri string) (*routeMatch, string, bool) {
+	routing, err := rt.routeHandshake(uri)
+	if err != nil {
+		h.log.Info("route", uri, err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil, "", false
+	}
+	routing.selectProtocol(r.Header.Values("Sec-WebSocket-Protocol"))
+	m, err := routing.route()
+	if err != nil {
+		h.log.Info("route", uri, err)
+		w.Header().Set("Sec-WebSocket-Protocol", strings.Join(routing.protocols(), ", "))
+		http.Error(w, unsupportedProtocolReason, http.StatusBadRequest)
+		return nil, "", false
+	}
+	return m, routing.selected, true
+}
+
+// Shutdown closes all sessions with StatusGoingAway. Requests served after it
+// are rejected with 503 status.
+func (h *HTTPHandler) Shutdown() {
+	done := h.done()
+	h.stop.Do(func() {
+		close(done)
+	})
+}
+
+// RegisterOnShutdown makes srv call Shutdown when it is shut down.
+func (h *HTTPHandler) RegisterOnShutdown(srv *http.Server) {
+	srv.RegisterOnShutdown(h.Shutdown)
+}
+
+func (h *HTTPHandler) done() chan struct{} {
+	h.init.Do(func() {
+		h.shutdown = make(chan struct{})
+	})
+	return h.shutdown
+}
@@ -0,0 +1,105 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package msutil
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	ms "github.com/cmacro/mogusocket"
+)
+
+func TestHTTPHandlerBufferedMessage(t *testing.T) {
+	srv := httptest.NewServer(NewHTTPHandler(newEchoSessions(), ms.Noop))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	// The message is sent along with the request, so it is read by the
+	// server before the connection is hijacked.
+	var buf bytes.Buffer
+	buf.WriteString("GET /ws HTTP/1.1\r\n" +
+		"Host: example.org\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"\r\n")
+	WriteClientText(&buf, []byte("early"))
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("unexpected status: %s", resp.Status)
+	}
+	h, p := mustReadFrame(t, br)
+	if h.OpCode != ms.OpText || string(p) != "early" {
+		t.Errorf("unexpected echo: %+v %q", h, p)
+	}
+}
+
+func TestHTTPHandlerShutdown(t *testing.T) {
+	h := NewHTTPHandler(newEchoSessions(), ms.Noop)
+	srv := httptest.NewUnstartedServer(h)
+	h.RegisterOnShutdown(srv.Config)
+	srv.Start()
+	defer srv.Close()
+
+	u := "ws" + strings.TrimPrefix(srv.URL, "http")
+	conn, br, _, err := ms.Dial(context.Background(), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	if br != nil {
+		ms.PutReader(br)
+	}
+
+	if err := WriteClientText(conn, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if act, err := ReadServerText(conn); err != nil || string(act) != "hello" {
+		t.Fatalf("unexpected echo: %q %v", act, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := srv.Config.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	h1, p := mustReadFrame(t, conn)
+	if h1.OpCode != ms.OpClose {
+		t.Fatalf("unexpected frame: %+v", h1)
+	}
+	if code, _ := ms.ParseCloseFrameData(p); code != ms.StatusGoingAway {
+		t.Errorf("unexpected close code: %v", code)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("unexpected status after shutdown: %d", rec.Code)
+	}
+}
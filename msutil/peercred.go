@@ -0,0 +1,38 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package msutil
+
+import (
+	"context"
+	"io"
+	"net"
+
+	ms "github.com/cmacro/mogusocket"
+)
+
+// PeerCredFromContext returns credentials of the client process connected
+// with the unix socket, which session context is ctx. It allows to authorize
+// local agents without tokens. The credentials are read once when the session
+// is created. See ms.PeerCredentials().
+func PeerCredFromContext(ctx context.Context) (*ms.PeerCred, bool) {
+	if c := sessionConnFromContext(ctx); c != nil && c.peer != nil {
+		return c.peer, true
+	}
+	return nil, false
+}
+
+// peerCred returns credentials of the peer of conn if it is a unix socket
+// connection.
+func peerCred(conn io.ReadWriter) *ms.PeerCred {
+	c, ok := conn.(net.Conn)
+	if !ok {
+		return nil
+	}
+	cred, err := ms.PeerCredentials(c)
+	if err != nil {
+		return nil
+	}
+	return cred
+}
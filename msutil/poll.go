@@ -0,0 +1,193 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package msutil
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	ms "github.com/cmacro/mogusocket"
+)
+
+// NewPollConnecter creates PollConnecter that serves sessions of given
+// SessionsHandler. It is intended to be used with ms.NewPollServer.
+func NewPollConnecter(sections ms.SessionsHandler, log ms.Logger) *PollConnecter {
+	return &PollConnecter{
+		log:             log,
+		SessionsHandler: sections,
+	}
+}
+
+// PollConnecter implements ms.PollHandler. It is the event loop counterpart
+// of Connecter: no goroutine and no Reader is held by a connection while it is
+// idle. Readers are taken from the pool only for the time of reading a
+// message.
+type PollConnecter struct {
+	log ms.Logger
+	ms.SessionsHandler
+
+	// ReadTimeout is the maximum amount of time a worker waits for the rest
+	// of a message once its first bytes became readable. It protects the
+	// worker pool from peers sending partial frames. The handshake made by
+	// Open() is limited by it as well.
+	//
+	// If it is zero, DefaultPollReadTimeout is used. Negative value disables
+	// the timeout.
+	ReadTimeout time.Duration
+
+	// BufferPool is the pool of write buffers of connections. If it is nil,
+	// the pool carried by the Open() context is used.
+	//
+	// Note that connections in the event loop mode are read without
+	// buffering, so no read buffer is held even while reading a message.
+	BufferPool *ms.BufferPool
+
+	// FlushPolicy, if set, makes connections coalesce sent messages. See
+	// Connecter.FlushPolicy.
+	FlushPolicy *FlushPolicy
+
+	// Upgrader, if set, is used to make the WebSocket handshake before the
+	// session is connected. See Connecter.Upgrader.
+	Upgrader *ms.Upgrader
+}
+
+var readers = sync.Pool{
+	New: func() interface{} { return new(Reader) },
+}
+
+// getReader takes Reader from the pool and prepares it to read from src.
+func getReader(src io.Reader, state ms.State, onIntermediate FrameHandlerFunc) *Reader {
+	r := readers.Get().(*Reader)
+	*r = Reader{
+		Source:         src,
+		State:          state,
+		CheckUTF8:      true,
+		OnIntermediate: onIntermediate,
+	}
+	return r
+}
+
+// putReader returns r to the pool. It must be called only after the whole
+// message is read by r.
+func putReader(r *Reader) {
+	*r = Reader{}
+	readers.Put(r)
+}
+
+// Open implements ms.PollHandler.
+func (c *PollConnecter) Open(ctx context.Context, conn net.Conn, done func()) (ms.PollSession, error) {
+	timeout := c.readTimeout()
+	if c.Upgrader != nil && timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+	}
+	src, hs, err := upgrade(c.Upgrader, conn, c.SessionsHandler)
+	if c.Upgrader != nil && timeout > 0 {
+		conn.SetReadDeadline(time.Time{})
+	}
+	if err != nil {
+		c.log.Info("upgrade", err)
+		return nil, err
+	}
+
+	sectionCtx, sectionCancel := context.WithCancel(ctx)
+	cancel := func() {
+		sectionCancel()
+		done()
+	}
+	sc := newSessionConn(conn, ms.StateServerSide, bufferPool(ctx, c.BufferPool), cancel)
+	sc.accept(hs)
+	sc.coalesce(flushPolicy(ctx, c.FlushPolicy))
+	sectionCtx = withSessionConn(sectionCtx, sc)
+	sc.closeOnExpiry(sectionCtx)
+	sc.closeOnShutdown(sectionCtx, ms.ShutdownFromContext(ctx))
+
+	ps := &pollSession{
+		log:     c.log,
+		timeout: timeout,
+		conn:    conn,
+		src:     src,
+		sc:      sc,
+		cancel:  sectionCancel,
+		handler: c.SessionsHandler,
+	}
+	wh := func(src io.Reader, isText bool) error {
+		err := ps.sc.send(src, isText)
+		if err != nil {
+			c.log.Error("connect writer", err)
+			cancel()
+		}
+		return err
+	}
+
+	section, err := c.SessionsHandler.Connect(sectionCtx, wh, cancel)
+	if err != nil {
+		c.log.Info("connection refused", err)
+		sectionCancel()
+		sc.release()
+		src.release()
+		return nil, err
+	}
+	ps.section = section
+
+	// Messages caught by the handshake would not make the connection
+	// readable, so they are read before the connection is polled.
+	for src.buffered() {
+		if err := ps.HandleRead(); err != nil {
+			ps.Close()
+			return nil, err
+		}
+	}
+
+	return ps, nil
+}
+
+// DefaultPollReadTimeout is the default PollConnecter.ReadTimeout.
+const DefaultPollReadTimeout = 10 * time.Second
+
+func (c *PollConnecter) readTimeout() time.Duration {
+	switch {
+	case c.ReadTimeout > 0:
+		return c.ReadTimeout
+	case c.ReadTimeout < 0:
+		return 0
+	}
+	return DefaultPollReadTimeout
+}
+
+type pollSession struct {
+	log     ms.Logger
+	timeout time.Duration
+	conn    net.Conn
+	src     *handshakeSource
+	sc      *sessionConn
+	cancel  context.CancelFunc
+	handler ms.SessionsHandler
+	section ms.SessionHandler
+}
+
+// HandleRead implements ms.PollSession.
+func (p *pollSession) HandleRead() error {
+	if p.timeout != 0 {
+		p.conn.SetReadDeadline(time.Now().Add(p.timeout))
+	}
+
+	r := getReader(p.src, p.sc.state, p.sc.handleControl)
+	err := p.sc.nextMessage(r, p.section, p.log)
+	if err == nil {
+		putReader(r)
+	}
+	return err
+}
+
+// Close implements ms.PollSession.
+func (p *pollSession) Close() {
+	p.handler.Close(p.section)
+	p.cancel()
+	p.sc.release()
+	p.src.release()
+}
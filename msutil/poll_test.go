@@ -0,0 +1,247 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package msutil
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ms "github.com/cmacro/mogusocket"
+)
+
+func TestPollServerEcho(t *testing.T) {
+	if p, err := ms.NewPoller(); err != nil {
+		t.Skip(err)
+	} else {
+		p.Close()
+	}
+
+	sessions := newEchoSessions()
+	addr := "unix://" + filepath.Join(t.TempDir(), "poll.sock")
+	srv := ms.NewPollServer(addr, NewPollConnecter(sessions, ms.Noop), ms.Noop)
+	srv.Workers = 2
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		srv.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	const clients = 8
+	conns := make([]net.Conn, clients)
+	for i := range conns {
+		conns[i] = mustDialServer(t, addr)
+		defer conns[i].Close()
+	}
+	// Every client sends several messages while others are idle. That is,
+	// two workers must be enough to serve all of them.
+	for round := 0; round < 3; round++ {
+		for i, conn := range conns {
+			msg := []byte("hello " + strconv.Itoa(round) + " from " + strconv.Itoa(i))
+			if err := WriteClientText(conn, msg); err != nil {
+				t.Fatal(err)
+			}
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			act, err := ReadServerText(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(act, msg) {
+				t.Fatalf("unexpected echo: %q; want %q", act, msg)
+			}
+		}
+	}
+
+	// Ping must be answered with pong by the event loop too.
+	conns[0].Write(ms.MustCompileFrame(ms.MaskFrame(ms.NewPingFrame([]byte("ping")))))
+	conns[0].SetReadDeadline(time.Now().Add(time.Second))
+	f, err := ms.ReadFrame(conns[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Header.OpCode != ms.OpPong || string(f.Payload) != "ping" {
+		t.Fatalf("unexpected response to ping: %+v %q", f.Header, f.Payload)
+	}
+
+	if n := sessions.opened(); n != clients {
+		t.Errorf("opened %d sessions; want %d", n, clients)
+	}
+	conns[1].Close()
+	waitFor(t, func() bool { return sessions.closed() == 1 })
+}
+
+// echoSessions is a SessionsHandler which sends back every received message.
+type echoSessions struct {
+	mu      sync.Mutex
+	maxid   int64
+	nclosed int32
+}
+
+type echoSession struct {
+	id     int64
+	send   ms.SendFunc
+	cancel func()
+}
+
+func newEchoSessions() *echoSessions {
+	return &echoSessions{}
+}
+
+func (s *echoSessions) Connect(ctx context.Context, w ms.SendFunc, c func()) (ms.SessionHandler, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxid++
+	return &echoSession{id: s.maxid, send: w, cancel: c}, nil
+}
+
+func (s *echoSessions) Close(session ms.SessionHandler) error {
+	atomic.AddInt32(&s.nclosed, 1)
+	session.Close()
+	return nil
+}
+
+func (s *echoSessions) opened() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.maxid
+}
+
+func (s *echoSessions) closed() int32 {
+	return atomic.LoadInt32(&s.nclosed)
+}
+
+func (e *echoSession) GetId() int64 { return e.id }
+func (e *echoSession) Close()       { e.cancel() }
+
+func (e *echoSession) ReadPump(r io.Reader, len int64, isText bool) error {
+	p, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return e.send(bytes.NewReader(p), isText)
+}
+
+func mustDialServer(t *testing.T, addr string) net.Conn {
+	var (
+		conn net.Conn
+		err  error
+	)
+	for i := 0; i < 100; i++ {
+		if conn, err = DialServer(addr); err == nil {
+			return conn
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal(err)
+	return nil
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("condition was not met in time")
+}
+
+func TestPollConnecterReadTimeout(t *testing.T) {
+	if p, err := ms.NewPoller(); err != nil {
+		t.Skip(err)
+	} else {
+		p.Close()
+	}
+
+	sessions := newEchoSessions()
+	addr := "unix://" + filepath.Join(t.TempDir(), "poll.sock")
+	c := NewPollConnecter(sessions, ms.Noop)
+	c.ReadTimeout = 50 * time.Millisecond
+	srv := ms.NewPollServer(addr, c, ms.Noop)
+	srv.Workers = 1
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		srv.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	slow := mustDialServer(t, addr)
+	defer slow.Close()
+	fast := mustDialServer(t, addr)
+	defer fast.Close()
+	waitFor(t, func() bool { return sessions.opened() == 2 })
+
+	// The partial frame holds the only worker until the read times out.
+	var buf bytes.Buffer
+	WriteClientText(&buf, []byte("partial"))
+	if _, err := slow.Write(buf.Bytes()[:3]); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteClientText(fast, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	fast.SetReadDeadline(time.Now().Add(time.Second))
+	if act, err := ReadServerText(fast); err != nil || string(act) != "hello" {
+		t.Fatalf("unexpected echo: %q %v", act, err)
+	}
+	waitFor(t, func() bool { return sessions.closed() == 1 })
+
+	for _, test := range []struct {
+		timeout, exp time.Duration
+	}{
+		{0, DefaultPollReadTimeout},
+		{-1, 0},
+		{time.Second, time.Second},
+	} {
+		c := PollConnecter{ReadTimeout: test.timeout}
+		if act := c.readTimeout(); act != test.exp {
+			t.Errorf("readTimeout() = %v for %v; want %v", act, test.timeout, test.exp)
+		}
+	}
+}
+
+func TestPollConnecterHandshakeTimeout(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	c := NewPollConnecter(newEchoSessions(), ms.Noop)
+	c.Upgrader = &ms.Upgrader{}
+	c.ReadTimeout = 50 * time.Millisecond
+
+	// The client never sends the request.
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Open(context.Background(), server, func() {})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("handshake succeeded")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("handshake is not limited by ReadTimeout")
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package msutil
+
+import (
+	"context"
+	"io"
+
+	ms "github.com/cmacro/mogusocket"
+)
+
+// DefaultReadBuffer contains size of PooledReader's default buffer.
+var DefaultReadBuffer = 4096
+
+// PooledReader implements buffered io.Reader which takes its buffer from the
+// pool only while it has unread bytes.
+//
+// When the buffer is drained it is returned to the pool, and the next read is
+// made directly into the caller's slice. That is, a reader blocked on idle
+// connection waiting for the next frame header holds no memory. Only when the
+// direct read shows that more bytes are likely pending, the buffer is taken
+// again to read them with a single call.
+type PooledReader struct {
+	src  io.Reader
+	pool *ms.BufferAccount
+	size int
+
+	buf  []byte
+	r, w int
+	err  error
+
+	// hot reports whether the last read from src filled the whole
+	// destination, so more bytes are likely pending.
+	hot bool
+}
+
+// NewPooledReader creates PooledReader which reads from src using buffers of
+// size n taken from the pool. If n <= 0 then DefaultReadBuffer is used.
+func NewPooledReader(src io.Reader, pool *ms.BufferAccount, n int) *PooledReader {
+	if n <= 0 {
+		n = DefaultReadBuffer
+	}
+	return &PooledReader{
+		src:  src,
+		pool: pool,
+		size: n,
+	}
+}
+
+// Buffered returns the number of bytes that can be read from the buffer
+// without reading from the source.
+func (p *PooledReader) Buffered() int {
+	return p.w - p.r
+}
+
+// Read implements io.Reader.
+func (p *PooledReader) Read(b []byte) (n int, err error) {
+	if p.r < p.w {
+		return p.readBuffered(b), nil
+	}
+	if p.err != nil {
+		err, p.err = p.err, nil
+		return 0, err
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if !p.hot || len(b) >= p.size {
+		n, err = p.src.Read(b)
+		p.hot = n == len(b) && err == nil
+		return n, err
+	}
+
+	p.buf = p.pool.Get(p.size)
+	n, err = p.src.Read(p.buf)
+	p.r, p.w = 0, n
+	p.hot = n == len(p.buf) && err == nil
+	if n == 0 {
+		p.Release()
+		return 0, err
+	}
+	p.err = err
+	return p.readBuffered(b), nil
+}
+
+func (p *PooledReader) readBuffered(b []byte) int {
+	n := copy(b, p.buf[p.r:p.w])
+	p.r += n
+	if p.r == p.w {
+		p.Release()
+	}
+	return n
+}
+
+// Release returns the buffer to the pool dropping any unread bytes.
+func (p *PooledReader) Release() {
+	if p.buf == nil {
+		return
+	}
+	p.pool.Put(p.buf)
+	p.buf = nil
+	p.r, p.w = 0, 0
+}
+
+// BufferAccountFromContext returns the BufferAccount of the connection which
+// session context is ctx. It could be used to report memory held by the
+// connection buffers.
+func BufferAccountFromContext(ctx context.Context) *ms.BufferAccount {
+	if c := sessionConnFromContext(ctx); c != nil {
+		return c.buf
+	}
+	return nil
+}
+
+// bufferPool returns p if it is non-nil or the pool carried by ctx.
+func bufferPool(ctx context.Context, p *ms.BufferPool) *ms.BufferPool {
+	if p != nil {
+		return p
+	}
+	return ms.BufferPoolFromContext(ctx)
+}
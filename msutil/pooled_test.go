@@ -0,0 +1,95 @@
+package msutil
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	ms "github.com/cmacro/mogusocket"
+)
+
+func TestPooledReader(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 100)
+
+	acct := ms.NewBufferPool(16, 1024, 1<<20).NewAccount()
+	r := NewPooledReader(bytes.NewReader(data), acct, 64)
+
+	var out bytes.Buffer
+	p := make([]byte, 7)
+	for {
+		n, err := r.Read(p)
+		out.Write(p[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if held := acct.Held(); r.Buffered() == 0 && held != 0 {
+			t.Fatalf("drained reader holds %d bytes", held)
+		}
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Errorf("unexpected data read")
+	}
+	if held := acct.Held(); held != 0 {
+		t.Errorf("reader holds %d bytes after EOF", held)
+	}
+}
+
+func TestPooledReaderRelease(t *testing.T) {
+	acct := ms.NewBufferPool(16, 1024, 1<<20).NewAccount()
+	r := NewPooledReader(bytes.NewReader(make([]byte, 256)), acct, 64)
+
+	p := make([]byte, 4)
+	for i := 0; i < 2; i++ {
+		if _, err := r.Read(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if acct.Held() == 0 {
+		t.Fatalf("expected buffered read")
+	}
+	r.Release()
+	if held := acct.Held(); held != 0 {
+		t.Errorf("released reader holds %d bytes", held)
+	}
+}
+
+func TestWriterPool(t *testing.T) {
+	acct := ms.NewBufferPool(16, 1024, 1<<20).NewAccount()
+
+	var buf bytes.Buffer
+	w := NewWriterPool(&buf, ms.StateServerSide, ms.OpText, acct, 128)
+	if held := acct.Held(); held != 0 {
+		t.Fatalf("idle writer holds %d bytes", held)
+	}
+	if _, err := w.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if held := acct.Held(); held == 0 {
+		t.Fatalf("expected writer to hold buffer")
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if held := acct.Held(); held != 0 {
+		t.Errorf("flushed writer holds %d bytes", held)
+	}
+
+	w.Reset(&buf, ms.StateServerSide, ms.OpText)
+	if _, err := w.Write([]byte("world")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var msgs []string
+	for _, f := range frames(buf.Bytes()) {
+		msgs = append(msgs, string(f.Payload))
+	}
+	if len(msgs) != 2 || msgs[0] != "hello" || msgs[1] != "world" {
+		t.Errorf("unexpected messages: %q", msgs)
+	}
+}
@@ -0,0 +1,28 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package msutil
+
+import (
+	"context"
+	"net"
+)
+
+// RemoteAddrFromContext returns the client address of the connection which
+// session context is ctx. The address taken from the PROXY protocol header
+// or the trusted X-Forwarded-For header is preferred, see
+// ms.Handshake.RemoteAddr.
+func RemoteAddrFromContext(ctx context.Context) (net.Addr, bool) {
+	c := sessionConnFromContext(ctx)
+	if c == nil {
+		return nil, false
+	}
+	if c.hs != nil && c.hs.RemoteAddr != nil {
+		return c.hs.RemoteAddr, true
+	}
+	if conn, ok := c.conn.(net.Conn); ok {
+		return conn.RemoteAddr(), true
+	}
+	return nil, false
+}
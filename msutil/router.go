@@ -0,0 +1,285 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package msutil
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	ms "github.com/cmacro/mogusocket"
+)
+
+var (
+	// ErrRouteNotFound rejects handshakes which path matches no route.
+	ErrRouteNotFound = ms.RejectConnectionError(
+		ms.RejectionStatus(http.StatusNotFound),
+		ms.RejectionReason("handshake error: not found"),
+	)
+
+	ErrNoRoute = errors.New("msutil: no route for the session")
+)
+
+// Router is the SessionsHandler which dispatches connections to handlers
+// registered for the path of the handshake request and the negotiated
+// subprotocol.
+//
+// Routing is made during the handshake, so the Connecter or PollConnecter
+// serving the router must have the Upgrader set. The router could be mounted
+// with HTTPHandler as well, in which case the request is routed before it is
+// upgraded. Handshakes which path matches no route are rejected with
+// ErrRouteNotFound. Subprotocols of the routes matching the path are
+// negotiated automatically, that is Upgrader.Protocol and
+// Upgrader.ProtocolCustom are not used. If every such route requires a
+// subprotocol and the client offers none of them, the handshake is rejected
+// with 400 status and the supported subprotocols are listed in the
+// Sec-WebSocket-Protocol header of the response.
+type Router struct {
+	log    ms.Logger
+	routes []*route
+}
+
+// NewRouter returns an empty Router.
+func NewRouter(log ms.Logger) *Router {
+	return &Router{log: log}
+}
+
+// Handle registers h for connections which path matches pattern. If
+// protocols are given, connections are routed to h only if one of them is
+// negotiated, otherwise regardless of the subprotocol.
+//
+// Pattern segments are separated by slashes. The "{name}" segment matches
+// any non-empty segment and the last "{name...}" segment matches the rest of
+// the path. Matched segments are available from the session context with
+// ParamsFromContext(). Routes are tried in the order of registration.
+func (r *Router) Handle(pattern string, h ms.SessionsHandler, protocols ...string) {
+	r.routes = append(r.routes, &route{
+		pattern:   pattern,
+		segments:  strings.Split(pattern, "/"),
+		protocols: protocols,
+		handler:   h,
+	})
+}
+
+// Protocols returns subprotocols supported by routes matching path.
+func (r *Router) Protocols(path string) []string {
+	var ret []string
+	for _, m := range r.match(path) {
+		ret = appendProtocols(ret, m.protocols)
+	}
+	return ret
+}
+
+// NewConnecter returns Connecter serving sessions of r.
+func (r *Router) NewConnecter() *Connecter {
+	c := NewConnecter(r, r.log)
+	c.Upgrader = &ms.Upgrader{}
+	return c
+}
+
+// NewPollConnecter returns PollConnecter serving sessions of r.
+func (r *Router) NewPollConnecter() *PollConnecter {
+	c := NewPollConnecter(r, r.log)
+	c.Upgrader = &ms.Upgrader{}
+	return c
+}
+
+func (r *Router) Connect(ctx context.Context, w ms.SendFunc, c func()) (ms.SessionHandler, error) {
+	sc := sessionConnFromContext(ctx)
+	if sc == nil || sc.hs == nil || sc.hs.route == nil {
+		return nil, ErrNoRoute
+	}
+	m := sc.hs.route
+	r.log.Debug("route", m.pattern, sc.hs.Protocol)
+	s, err := m.handler.Connect(ctx, w, c)
+	if err != nil {
+		return nil, err
+	}
+	return &routedSession{SessionHandler: s, handler: m.handler}, nil
+}
+
+func (r *Router) Close(session ms.SessionHandler) error {
+	if s, ok := session.(*routedSession); ok {
+		return s.handler.Close(s.SessionHandler)
+	}
+	session.Close()
+	return nil
+}
+
+// routeHandshake returns routes matching the path of the request uri.
+func (r *Router) routeHandshake(uri string) (*routing, error) {
+	path, _, _ := strings.Cut(uri, "?")
+	matches := r.match(path)
+	if len(matches) == 0 {
+		return nil, ErrRouteNotFound
+	}
+	return &routing{matches: matches}, nil
+}
+
+func (r *Router) match(path string) []*routeMatch {
+	var ret []*routeMatch
+	for _, rt := range r.routes {
+		if params, ok := rt.match(path); ok {
+			ret = append(ret, &routeMatch{route: rt, params: params})
+		}
+	}
+	return ret
+}
+
+// handshakeRouter is implemented by SessionsHandler which routes connections
+// at the handshake, i.e. Router.
+type handshakeRouter interface {
+	routeHandshake(uri string) (*routing, error)
+}
+
+type route struct {
+	pattern   string
+	segments  []string
+	protocols []string
+	handler   ms.SessionsHandler
+}
+
+// match reports whether path matches the route pattern and returns the path
+// parameters.
+func (rt *route) match(path string) (map[string]string, bool) {
+	var params map[string]string
+	for i, seg := range rt.segments {
+		name, isParam := paramName(seg)
+		if isParam && strings.HasSuffix(name, "...") && i == len(rt.segments)-1 {
+			params = setParam(params, strings.TrimSuffix(name, "..."), path)
+			return params, true
+		}
+		v, rest, more := strings.Cut(path, "/")
+		switch {
+		case isParam && v != "":
+			params = setParam(params, name, v)
+		case isParam || v != seg:
+			return nil, false
+		}
+		path = rest
+		if !more {
+			return params, i == len(rt.segments)-1
+		}
+	}
+	return nil, false
+}
+
+func (rt *route) accept(protocol string) bool {
+	for _, p := range rt.protocols {
+		if p == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+func paramName(seg string) (string, bool) {
+	if len(seg) > 2 && seg[0] == '{' && seg[len(seg)-1] == '}' {
+		return seg[1 : len(seg)-1], true
+	}
+	return "", false
+}
+
+func setParam(params map[string]string, name, v string) map[string]string {
+	if params == nil {
+		params = make(map[string]string)
+	}
+	params[name] = v
+	return params
+}
+
+func appendProtocols(dst, src []string) []string {
+	for _, p := range src {
+		dup := false
+		for _, d := range dst {
+			dup = dup || d == p
+		}
+		if !dup {
+			dst = append(dst, p)
+		}
+	}
+	return dst
+}
+
+// routeMatch is the route matched by the path of the handshake request.
+type routeMatch struct {
+	*route
+	params map[string]string
+}
+
+// routing is the state of routing of a single handshake.
+type routing struct {
+	matches  []*routeMatch
+	selected string
+}
+
+// acceptProtocol reports whether p is supported by any matched route. It is
+// used as Upgrader.Protocol.
+func (r *routing) acceptProtocol(p []byte) bool {
+	for _, m := range r.matches {
+		if m.accept(string(p)) {
+			r.selected = string(p)
+			return true
+		}
+	}
+	return false
+}
+
+// selectProtocol selects the first of offered subprotocols supported by any
+// matched route. The offered values are the Sec-WebSocket-Protocol header
+// values of the request.
+func (r *routing) selectProtocol(offered []string) {
+	for _, v := range offered {
+		for _, p := range strings.Split(v, ",") {
+			if p = strings.TrimSpace(p); p != "" && r.acceptProtocol([]byte(p)) {
+				return
+			}
+		}
+	}
+}
+
+// route returns the first matched route which supports the selected
+// subprotocol.
+func (r *routing) route() (*routeMatch, error) {
+	for _, m := range r.matches {
+		if len(m.protocols) == 0 || m.accept(r.selected) {
+			return m, nil
+		}
+	}
+	return nil, ms.RejectConnectionError(
+		ms.RejectionStatus(http.StatusBadRequest),
+		ms.RejectionReason(unsupportedProtocolReason),
+		ms.RejectionHeader(ms.HandshakeHeaderString(
+			"Sec-WebSocket-Protocol: "+strings.Join(r.protocols(), ", ")+"\r\n",
+		)),
+	)
+}
+
+const unsupportedProtocolReason = "handshake error: unsupported subprotocol"
+
+// protocols returns subprotocols supported by the matched routes.
+func (r *routing) protocols() []string {
+	var ret []string
+	for _, m := range r.matches {
+		ret = appendProtocols(ret, m.protocols)
+	}
+	return ret
+}
+
+// ParamsFromContext returns the path parameters of the route which served
+// the session with context ctx.
+func ParamsFromContext(ctx context.Context) (map[string]string, bool) {
+	if c := sessionConnFromContext(ctx); c != nil && c.hs != nil && c.hs.route != nil {
+		return c.hs.route.params, true
+	}
+	return nil, false
+}
+
+// routedSession remembers the handler of the session to close it with.
+type routedSession struct {
+	ms.SessionHandler
+	handler ms.SessionsHandler
+}
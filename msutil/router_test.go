@@ -0,0 +1,231 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package msutil
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	ms "github.com/cmacro/mogusocket"
+)
+
+func TestRouteMatch(t *testing.T) {
+	for _, test := range []struct {
+		pattern string
+		path    string
+		params  map[string]string
+		ok      bool
+	}{
+		{pattern: "/chat", path: "/chat", ok: true},
+		{pattern: "/chat", path: "/chat/"},
+		{pattern: "/chat", path: "/chat/x"},
+		{pattern: "/chat", path: "/"},
+		{pattern: "/", path: "/", ok: true},
+		{
+			pattern: "/rooms/{id}",
+			path:    "/rooms/42",
+			params:  map[string]string{"id": "42"},
+			ok:      true,
+		},
+		{pattern: "/rooms/{id}", path: "/rooms/"},
+		{pattern: "/rooms/{id}", path: "/rooms/42/x"},
+		{
+			pattern: "/rooms/{id}/users/{user}",
+			path:    "/rooms/42/users/alice",
+			params:  map[string]string{"id": "42", "user": "alice"},
+			ok:      true,
+		},
+		{
+			pattern: "/files/{path...}",
+			path:    "/files/a/b.txt",
+			params:  map[string]string{"path": "a/b.txt"},
+			ok:      true,
+		},
+		{
+			pattern: "/files/{path...}",
+			path:    "/files/",
+			params:  map[string]string{"path": ""},
+			ok:      true,
+		},
+		{pattern: "/files/{path...}", path: "/files"},
+	} {
+		t.Run(test.pattern+" "+test.path, func(t *testing.T) {
+			r := NewRouter(ms.Noop)
+			r.Handle(test.pattern, nil)
+			params, ok := r.routes[0].match(test.path)
+			if ok != test.ok {
+				t.Fatalf("unexpected match: %t; want %t", ok, test.ok)
+			}
+			if ok && !reflect.DeepEqual(params, test.params) {
+				t.Errorf("unexpected params: %v; want %v", params, test.params)
+			}
+		})
+	}
+}
+
+// routeSessions reports the name and the path parameters of each connected
+// session.
+type routeSessions struct {
+	name string
+	ch   chan routed
+}
+
+type routed struct {
+	name     string
+	protocol string
+	params   map[string]string
+}
+
+func (s routeSessions) Connect(ctx context.Context, w ms.SendFunc, c func()) (ms.SessionHandler, error) {
+	hs, _ := HandshakeFromContext(ctx)
+	params, _ := ParamsFromContext(ctx)
+	s.ch <- routed{s.name, hs.Protocol, params}
+	return &echoSession{id: 1, send: w, cancel: c}, nil
+}
+
+func (routeSessions) Close(s ms.SessionHandler) error {
+	s.Close()
+	return nil
+}
+
+func TestRouter(t *testing.T) {
+	ch := make(chan routed, 1)
+	r := NewRouter(ms.Noop)
+	r.Handle("/chat", routeSessions{"chat", ch})
+	r.Handle("/rooms/{id}", routeSessions{"room", ch})
+	r.Handle("/metrics", routeSessions{"metrics.v2", ch}, "v2.metrics")
+	r.Handle("/metrics", routeSessions{"metrics.v1", ch}, "v1.metrics", "metrics")
+
+	if act, exp := r.Protocols("/metrics"), []string{"v2.metrics", "v1.metrics", "metrics"}; !reflect.DeepEqual(act, exp) {
+		t.Errorf("unexpected protocols: %v; want %v", act, exp)
+	}
+	srv := httptest.NewServer(NewHTTPHandler(r, ms.Noop))
+	defer srv.Close()
+
+	for _, test := range []struct {
+		name      string
+		uri       string
+		protocols []string
+		exp       routed
+		status    int
+		advertise string
+	}{
+		{
+			name: "path",
+			uri:  "/chat?user=1",
+			exp:  routed{name: "chat"},
+		},
+		{
+			name:      "path ignores protocol",
+			uri:       "/chat",
+			protocols: []string{"v2.metrics"},
+			exp:       routed{name: "chat"},
+		},
+		{
+			name: "params",
+			uri:  "/rooms/42",
+			exp:  routed{name: "room", params: map[string]string{"id": "42"}},
+		},
+		{
+			name:      "protocol",
+			uri:       "/metrics",
+			protocols: []string{"v2.metrics"},
+			exp:       routed{name: "metrics.v2", protocol: "v2.metrics"},
+		},
+		{
+			name:      "protocol preference",
+			uri:       "/metrics",
+			protocols: []string{"graphql", "metrics", "v2.metrics"},
+			exp:       routed{name: "metrics.v1", protocol: "metrics"},
+		},
+		{
+			name:   "not found",
+			uri:    "/unknown",
+			status: http.StatusNotFound,
+		},
+		{
+			name:      "unsupported protocol",
+			uri:       "/metrics",
+			protocols: []string{"graphql"},
+			status:    http.StatusBadRequest,
+			advertise: "v2.metrics, v1.metrics, metrics",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			for _, mode := range []string{"goroutine", "poll", "http"} {
+				server, client := net.Pipe()
+				defer client.Close()
+				defer server.Close()
+
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				switch mode {
+				case "goroutine":
+					go r.NewConnecter().Run(ctx, server)
+				case "poll":
+					go r.NewPollConnecter().Open(ctx, server, func() {})
+				case "http":
+					conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+					if err != nil {
+						t.Fatal(err)
+					}
+					defer conn.Close()
+					client = conn
+				}
+				client.SetDeadline(time.Now().Add(time.Second))
+
+				if test.status != 0 {
+					resp := mustRoundTrip(t, client, test.uri, test.protocols)
+					if resp.StatusCode != test.status {
+						t.Fatalf("%s: unexpected status: %s", mode, resp.Status)
+					}
+					if act := resp.Header.Get("Sec-WebSocket-Protocol"); act != test.advertise {
+						t.Errorf("unexpected advertised protocols: %q; want %q", act, test.advertise)
+					}
+					continue
+				}
+
+				u, _ := url.Parse("ws://example.org" + test.uri)
+				_, hs, err := ms.Dialer{Protocols: test.protocols}.Upgrade(client, u)
+				if err != nil {
+					t.Fatal(mode, err)
+				}
+				if hs.Protocol != test.exp.protocol {
+					t.Errorf("%s: unexpected protocol: %q; want %q", mode, hs.Protocol, test.exp.protocol)
+				}
+				if act := <-ch; !reflect.DeepEqual(act, test.exp) {
+					t.Errorf("%s: unexpected route: %+v; want %+v", mode, act, test.exp)
+				}
+			}
+		})
+	}
+}
+
+func mustRoundTrip(t *testing.T, conn net.Conn, uri string, protocols []string) *http.Response {
+	req, err := http.NewRequest("GET", "http://example.org"+uri, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	for _, p := range protocols {
+		req.Header.Add("Sec-WebSocket-Protocol", p)
+	}
+	go req.Write(conn)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
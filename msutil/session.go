@@ -0,0 +1,341 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package msutil
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	ms "github.com/cmacro/mogusocket"
+)
+
+// closeTimeout is the time given to the peer to answer our close frame.
+const closeTimeout = 5 * time.Second
+
+// releaseFlushTimeout bounds the write of coalesced messages left pending
+// when the session is finished.
+const releaseFlushTimeout = 100 * time.Millisecond
+
+// maxCloseReason is the maximum size of the close frame reason, which is the
+// control frame payload limit without the status code.
+const maxCloseReason = ms.MaxControlFramePayloadSize - 2
+
+// sessionConn holds the write side of a WebSocket connection served by a
+// session. It serializes data messages sent by the session and control frame
+// responses written by the reading goroutine.
+type sessionConn struct {
+	conn   io.ReadWriter
+	state  ms.State
+	buf    *ms.BufferAccount
+	cancel func()
+
+	// hs is the result of the WebSocket handshake, if it was made.
+	hs *handshake
+
+	// peer is the credentials of the client process connected with the unix
+	// socket, if any.
+	peer *ms.PeerCred
+
+	// msg is held by the writer of a data message for the whole message
+	// time. Unlike mu it could be waited for with a context.
+	msg chan struct{}
+
+	// mu is held while a frame is written to conn. Control frames could be
+	// written between fragments of a data message.
+	mu      sync.Mutex
+	w       *Writer
+	closing bool
+
+	// out buffers messages written to conn if they are coalesced, see
+	// FlushPolicy.
+	out *coalescer
+}
+
+func newSessionConn(conn io.ReadWriter, state ms.State, pool *ms.BufferPool, cancel func()) *sessionConn {
+	buf := pool.NewAccount()
+	return &sessionConn{
+		conn:   conn,
+		state:  state,
+		buf:    buf,
+		cancel: cancel,
+		msg:    make(chan struct{}, 1),
+		w:      NewWriterPool(conn, state, 0, buf, 0),
+	}
+}
+
+// accept sets the handshake of the connection served by the server, which is
+// nil if no handshake was made, and the credentials of the peer. It must be
+// called before the session is connected.
+func (c *sessionConn) accept(hs *handshake) {
+	c.hs = hs
+	if hs != nil {
+		c.peer = hs.Peer
+	} else {
+		c.peer = peerCred(c.conn)
+	}
+}
+
+// coalesce makes the connection buffer data messages according to p. It
+// must be called before the session is connected.
+func (c *sessionConn) coalesce(p *FlushPolicy) {
+	if p == nil {
+		return
+	}
+	c.out = newCoalescer(c.conn, c.buf, p, c.flushPending)
+}
+
+// dst returns the writer of frames.
+func (c *sessionConn) dst() io.Writer {
+	if c.out != nil {
+		return c.out
+	}
+	return c.conn
+}
+
+// endWrite is called after a frame is written to dst. Pending bytes are
+// written unless the frame ends a data message, which could be coalesced.
+func (c *sessionConn) endWrite(fin bool) error {
+	if c.out == nil {
+		return nil
+	}
+	if fin {
+		return c.out.endMessage()
+	}
+	return c.out.Flush()
+}
+
+// flushPending writes messages buffered longer than the latency budget.
+func (c *sessionConn) flushPending() {
+	c.mu.Lock()
+	err := c.out.Flush()
+	c.mu.Unlock()
+	if err != nil {
+		c.interrupt()
+	}
+}
+
+type sessionConnKey struct{}
+
+// withSessionConn returns a copy of ctx that carries c. Such context is
+// passed to the session's Connect().
+func withSessionConn(ctx context.Context, c *sessionConn) context.Context {
+	return context.WithValue(ctx, sessionConnKey{}, c)
+}
+
+func sessionConnFromContext(ctx context.Context) *sessionConn {
+	c, _ := ctx.Value(sessionConnKey{}).(*sessionConn)
+	return c
+}
+
+// CloseSession starts the closing handshake of the connection which session
+// context is ctx. It sends close frame with given code and reason and cancels
+// the session context. Unlike returning CloseWithError from ReadPump(), it
+// could be called from any goroutine.
+func CloseSession(ctx context.Context, code ms.StatusCode, reason string) error {
+	c := sessionConnFromContext(ctx)
+	if c == nil {
+		return ErrNoSession
+	}
+	return c.closeWith(code, reason)
+}
+
+// release returns buffers held by the connection to the pool. The buffer of
+// a message being written is released by the writer itself.
+//
+// Pending coalesced messages are written within releaseFlushTimeout, so a
+// peer which does not read could not hold the session. The deadline of the
+// closing connection is already set by closeWith() or interrupt().
+func (c *sessionConn) release() {
+	if c.out != nil {
+		c.mu.Lock()
+		if !c.closing {
+			c.setWriteDeadline(time.Now().Add(releaseFlushTimeout))
+		}
+		c.out.Flush()
+		c.out.release()
+		c.mu.Unlock()
+	}
+	select {
+	case c.msg <- struct{}{}:
+		c.w.Release()
+		<-c.msg
+	default:
+	}
+}
+
+// send writes whole src as a single message. It implements ms.SendFunc.
+func (c *sessionConn) send(src io.Reader, isText bool) error {
+	opcode := ms.OpText
+	if !isText {
+		opcode = ms.OpBinary
+	}
+
+	c.msg <- struct{}{}
+	defer func() { <-c.msg }()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closing {
+		return ErrConnClosing
+	}
+	c.w.Reset(c.dst(), c.state, opcode)
+	defer c.w.Release()
+	_, err := io.Copy(c.w, src)
+	if err == nil {
+		err = c.w.Flush()
+	}
+	if err == nil {
+		err = c.endWrite(true)
+	}
+	return err
+}
+
+// handleControl handles control frame and writes response when needed. It
+// implements FrameHandlerFunc and could be used as Reader.OnIntermediate.
+func (c *sessionConn) handleControl(h ms.Header, r io.Reader) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	dst := c.dst()
+	if c.closing {
+		// Our close frame is sent already, so the peer's close frame is the
+		// answer which must not be answered.
+		dst = io.Discard
+	}
+	err := (ControlHandler{
+		DisableSrcCiphering: true,
+		Src:                 r,
+		Dst:                 dst,
+		State:               c.state,
+	}).Handle(h)
+	// The answer is sent even if the handler reports the closed connection.
+	if ferr := c.endWrite(false); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// closeWith starts the closing handshake sending close frame with given code
+// and reason. It cancels the session and gives the peer closeTimeout to
+// answer before reads and writes fail.
+func (c *sessionConn) closeWith(code ms.StatusCode, reason string) error {
+	c.mu.Lock()
+	if c.closing {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closing = true
+	if len(reason) > maxCloseReason {
+		reason = reason[:maxCloseReason]
+		for !utf8.ValidString(reason) {
+			reason = reason[:len(reason)-1]
+		}
+	}
+	d, ok := c.conn.(deadliner)
+	if ok {
+		d.SetDeadline(time.Now().Add(closeTimeout))
+	}
+	err := WriteMessage(c.dst(), c.state, ms.OpClose, ms.NewCloseFrameBody(code, reason))
+	if err == nil {
+		err = c.endWrite(false)
+	}
+	c.mu.Unlock()
+
+	c.cancel()
+	return err
+}
+
+// closeOnShutdown closes the connection with StatusGoingAway when shutdown is
+// closed. It stops watching when ctx is done.
+func (c *sessionConn) closeOnShutdown(ctx context.Context, shutdown <-chan struct{}) {
+	if shutdown == nil {
+		return
+	}
+	go func() {
+		select {
+		case <-shutdown:
+			c.closeWith(ms.StatusGoingAway, "server shutdown")
+		case <-ctx.Done():
+		}
+	}()
+}
+
+// interrupt breaks the connection without closing handshake. It is used
+// when the frame stream could be left in inconsistent state.
+func (c *sessionConn) interrupt() {
+	c.mu.Lock()
+	c.closing = true
+	c.mu.Unlock()
+
+	if d, ok := c.conn.(deadliner); ok {
+		d.SetDeadline(aLongTimeAgo)
+	}
+	c.cancel()
+}
+
+// setWriteDeadline sets write deadline on conn if it is supported.
+func (c *sessionConn) setWriteDeadline(t time.Time) {
+	if d, ok := c.conn.(deadliner); ok {
+		d.SetWriteDeadline(t)
+	}
+}
+
+// aLongTimeAgo is a non-zero time, far in the past, used for immediate
+// cancellation of network operations.
+var aLongTimeAgo = time.Unix(1, 0)
+
+type deadliner interface {
+	SetDeadline(time.Time) error
+	SetReadDeadline(time.Time) error
+	SetWriteDeadline(time.Time) error
+}
+
+// readPumper is implemented by both ms.SessionHandler and ms.ClientHandler.
+type readPumper interface {
+	ReadPump(r io.Reader, len int64, isText bool) error
+}
+
+// nextMessage reads next frame from r. It handles control frames and passes
+// data messages to the session. It returns after the whole message is read
+// or discarded.
+func (c *sessionConn) nextMessage(r *Reader, session readPumper, log ms.Logger) error {
+	h, err := r.NextFrame()
+	if err != nil {
+		if err == io.EOF {
+			if s, ok := session.(ms.SessionHandler); ok {
+				log.Info("closed", s.GetId())
+			} else {
+				log.Info("closed")
+			}
+		} else {
+			log.Error("next frame error", err)
+		}
+		return err
+	}
+	if h.OpCode.IsControl() {
+		log.Debug("is control", h.OpCode)
+		if err = c.handleControl(h, r); err != nil {
+			log.Error("handle control", err)
+		}
+		return err
+	}
+	err = session.ReadPump(r, h.Length, h.OpCode == ms.OpText)
+	if err != nil {
+		log.Info("read dump", err)
+		var ce CloseWithError
+		if errors.As(err, &ce) {
+			c.closeWith(ce.Code, ce.Reason)
+		}
+		return err
+	}
+	// Drop the bytes left unread by the session, so the next frame header
+	// could be read.
+	return r.Discard()
+}
@@ -0,0 +1,292 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package msutil
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	ms "github.com/cmacro/mogusocket"
+)
+
+var (
+	ErrNoSession    = errors.New("context is not a session context")
+	ErrConnClosing  = errors.New("connection is closing")
+	ErrWriterClosed = errors.New("message writer is closed")
+)
+
+// NextWriter returns a MessageWriter which sends the next data message of the
+// session as a sequence of fragments. The ctx must be derived from the context
+// passed to the session's Connect().
+//
+// Other messages of the session wait until the returned writer is closed.
+// Control frames could still be sent between the fragments.
+//
+// If ctx is canceled before the message is complete, the connection is closed
+// with ms.StatusGoingAway. If the cancellation interrupts writing of a
+// fragment, the connection is broken without the closing handshake.
+func NextWriter(ctx context.Context, isText bool) (*MessageWriter, error) {
+	sc := sessionConnFromContext(ctx)
+	if sc == nil {
+		return nil, ErrNoSession
+	}
+	select {
+	case sc.msg <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	sc.mu.Lock()
+	closing := sc.closing
+	sc.mu.Unlock()
+	if closing {
+		<-sc.msg
+		return nil, ErrConnClosing
+	}
+
+	op := ms.OpText
+	if !isText {
+		op = ms.OpBinary
+	}
+	sc.w.Reset(sc.dst(), sc.state, op)
+
+	m := &MessageWriter{
+		ctx:  ctx,
+		sc:   sc,
+		done: make(chan struct{}),
+	}
+	if ctx.Done() != nil {
+		go m.watch()
+	}
+	return m, nil
+}
+
+// MessageWriter writes a single data message of a session. Payload is sent
+// in fragments as soon as FragmentSize bytes are written, so the writes block
+// while the peer does not read the previous fragments.
+//
+// MessageWriter must be closed to complete the message and let other
+// messages be sent.
+type MessageWriter struct {
+	// FragmentSize is the maximum payload size of the sent fragments. If it
+	// is zero, the size of the connection write buffer is used. It must not
+	// be changed after the first write.
+	FragmentSize int
+
+	// Progress is called after each fragment is sent with the number of
+	// payload bytes of the message sent so far.
+	Progress func(sent int64)
+
+	ctx    context.Context
+	sc     *sessionConn
+	frag   int
+	sent   int64
+	err    error
+	closed bool
+	done   chan struct{}
+
+	mu          sync.Mutex
+	flushing    bool
+	interrupted bool
+}
+
+// Sent returns the number of payload bytes sent so far.
+func (m *MessageWriter) Sent() int64 {
+	return m.sent
+}
+
+// Write implements io.Writer.
+func (m *MessageWriter) Write(p []byte) (n int, err error) {
+	if m.closed {
+		return 0, ErrWriterClosed
+	}
+	if m.err != nil {
+		return 0, m.err
+	}
+	frag := m.fragmentSize()
+	for len(p) > 0 {
+		if err = m.flushFull(frag); err != nil {
+			return n, err
+		}
+		k := frag - m.sc.w.Buffered()
+		if k > len(p) {
+			k = len(p)
+		}
+		// Never flushes as there is free space for k bytes.
+		m.sc.w.Write(p[:k])
+		n += k
+		p = p[k:]
+	}
+	return n, nil
+}
+
+// ReadFrom implements io.ReaderFrom. It sends the data read from src until
+// io.EOF. It does not complete the message.
+func (m *MessageWriter) ReadFrom(src io.Reader) (n int64, err error) {
+	if m.closed {
+		return 0, ErrWriterClosed
+	}
+	if m.err != nil {
+		return 0, m.err
+	}
+	frag := m.fragmentSize()
+	for {
+		if err = m.flushFull(frag); err != nil {
+			return n, err
+		}
+		want := int64(frag - m.sc.w.Buffered())
+		nn, err := m.sc.w.ReadFrom(io.LimitReader(src, want))
+		n += nn
+		if err != nil || nn < want {
+			return n, err
+		}
+	}
+}
+
+// Close sends the rest of the message with the final fragment. It must be
+// called even if some write has failed.
+func (m *MessageWriter) Close() error {
+	if m.closed {
+		return ErrWriterClosed
+	}
+	err := m.err
+	if err == nil {
+		// Make empty message to be sent as well.
+		m.sc.w.Write(nil)
+		err = m.flush(true)
+	}
+
+	m.closed = true
+	close(m.done)
+	m.sc.w.Release()
+	<-m.sc.msg
+
+	return err
+}
+
+// abort closes the writer without completing the message. The buffered data
+// is dropped. If some fragments are already sent, the message could not be
+// completed anymore, so the connection is closed.
+func (m *MessageWriter) abort(reason string) {
+	if m.closed {
+		return
+	}
+	if m.err == nil && m.sent > 0 {
+		m.sc.closeWith(ms.StatusInternalServerError, reason)
+	}
+
+	m.closed = true
+	close(m.done)
+	m.sc.w.Release()
+	<-m.sc.msg
+}
+
+// fragmentSize prepares the buffer for the first fragment and returns the
+// fragment size.
+func (m *MessageWriter) fragmentSize() int {
+	if m.frag == 0 {
+		m.frag = m.FragmentSize
+		if m.frag <= 0 {
+			m.sc.w.Grow(0)
+			m.frag = m.sc.w.Size() - 1
+		}
+		// The spare byte lets Writer.ReadFrom() reach the end of limited
+		// source before the buffer is full, so it never flushes by itself.
+		m.sc.w.Grow(m.frag + 1)
+	}
+	return m.frag
+}
+
+// flushFull sends the buffered fragment if it has reached the frag size.
+// The fragment is sent only when more data comes, so the last fragment is
+// always sent by Close().
+func (m *MessageWriter) flushFull(frag int) error {
+	if m.sc.w.Buffered() < frag {
+		return nil
+	}
+	return m.flush(false)
+}
+
+func (m *MessageWriter) flush(fin bool) (err error) {
+	// Mark the flush before the context check, so the cancellation is either
+	// seen here or interrupts the write.
+	m.setFlushing(true)
+	if err = m.ctx.Err(); err != nil {
+		if m.setFlushing(false) {
+			m.sc.setWriteDeadline(time.Time{})
+		}
+		m.err = err
+		m.sc.closeWith(ms.StatusGoingAway, "message canceled")
+		return err
+	}
+
+	n := m.sc.w.Buffered()
+
+	m.sc.mu.Lock()
+	closing := m.sc.closing
+	if closing {
+		err = ErrConnClosing
+	} else if fin {
+		err = m.sc.w.Flush()
+	} else {
+		err = m.sc.w.FlushFragment()
+	}
+	if err == nil {
+		err = m.sc.endWrite(fin)
+	}
+	m.sc.mu.Unlock()
+	interrupted := m.setFlushing(false)
+
+	if closing {
+		m.err = err
+		return err
+	}
+	if err != nil {
+		if interrupted {
+			err = m.ctx.Err()
+		}
+		m.err = err
+		m.sc.interrupt()
+		return err
+	}
+	if interrupted {
+		// Cancellation came after the fragment was written. Next flush
+		// will close the connection properly.
+		m.sc.setWriteDeadline(time.Time{})
+	}
+
+	m.sent += int64(n)
+	if m.Progress != nil {
+		m.Progress(m.sent)
+	}
+	return nil
+}
+
+// setFlushing marks whether the fragment is being written. It returns true
+// if the write was interrupted by the context cancellation.
+func (m *MessageWriter) setFlushing(v bool) (interrupted bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.flushing = v
+	interrupted, m.interrupted = m.interrupted, false
+	return interrupted
+}
+
+// watch unblocks a fragment write when the context is canceled.
+func (m *MessageWriter) watch() {
+	select {
+	case <-m.ctx.Done():
+		m.mu.Lock()
+		if m.flushing {
+			m.interrupted = true
+			m.sc.setWriteDeadline(aLongTimeAgo)
+		}
+		m.mu.Unlock()
+	case <-m.done:
+	}
+}
@@ -0,0 +1,227 @@
+package msutil
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	ms "github.com/cmacro/mogusocket"
+)
+
+func TestNextWriterFragments(t *testing.T) {
+	ctx, client := serveSession(t)
+
+	m, err := NextWriter(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.FragmentSize = 10
+
+	var progress []int64
+	m.Progress = func(sent int64) { progress = append(progress, sent) }
+
+	data := bytes.Repeat([]byte("x"), 35)
+	done := make(chan error, 1)
+	go func() {
+		if _, err := m.ReadFrom(bytes.NewReader(data)); err != nil {
+			done <- err
+			return
+		}
+		done <- m.Close()
+	}()
+
+	var payload []byte
+	for i, exp := range []struct {
+		op  ms.OpCode
+		fin bool
+		n   int64
+	}{
+		{ms.OpText, false, 10},
+		{ms.OpContinuation, false, 10},
+		{ms.OpContinuation, false, 10},
+		{ms.OpContinuation, true, 5},
+	} {
+		h, p := mustReadFrame(t, client)
+		if h.OpCode != exp.op || h.Fin != exp.fin || h.Length != exp.n {
+			t.Fatalf("#%d: unexpected header: %+v", i, h)
+		}
+		payload = append(payload, p...)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(payload, data) {
+		t.Errorf("unexpected payload: %q", payload)
+	}
+	if exp := []int64{10, 20, 30, 35}; !reflect.DeepEqual(progress, exp) {
+		t.Errorf("unexpected progress: %v; want %v", progress, exp)
+	}
+
+	// The next message could be sent after the writer is closed.
+	m, err = NextWriter(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { done <- m.Close() }()
+	if h, _ := mustReadFrame(t, client); h.OpCode != ms.OpBinary || !h.Fin || h.Length != 0 {
+		t.Errorf("unexpected header of empty message: %+v", h)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNextWriterCancel(t *testing.T) {
+	sctx, client := serveSession(t)
+	ctx, cancel := context.WithCancel(sctx)
+
+	m, err := NextWriter(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.FragmentSize = 4
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := m.Write([]byte("abcdefgh"))
+		if err == nil {
+			cancel()
+			_, err = m.Write([]byte("ijkl"))
+		}
+		m.Close()
+		done <- err
+	}()
+
+	if h, p := mustReadFrame(t, client); h.OpCode != ms.OpBinary || string(p) != "abcd" {
+		t.Fatalf("unexpected frame: %+v %q", h, p)
+	}
+	h, p := mustReadFrame(t, client)
+	if h.OpCode != ms.OpClose {
+		t.Fatalf("unexpected frame: %+v", h)
+	}
+	if code, _ := ms.ParseCloseFrameData(p); code != ms.StatusGoingAway {
+		t.Errorf("unexpected close code: %v", code)
+	}
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := NextWriter(sctx, true); err != ErrConnClosing && err != context.Canceled {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNextWriterCancelBlocked(t *testing.T) {
+	sctx, _ := serveSession(t)
+	ctx, cancel := context.WithCancel(sctx)
+
+	m, err := NextWriter(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.FragmentSize = 4
+
+	done := make(chan error, 1)
+	go func() {
+		// The peer does not read, so the first fragment blocks.
+		_, err := m.Write([]byte("abcdefgh"))
+		m.Close()
+		done <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("write was not interrupted")
+	}
+}
+
+func TestNextWriterNoSession(t *testing.T) {
+	if _, err := NextWriter(context.Background(), true); err != ErrNoSession {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestCloseSession(t *testing.T) {
+	ctx, conn := serveSession(t)
+	conn.SetDeadline(time.Now().Add(time.Second))
+
+	errc := make(chan error, 1)
+	go func() { errc <- CloseSession(ctx, ms.StatusPolicyViolation, "bye") }()
+
+	h, p := mustReadFrame(t, conn)
+	if h.OpCode != ms.OpClose {
+		t.Fatalf("unexpected opcode: %v", h.OpCode)
+	}
+	if code, reason := ms.ParseCloseFrameData(p); code != ms.StatusPolicyViolation || reason != "bye" {
+		t.Errorf("unexpected close frame: %v %q", code, reason)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ctx.Done():
+	default:
+		t.Error("session context is not canceled")
+	}
+	if err := CloseSession(context.Background(), ms.StatusNormalClosure, ""); err != ErrNoSession {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// serveSession runs Connecter on one end of the pipe and returns the session
+// context and the other end.
+func serveSession(t *testing.T) (context.Context, net.Conn) {
+	server, client := net.Pipe()
+	sessions := &ctxSessions{ctx: make(chan context.Context, 1)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		NewConnecter(sessions, ms.Noop).Run(ctx, server)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		client.Close()
+		server.Close()
+		<-done
+	})
+
+	return <-sessions.ctx, client
+}
+
+type ctxSessions struct {
+	ctx chan context.Context
+}
+
+func (s *ctxSessions) Connect(ctx context.Context, w ms.SendFunc, c func()) (ms.SessionHandler, error) {
+	s.ctx <- ctx
+	return &echoSession{send: w, cancel: c}, nil
+}
+
+func (s *ctxSessions) Close(session ms.SessionHandler) error {
+	session.Close()
+	return nil
+}
+
+func mustReadFrame(t *testing.T, r io.Reader) (ms.Header, []byte) {
+	h, err := ms.ReadHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := make([]byte, h.Length)
+	if _, err := io.ReadFull(r, p); err != nil {
+		t.Fatal(err)
+	}
+	return h, p
+}
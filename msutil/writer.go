@@ -148,6 +148,12 @@ type Writer struct {
 	// noFlush reports whether buffer must grow instead of being flushed.
 	noFlush bool
 
+	// pool is the source of the buffer for writers created by
+	// NewWriterPool(). Such writers hold the buffer only while there is a
+	// message being written.
+	pool     *ms.BufferAccount
+	poolSize int
+
 	// Raw representation of the buffer, including reserved header bytes.
 	raw []byte
 
@@ -216,6 +222,46 @@ func NewWriterBuffer(dest io.Writer, state ms.State, op ms.OpCode, buf []byte) *
 	return w
 }
 
+// NewWriterPool returns a new Writer which takes its buffer of size n from the
+// pool on the first write and returns it back after every Flush(). That is,
+// the Writer does not hold any memory between messages.
+//
+// If n <= ms.MinHeaderSize then the default buffer size is used.
+func NewWriterPool(dest io.Writer, state ms.State, op ms.OpCode, pool *ms.BufferAccount, n int) *Writer {
+	if n <= ms.MinHeaderSize {
+		n = DefaultWriteBuffer
+	}
+	return &Writer{
+		dest:     dest,
+		state:    state,
+		op:       op,
+		pool:     pool,
+		poolSize: n,
+	}
+}
+
+// acquire takes the buffer from the pool if it was released before.
+func (w *Writer) acquire() {
+	if w.raw == nil && w.pool != nil {
+		w.raw = w.pool.Get(w.poolSize)
+		w.initBuf()
+	}
+}
+
+// Release returns the buffer of a Writer created by NewWriterPool() to the
+// pool dropping any unflushed data. It does nothing for other writers.
+//
+// Writer takes new buffer from the pool on next write.
+func (w *Writer) Release() {
+	if w.pool == nil || w.raw == nil {
+		return
+	}
+	w.pool.Put(w.raw)
+	w.raw = nil
+	w.buf = nil
+	w.n = 0
+}
+
 func (w *Writer) initBuf() {
 	offset := reserve(w.state, len(w.raw))
 	if len(w.raw) <= offset {
@@ -232,7 +278,9 @@ func (w *Writer) Reset(dest io.Writer, state ms.State, op ms.OpCode) {
 	w.state = state
 	w.op = op
 
-	w.initBuf()
+	if w.raw != nil {
+		w.initBuf()
+	}
 
 	w.n = 0
 	w.dirty = false
@@ -284,6 +332,8 @@ func (w *Writer) Buffered() int {
 // with payload of N bytes will not fit into that buffer. Writer reserves some
 // space to fit WebSocket header data.
 func (w *Writer) Write(p []byte) (n int, err error) {
+	w.acquire()
+
 	// Even empty p may make a sense.
 	w.dirty = true
 
@@ -339,6 +389,8 @@ func ceilPowerOfTwo(n int) int {
 // Grow grows Writer's internal buffer capacity to guarantee space for another
 // n bytes of _payload_ -- that is, frame header is not included in n.
 func (w *Writer) Grow(n int) {
+	w.acquire()
+
 	// NOTE: we must respect the possibility of header reserved bytes grow.
 	var (
 		size       = len(w.raw)
@@ -368,8 +420,16 @@ func (w *Writer) Grow(n int) {
 	if size == len(w.raw) {
 		return
 	}
-	p := make([]byte, size)
+	var p []byte
+	if w.pool != nil {
+		p = w.pool.Get(size)
+	} else {
+		p = make([]byte, size)
+	}
 	copy(p[nextOffset-prevOffset:], w.raw[:prevOffset+buffered])
+	if w.pool != nil {
+		w.pool.Put(w.raw)
+	}
 	w.raw = p
 	w.buf = w.raw[nextOffset:]
 }
@@ -421,6 +481,8 @@ func (w *Writer) WriteThrough(p []byte) (n int, err error) {
 
 // ReadFrom implements io.ReaderFrom.
 func (w *Writer) ReadFrom(src io.Reader) (n int64, err error) {
+	w.acquire()
+
 	var nn int
 	for err == nil {
 		if w.Available() == 0 {
@@ -475,6 +537,7 @@ func (w *Writer) Flush() error {
 	w.n = 0
 	w.dirty = false
 	w.fseq = 0
+	w.Release()
 
 	return w.err
 }
@@ -494,6 +557,9 @@ func (w *Writer) FlushFragment() error {
 }
 
 func (w *Writer) flushFragment(fin bool) (err error) {
+	// The buffer could be released if the only write made was WriteThrough().
+	w.acquire()
+
 	var (
 		payload = w.buf[:w.n]
 		header  = ms.Header{
@@ -0,0 +1,122 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package mux
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	ms "github.com/cmacro/mogusocket"
+)
+
+// Server is the ms.SessionsHandler making a Session of each connection.
+type Server struct {
+	Config
+	log ms.Logger
+
+	// OnSession is called in a new goroutine with each new session.
+	// Streams opened by the client are accepted with Session.Accept().
+	OnSession func(s *Session)
+
+	lastID int64
+}
+
+// NewServer returns Server with default configuration.
+func NewServer(log ms.Logger) *Server {
+	return &Server{log: log}
+}
+
+// Connect implements ms.SessionsHandler.
+func (s *Server) Connect(ctx context.Context, w ms.SendFunc, c func()) (ms.SessionHandler, error) {
+	sess := &serverSession{
+		id:   atomic.AddInt64(&s.lastID, 1),
+		sess: newSession(ctx, w, c, false, s.Config, s.log),
+	}
+	if s.OnSession != nil {
+		go s.OnSession(sess.sess)
+	}
+	return sess, nil
+}
+
+// Close implements ms.SessionsHandler.
+func (s *Server) Close(session ms.SessionHandler) error {
+	if sess, ok := session.(*serverSession); ok {
+		sess.sess.shutdown(ErrSessionShutdown)
+	}
+	return nil
+}
+
+type serverSession struct {
+	id   int64
+	sess *Session
+}
+
+func (s *serverSession) GetId() int64 { return s.id }
+
+func (s *serverSession) Close() { s.sess.Close() }
+
+func (s *serverSession) ReadPump(r io.Reader, len int64, isText bool) error {
+	return s.sess.readPump(r, isText)
+}
+
+// Client is the ms.ClientHandler making a Session of the connection. It is
+// used with msutil.Client or msutil.AutoConnectClient; a new Session is made
+// for each connection.
+type Client struct {
+	Config
+	log ms.Logger
+
+	// OnSession is called in a new goroutine with each new session.
+	OnSession func(s *Session)
+
+	mu   sync.Mutex
+	sess *Session
+}
+
+// NewClient returns Client with default configuration.
+func NewClient(log ms.Logger) *Client {
+	return &Client{log: log}
+}
+
+// Session returns the session of the current connection or nil.
+func (c *Client) Session() *Session {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sess
+}
+
+// Connect implements ms.ClientHandler.
+func (c *Client) Connect(ctx context.Context, w ms.SendFunc, cancel func()) error {
+	sess := newSession(ctx, w, cancel, true, c.Config, c.log)
+	c.mu.Lock()
+	c.sess = sess
+	c.mu.Unlock()
+	if c.OnSession != nil {
+		go c.OnSession(sess)
+	}
+	return nil
+}
+
+// ReadPump implements ms.ClientHandler.
+func (c *Client) ReadPump(r io.Reader, len int64, isText bool) error {
+	sess := c.Session()
+	if sess == nil {
+		return ErrSessionShutdown
+	}
+	return sess.readPump(r, isText)
+}
+
+// Close implements ms.ClientHandler.
+func (c *Client) Close() {
+	c.mu.Lock()
+	sess := c.sess
+	c.sess = nil
+	c.mu.Unlock()
+	if sess != nil {
+		sess.shutdown(ErrSessionShutdown)
+	}
+}
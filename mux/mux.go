@@ -0,0 +1,156 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+// Package mux multiplexes many bidirectional streams over a single
+// mogusocket session.
+//
+// The protocol is similar to yamux. Each frame is sent as a binary message
+// which starts with 12 bytes header:
+//
+//	version (1) | type (1) | flags (2) | stream id (4) | length (4)
+//
+// Data frames carry length bytes of stream data. Window update frames grant
+// the peer length more bytes of the stream window. Ping frames carry an
+// opaque value in length and go away frames carry the error code. Streams
+// are opened with SYN flag, confirmed with ACK, half closed with FIN and
+// reset with RST. Streams opened by the client have odd ids and the ones
+// opened by the server have even ids.
+package mux
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+)
+
+const protoVersion = 0
+
+// Frame types.
+const (
+	typeData byte = iota
+	typeWindowUpdate
+	typePing
+	typeGoAway
+)
+
+// Frame flags.
+const (
+	flagSYN uint16 = 1 << iota
+	flagACK
+	flagFIN
+	flagRST
+)
+
+// Go away error codes.
+const (
+	goAwayNormal uint32 = iota
+	goAwayProtocolError
+	goAwayInternalError
+)
+
+const headerSize = 12
+
+var (
+	ErrInvalidFrame     = errors.New("mux: invalid frame")
+	ErrSessionShutdown  = errors.New("mux: session shutdown")
+	ErrRemoteGoAway     = errors.New("mux: remote end is not accepting streams")
+	ErrStreamClosed     = errors.New("mux: stream closed")
+	ErrStreamReset      = errors.New("mux: stream reset")
+	ErrStreamsExhausted = errors.New("mux: stream ids exhausted")
+	ErrKeepAliveTimeout = errors.New("mux: keepalive timeout")
+
+	// ErrTimeout is returned by stream operations exceeding deadlines. It
+	// implements net.Error with Timeout() returning true.
+	ErrTimeout = os.ErrDeadlineExceeded
+)
+
+type header [headerSize]byte
+
+func makeHeader(typ byte, flags uint16, id, length uint32) header {
+	var h header
+	h[0] = protoVersion
+	h[1] = typ
+	binary.BigEndian.PutUint16(h[2:4], flags)
+	binary.BigEndian.PutUint32(h[4:8], id)
+	binary.BigEndian.PutUint32(h[8:12], length)
+	return h
+}
+
+func (h *header) typ() byte        { return h[1] }
+func (h *header) flags() uint16    { return binary.BigEndian.Uint16(h[2:4]) }
+func (h *header) streamID() uint32 { return binary.BigEndian.Uint32(h[4:8]) }
+func (h *header) length() uint32   { return binary.BigEndian.Uint32(h[8:12]) }
+
+// Default configuration values.
+const (
+	DefaultAcceptBacklog     = 256
+	DefaultStreamWindow      = initialWindow
+	DefaultMaxFrameSize      = 32 << 10
+	DefaultKeepAliveInterval = 30 * time.Second
+)
+
+// initialWindow is the window every stream starts with. Larger windows are
+// granted with window updates sent along with SYN and ACK.
+const initialWindow = 256 << 10
+
+// Config is the configuration of sessions. Zero values mean defaults.
+type Config struct {
+	// AcceptBacklog is the number of streams opened by the peer and not yet
+	// accepted. Streams over the backlog are reset.
+	AcceptBacklog int
+
+	// StreamWindow is the receive window of each stream. It could not be
+	// less than the initial window of 256KiB.
+	StreamWindow uint32
+
+	// MaxFrameSize limits the data of a single frame sent.
+	MaxFrameSize int
+
+	// KeepAliveInterval is the interval of pings sent to the peer. The
+	// session is closed if a ping is not answered within the interval.
+	// Negative value disables keepalive.
+	KeepAliveInterval time.Duration
+}
+
+func (c Config) acceptBacklog() int {
+	if c.AcceptBacklog > 0 {
+		return c.AcceptBacklog
+	}
+	return DefaultAcceptBacklog
+}
+
+func (c Config) streamWindow() uint32 {
+	if c.StreamWindow > initialWindow {
+		return c.StreamWindow
+	}
+	return initialWindow
+}
+
+func (c Config) maxFrameSize() int {
+	if c.MaxFrameSize > 0 {
+		return c.MaxFrameSize
+	}
+	return DefaultMaxFrameSize
+}
+
+func (c Config) keepAliveInterval() time.Duration {
+	if c.KeepAliveInterval == 0 {
+		return DefaultKeepAliveInterval
+	}
+	return c.KeepAliveInterval
+}
+
+// Addr is the address of a session or stream.
+type Addr struct {
+	StreamID uint32
+}
+
+// Network implements net.Addr.
+func (a Addr) Network() string { return "mux" }
+
+func (a Addr) String() string {
+	return "mux:" + strconv.FormatUint(uint64(a.StreamID), 10)
+}
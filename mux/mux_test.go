@@ -0,0 +1,32 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package mux
+
+import "testing"
+
+func TestHeader(t *testing.T) {
+	h := makeHeader(typeWindowUpdate, flagSYN|flagFIN, 0x01020304, 0xfffffffe)
+	if h.typ() != typeWindowUpdate || h.flags() != flagSYN|flagFIN ||
+		h.streamID() != 0x01020304 || h.length() != 0xfffffffe {
+		t.Fatalf("unexpected header: % x", h)
+	}
+	if h[0] != protoVersion {
+		t.Fatalf("unexpected version: %d", h[0])
+	}
+}
+
+func TestConfigDefaults(t *testing.T) {
+	var c Config
+	if c.acceptBacklog() != DefaultAcceptBacklog ||
+		c.streamWindow() != DefaultStreamWindow ||
+		c.maxFrameSize() != DefaultMaxFrameSize ||
+		c.keepAliveInterval() != DefaultKeepAliveInterval {
+		t.Fatalf("unexpected defaults")
+	}
+	c.StreamWindow = 1
+	if c.streamWindow() != initialWindow {
+		t.Fatalf("window is less than initial one: %d", c.streamWindow())
+	}
+}
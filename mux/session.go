@@ -0,0 +1,352 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package mux
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	ms "github.com/cmacro/mogusocket"
+	"github.com/cmacro/mogusocket/msutil"
+)
+
+// Session is the multiplexed connection. It implements net.Listener
+// accepting streams opened by the peer.
+type Session struct {
+	ctx    context.Context
+	send   ms.SendFunc
+	cancel func()
+	conf   Config
+	log    ms.Logger
+
+	accept chan *Stream
+	closed chan struct{}
+
+	mu           sync.Mutex
+	streams      map[uint32]*Stream
+	nextID       uint32
+	pings        map[uint32]chan struct{}
+	pingID       uint32
+	remoteGoAway bool
+	localGoAway  bool
+	err          error
+}
+
+func newSession(ctx context.Context, send ms.SendFunc, cancel func(), client bool, conf Config, log ms.Logger) *Session {
+	s := &Session{
+		ctx:     ctx,
+		send:    send,
+		cancel:  cancel,
+		conf:    conf,
+		log:     log,
+		accept:  make(chan *Stream, conf.acceptBacklog()),
+		closed:  make(chan struct{}),
+		streams: make(map[uint32]*Stream),
+		pings:   make(map[uint32]chan struct{}),
+		nextID:  2,
+	}
+	if client {
+		s.nextID = 1
+	}
+	if conf.keepAliveInterval() > 0 {
+		go s.keepAlive()
+	}
+	return s
+}
+
+// Open opens a new stream. It does not wait for the peer to accept it.
+func (s *Session) Open() (*Stream, error) {
+	s.mu.Lock()
+	switch {
+	case s.err != nil:
+		s.mu.Unlock()
+		return nil, ErrSessionShutdown
+	case s.remoteGoAway:
+		s.mu.Unlock()
+		return nil, ErrRemoteGoAway
+	case s.nextID >= math.MaxUint32-1:
+		s.mu.Unlock()
+		return nil, ErrStreamsExhausted
+	}
+	id := s.nextID
+	s.nextID += 2
+	st := newStream(s, id)
+	s.streams[id] = st
+	s.mu.Unlock()
+
+	if err := st.sendWindowUpdate(flagSYN, s.conf.streamWindow()-initialWindow); err != nil {
+		s.forget(id)
+		return nil, err
+	}
+	return st, nil
+}
+
+// OpenConn is Open() returning net.Conn.
+func (s *Session) OpenConn() (net.Conn, error) {
+	st, err := s.Open()
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// AcceptStream waits for the next stream opened by the peer.
+func (s *Session) AcceptStream() (*Stream, error) {
+	select {
+	case st := <-s.accept:
+		return st, nil
+	case <-s.closed:
+		return nil, ErrSessionShutdown
+	}
+}
+
+// Accept implements net.Listener.
+func (s *Session) Accept() (net.Conn, error) {
+	st, err := s.AcceptStream()
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// Addr implements net.Listener.
+func (s *Session) Addr() net.Addr {
+	return Addr{}
+}
+
+// NumStreams returns the number of open streams.
+func (s *Session) NumStreams() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.streams)
+}
+
+// Done returns the channel closed when the session is shut down.
+func (s *Session) Done() <-chan struct{} {
+	return s.closed
+}
+
+// GoAway tells the peer that no more streams are accepted. Open streams are
+// not affected.
+func (s *Session) GoAway() error {
+	s.mu.Lock()
+	s.localGoAway = true
+	s.mu.Unlock()
+	return s.writeFrame(makeHeader(typeGoAway, 0, 0, goAwayNormal), nil)
+}
+
+// Close sends go away to the peer, resets all streams and closes the
+// underlying connection.
+func (s *Session) Close() error {
+	s.GoAway()
+	s.shutdown(ErrSessionShutdown)
+	msutil.CloseSession(s.ctx, ms.StatusNormalClosure, "")
+	s.cancel()
+	return nil
+}
+
+// Ping sends a ping to the peer and returns the round trip time.
+func (s *Session) Ping() (time.Duration, error) {
+	ch := make(chan struct{})
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return 0, ErrSessionShutdown
+	}
+	s.pingID++
+	id := s.pingID
+	s.pings[id] = ch
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pings, id)
+		s.mu.Unlock()
+	}()
+
+	start := time.Now()
+	if err := s.writeFrame(makeHeader(typePing, flagSYN, 0, id), nil); err != nil {
+		return 0, err
+	}
+	timeout := s.conf.keepAliveInterval()
+	if timeout < 0 {
+		timeout = DefaultKeepAliveInterval
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-ch:
+		return time.Since(start), nil
+	case <-t.C:
+		return 0, ErrKeepAliveTimeout
+	case <-s.closed:
+		return 0, ErrSessionShutdown
+	}
+}
+
+func (s *Session) keepAlive() {
+	t := time.NewTicker(s.conf.keepAliveInterval())
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if _, err := s.Ping(); err == ErrKeepAliveTimeout {
+				s.log.Info("mux keepalive timeout")
+				s.shutdown(err)
+				msutil.CloseSession(s.ctx, ms.StatusGoingAway, "keepalive timeout")
+				return
+			}
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+// shutdown fails all streams and pending operations with err.
+func (s *Session) shutdown(err error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.err = err
+	streams := s.streams
+	s.streams = make(map[uint32]*Stream)
+	close(s.closed)
+	s.mu.Unlock()
+
+	for _, st := range streams {
+		st.notify()
+	}
+}
+
+func (s *Session) forget(id uint32) {
+	s.mu.Lock()
+	delete(s.streams, id)
+	s.mu.Unlock()
+}
+
+func (s *Session) writeFrame(h header, p []byte) error {
+	select {
+	case <-s.closed:
+		return ErrSessionShutdown
+	default:
+	}
+	var src io.Reader = bytes.NewReader(h[:])
+	if len(p) > 0 {
+		src = io.MultiReader(src, bytes.NewReader(p))
+	}
+	return s.send(src, false)
+}
+
+// readPump reads a single frame of the message.
+func (s *Session) readPump(r io.Reader, isText bool) error {
+	if isText {
+		return s.protocolError("text message")
+	}
+	var h header
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			return s.protocolError("short frame")
+		}
+		return err
+	}
+	if h[0] != protoVersion {
+		return s.protocolError("unsupported version")
+	}
+
+	switch h.typ() {
+	case typeData, typeWindowUpdate:
+		return s.handleStreamFrame(&h, r)
+	case typePing:
+		if h.flags()&flagSYN != 0 {
+			return s.writeFrame(makeHeader(typePing, flagACK, 0, h.length()), nil)
+		}
+		s.mu.Lock()
+		ch, ok := s.pings[h.length()]
+		delete(s.pings, h.length())
+		s.mu.Unlock()
+		if ok {
+			close(ch)
+		}
+	case typeGoAway:
+		s.mu.Lock()
+		s.remoteGoAway = true
+		s.mu.Unlock()
+		if code := h.length(); code != goAwayNormal {
+			s.log.Info("mux go away", code)
+		}
+	default:
+		return s.protocolError("unknown frame type")
+	}
+	return nil
+}
+
+func (s *Session) handleStreamFrame(h *header, r io.Reader) error {
+	var (
+		id    = h.streamID()
+		flags = h.flags()
+	)
+	if flags&flagSYN != 0 {
+		if err := s.incoming(id); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	st := s.streams[id]
+	s.mu.Unlock()
+	if st == nil {
+		// The stream is closed or reset already; drop its frames.
+		return nil
+	}
+
+	if h.typ() == typeWindowUpdate {
+		st.incrSendWindow(h.length())
+	} else if err := st.readData(h.length(), r); err != nil {
+		st.Reset()
+		return nil
+	}
+	st.processFlags(flags)
+	return nil
+}
+
+// incoming registers the stream opened by the peer.
+func (s *Session) incoming(id uint32) error {
+	s.mu.Lock()
+	if _, ok := s.streams[id]; ok || id%2 == s.nextID%2 {
+		s.mu.Unlock()
+		return s.protocolError("duplicate stream id")
+	}
+	if s.localGoAway || s.err != nil {
+		s.mu.Unlock()
+		return s.writeFrame(makeHeader(typeWindowUpdate, flagRST, id, 0), nil)
+	}
+	st := newStream(s, id)
+	s.streams[id] = st
+	s.mu.Unlock()
+
+	if err := st.sendWindowUpdate(flagACK, s.conf.streamWindow()-initialWindow); err != nil {
+		return err
+	}
+	select {
+	case s.accept <- st:
+		return nil
+	default:
+		s.log.Info("mux accept backlog is full", id)
+		st.Reset()
+		return nil
+	}
+}
+
+func (s *Session) protocolError(reason string) error {
+	s.log.Info("mux protocol error", reason)
+	s.writeFrame(makeHeader(typeGoAway, 0, 0, goAwayProtocolError), nil)
+	s.shutdown(ErrInvalidFrame)
+	return msutil.CloseWithError{Code: ms.StatusProtocolError, Reason: reason}
+}
@@ -0,0 +1,368 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package mux
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	ms "github.com/cmacro/mogusocket"
+	"github.com/cmacro/mogusocket/msutil"
+)
+
+// socketPair returns both ends of a unix socket connection. Unlike net.Pipe()
+// sockets are buffered, so both sides could write control frames at once.
+func socketPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("unix", filepath.Join(t.TempDir(), "mux.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		accepted <- conn
+	}()
+	client, err := net.Dial("unix", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := <-accepted
+	if server == nil {
+		t.Fatal("accept failed")
+	}
+	return client, server
+}
+
+// pair connects mux client and server and returns their sessions.
+func pair(t *testing.T, conf Config) (client, server *Session) {
+	t.Helper()
+	cc, sc := socketPair(t)
+
+	srv := NewServer(ms.Noop)
+	srv.Config = conf
+	sessions := make(chan *Session, 1)
+	srv.OnSession = func(s *Session) { sessions <- s }
+	cli := NewClient(ms.Noop)
+	cli.Config = conf
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		msutil.NewConnecter(srv, ms.Noop).Run(ctx, sc)
+		sc.Close()
+	}()
+	go func() {
+		defer wg.Done()
+		msutil.ConnectClient(ctx, cc, cli, ms.Noop)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		cc.Close()
+		sc.Close()
+		wg.Wait()
+	})
+
+	server = <-sessions
+	for client = cli.Session(); client == nil; client = cli.Session() {
+		time.Sleep(time.Millisecond)
+	}
+	return client, server
+}
+
+func echo(s *Session) {
+	for {
+		st, err := s.AcceptStream()
+		if err != nil {
+			return
+		}
+		go func() {
+			io.Copy(st, st)
+			st.Close()
+		}()
+	}
+}
+
+func TestStreamEcho(t *testing.T) {
+	client, server := pair(t, Config{})
+	go echo(server)
+	go echo(client)
+
+	for _, s := range []*Session{client, server} {
+		st, err := s.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := st.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		st.CloseWrite()
+		st.SetDeadline(time.Now().Add(time.Second))
+		p, err := io.ReadAll(st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(p) != "hello" {
+			t.Fatalf("unexpected echo: %q", p)
+		}
+		if s == client && st.ID()%2 != 1 || s == server && st.ID()%2 != 0 {
+			t.Fatalf("unexpected stream id: %d", st.ID())
+		}
+	}
+}
+
+func TestManyStreams(t *testing.T) {
+	client, server := pair(t, Config{MaxFrameSize: 1000})
+	go echo(server)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			data := make([]byte, 10000+i*100)
+			rand.New(rand.NewSource(int64(i))).Read(data)
+
+			st, err := client.Open()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			st.SetDeadline(time.Now().Add(5 * time.Second))
+			go func() {
+				st.Write(data)
+				st.Close()
+			}()
+			p, err := io.ReadAll(st)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !bytes.Equal(p, data) {
+				t.Errorf("stream %d: data mismatch", st.ID())
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; client.NumStreams() > 0; i++ {
+		if i == 100 {
+			t.Fatalf("streams are not released: %d", client.NumStreams())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFlowControl(t *testing.T) {
+	client, server := pair(t, Config{})
+
+	st, err := client.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := server.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The peer does not read, so only the initial window could be written.
+	data := make([]byte, 3*initialWindow)
+	rand.Read(data)
+	st.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	n, err := st.Write(data)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != initialWindow {
+		t.Fatalf("unexpected bytes written: %d; want %d", n, initialWindow)
+	}
+	var nerr net.Error
+	if !errors.As(err, &nerr) || !nerr.Timeout() {
+		t.Fatal("error is not timeout")
+	}
+
+	// Reading opens the window.
+	st.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	go func() {
+		st.Write(data[n:])
+		st.Close()
+	}()
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	p, err := io.ReadAll(peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p, data) {
+		t.Fatal("data mismatch")
+	}
+}
+
+func TestFlowControlLargeWindow(t *testing.T) {
+	const window = 1 << 20
+	client, server := pair(t, Config{StreamWindow: window})
+
+	st, err := client.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := server.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The configured window is granted to the peer which does not read.
+	data := make([]byte, 2*window)
+	rand.Read(data)
+	st.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	n, err := st.Write(data)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != window {
+		t.Fatalf("unexpected bytes written: %d; want %d", n, window)
+	}
+
+	st.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	go func() {
+		st.Write(data[n:])
+		st.Close()
+	}()
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	p, err := io.ReadAll(peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p, data) {
+		t.Fatal("data mismatch")
+	}
+}
+
+func TestStreamReset(t *testing.T) {
+	client, server := pair(t, Config{})
+
+	st, _ := client.Open()
+	st.Write([]byte("x"))
+	peer, err := server.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer.Reset()
+
+	st.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := st.Read(make([]byte, 1)); err != ErrStreamReset {
+		t.Fatalf("unexpected read error: %v", err)
+	}
+	if _, err := st.Write([]byte("x")); err != ErrStreamReset {
+		t.Fatalf("unexpected write error: %v", err)
+	}
+	if _, err := peer.Read(make([]byte, 1)); err != ErrStreamReset {
+		t.Fatalf("unexpected read error: %v", err)
+	}
+}
+
+func TestStreamReadDeadline(t *testing.T) {
+	client, _ := pair(t, Config{})
+	st, _ := client.Open()
+
+	st.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, err := st.Read(make([]byte, 1)); err != ErrTimeout {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := st.Write([]byte("x")); err != nil {
+		t.Fatalf("write after read timeout: %v", err)
+	}
+}
+
+func TestPing(t *testing.T) {
+	client, server := pair(t, Config{})
+	for _, s := range []*Session{client, server} {
+		if _, err := s.Ping(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSessionClose(t *testing.T) {
+	client, server := pair(t, Config{})
+	st, _ := client.Open()
+	if _, err := server.AcceptStream(); err != nil {
+		t.Fatal(err)
+	}
+
+	server.Close()
+	if _, err := server.Open(); err != ErrSessionShutdown {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	st.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := st.Read(make([]byte, 1)); err != ErrSessionShutdown {
+		t.Fatalf("unexpected read error: %v", err)
+	}
+	select {
+	case <-client.Done():
+	case <-time.After(time.Second):
+		t.Fatal("client session is not shut down")
+	}
+}
+
+func TestGoAway(t *testing.T) {
+	client, server := pair(t, Config{})
+	if err := server.GoAway(); err != nil {
+		t.Fatal(err)
+	}
+	// Ping makes sure the go away frame is handled.
+	if _, err := client.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Open(); err != ErrRemoteGoAway {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := server.Open(); err != nil {
+		t.Fatalf("streams could be opened after go away: %v", err)
+	}
+}
+
+func TestKeepAliveTimeout(t *testing.T) {
+	cc, sc := socketPair(t)
+	defer cc.Close()
+	srv := NewServer(ms.Noop)
+	srv.KeepAliveInterval = 20 * time.Millisecond
+
+	done := make(chan struct{})
+	go func() {
+		msutil.NewConnecter(srv, ms.Noop).Run(context.Background(), sc)
+		sc.Close()
+		close(done)
+	}()
+
+	// The peer reads pings but does not answer them.
+	cc.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		h, err := ms.ReadHeader(cc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := make([]byte, h.Length)
+		io.ReadFull(cc, p)
+		if h.OpCode == ms.OpClose {
+			if code, _ := ms.ParseCloseFrameData(p); code != ms.StatusGoingAway {
+				t.Fatalf("unexpected close code: %d", code)
+			}
+			break
+		}
+	}
+	cc.Close()
+	<-done
+}
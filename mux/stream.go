@@ -0,0 +1,289 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package mux
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Stream is a bidirectional stream of the session. It implements net.Conn.
+type Stream struct {
+	id uint32
+	s  *Session
+
+	// recvNotify and sendNotify wake up blocked Read() and Write().
+	recvNotify chan struct{}
+	sendNotify chan struct{}
+
+	mu            sync.Mutex
+	recvBuf       bytes.Buffer
+	recvWindow    uint32
+	sendWindow    uint32
+	localClosed   bool
+	remoteClosed  bool
+	reset         bool
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+func newStream(s *Session, id uint32) *Stream {
+	return &Stream{
+		id:         id,
+		s:          s,
+		recvNotify: make(chan struct{}, 1),
+		sendNotify: make(chan struct{}, 1),
+		recvWindow: s.conf.streamWindow(),
+		sendWindow: initialWindow,
+	}
+}
+
+// ID returns the stream id.
+func (st *Stream) ID() uint32 { return st.id }
+
+// Session returns the session of the stream.
+func (st *Stream) Session() *Session { return st.s }
+
+// Read implements net.Conn. It returns io.EOF after the peer has closed the
+// stream and all data is read.
+func (st *Stream) Read(p []byte) (n int, err error) {
+	for {
+		st.mu.Lock()
+		switch {
+		case st.reset:
+			st.mu.Unlock()
+			return 0, ErrStreamReset
+		case st.recvBuf.Len() > 0:
+			n, _ = st.recvBuf.Read(p)
+			delta := st.windowDelta()
+			st.mu.Unlock()
+			if delta > 0 {
+				err = st.sendWindowUpdate(0, delta)
+			}
+			return n, err
+		case st.remoteClosed:
+			st.mu.Unlock()
+			return 0, io.EOF
+		}
+		deadline := st.readDeadline
+		st.mu.Unlock()
+
+		if err := st.wait(st.recvNotify, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// windowDelta returns the window to grant the peer after some data is read.
+// Updates are sent when at least a half of the window is consumed, so they
+// are not sent for every read.
+func (st *Stream) windowDelta() uint32 {
+	max := st.s.conf.streamWindow()
+	delta := max - uint32(st.recvBuf.Len()) - st.recvWindow
+	if delta < max/2 {
+		return 0
+	}
+	st.recvWindow += delta
+	return delta
+}
+
+// Write implements net.Conn. It blocks while the peer's window is exhausted.
+func (st *Stream) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		st.mu.Lock()
+		switch {
+		case st.reset:
+			st.mu.Unlock()
+			return n, ErrStreamReset
+		case st.localClosed:
+			st.mu.Unlock()
+			return n, ErrStreamClosed
+		case st.sendWindow == 0:
+			deadline := st.writeDeadline
+			st.mu.Unlock()
+			if err := st.wait(st.sendNotify, deadline); err != nil {
+				return n, err
+			}
+			continue
+		}
+		m := len(p)
+		if max := st.s.conf.maxFrameSize(); m > max {
+			m = max
+		}
+		if uint32(m) > st.sendWindow {
+			m = int(st.sendWindow)
+		}
+		st.sendWindow -= uint32(m)
+		st.mu.Unlock()
+
+		if err := st.s.writeFrame(makeHeader(typeData, 0, st.id, uint32(m)), p[:m]); err != nil {
+			return n, err
+		}
+		n += m
+		p = p[m:]
+	}
+	return n, nil
+}
+
+// wait waits for the notification, deadline or session shutdown.
+func (st *Stream) wait(ch chan struct{}, deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return ErrTimeout
+		}
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case <-ch:
+		return nil
+	case <-timeout:
+		return ErrTimeout
+	case <-st.s.closed:
+		// Let the buffered data be read before the error is reported.
+		st.mu.Lock()
+		buffered := st.recvBuf.Len() > 0
+		st.mu.Unlock()
+		if buffered {
+			return nil
+		}
+		return ErrSessionShutdown
+	}
+}
+
+// Close half closes the stream: the peer reads io.EOF after the data
+// written, while the stream could still read the data sent by the peer.
+func (st *Stream) Close() error {
+	st.mu.Lock()
+	if st.localClosed || st.reset {
+		st.mu.Unlock()
+		return nil
+	}
+	st.localClosed = true
+	done := st.remoteClosed
+	st.mu.Unlock()
+	st.notify()
+
+	err := st.s.writeFrame(makeHeader(typeWindowUpdate, flagFIN, st.id, 0), nil)
+	if done {
+		st.s.forget(st.id)
+	}
+	return err
+}
+
+// CloseWrite is the same as Close().
+func (st *Stream) CloseWrite() error {
+	return st.Close()
+}
+
+// Reset aborts the stream in both directions. Data not read yet is
+// dropped.
+func (st *Stream) Reset() error {
+	st.mu.Lock()
+	if st.reset {
+		st.mu.Unlock()
+		return nil
+	}
+	st.reset = true
+	st.mu.Unlock()
+	st.notify()
+	st.s.forget(st.id)
+	return st.s.writeFrame(makeHeader(typeWindowUpdate, flagRST, st.id, 0), nil)
+}
+
+// LocalAddr implements net.Conn.
+func (st *Stream) LocalAddr() net.Addr { return Addr{st.id} }
+
+// RemoteAddr implements net.Conn.
+func (st *Stream) RemoteAddr() net.Addr { return Addr{st.id} }
+
+// SetDeadline implements net.Conn.
+func (st *Stream) SetDeadline(t time.Time) error {
+	st.SetReadDeadline(t)
+	return st.SetWriteDeadline(t)
+}
+
+// SetReadDeadline implements net.Conn.
+func (st *Stream) SetReadDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.readDeadline = t
+	st.mu.Unlock()
+	notify(st.recvNotify)
+	return nil
+}
+
+// SetWriteDeadline implements net.Conn.
+func (st *Stream) SetWriteDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.writeDeadline = t
+	st.mu.Unlock()
+	notify(st.sendNotify)
+	return nil
+}
+
+func (st *Stream) sendWindowUpdate(flags uint16, delta uint32) error {
+	return st.s.writeFrame(makeHeader(typeWindowUpdate, flags, st.id, delta), nil)
+}
+
+func (st *Stream) incrSendWindow(delta uint32) {
+	st.mu.Lock()
+	st.sendWindow += delta
+	st.mu.Unlock()
+	notify(st.sendNotify)
+}
+
+// readData reads n bytes of data frame into the receive buffer.
+func (st *Stream) readData(n uint32, r io.Reader) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if n > st.recvWindow {
+		st.s.log.Info("mux stream window exceeded", st.id)
+		return ErrInvalidFrame
+	}
+	if _, err := io.CopyN(&st.recvBuf, r, int64(n)); err != nil {
+		return err
+	}
+	st.recvWindow -= n
+	notify(st.recvNotify)
+	return nil
+}
+
+func (st *Stream) processFlags(flags uint16) {
+	st.mu.Lock()
+	var done bool
+	switch {
+	case flags&flagRST != 0:
+		st.reset = true
+		done = true
+	case flags&flagFIN != 0:
+		st.remoteClosed = true
+		done = st.localClosed
+	}
+	st.mu.Unlock()
+	if flags&(flagRST|flagFIN) != 0 {
+		st.notify()
+	}
+	if done {
+		st.s.forget(st.id)
+	}
+}
+
+func (st *Stream) notify() {
+	notify(st.recvNotify)
+	notify(st.sendNotify)
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
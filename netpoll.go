@@ -0,0 +1,79 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package mogusocket
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// ErrPollerUnsupported is returned by NewPoller on platforms that have no
+// event notification facility supported by this package.
+var ErrPollerUnsupported = errors.New("poller is not supported on this platform")
+
+// ErrPollerClosed is returned by Poller methods called after Close().
+var ErrPollerClosed = errors.New("poller closed")
+
+// PollEvent represents readiness state of a connection reported by Poller.
+type PollEvent uint8
+
+// Events reported by Poller. One callback call could carry several of them.
+const (
+	PollReadable PollEvent = 1 << iota
+	PollHup
+	PollErr
+)
+
+// Readable reports whether there is data to be read from the connection.
+func (e PollEvent) Readable() bool { return e&PollReadable != 0 }
+
+// Closed reports whether peer has closed the connection or an error occurred
+// on it.
+func (e PollEvent) Closed() bool { return e&(PollHup|PollErr) != 0 }
+
+// Poller describes an object that watches connections readiness without
+// holding a goroutine per connection.
+//
+// Every connection is registered in one-shot mode: after the callback is
+// called, no more events are reported for the connection until Resume() is
+// called. That is, the callback owner could read from the connection in any
+// goroutine without racing with the next notification.
+type Poller interface {
+	// Start registers conn and calls cb when conn becomes readable or closed.
+	// Note that cb is called from the poller's goroutine and must not block.
+	Start(conn net.Conn, cb func(PollEvent)) error
+
+	// Resume rearms notifications for conn which was previously started.
+	Resume(conn net.Conn) error
+
+	// Stop removes conn from the poller. It must be called before conn is
+	// closed.
+	Stop(conn net.Conn) error
+
+	// Close stops the poller. Registered connections are not closed.
+	Close() error
+}
+
+// PollHandler is the interface for handling connections in the event loop
+// mode of the Server. See NewPollServer.
+type PollHandler interface {
+	// Open is called once for every accepted connection. The done function
+	// asks the Server to stop watching the connection, call Close() on
+	// returned session and close the connection. It is safe to call done more
+	// than once and from any goroutine.
+	Open(ctx context.Context, conn net.Conn, done func()) (PollSession, error)
+}
+
+// PollSession represents a connection served in the event loop mode.
+type PollSession interface {
+	// HandleRead is called from the worker pool goroutine when connection
+	// becomes readable. It should read at least one frame from the
+	// connection. Non-nil error makes Server to close the connection.
+	HandleRead() error
+
+	// Close is called once when the connection is about to be closed.
+	Close()
+}
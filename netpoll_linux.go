@@ -0,0 +1,211 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+//go:build linux
+
+package mogusocket
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+)
+
+const (
+	epollEventsRead = syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT
+	// epollMaxEvents is the number of events fetched by one epoll_wait call.
+	epollMaxEvents = 128
+)
+
+// epoll implements Poller with linux epoll(7) facility.
+//
+// Note that descriptors are registered in level-triggered one-shot mode. That
+// is, if the handler did not read all available data from connection, Resume()
+// makes epoll report it again immediately.
+type epoll struct {
+	fd   int
+	wake [2]int // Pipe used to interrupt epoll_wait on Close().
+
+	mu       sync.RWMutex
+	closed   bool
+	handlers map[int]func(PollEvent)
+	done     chan struct{}
+}
+
+// NewPoller creates new Poller backed by the platform event notification
+// facility.
+func NewPoller() (Poller, error) {
+	fd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("epoll_create1: %w", err)
+	}
+	ep := &epoll{
+		fd:       fd,
+		handlers: make(map[int]func(PollEvent)),
+		done:     make(chan struct{}),
+	}
+	if err = syscall.Pipe2(ep.wake[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("pipe2: %w", err)
+	}
+	err = syscall.EpollCtl(fd, syscall.EPOLL_CTL_ADD, ep.wake[0], &syscall.EpollEvent{
+		Events: syscall.EPOLLIN,
+		Fd:     int32(ep.wake[0]),
+	})
+	if err != nil {
+		ep.closeFds()
+		return nil, fmt.Errorf("epoll_ctl: %w", err)
+	}
+	go ep.wait()
+	return ep, nil
+}
+
+func (ep *epoll) Start(conn net.Conn, cb func(PollEvent)) error {
+	fd, err := connFd(conn)
+	if err != nil {
+		return err
+	}
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	if ep.closed {
+		return ErrPollerClosed
+	}
+	ep.handlers[fd] = cb
+	err = syscall.EpollCtl(ep.fd, syscall.EPOLL_CTL_ADD, fd, &syscall.EpollEvent{
+		Events: epollEventsRead,
+		Fd:     int32(fd),
+	})
+	if err != nil {
+		delete(ep.handlers, fd)
+	}
+	return err
+}
+
+func (ep *epoll) Resume(conn net.Conn) error {
+	fd, err := connFd(conn)
+	if err != nil {
+		return err
+	}
+	ep.mu.RLock()
+	defer ep.mu.RUnlock()
+	if ep.closed {
+		return ErrPollerClosed
+	}
+	return syscall.EpollCtl(ep.fd, syscall.EPOLL_CTL_MOD, fd, &syscall.EpollEvent{
+		Events: epollEventsRead,
+		Fd:     int32(fd),
+	})
+}
+
+func (ep *epoll) Stop(conn net.Conn) error {
+	fd, err := connFd(conn)
+	if err != nil {
+		return err
+	}
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	if ep.closed {
+		return ErrPollerClosed
+	}
+	delete(ep.handlers, fd)
+	return syscall.EpollCtl(ep.fd, syscall.EPOLL_CTL_DEL, fd, nil)
+}
+
+func (ep *epoll) Close() error {
+	ep.mu.Lock()
+	if ep.closed {
+		ep.mu.Unlock()
+		return ErrPollerClosed
+	}
+	ep.closed = true
+	ep.handlers = nil
+	ep.mu.Unlock()
+
+	// Interrupt epoll_wait() and wait for the loop to exit before closing
+	// descriptors it uses.
+	syscall.Write(ep.wake[1], []byte{0})
+	<-ep.done
+	ep.closeFds()
+	return nil
+}
+
+func (ep *epoll) closeFds() {
+	syscall.Close(ep.wake[0])
+	syscall.Close(ep.wake[1])
+	syscall.Close(ep.fd)
+}
+
+func (ep *epoll) wait() {
+	defer close(ep.done)
+
+	var (
+		events  = make([]syscall.EpollEvent, epollMaxEvents)
+		pending = make([]func(), 0, epollMaxEvents)
+	)
+	for {
+		n, err := syscall.EpollWait(ep.fd, events, -1)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			return
+		}
+		// Collect callbacks under the lock, but call them without it. That
+		// is, callbacks are free to call Stop() or Resume().
+		ep.mu.RLock()
+		if ep.closed {
+			ep.mu.RUnlock()
+			return
+		}
+		for i := 0; i < n; i++ {
+			fd := int(events[i].Fd)
+			if fd == ep.wake[0] {
+				continue
+			}
+			if cb := ep.handlers[fd]; cb != nil {
+				ev := toPollEvent(events[i].Events)
+				pending = append(pending, func() { cb(ev) })
+			}
+		}
+		ep.mu.RUnlock()
+
+		for i, fn := range pending {
+			fn()
+			pending[i] = nil
+		}
+		pending = pending[:0]
+	}
+}
+
+func toPollEvent(e uint32) (ret PollEvent) {
+	if e&syscall.EPOLLIN != 0 {
+		ret |= PollReadable
+	}
+	if e&(syscall.EPOLLHUP|syscall.EPOLLRDHUP) != 0 {
+		ret |= PollHup
+	}
+	if e&syscall.EPOLLERR != 0 {
+		ret |= PollErr
+	}
+	return ret
+}
+
+// connFd returns file descriptor of conn without duplicating it.
+//
+// Note that descriptor is valid only until conn is closed.
+func connFd(conn net.Conn) (fd int, err error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return -1, fmt.Errorf("poller: %T is not a syscall.Conn", conn)
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	err = rc.Control(func(x uintptr) {
+		fd = int(x)
+	})
+	return fd, err
+}
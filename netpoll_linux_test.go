@@ -0,0 +1,104 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+//go:build linux
+
+package mogusocket
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPollerOneShot(t *testing.T) {
+	client, server := pollPipe(t)
+	defer client.Close()
+	defer server.Close()
+
+	p, err := NewPoller()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	events := make(chan PollEvent, 4)
+	if err := p.Start(server, func(ev PollEvent) { events <- ev }); err != nil {
+		t.Fatal(err)
+	}
+
+	client.Write([]byte("ab"))
+	if ev := waitEvent(t, events); !ev.Readable() {
+		t.Fatalf("unexpected event: %v", ev)
+	}
+	// One-shot mode: no events until Resume() even if data is still
+	// available.
+	select {
+	case ev := <-events:
+		t.Fatalf("unexpected event before resume: %v", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	buf := make([]byte, 1)
+	server.Read(buf)
+	if err := p.Resume(server); err != nil {
+		t.Fatal(err)
+	}
+	// One byte left unread, level-triggered mode reports it again.
+	if ev := waitEvent(t, events); !ev.Readable() {
+		t.Fatalf("unexpected event: %v", ev)
+	}
+	server.Read(buf)
+
+	client.Close()
+	if err := p.Resume(server); err != nil {
+		t.Fatal(err)
+	}
+	if ev := waitEvent(t, events); !ev.Closed() {
+		t.Fatalf("expected hup event; got %v", ev)
+	}
+
+	if err := p.Stop(server); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Start(server, func(PollEvent) {}); err != ErrPollerClosed {
+		t.Fatalf("unexpected error: %v; want %v", err, ErrPollerClosed)
+	}
+}
+
+func waitEvent(t *testing.T, events chan PollEvent) PollEvent {
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+		return 0
+	}
+}
+
+func pollPipe(t *testing.T) (client, server net.Conn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		accepted <- conn
+	}()
+	client, err = net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server = <-accepted
+	if server == nil {
+		t.Fatal("accept failed")
+	}
+	return client, server
+}
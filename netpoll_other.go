@@ -0,0 +1,15 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+//go:build !linux
+
+package mogusocket
+
+// NewPoller creates new Poller backed by the platform event notification
+// facility.
+//
+// It returns ErrPollerUnsupported on this platform.
+func NewPoller() (Poller, error) {
+	return nil, ErrPollerUnsupported
+}
@@ -0,0 +1,101 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package mogusocket
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const headerOriginCanonical = "Origin"
+
+// ErrHandshakeBadOrigin is returned by upgraders when the Origin header of
+// the request is not allowed.
+var ErrHandshakeBadOrigin = RejectConnectionError(
+	RejectionStatus(http.StatusForbidden),
+	RejectionReason("handshake error: origin not allowed"),
+)
+
+// OriginPolicy describes which origins are allowed to make the handshake.
+// Browsers send the Origin header with every WebSocket handshake, so the
+// policy protects authenticated sessions from cross-site WebSocket hijacking.
+// Requests without the Origin header, which are made by non-browser clients,
+// are always allowed.
+//
+// The zero policy allows the same origin only, that is the origin which host
+// matches the Host header of the request.
+type OriginPolicy struct {
+	// AllowAll disables origin checks.
+	AllowAll bool
+
+	// Origins is the list of origins allowed in addition to the same origin.
+	// An entry could be a full origin, such as "https://example.org", or a
+	// host without scheme, such as "example.org:8080". The host could have a
+	// wildcard subdomain, such as "*.example.org", which matches any
+	// subdomain but not the domain itself.
+	Origins []string
+
+	// Check, if set, is used instead of the same origin and Origins checks.
+	// It receives the Origin and Host header values.
+	Check func(origin, host string) bool
+}
+
+// check returns ErrHandshakeBadOrigin if origin is not allowed for the
+// request to host.
+func (p *OriginPolicy) check(origin, host string) error {
+	if p.AllowAll || origin == "" {
+		return nil
+	}
+	if p.Check != nil {
+		if p.Check(origin, host) {
+			return nil
+		}
+		return ErrHandshakeBadOrigin
+	}
+	if SameOrigin(origin, host) {
+		return nil
+	}
+	for _, pattern := range p.Origins {
+		if matchOrigin(pattern, origin) {
+			return nil
+		}
+	}
+	return ErrHandshakeBadOrigin
+}
+
+// SameOrigin reports whether the host of origin is equal to host.
+func SameOrigin(origin, host string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return strings.EqualFold(u.Host, host)
+}
+
+// matchOrigin reports whether origin matches the pattern described by
+// OriginPolicy.Origins.
+func matchOrigin(pattern, origin string) bool {
+	if strings.EqualFold(pattern, origin) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	host := pattern
+	if scheme, rest, ok := strings.Cut(pattern, "://"); ok {
+		if !strings.EqualFold(scheme, u.Scheme) {
+			return false
+		}
+		host = rest
+	}
+	if suffix, ok := strings.CutPrefix(host, "*."); ok {
+		return len(u.Host) > len(suffix)+1 &&
+			strings.EqualFold(u.Host[len(u.Host)-len(suffix):], suffix) &&
+			u.Host[len(u.Host)-len(suffix)-1] == '.'
+	}
+	return strings.EqualFold(host, u.Host)
+}
@@ -0,0 +1,146 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package mogusocket
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestMatchOrigin(t *testing.T) {
+	for _, test := range []struct {
+		pattern string
+		origin  string
+		exp     bool
+	}{
+		{"https://example.org", "https://example.org", true},
+		{"https://example.org", "http://example.org", false},
+		{"https://example.org", "https://example.org.evil.com", false},
+		{"example.org", "http://example.org", true},
+		{"example.org", "https://EXAMPLE.org", true},
+		{"example.org", "https://example.org:8443", false},
+		{"example.org:8443", "https://example.org:8443", true},
+		{"*.example.org", "https://app.example.org", true},
+		{"*.example.org", "https://a.b.example.org", true},
+		{"*.example.org", "https://example.org", false},
+		{"*.example.org", "https://evilexample.org", false},
+		{"https://*.example.org", "http://app.example.org", false},
+		{"null", "null", true},
+		{"example.org", "null", false},
+	} {
+		if act := matchOrigin(test.pattern, test.origin); act != test.exp {
+			t.Errorf("matchOrigin(%q, %q) = %v; want %v", test.pattern, test.origin, act, test.exp)
+		}
+	}
+}
+
+var originTests = []struct {
+	name   string
+	policy OriginPolicy
+	origin string
+	ok     bool
+}{
+	{name: "no origin", ok: true},
+	{name: "same origin", origin: "https://example.org", ok: true},
+	{name: "forged", origin: "https://evil.com"},
+	{name: "forged suffix", origin: "https://example.org.evil.com"},
+	{name: "null", origin: "null"},
+	{name: "allow all", origin: "https://evil.com", policy: OriginPolicy{AllowAll: true}, ok: true},
+	{
+		name:   "allowlist",
+		origin: "https://app.example.com",
+		policy: OriginPolicy{Origins: []string{"https://*.example.com"}},
+		ok:     true,
+	},
+	{
+		name:   "allowlist forged",
+		origin: "https://example.com.evil.com",
+		policy: OriginPolicy{Origins: []string{"https://*.example.com"}},
+	},
+	{
+		name:   "custom",
+		origin: "https://trusted.net",
+		policy: OriginPolicy{Check: func(origin, host string) bool {
+			return origin == "https://trusted.net" && host == "example.org"
+		}},
+		ok: true,
+	},
+	{
+		name:   "custom rejects same origin",
+		origin: "https://example.org",
+		policy: OriginPolicy{Check: func(origin, host string) bool { return false }},
+	},
+}
+
+func originRequest(origin string) *http.Request {
+	h := make(http.Header)
+	h.Set(headerUpgrade, "websocket")
+	h.Set(headerConnection, "Upgrade")
+	h.Set(headerSecVersion, "13")
+	h.Set(headerSecKey, string(mustMakeNonce()))
+	if origin != "" {
+		h.Set(headerOriginCanonical, origin)
+	}
+	return mustMakeRequest("GET", "ws://example.org/", h)
+}
+
+func checkOriginResponse(t *testing.T, p []byte, err error, ok bool) {
+	t.Helper()
+	resp, rerr := http.ReadResponse(bufio.NewReader(bytes.NewReader(p)), nil)
+	if rerr != nil {
+		t.Fatal(rerr)
+	}
+	if ok {
+		if err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+			t.Fatalf("unexpected rejection: %v %s", err, resp.Status)
+		}
+		return
+	}
+	if err != ErrHandshakeBadOrigin {
+		t.Errorf("unexpected error: %v; want %v", err, ErrHandshakeBadOrigin)
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("unexpected status: %s", resp.Status)
+	}
+}
+
+func TestUpgraderOrigin(t *testing.T) {
+	for _, test := range originTests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			conn := struct {
+				io.Reader
+				io.Writer
+			}{bytes.NewReader(dumpRequest(originRequest(test.origin))), &out}
+
+			var headers []string
+			u := Upgrader{
+				Origin: test.policy,
+				OnHeader: func(k, v []byte) error {
+					headers = append(headers, string(k))
+					return nil
+				},
+			}
+			_, err := u.Upgrade(conn)
+			checkOriginResponse(t, out.Bytes(), err, test.ok)
+			if test.origin != "" && (len(headers) != 1 || headers[0] != "Origin") {
+				t.Errorf("Origin is not passed to OnHeader: %q", headers)
+			}
+		})
+	}
+}
+
+func TestHTTPUpgraderOrigin(t *testing.T) {
+	for _, test := range originTests {
+		t.Run(test.name, func(t *testing.T) {
+			res := newRecorder()
+			_, _, _, err := HTTPUpgrader{Origin: test.policy}.Upgrade(originRequest(test.origin), res)
+			checkOriginResponse(t, res.Bytes(), err, test.ok)
+		})
+	}
+}
@@ -0,0 +1,367 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+// Package proxy implements WebSocket reverse proxy. It accepts client
+// handshakes, makes the handshake with the upstream server forwarding
+// selected headers and offered subprotocols and then relays messages in both
+// directions. Hooks could inspect, rewrite or drop relayed messages and
+// control frames.
+//
+// Extensions are not negotiated, so messages could be relayed as is.
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	ms "github.com/cmacro/mogusocket"
+)
+
+var (
+	ErrNoUpstream = errors.New("proxy: upstream is not set")
+	ErrBadGateway = ms.RejectConnectionError(
+		ms.RejectionStatus(http.StatusBadGateway),
+		ms.RejectionReason("proxy: upstream handshake failed"),
+	)
+)
+
+// DefaultMaxMessageSize is used when Proxy.MaxMessageSize is zero.
+const DefaultMaxMessageSize = 16 << 20
+
+// DefaultForwardHeaders is the list of request headers forwarded to the
+// upstream when Proxy.ForwardHeaders is nil.
+var DefaultForwardHeaders = []string{
+	"Origin",
+	"Cookie",
+	"Authorization",
+	"User-Agent",
+}
+
+// Direction is the direction of a relayed message.
+type Direction uint8
+
+const (
+	// Upstream is the direction from the client to the upstream server.
+	Upstream Direction = iota
+	// Downstream is the direction from the upstream server to the client.
+	Downstream
+)
+
+func (d Direction) String() string {
+	if d == Upstream {
+		return "upstream"
+	}
+	return "downstream"
+}
+
+// Message is a relayed data message or control frame. Payload of data
+// messages is the whole message, even if it was received in fragments.
+type Message struct {
+	OpCode  ms.OpCode
+	Payload []byte
+}
+
+// Hook is called with every relayed message. It could modify m. Returning
+// false drops the message. Returning an error stops relaying and closes both
+// connections with the code of msutil.CloseWithError if err is such, or with
+// StatusInternalServerError otherwise.
+type Hook func(ctx context.Context, dir Direction, m *Message) (forward bool, err error)
+
+// Request describes the handshake request of the client.
+type Request struct {
+	// URI is the request URI, e.g. "/chat?room=1".
+	URI string
+
+	// Host is the value of the Host header.
+	Host string
+
+	// Header contains request headers except the Host and WebSocket
+	// handshake headers.
+	Header http.Header
+
+	// Protocols is the list of subprotocols offered by the client.
+	Protocols []string
+
+	// RemoteAddr is the address of the client, if it is known.
+	RemoteAddr string
+}
+
+type requestKey struct{}
+
+// RequestFromContext returns the request of the proxied connection which
+// context is ctx. Such context is passed to the hooks.
+func RequestFromContext(ctx context.Context) (*Request, bool) {
+	r, ok := ctx.Value(requestKey{}).(*Request)
+	return r, ok
+}
+
+// Proxy is the WebSocket reverse proxy. It implements ms.ConnectHandler,
+// which makes the handshake with ms.Upgrader, and http.Handler, which makes
+// the handshake with ms.HTTPUpgrader.
+//
+// The upstream handshake is made before the client handshake is answered, so
+// the subprotocol selected by the upstream is the one selected for the
+// client and failed upstream handshake rejects the client with 502 status.
+type Proxy struct {
+	log ms.Logger
+
+	// Upstream is the URL of the upstream server, e.g. "ws://backend:8080".
+	// The path and query of the client request are appended to it.
+	Upstream string
+
+	// Dialer is used to connect the upstream. Its Protocols are replaced with
+	// the protocols offered by the client and forwarded headers are written
+	// after its Header.
+	Dialer ms.Dialer
+
+	// ForwardHeaders is the list of client request headers sent to the
+	// upstream. If it is nil, DefaultForwardHeaders is used. X-Forwarded-For
+	// and X-Forwarded-Host are always sent.
+	//
+	// Note that the Host header sent to the upstream is its own host, so the
+	// upstream checking the forwarded Origin must allow client origins.
+	ForwardHeaders []string
+
+	// MaxMessageSize limits the size of relayed messages. Larger messages
+	// close both connections with StatusMessageTooBig. If it is zero,
+	// DefaultMaxMessageSize is used.
+	MaxMessageSize int64
+
+	// OnRequest, if set, is called before the upstream is connected. Returned
+	// error rejects the client. Use ms.RejectConnectionError to control the
+	// response status.
+	OnRequest func(ctx context.Context, r *Request) error
+
+	// OnMessage, if set, is called with every data message.
+	OnMessage Hook
+
+	// OnControl, if set, is called with every control frame. Close frames
+	// could be rewritten but not dropped.
+	OnControl Hook
+}
+
+// NewProxy returns Proxy to the upstream server at URL upstream.
+func NewProxy(upstream string, log ms.Logger) *Proxy {
+	return &Proxy{
+		log:      log,
+		Upstream: upstream,
+	}
+}
+
+// upstreamConn is the connection to the upstream server.
+type upstreamConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	hs   ms.Handshake
+}
+
+// Run serves the client connection conn. It implements ms.ConnectHandler.
+func (p *Proxy) Run(ctx context.Context, conn io.ReadWriter) {
+	req := &Request{Header: make(http.Header)}
+	if c, ok := conn.(net.Conn); ok {
+		req.RemoteAddr = c.RemoteAddr().String()
+	}
+	ctx = context.WithValue(ctx, requestKey{}, req)
+
+	var up *upstreamConn
+	u := ms.Upgrader{
+		OnRequest: func(uri []byte) error {
+			req.URI = string(uri)
+			return nil
+		},
+		OnHost: func(host []byte) error {
+			req.Host = string(host)
+			return nil
+		},
+		OnHeader: func(key, value []byte) error {
+			req.Header.Add(string(key), string(value))
+			return nil
+		},
+		// Offered protocols are recorded to be offered to the upstream. The
+		// selected one is written by OnBeforeUpgrade.
+		ProtocolCustom: func(v []byte) (string, bool) {
+			req.Protocols = appendProtocols(req.Protocols, string(v))
+			return "", true
+		},
+		OnBeforeUpgrade: func() (ms.HandshakeHeader, error) {
+			var err error
+			up, err = p.connect(ctx, req)
+			if err != nil {
+				return nil, err
+			}
+			return protocolHeader(up.hs.Protocol), nil
+		},
+	}
+	br, _, err := u.UpgradeBuffered(conn)
+	if err != nil {
+		p.log.Info("proxy upgrade", err)
+		if up != nil {
+			up.conn.Close()
+		}
+		return
+	}
+	var r io.Reader = conn
+	if br != nil {
+		defer ms.PutReader(br)
+		r = br
+	}
+	p.relay(ctx, conn, r, up)
+}
+
+// ServeHTTP serves the client request. It implements http.Handler.
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req := &Request{
+		URI:        r.RequestURI,
+		Host:       r.Host,
+		Header:     r.Header.Clone(),
+		RemoteAddr: r.RemoteAddr,
+	}
+	for _, v := range r.Header["Sec-Websocket-Protocol"] {
+		req.Protocols = appendProtocols(req.Protocols, v)
+	}
+	for _, k := range []string{"Upgrade", "Connection"} {
+		req.Header.Del(k)
+	}
+	for k := range req.Header {
+		if strings.HasPrefix(k, "Sec-Websocket-") {
+			delete(req.Header, k)
+		}
+	}
+	ctx := context.WithValue(r.Context(), requestKey{}, req)
+
+	up, err := p.connect(ctx, req)
+	if err != nil {
+		code := http.StatusInternalServerError
+		var rej *ms.ConnectionRejectedError
+		if errors.As(err, &rej) && rej.StatusCode() != 0 {
+			code = rej.StatusCode()
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+	var u ms.HTTPUpgrader
+	if protocol := up.hs.Protocol; protocol != "" {
+		u.Protocol = func(p string) bool { return p == protocol }
+	}
+	conn, rw, _, err := u.Upgrade(r, w)
+	if err != nil {
+		p.log.Info("proxy upgrade", err)
+		up.conn.Close()
+		if conn != nil {
+			conn.Close()
+		}
+		return
+	}
+	defer conn.Close()
+	p.relay(ctx, conn, rw.Reader, up)
+}
+
+// connect makes the handshake with the upstream for the client request req.
+func (p *Proxy) connect(ctx context.Context, req *Request) (*upstreamConn, error) {
+	if p.OnRequest != nil {
+		if err := p.OnRequest(ctx, req); err != nil {
+			return nil, err
+		}
+	}
+	target, err := p.target(req.URI)
+	if err != nil {
+		return nil, err
+	}
+	d := p.Dialer
+	d.Protocols = req.Protocols
+	d.Header = forwardHeader(p.Dialer.Header, p.forward(req))
+
+	conn, br, hs, err := d.Dial(ctx, target)
+	if err != nil {
+		p.log.Error("proxy dial", target, err)
+		return nil, ErrBadGateway
+	}
+	return &upstreamConn{conn: conn, br: br, hs: hs}, nil
+}
+
+// target returns the upstream URL for the request URI.
+func (p *Proxy) target(uri string) (string, error) {
+	if p.Upstream == "" {
+		return "", ErrNoUpstream
+	}
+	u, err := url.Parse(p.Upstream)
+	if err != nil {
+		return "", err
+	}
+	ru, err := url.ParseRequestURI(uri)
+	if err != nil {
+		return "", ms.ErrMalformedRequest
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + ru.Path
+	u.RawPath = ""
+	u.RawQuery = ru.RawQuery
+	return u.String(), nil
+}
+
+// forward returns headers sent to the upstream for the request req.
+func (p *Proxy) forward(req *Request) http.Header {
+	keys := p.ForwardHeaders
+	if keys == nil {
+		keys = DefaultForwardHeaders
+	}
+	h := make(http.Header)
+	for _, k := range keys {
+		if vs := req.Header.Values(k); len(vs) > 0 {
+			h[http.CanonicalHeaderKey(k)] = vs
+		}
+	}
+	xff := req.Header.Get("X-Forwarded-For")
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		if xff != "" {
+			xff += ", "
+		}
+		xff += host
+	}
+	if xff != "" {
+		h.Set("X-Forwarded-For", xff)
+	}
+	if req.Host != "" {
+		h.Set("X-Forwarded-Host", req.Host)
+	}
+	return h
+}
+
+// forwardHeader returns HandshakeHeader which writes h after base.
+func forwardHeader(base ms.HandshakeHeader, h http.Header) ms.HandshakeHeader {
+	if base == nil {
+		return ms.HandshakeHeaderHTTP(h)
+	}
+	return ms.HandshakeHeaderFunc(func(w io.Writer) (int64, error) {
+		n, err := base.WriteTo(w)
+		if err != nil {
+			return n, err
+		}
+		m, err := ms.HandshakeHeaderHTTP(h).WriteTo(w)
+		return n + m, err
+	})
+}
+
+// protocolHeader returns the header with the selected subprotocol.
+func protocolHeader(protocol string) ms.HandshakeHeader {
+	if protocol == "" {
+		return ms.HandshakeHeaderString("")
+	}
+	return ms.HandshakeHeaderString("Sec-WebSocket-Protocol: " + protocol + "\r\n")
+}
+
+// appendProtocols appends the protocols listed in the header value v to ps.
+func appendProtocols(ps []string, v string) []string {
+	for _, p := range strings.Split(v, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			ps = append(ps, p)
+		}
+	}
+	return ps
+}
@@ -0,0 +1,355 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	ms "github.com/cmacro/mogusocket"
+	"github.com/cmacro/mogusocket/internal/mstest"
+	"github.com/cmacro/mogusocket/msutil"
+)
+
+// upstream is the echo server which records handshake requests.
+type upstream struct {
+	addr string
+
+	mu     sync.Mutex
+	uri    string
+	header http.Header
+}
+
+func newUpstream(t *testing.T) *upstream {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	u := &upstream{addr: ln.Addr().String()}
+	c := msutil.NewConnecter(echoSessions{}, ms.Noop)
+	c.Upgrader = &ms.Upgrader{
+		Protocol: func(p []byte) bool { return string(p) == "chat" },
+		// The client origin is forwarded, but the host is the upstream one.
+		Origin: ms.OriginPolicy{Origins: []string{"http://example.org"}},
+		OnRequest: func(uri []byte) error {
+			u.mu.Lock()
+			u.uri = string(uri)
+			u.header = make(http.Header)
+			u.mu.Unlock()
+			return nil
+		},
+		OnHeader: func(k, v []byte) error {
+			u.mu.Lock()
+			u.header.Add(string(k), string(v))
+			u.mu.Unlock()
+			return nil
+		},
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				c.Run(context.Background(), conn)
+			}()
+		}
+	}()
+	return u
+}
+
+func (u *upstream) request() (string, http.Header) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.uri, u.header
+}
+
+type echoSessions struct{}
+
+func (echoSessions) Connect(ctx context.Context, w ms.SendFunc, c func()) (ms.SessionHandler, error) {
+	return &echoSession{send: w, cancel: c}, nil
+}
+
+func (echoSessions) Close(s ms.SessionHandler) error {
+	s.Close()
+	return nil
+}
+
+// echoSession sends back received messages. It closes the connection with
+// status 4000 when "close" is received.
+type echoSession struct {
+	send   ms.SendFunc
+	cancel func()
+}
+
+func (s *echoSession) GetId() int64 { return 1 }
+func (s *echoSession) Close()       { s.cancel() }
+
+func (s *echoSession) ReadPump(r io.Reader, n int64, isText bool) error {
+	p, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if string(p) == "close" {
+		return msutil.CloseWithError{Code: 4000, Reason: "bye"}
+	}
+	return s.send(bytes.NewReader(p), isText)
+}
+
+// dialPipe runs p.Run() on the pipe and makes the client handshake.
+func dialPipe(t *testing.T, p *Proxy, d ms.Dialer, uri string) (io.ReadWriter, ms.Handshake) {
+	server, client := net.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx, server)
+		server.Close()
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		client.Close()
+		<-done
+	})
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	u, _ := url.Parse("ws://example.org" + uri)
+	br, hs, err := d.Upgrade(client, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r io.Reader = client
+	if br != nil {
+		r = br
+	}
+	return struct {
+		io.Reader
+		io.Writer
+	}{r, client}, hs
+}
+
+func expectMessage(t *testing.T, r io.Reader, op ms.OpCode, exp string) {
+	t.Helper()
+	h, p := mstest.ReadFrame(t, r)
+	if h.OpCode != op || string(p) != exp {
+		t.Fatalf("unexpected message: %v %q; want %v %q", h.OpCode, p, op, exp)
+	}
+}
+
+func TestProxyRun(t *testing.T) {
+	up := newUpstream(t)
+	p := NewProxy("ws://"+up.addr+"/base/", ms.Noop)
+
+	d := ms.Dialer{
+		Protocols: []string{"other", "chat"},
+		Header: ms.HandshakeHeaderHTTP(http.Header{
+			"Origin":   []string{"http://example.org"},
+			"X-Secret": []string{"secret"},
+		}),
+	}
+	conn, hs := dialPipe(t, p, d, "/chat?room=1")
+	if hs.Protocol != "chat" {
+		t.Errorf("unexpected protocol: %q", hs.Protocol)
+	}
+
+	uri, header := up.request()
+	if uri != "/base/chat?room=1" {
+		t.Errorf("unexpected upstream uri: %q", uri)
+	}
+	if v := header.Get("Origin"); v != "http://example.org" {
+		t.Errorf("unexpected upstream Origin: %q", v)
+	}
+	if v := header.Get("X-Forwarded-Host"); v != "example.org" {
+		t.Errorf("unexpected upstream X-Forwarded-Host: %q", v)
+	}
+	if v := header.Get("X-Secret"); v != "" {
+		t.Errorf("unexpected forwarded X-Secret: %q", v)
+	}
+
+	go msutil.WriteClientText(conn, []byte("hello"))
+	expectMessage(t, conn, ms.OpText, "hello")
+	go msutil.WriteClientBinary(conn, []byte{1, 2, 3})
+	expectMessage(t, conn, ms.OpBinary, "\x01\x02\x03")
+
+	// Fragmented message is relayed as a whole.
+	go func() {
+		w := msutil.NewWriterSize(conn, ms.StateClientSide, ms.OpText, 4)
+		w.Write([]byte("fragmented message"))
+		w.Flush()
+	}()
+	expectMessage(t, conn, ms.OpText, "fragmented message")
+
+	go msutil.WriteClientMessage(conn, ms.OpPing, []byte("ping"))
+	expectMessage(t, conn, ms.OpPong, "ping")
+}
+
+func TestProxyClose(t *testing.T) {
+	up := newUpstream(t)
+	p := NewProxy("ws://"+up.addr, ms.Noop)
+
+	t.Run("upstream", func(t *testing.T) {
+		conn, _ := dialPipe(t, p, ms.Dialer{}, "/")
+		go msutil.WriteClientText(conn, []byte("close"))
+		mstest.ExpectClose(t, conn, 4000)
+		go msutil.WriteClientMessage(conn, ms.OpClose, ms.NewCloseFrameBody(4000, ""))
+	})
+	t.Run("client", func(t *testing.T) {
+		conn, _ := dialPipe(t, p, ms.Dialer{}, "/")
+		go msutil.WriteClientMessage(conn, ms.OpClose, ms.NewCloseFrameBody(ms.StatusNormalClosure, ""))
+		mstest.ExpectClose(t, conn, ms.StatusNormalClosure)
+	})
+	t.Run("too big", func(t *testing.T) {
+		p := NewProxy("ws://"+up.addr, ms.Noop)
+		p.MaxMessageSize = 4
+		conn, _ := dialPipe(t, p, ms.Dialer{}, "/")
+		go msutil.WriteClientText(conn, []byte("too big"))
+		mstest.ExpectClose(t, conn, ms.StatusMessageTooBig)
+	})
+}
+
+func TestProxyHooks(t *testing.T) {
+	up := newUpstream(t)
+	p := NewProxy("ws://"+up.addr, ms.Noop)
+
+	var (
+		mu   sync.Mutex
+		seen []string
+	)
+	p.OnMessage = func(ctx context.Context, dir Direction, m *Message) (bool, error) {
+		if _, ok := RequestFromContext(ctx); !ok {
+			t.Errorf("no request in the hook context")
+		}
+		mu.Lock()
+		seen = append(seen, dir.String()+" "+string(m.Payload))
+		mu.Unlock()
+
+		switch {
+		case dir == Upstream && string(m.Payload) == "drop":
+			return false, nil
+		case dir == Upstream && string(m.Payload) == "kill":
+			return false, msutil.CloseWithError{Code: 4001, Reason: "killed"}
+		case dir == Downstream:
+			m.Payload = bytes.ToUpper(m.Payload)
+		}
+		return true, nil
+	}
+	p.OnControl = func(ctx context.Context, dir Direction, m *Message) (bool, error) {
+		return m.OpCode != ms.OpPing, nil
+	}
+
+	conn, _ := dialPipe(t, p, ms.Dialer{}, "/")
+
+	go func() {
+		msutil.WriteClientText(conn, []byte("drop"))
+		msutil.WriteClientMessage(conn, ms.OpPing, nil)
+		msutil.WriteClientText(conn, []byte("hello"))
+	}()
+	expectMessage(t, conn, ms.OpText, "HELLO")
+
+	mu.Lock()
+	exp := []string{"upstream drop", "upstream hello", "downstream hello"}
+	if len(seen) != len(exp) {
+		t.Errorf("unexpected hook calls: %q; want %q", seen, exp)
+	} else {
+		for i := range exp {
+			if seen[i] != exp[i] {
+				t.Errorf("unexpected hook calls: %q; want %q", seen, exp)
+				break
+			}
+		}
+	}
+	mu.Unlock()
+
+	go msutil.WriteClientText(conn, []byte("kill"))
+	mstest.ExpectClose(t, conn, 4001)
+}
+
+func TestProxyServeHTTP(t *testing.T) {
+	up := newUpstream(t)
+	p := NewProxy("ws://"+up.addr, ms.Noop)
+	p.OnRequest = func(ctx context.Context, r *Request) error {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			return ms.RejectConnectionError(
+				ms.RejectionStatus(http.StatusForbidden),
+				ms.RejectionReason("forbidden"),
+			)
+		}
+		return nil
+	}
+	srv := httptest.NewServer(p)
+	defer srv.Close()
+	wsURL := "ws" + srv.URL[len("http"):]
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Run("relay", func(t *testing.T) {
+		d := ms.Dialer{
+			Protocols: []string{"chat"},
+			Header: ms.HandshakeHeaderHTTP(http.Header{
+				"Authorization": []string{"Bearer token"},
+			}),
+		}
+		conn, br, hs, err := d.Dial(ctx, wsURL+"/ws")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if hs.Protocol != "chat" {
+			t.Errorf("unexpected protocol: %q", hs.Protocol)
+		}
+		if _, header := up.request(); header.Get("X-Forwarded-For") != "127.0.0.1" {
+			t.Errorf("unexpected X-Forwarded-For: %q", header.Get("X-Forwarded-For"))
+		}
+		var r io.Reader = conn
+		if br != nil {
+			r = br
+		}
+		rw := struct {
+			io.Reader
+			io.Writer
+		}{r, conn}
+		if err := msutil.WriteClientText(conn, []byte("over http")); err != nil {
+			t.Fatal(err)
+		}
+		expectMessage(t, rw, ms.OpText, "over http")
+	})
+	t.Run("rejected", func(t *testing.T) {
+		_, _, _, err := ms.Dialer{}.Dial(ctx, wsURL+"/ws")
+		if err != ms.StatusError(http.StatusForbidden) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+	t.Run("bad gateway", func(t *testing.T) {
+		p := NewProxy("ws://"+closedAddr(t), ms.Noop)
+		srv := httptest.NewServer(p)
+		defer srv.Close()
+		_, _, _, err := ms.Dialer{}.Dial(ctx, "ws"+srv.URL[len("http"):])
+		if err != ms.StatusError(http.StatusBadGateway) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func closedAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	return ln.Addr().String()
+}
@@ -0,0 +1,227 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ms "github.com/cmacro/mogusocket"
+	"github.com/cmacro/mogusocket/msutil"
+)
+
+// closeTimeout is the time given to both peers to finish the closing
+// handshake.
+const closeTimeout = 5 * time.Second
+
+// errClosed is returned by the pump which relayed the close frame.
+var errClosed = errors.New("proxy: close frame relayed")
+
+// peer is one side of the relay. The state is the state of the proxy on this
+// side, so frames written to the upstream are masked.
+type peer struct {
+	conn  io.ReadWriter
+	r     *msutil.Reader
+	state ms.State
+	mu    sync.Mutex
+}
+
+func newPeer(conn io.ReadWriter, r io.Reader, state ms.State) *peer {
+	return &peer{
+		conn:  conn,
+		r:     &msutil.Reader{Source: r, State: state, CheckUTF8: true},
+		state: state,
+	}
+}
+
+func (p *peer) write(op ms.OpCode, payload []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return msutil.WriteMessage(p.conn, p.state, op, payload)
+}
+
+func (p *peer) setDeadline(t time.Time) {
+	if d, ok := p.conn.(interface{ SetDeadline(time.Time) error }); ok {
+		d.SetDeadline(t)
+	}
+}
+
+func (p *peer) close() {
+	if c, ok := p.conn.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// relay relays messages between the client and the upstream.
+type relay struct {
+	*Proxy
+	ctx        context.Context
+	client, up *peer
+	closeOnce  sync.Once
+	aborted    int32
+}
+
+func (p *Proxy) relay(ctx context.Context, conn io.ReadWriter, r io.Reader, up *upstreamConn) {
+	var ur io.Reader = up.conn
+	if up.br != nil {
+		defer ms.PutReader(up.br)
+		ur = up.br
+	}
+	rl := &relay{
+		Proxy:  p,
+		ctx:    ctx,
+		client: newPeer(conn, r, ms.StateServerSide),
+		up:     newPeer(up.conn, ur, ms.StateClientSide),
+	}
+	defer rl.up.close()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			rl.abort(ms.StatusGoingAway, "proxy shutdown")
+		case <-stop:
+		}
+	}()
+
+	errc := make(chan error, 2)
+	go func() { errc <- rl.pump(Upstream, rl.client, rl.up) }()
+	go func() { errc <- rl.pump(Downstream, rl.up, rl.client) }()
+
+	err := <-errc
+	if err == errClosed {
+		// Let the other side answer the close frame.
+		rl.client.setDeadline(time.Now().Add(closeTimeout))
+		rl.up.setDeadline(time.Now().Add(closeTimeout))
+	} else {
+		p.log.Info("proxy relay", err)
+		code, reason := closeCode(err)
+		rl.abort(code, reason)
+	}
+	<-errc
+}
+
+// abort sends close frame with code and reason to both sides and gives them
+// closeTimeout to answer.
+func (rl *relay) abort(code ms.StatusCode, reason string) {
+	rl.closeOnce.Do(func() {
+		atomic.StoreInt32(&rl.aborted, 1)
+		body := ms.NewCloseFrameBody(code, reason)
+		for _, p := range []*peer{rl.client, rl.up} {
+			p.setDeadline(time.Now().Add(closeTimeout))
+			p.write(ms.OpClose, body)
+		}
+	})
+}
+
+// pump relays messages from src to dst until the close frame is relayed or
+// an error occurs.
+func (rl *relay) pump(dir Direction, src, dst *peer) error {
+	src.r.OnIntermediate = func(h ms.Header, r io.Reader) error {
+		return rl.control(dir, h, r, dst)
+	}
+	max := rl.MaxMessageSize
+	if max == 0 {
+		max = DefaultMaxMessageSize
+	}
+	for {
+		h, err := src.r.NextFrame()
+		if err != nil {
+			return err
+		}
+		if h.OpCode.IsControl() {
+			if err := rl.control(dir, h, src.r, dst); err != nil {
+				return err
+			}
+			continue
+		}
+		if h.Length > max {
+			return msutil.CloseWithError{Code: ms.StatusMessageTooBig, Reason: "message too big"}
+		}
+		p, err := io.ReadAll(io.LimitReader(src.r, max+1))
+		if err != nil {
+			return err
+		}
+		if int64(len(p)) > max {
+			return msutil.CloseWithError{Code: ms.StatusMessageTooBig, Reason: "message too big"}
+		}
+		m := &Message{OpCode: h.OpCode, Payload: p}
+		if !rl.inspect(rl.OnMessage, dir, m, &err) {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err := dst.write(m.OpCode, m.Payload); err != nil {
+			return err
+		}
+	}
+}
+
+// control relays the control frame. It returns errClosed after relaying the
+// close frame.
+func (rl *relay) control(dir Direction, h ms.Header, r io.Reader, dst *peer) error {
+	p, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m := &Message{OpCode: h.OpCode, Payload: p}
+	forward := rl.inspect(rl.OnControl, dir, m, &err)
+	if err != nil {
+		return err
+	}
+	if h.OpCode == ms.OpClose {
+		// Both sides got the close frame already if the relay is aborted.
+		if atomic.LoadInt32(&rl.aborted) == 0 {
+			dst.write(m.OpCode, m.Payload)
+		}
+		return errClosed
+	}
+	if forward {
+		return dst.write(m.OpCode, m.Payload)
+	}
+	return nil
+}
+
+// inspect calls the hook with m. It reports whether m must be forwarded and
+// stores the hook error converted to CloseWithError in errp.
+func (rl *relay) inspect(hook Hook, dir Direction, m *Message, errp *error) bool {
+	if hook == nil {
+		return true
+	}
+	forward, err := hook(rl.ctx, dir, m)
+	if err != nil {
+		var ce msutil.CloseWithError
+		if !errors.As(err, &ce) {
+			err = msutil.CloseWithError{Code: ms.StatusInternalServerError, Reason: "proxy error", Err: err}
+		}
+		*errp = err
+		return false
+	}
+	return forward
+}
+
+// closeCode returns the close code and reason for the relay error.
+func closeCode(err error) (ms.StatusCode, string) {
+	var (
+		ce msutil.CloseWithError
+		pe ms.ProtocolError
+	)
+	switch {
+	case errors.As(err, &ce):
+		return ce.Code, ce.Reason
+	case errors.As(err, &pe):
+		return ms.StatusProtocolError, "protocol error"
+	case errors.Is(err, msutil.ErrInvalidUTF8):
+		return ms.StatusInvalidFramePayloadData, "invalid utf8"
+	default:
+		return ms.StatusGoingAway, "peer gone"
+	}
+}
@@ -0,0 +1,386 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package mogusocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DefaultProxyHeaderTimeout is used when ProxyProtocol.Timeout is zero.
+const DefaultProxyHeaderTimeout = 5 * time.Second
+
+// Errors used by ProxyProtocol.
+var (
+	ErrProxyHeader  = fmt.Errorf("malformed PROXY protocol header")
+	ErrProxyVersion = fmt.Errorf("unsupported PROXY protocol version")
+)
+
+// Types of PROXY protocol v2 TLVs.
+const (
+	ProxyTLVALPN      = 0x01
+	ProxyTLVAuthority = 0x02
+	ProxyTLVCRC32C    = 0x03
+	ProxyTLVNoop      = 0x04
+	ProxyTLVUniqueID  = 0x05
+	ProxyTLVSSL       = 0x20
+	ProxyTLVNetNS     = 0x30
+)
+
+const (
+	proxyV1Prefix = "PROXY "
+	proxyV1MaxLen = 107
+	proxyV2Sig    = "\r\n\r\n\x00\r\nQUIT\n"
+)
+
+// ProxyProtocol contains options of reading HAProxy PROXY protocol headers
+// of accepted connections. Both v1 and v2 versions are supported.
+//
+// See https://www.haproxy.org/download/2.8/doc/proxy-protocol.txt
+type ProxyProtocol struct {
+	// Trusted lists IP addresses and CIDRs of proxies which headers are
+	// read. Connections from other sources are served as is, so a client
+	// could not spoof its address. If it is empty, no source is trusted.
+	Trusted []string
+
+	// TrustAll makes every connection start with the header regardless of
+	// its source. It is meant for listeners reachable only by the proxy,
+	// e.g. unix sockets.
+	TrustAll bool
+
+	// Timeout limits the time of reading the header. If it is zero,
+	// DefaultProxyHeaderTimeout is used.
+	Timeout time.Duration
+}
+
+// ProxyHeader is the PROXY protocol header of a connection.
+type ProxyHeader struct {
+	// Version is 1 or 2.
+	Version int
+
+	// Local reports that the connection was made by the proxy itself, e.g.
+	// for health checks, or the proxy did not tell the addresses. Source and
+	// Destination are nil then.
+	Local bool
+
+	// Source and Destination are the addresses of the client connection
+	// accepted by the proxy.
+	Source, Destination net.Addr
+
+	// TLVs are the additional fields of the v2 header.
+	TLVs []ProxyTLV
+}
+
+// ProxyTLV is the type-length-value field of the PROXY protocol v2 header.
+type ProxyTLV struct {
+	Type  byte
+	Value []byte
+}
+
+// TLV returns the value of the first TLV of given type.
+func (h *ProxyHeader) TLV(typ byte) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == typ {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// ProxyConn is the connection which started with the PROXY protocol header.
+// Its RemoteAddr() and LocalAddr() return the addresses of the header.
+type ProxyConn struct {
+	net.Conn
+	Header *ProxyHeader
+}
+
+// RemoteAddr returns the address of the client.
+func (c *ProxyConn) RemoteAddr() net.Addr {
+	if c.Header.Source != nil {
+		return c.Header.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the address the client connected to.
+func (c *ProxyConn) LocalAddr() net.Addr {
+	if c.Header.Destination != nil {
+		return c.Header.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+// SyscallConn returns the raw connection of the underlying connection. It
+// allows to serve ProxyConn in the event loop mode.
+func (c *ProxyConn) SyscallConn() (syscall.RawConn, error) {
+	sc, ok := c.Conn.(syscall.Conn)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a syscall.Conn", c.Conn)
+	}
+	return sc.SyscallConn()
+}
+
+// Accept reads the header of conn if it came from a trusted source. It
+// returns *ProxyConn in that case and conn itself otherwise.
+//
+// No bytes after the header are read, so the returned connection could be
+// polled for readability.
+func (p *ProxyProtocol) Accept(conn net.Conn) (net.Conn, error) {
+	if !p.TrustAll && !trustedIP(p.Trusted, addrIP(conn.RemoteAddr())) {
+		return conn, nil
+	}
+	conn.SetReadDeadline(time.Now().Add(nonZeroDuration(p.Timeout, DefaultProxyHeaderTimeout)))
+	h, err := ReadProxyHeader(conn)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(noDeadline)
+	return &ProxyConn{Conn: conn, Header: h}, nil
+}
+
+// ReadProxyHeader reads the PROXY protocol header from r. It never reads the
+// bytes following the header.
+func ReadProxyHeader(r io.Reader) (*ProxyHeader, error) {
+	// Both v2 signature and the shortest v1 header are at least 12 bytes.
+	p := make([]byte, len(proxyV2Sig), proxyV1MaxLen)
+	if _, err := io.ReadFull(r, p); err != nil {
+		return nil, err
+	}
+	switch {
+	case string(p) == proxyV2Sig:
+		return readProxyV2(r)
+	case strings.HasPrefix(string(p), proxyV1Prefix):
+		return readProxyV1(r, p)
+	}
+	return nil, ErrProxyVersion
+}
+
+func readProxyV1(r io.Reader, p []byte) (*ProxyHeader, error) {
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(p, []byte("\r\n")) {
+		if len(p) == proxyV1MaxLen {
+			return nil, ErrProxyHeader
+		}
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		p = append(p, b[0])
+	}
+	h := &ProxyHeader{Version: 1}
+	fields := strings.Split(string(p[len(proxyV1Prefix):len(p)-2]), " ")
+	switch fields[0] {
+	case "UNKNOWN":
+		h.Local = true
+		return h, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, ErrProxyHeader
+	}
+	if len(fields) != 5 {
+		return nil, ErrProxyHeader
+	}
+	src, err := parseProxyV1Addr(fields[0], fields[1], fields[3])
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseProxyV1Addr(fields[0], fields[2], fields[4])
+	if err != nil {
+		return nil, err
+	}
+	h.Source, h.Destination = src, dst
+	return h, nil
+}
+
+func parseProxyV1Addr(proto, host, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil || (proto == "TCP4") != (ip.To4() != nil) {
+		return nil, ErrProxyHeader
+	}
+	n, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, ErrProxyHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: int(n)}, nil
+}
+
+func readProxyV2(r io.Reader) (*ProxyHeader, error) {
+	var head [4]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+	if head[0]>>4 != 2 {
+		return nil, ErrProxyVersion
+	}
+	p := make([]byte, binary.BigEndian.Uint16(head[2:]))
+	if _, err := io.ReadFull(r, p); err != nil {
+		return nil, err
+	}
+
+	h := &ProxyHeader{Version: 2}
+	switch head[0] & 0xf {
+	case 0x0:
+		h.Local = true
+	case 0x1:
+	default:
+		return nil, ErrProxyHeader
+	}
+
+	var n int
+	switch head[1] >> 4 {
+	case 0x1:
+		n = 12
+	case 0x2:
+		n = 36
+	case 0x3:
+		n = 216
+	}
+	if len(p) < n {
+		return nil, ErrProxyHeader
+	}
+	if !h.Local {
+		h.Source, h.Destination = proxyV2Addrs(head[1], p[:n])
+		h.Local = h.Source == nil
+	}
+
+	for tlvs := p[n:]; len(tlvs) > 0; {
+		if len(tlvs) < 3 {
+			return nil, ErrProxyHeader
+		}
+		m := 3 + int(binary.BigEndian.Uint16(tlvs[1:]))
+		if len(tlvs) < m {
+			return nil, ErrProxyHeader
+		}
+		h.TLVs = append(h.TLVs, ProxyTLV{Type: tlvs[0], Value: tlvs[3:m]})
+		tlvs = tlvs[m:]
+	}
+	return h, nil
+}
+
+// proxyV2Addrs returns the addresses of the family and the protocol fam. It
+// returns nils for unspecified ones.
+func proxyV2Addrs(fam byte, p []byte) (src, dst net.Addr) {
+	stream := fam&0xf == 0x1
+	switch fam >> 4 {
+	case 0x1, 0x2:
+		n := (len(p) - 4) / 2
+		sip, dip := net.IP(p[:n]), net.IP(p[n:2*n])
+		sport := int(binary.BigEndian.Uint16(p[2*n:]))
+		dport := int(binary.BigEndian.Uint16(p[2*n+2:]))
+		if stream {
+			return &net.TCPAddr{IP: sip, Port: sport}, &net.TCPAddr{IP: dip, Port: dport}
+		}
+		return &net.UDPAddr{IP: sip, Port: sport}, &net.UDPAddr{IP: dip, Port: dport}
+	case 0x3:
+		network := "unixgram"
+		if stream {
+			network = "unix"
+		}
+		return &net.UnixAddr{Name: cString(p[:108]), Net: network},
+			&net.UnixAddr{Name: cString(p[108:]), Net: network}
+	}
+	return nil, nil
+}
+
+func cString(p []byte) string {
+	if i := bytes.IndexByte(p, 0); i >= 0 {
+		p = p[:i]
+	}
+	return string(p)
+}
+
+// remoteAddr returns the address of the client connected with conn and the
+// PROXY protocol header of the connection.
+func remoteAddr(conn io.ReadWriter) (net.Addr, *ProxyHeader) {
+	switch c := conn.(type) {
+	case *ProxyConn:
+		return c.RemoteAddr(), c.Header
+	case net.Conn:
+		return c.RemoteAddr(), nil
+	}
+	return nil, nil
+}
+
+func joinForwarded(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "," + b
+}
+
+// forwardedFor returns the client address taken from the X-Forwarded-For
+// header value xff. Hops are taken from right to left while the address
+// they were received from is trusted.
+func forwardedFor(remote net.Addr, xff string, trusted []string) net.Addr {
+	ip := addrIP(remote)
+	if xff == "" || !trustedIP(trusted, ip) {
+		return remote
+	}
+	hops := strings.Split(xff, ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		addr := &net.TCPAddr{IP: net.ParseIP(hop)}
+		if host, port, err := net.SplitHostPort(hop); err == nil {
+			addr.IP = net.ParseIP(host)
+			addr.Port, _ = strconv.Atoi(port)
+		}
+		if addr.IP == nil {
+			break
+		}
+		remote = addr
+		if !trustedIP(trusted, addr.IP) {
+			break
+		}
+	}
+	return remote
+}
+
+// trustedIP reports whether ip matches one of IP addresses and CIDRs of list.
+func trustedIP(list []string, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, s := range list {
+		if _, n, err := net.ParseCIDR(s); err == nil {
+			if n.Contains(ip) {
+				return true
+			}
+		} else if t := net.ParseIP(s); t != nil && t.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	case *net.IPAddr:
+		return a.IP
+	case nil:
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+func nonZeroDuration(a, b time.Duration) time.Duration {
+	if a == 0 {
+		return b
+	}
+	return a
+}
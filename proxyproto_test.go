@@ -0,0 +1,396 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package mogusocket
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func proxyV2Header(verCmd, fam byte, addrs []byte, tlvs ...ProxyTLV) []byte {
+	p := append([]byte(proxyV2Sig), verCmd, fam, 0, 0)
+	p = append(p, addrs...)
+	for _, tlv := range tlvs {
+		p = append(p, tlv.Type)
+		p = binary.BigEndian.AppendUint16(p, uint16(len(tlv.Value)))
+		p = append(p, tlv.Value...)
+	}
+	binary.BigEndian.PutUint16(p[len(proxyV2Sig)+2:], uint16(len(p)-len(proxyV2Sig)-4))
+	return p
+}
+
+func proxyV2Inet(src, dst string, sport, dport uint16) []byte {
+	p := append(net.ParseIP(src).To4(), net.ParseIP(dst).To4()...)
+	p = binary.BigEndian.AppendUint16(p, sport)
+	return binary.BigEndian.AppendUint16(p, dport)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	unixAddrs := make([]byte, 216)
+	copy(unixAddrs, "/run/client.sock")
+	copy(unixAddrs[108:], "/run/server.sock")
+	ip6 := append(net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2")...)
+	ip6 = append(ip6, 0xc3, 0x50, 0x01, 0xbb)
+
+	for _, test := range []struct {
+		name   string
+		in     []byte
+		exp    *ProxyHeader
+		err    error
+		errAny bool
+	}{
+		{
+			name: "v1 tcp4",
+			in:   []byte("PROXY TCP4 203.0.113.7 10.0.0.1 51234 443\r\n"),
+			exp: &ProxyHeader{
+				Version:     1,
+				Source:      &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 51234},
+				Destination: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 443},
+			},
+		},
+		{
+			name: "v1 tcp6",
+			in:   []byte("PROXY TCP6 2001:db8::1 2001:db8::2 50000 443\r\n"),
+			exp: &ProxyHeader{
+				Version:     1,
+				Source:      &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 50000},
+				Destination: &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 443},
+			},
+		},
+		{
+			name: "v1 unknown",
+			in:   []byte("PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n"),
+			exp:  &ProxyHeader{Version: 1, Local: true},
+		},
+		{
+			name: "v1 family mismatch",
+			in:   []byte("PROXY TCP4 2001:db8::1 10.0.0.1 1 2\r\n"),
+			err:  ErrProxyHeader,
+		},
+		{
+			name: "v1 bad port",
+			in:   []byte("PROXY TCP4 203.0.113.7 10.0.0.1 65536 443\r\n"),
+			err:  ErrProxyHeader,
+		},
+		{
+			name: "v1 too long",
+			in:   []byte("PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n"),
+			err:  ErrProxyHeader,
+		},
+		{
+			name: "not a header",
+			in:   []byte("GET / HTTP/1.1\r\n"),
+			err:  ErrProxyVersion,
+		},
+		{
+			name:   "truncated",
+			in:     []byte("PROXY TCP4 203.0.113.7"),
+			errAny: true,
+		},
+		{
+			name: "v2 inet",
+			in: proxyV2Header(0x21, 0x11, proxyV2Inet("203.0.113.7", "10.0.0.1", 51234, 443),
+				ProxyTLV{Type: ProxyTLVAuthority, Value: []byte("example.org")},
+				ProxyTLV{Type: ProxyTLVUniqueID, Value: []byte{1, 2, 3}},
+			),
+			exp: &ProxyHeader{
+				Version:     2,
+				Source:      &net.TCPAddr{IP: net.ParseIP("203.0.113.7").To4(), Port: 51234},
+				Destination: &net.TCPAddr{IP: net.ParseIP("10.0.0.1").To4(), Port: 443},
+				TLVs: []ProxyTLV{
+					{Type: ProxyTLVAuthority, Value: []byte("example.org")},
+					{Type: ProxyTLVUniqueID, Value: []byte{1, 2, 3}},
+				},
+			},
+		},
+		{
+			name: "v2 inet6",
+			in:   proxyV2Header(0x21, 0x21, ip6),
+			exp: &ProxyHeader{
+				Version:     2,
+				Source:      &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 50000},
+				Destination: &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 443},
+			},
+		},
+		{
+			name: "v2 unix",
+			in:   proxyV2Header(0x21, 0x31, unixAddrs),
+			exp: &ProxyHeader{
+				Version:     2,
+				Source:      &net.UnixAddr{Name: "/run/client.sock", Net: "unix"},
+				Destination: &net.UnixAddr{Name: "/run/server.sock", Net: "unix"},
+			},
+		},
+		{
+			name: "v2 local",
+			in:   proxyV2Header(0x20, 0x00, nil),
+			exp:  &ProxyHeader{Version: 2, Local: true},
+		},
+		{
+			name: "v2 bad version",
+			in:   proxyV2Header(0x11, 0x11, proxyV2Inet("203.0.113.7", "10.0.0.1", 1, 2)),
+			err:  ErrProxyVersion,
+		},
+		{
+			name: "v2 bad command",
+			in:   proxyV2Header(0x22, 0x11, proxyV2Inet("203.0.113.7", "10.0.0.1", 1, 2)),
+			err:  ErrProxyHeader,
+		},
+		{
+			name: "v2 short addresses",
+			in:   proxyV2Header(0x21, 0x21, make([]byte, 12)),
+			err:  ErrProxyHeader,
+		},
+		{
+			name: "v2 truncated tlv",
+			in:   proxyV2Header(0x21, 0x11, append(proxyV2Inet("203.0.113.7", "10.0.0.1", 1, 2), 0)),
+			err:  ErrProxyHeader,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			const rest = "rest of the stream"
+			r := bytes.NewReader(append(append([]byte{}, test.in...), rest...))
+			h, err := ReadProxyHeader(r)
+			switch {
+			case test.errAny:
+				if err == nil {
+					t.Fatalf("expected error")
+				}
+				return
+			case err != test.err:
+				t.Fatalf("unexpected error: %v; want %v", err, test.err)
+			case err != nil:
+				return
+			}
+			if !reflect.DeepEqual(h, test.exp) {
+				t.Errorf("unexpected header:\nact %+v\nexp %+v", h, test.exp)
+			}
+			if p, _ := io.ReadAll(r); string(p) != rest {
+				t.Errorf("bytes after the header are consumed: %q", p)
+			}
+		})
+	}
+}
+
+func TestProxyHeaderTLV(t *testing.T) {
+	h := ProxyHeader{TLVs: []ProxyTLV{{Type: ProxyTLVALPN, Value: []byte("h2")}}}
+	if v, ok := h.TLV(ProxyTLVALPN); !ok || string(v) != "h2" {
+		t.Errorf("unexpected alpn: %q %t", v, ok)
+	}
+	if _, ok := h.TLV(ProxyTLVSSL); ok {
+		t.Errorf("unexpected ssl tlv")
+	}
+}
+
+func TestProxyProtocolAcceptUntrusted(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	for _, p := range []ProxyProtocol{
+		{Trusted: []string{"10.0.0.0/8"}},
+		{},
+	} {
+		conn, err := p.Accept(server)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conn != server {
+			t.Errorf("header is read from untrusted source with %+v", p)
+		}
+	}
+}
+
+func TestProxyProtocolTimeout(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	p := ProxyProtocol{TrustAll: true, Timeout: 10 * time.Millisecond}
+	if _, err := p.Accept(server); !isTimeoutError(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+type remoteAddrHandler chan string
+
+func (h remoteAddrHandler) Run(ctx context.Context, conn io.ReadWriter) {
+	p := make([]byte, 5)
+	io.ReadFull(conn, p)
+	h <- conn.(net.Conn).RemoteAddr().String() + " " + string(p)
+}
+
+func TestServerProxyProtocol(t *testing.T) {
+	addrs := make(remoteAddrHandler, 1)
+	sock := filepath.Join(t.TempDir(), "proxy.sock")
+	srv := NewServer("unix://"+sock, addrs, Noop)
+	srv.ProxyProtocol = &ProxyProtocol{TrustAll: true}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go srv.Run(ctx)
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	for i := 0; i < 100; i++ {
+		if conn, err = net.Dial("unix", sock); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("PROXY TCP4 203.0.113.7 10.0.0.1 51234 443\r\nhello"))
+	select {
+	case act := <-addrs:
+		if exp := "203.0.113.7:51234 hello"; act != exp {
+			t.Errorf("unexpected connection: %q; want %q", act, exp)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("connection was not served")
+	}
+}
+
+func TestForwardedFor(t *testing.T) {
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 40000}
+	trusted := []string{"10.0.0.0/8", "192.0.2.1"}
+	for _, test := range []struct {
+		name   string
+		remote net.Addr
+		xff    string
+		exp    string
+	}{
+		{
+			name:   "no header",
+			remote: remote,
+			exp:    "10.0.0.2:40000",
+		},
+		{
+			name:   "untrusted remote",
+			remote: &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 1},
+			xff:    "203.0.113.7",
+			exp:    "198.51.100.1:1",
+		},
+		{
+			name:   "single hop",
+			remote: remote,
+			xff:    "203.0.113.7",
+			exp:    "203.0.113.7:0",
+		},
+		{
+			name:   "trusted chain",
+			remote: remote,
+			xff:    "203.0.113.7, 192.0.2.1, 10.1.1.1",
+			exp:    "203.0.113.7:0",
+		},
+		{
+			name:   "spoofed left hops",
+			remote: remote,
+			xff:    "1.1.1.1, 203.0.113.7, 10.1.1.1",
+			exp:    "203.0.113.7:0",
+		},
+		{
+			name:   "port",
+			remote: remote,
+			xff:    "[2001:db8::1]:443",
+			exp:    "[2001:db8::1]:443",
+		},
+		{
+			name:   "garbage",
+			remote: remote,
+			xff:    "unknown, 10.1.1.1",
+			exp:    "10.1.1.1:0",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if act := forwardedFor(test.remote, test.xff, trusted).String(); act != test.exp {
+				t.Errorf("unexpected address: %q; want %q", act, test.exp)
+			}
+		})
+	}
+}
+
+func TestUpgraderRemoteAddr(t *testing.T) {
+	h := make(http.Header)
+	h.Set(headerUpgrade, "websocket")
+	h.Set(headerConnection, "Upgrade")
+	h.Set(headerSecVersion, "13")
+	h.Set(headerSecKey, string(mustMakeNonce()))
+	h.Set("X-Forwarded-For", "203.0.113.7")
+	req := mustMakeRequest("GET", "ws://example.org/", h)
+
+	server, client := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go func() {
+		client.Write(dumpRequest(req))
+		io.Copy(io.Discard, client)
+	}()
+
+	header := &ProxyHeader{
+		Version: 2,
+		Source:  &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 40000},
+	}
+	conn := &ProxyConn{Conn: server, Header: header}
+	u := Upgrader{TrustedProxies: []string{"10.0.0.0/8"}}
+	hs, err := u.Upgrade(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hs.Proxy != header {
+		t.Errorf("proxy header is not set")
+	}
+	if act, exp := hs.RemoteAddr.String(), "203.0.113.7:0"; act != exp {
+		t.Errorf("unexpected remote addr: %q; want %q", act, exp)
+	}
+}
+
+func TestHTTPUpgraderRemoteAddr(t *testing.T) {
+	h := make(http.Header)
+	h.Set(headerUpgrade, "websocket")
+	h.Set(headerConnection, "Upgrade")
+	h.Set(headerSecVersion, "13")
+	h.Set(headerSecKey, string(mustMakeNonce()))
+	h.Add("X-Forwarded-For", "203.0.113.7")
+	h.Add("X-Forwarded-For", "10.1.1.1")
+	req := mustMakeRequest("GET", "ws://example.org/", h)
+
+	res := newRecorder()
+	res.conn = func(buf *bytes.Buffer) net.Conn {
+		return addrConn{
+			stubConn: stubConn{read: buf.Read, write: buf.Write},
+			remote:   &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 40000},
+		}
+	}
+	u := HTTPUpgrader{TrustedProxies: []string{"127.0.0.1", "10.0.0.0/8"}}
+	_, _, hs, err := u.Upgrade(req, res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if act, exp := hs.RemoteAddr.String(), "203.0.113.7:0"; act != exp {
+		t.Errorf("unexpected remote addr: %q; want %q", act, exp)
+	}
+}
+
+type addrConn struct {
+	stubConn
+	remote net.Addr
+}
+
+func (c addrConn) RemoteAddr() net.Addr { return c.remote }
@@ -0,0 +1,342 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package pubsub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	ms "github.com/cmacro/mogusocket"
+	"github.com/cmacro/mogusocket/msutil"
+)
+
+// OverflowPolicy defines what happens when the queue of a subscriber is full.
+type OverflowPolicy int
+
+const (
+	// DropOldest drops the oldest queued message to queue the new one.
+	DropOldest OverflowPolicy = iota
+	// DropNewest drops the new message.
+	DropNewest
+	// Disconnect closes the subscriber connection with
+	// ms.StatusPolicyViolation.
+	Disconnect
+)
+
+// DefaultQueueSize is the queue size used when Broker.QueueSize is zero.
+const DefaultQueueSize = 256
+
+// Broker is the ms.SessionsHandler of pub/sub connections. It is served with
+// msutil.Connecter or msutil.PollConnecter:
+//
+//	b := pubsub.NewBroker(log)
+//	srv := ms.NewServer("unix:///run/bus.sock", msutil.NewConnecter(b, log), log)
+type Broker struct {
+	log ms.Logger
+
+	// QueueSize is the number of messages queued for a subscriber before
+	// Overflow policy is applied. Zero means DefaultQueueSize.
+	QueueSize int
+
+	// Overflow is the policy applied to a full subscriber queue.
+	Overflow OverflowPolicy
+
+	// MaxInFlight is the number of messages delivered to a subscriber and
+	// not yet acknowledged, after which delivery is paused until an ack is
+	// received. Zero means acks are not awaited.
+	MaxInFlight int
+
+	lastID   int64
+	mu       sync.Mutex
+	subs     map[int64]*subscriber
+	retained map[string]*Message
+}
+
+// NewBroker returns Broker with default options.
+func NewBroker(log ms.Logger) *Broker {
+	return &Broker{
+		log:      log,
+		subs:     make(map[int64]*subscriber),
+		retained: make(map[string]*Message),
+	}
+}
+
+// Connect implements ms.SessionsHandler.
+func (b *Broker) Connect(ctx context.Context, w ms.SendFunc, c func()) (ms.SessionHandler, error) {
+	s := &subscriber{
+		id:       atomic.AddInt64(&b.lastID, 1),
+		b:        b,
+		ctx:      ctx,
+		send:     w,
+		cancel:   c,
+		filters:  make(map[string]bool),
+		wake:     make(chan struct{}, 1),
+		inflight: make(map[uint64]struct{}),
+	}
+	b.mu.Lock()
+	b.subs[s.id] = s
+	b.mu.Unlock()
+
+	go s.deliver()
+	return s, nil
+}
+
+// Close implements ms.SessionsHandler.
+func (b *Broker) Close(session ms.SessionHandler) error {
+	s, ok := session.(*subscriber)
+	if !ok {
+		return nil
+	}
+	b.mu.Lock()
+	delete(b.subs, s.id)
+	b.mu.Unlock()
+	s.Close()
+	return nil
+}
+
+// Publish publishes the message to all matching subscribers. If retain is
+// true, the message is kept for future subscribers replacing the previous
+// one; retained message with empty payload removes the kept one.
+func (b *Broker) Publish(topic string, payload json.RawMessage, retain bool) error {
+	if err := ValidTopic(topic); err != nil {
+		return err
+	}
+	m := &Message{Topic: topic, Payload: payload}
+
+	b.mu.Lock()
+	if retain {
+		if len(payload) == 0 || bytes.Equal(payload, []byte("null")) {
+			delete(b.retained, topic)
+		} else {
+			b.retained[topic] = &Message{Topic: topic, Payload: payload, Retained: true}
+		}
+	}
+	subs := make([]*subscriber, 0, len(b.subs))
+	for _, s := range b.subs {
+		subs = append(subs, s)
+	}
+	b.mu.Unlock()
+
+	for _, s := range subs {
+		if s.matches(topic) {
+			s.enqueue(m)
+		}
+	}
+	return nil
+}
+
+// Retained returns the retained message of the topic or nil.
+func (b *Broker) Retained(topic string) *Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.retained[topic]
+}
+
+func (b *Broker) queueSize() int {
+	if b.QueueSize > 0 {
+		return b.QueueSize
+	}
+	return DefaultQueueSize
+}
+
+// subscriber is the broker side of a client connection.
+type subscriber struct {
+	id     int64
+	b      *Broker
+	ctx    context.Context
+	send   ms.SendFunc
+	cancel func()
+
+	mu      sync.Mutex
+	filters map[string]bool
+	queue   []*Message
+	seq     uint64
+	wake    chan struct{}
+
+	// inflight contains ids of delivered messages which are not
+	// acknowledged. It is tracked only if Broker.MaxInFlight is set.
+	inflight map[uint64]struct{}
+
+	// overflowed is set when the subscriber is disconnected due to the
+	// queue overflow.
+	overflowed bool
+}
+
+func (s *subscriber) GetId() int64 { return s.id }
+
+func (s *subscriber) Close() { s.cancel() }
+
+func (s *subscriber) ReadPump(r io.Reader, len int64, isText bool) error {
+	var f Frame
+	if err := msutil.ReadJSON(r, &f); err != nil {
+		return err
+	}
+
+	var err error
+	switch f.Op {
+	case OpSubscribe:
+		err = s.subscribe(f.Topic)
+	case OpUnsubscribe:
+		s.mu.Lock()
+		delete(s.filters, f.Topic)
+		s.mu.Unlock()
+	case OpPublish:
+		err = s.b.Publish(f.Topic, f.Payload, f.Retain)
+	case OpAck:
+		s.ack(f.ID)
+		return nil
+	default:
+		return msutil.CloseWithError{
+			Code:   ms.StatusProtocolError,
+			Reason: "unknown operation",
+		}
+	}
+
+	reply := Frame{Op: OpOK, ID: f.ID}
+	if err != nil {
+		reply = Frame{Op: OpError, ID: f.ID, Error: err.Error()}
+	}
+	return s.write(&reply)
+}
+
+func (s *subscriber) subscribe(filter string) error {
+	if err := ValidFilter(filter); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.filters[filter] = true
+	s.mu.Unlock()
+
+	var retained []*Message
+	s.b.mu.Lock()
+	for topic, m := range s.b.retained {
+		if Match(filter, topic) {
+			retained = append(retained, m)
+		}
+	}
+	s.b.mu.Unlock()
+	for _, m := range retained {
+		s.enqueue(m)
+	}
+	return nil
+}
+
+func (s *subscriber) matches(topic string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for f := range s.filters {
+		if Match(f, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *subscriber) enqueue(m *Message) {
+	s.mu.Lock()
+	if s.overflowed {
+		s.mu.Unlock()
+		return
+	}
+	if len(s.queue) >= s.b.queueSize() {
+		switch s.b.Overflow {
+		case DropNewest:
+			s.mu.Unlock()
+			s.b.log.Debug("subscriber queue overflow", s.id)
+			return
+		case Disconnect:
+			s.overflowed = true
+			s.queue = nil
+			s.mu.Unlock()
+			s.b.log.Info("subscriber queue overflow", s.id)
+			// The close frame is written to the slow connection, so the
+			// publisher must not wait for it.
+			go msutil.CloseSession(s.ctx, ms.StatusPolicyViolation, "queue overflow")
+			return
+		default:
+			s.queue[0] = nil
+			s.queue = s.queue[1:]
+		}
+	}
+	s.queue = append(s.queue, m)
+	s.mu.Unlock()
+	s.signal()
+}
+
+// ack acknowledges the delivered message. Unknown ids and repeated acks are
+// ignored.
+func (s *subscriber) ack(id uint64) {
+	s.mu.Lock()
+	_, ok := s.inflight[id]
+	delete(s.inflight, id)
+	s.mu.Unlock()
+	if ok {
+		s.signal()
+	}
+}
+
+func (s *subscriber) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next takes the next message to deliver. It reports false if the queue is
+// empty or too many messages are not acknowledged.
+func (s *subscriber) next() (*Frame, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.queue) == 0 || s.b.MaxInFlight > 0 && len(s.inflight) >= s.b.MaxInFlight {
+		return nil, false
+	}
+	m := s.queue[0]
+	s.queue[0] = nil
+	s.queue = s.queue[1:]
+	s.seq++
+	if s.b.MaxInFlight > 0 {
+		s.inflight[s.seq] = struct{}{}
+	}
+	return &Frame{
+		Op:      OpMessage,
+		ID:      s.seq,
+		Topic:   m.Topic,
+		Payload: m.Payload,
+		Retain:  m.Retained,
+	}, true
+}
+
+// deliver writes queued messages until the session is closed.
+func (s *subscriber) deliver() {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.wake:
+		}
+		for {
+			f, ok := s.next()
+			if !ok {
+				break
+			}
+			if err := s.write(f); err != nil {
+				s.b.log.Info("deliver", s.id, err)
+				return
+			}
+		}
+	}
+}
+
+func (s *subscriber) write(f *Frame) error {
+	p, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return s.send(bytes.NewReader(p), true)
+}
@@ -0,0 +1,223 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package pubsub
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+	"time"
+
+	ms "github.com/cmacro/mogusocket"
+	"github.com/cmacro/mogusocket/internal/mstest"
+)
+
+type rawClient struct {
+	*mstest.Conn
+	t *testing.T
+}
+
+// serveRaw serves b on one end of a pipe and returns a client speaking raw
+// frames on the other end.
+func serveRaw(t *testing.T, b *Broker) *rawClient {
+	t.Helper()
+	return &rawClient{Conn: mstest.Serve(t, b), t: t}
+}
+
+func (c *rawClient) write(f Frame) {
+	c.t.Helper()
+	p, _ := json.Marshal(f)
+	c.WriteText(p)
+}
+
+func (c *rawClient) read() Frame {
+	c.t.Helper()
+	p, _ := c.ReadMessage()
+	var f Frame
+	if err := json.Unmarshal(p, &f); err != nil {
+		c.t.Fatal(err)
+	}
+	return f
+}
+
+// readN reads n frames sorted by op, since replies and deliveries are
+// written by different goroutines.
+func (c *rawClient) readN(n int) []Frame {
+	c.t.Helper()
+	fs := make([]Frame, n)
+	for i := range fs {
+		fs[i] = c.read()
+	}
+	sort.SliceStable(fs, func(i, j int) bool { return fs[i].Op < fs[j].Op })
+	return fs
+}
+
+func TestBrokerPublish(t *testing.T) {
+	b := NewBroker(ms.Noop)
+	sub := serveRaw(t, b)
+	pub := serveRaw(t, b)
+
+	sub.write(Frame{Op: OpSubscribe, ID: 1, Topic: "a/*/c"})
+	if f := sub.read(); f.Op != OpOK || f.ID != 1 {
+		t.Fatalf("unexpected reply: %+v", f)
+	}
+	sub.write(Frame{Op: OpSubscribe, ID: 2, Topic: "a/#/c"})
+	if f := sub.read(); f.Op != OpError || f.ID != 2 || f.Error == "" {
+		t.Fatalf("unexpected reply: %+v", f)
+	}
+
+	pub.write(Frame{Op: OpPublish, ID: 1, Topic: "a/b/d", Payload: json.RawMessage(`0`)})
+	pub.read()
+	pub.write(Frame{Op: OpPublish, ID: 2, Topic: "a/b/c", Payload: json.RawMessage(`{"v":1}`)})
+	if f := pub.read(); f.Op != OpOK || f.ID != 2 {
+		t.Fatalf("unexpected reply: %+v", f)
+	}
+	pub.write(Frame{Op: OpPublish, ID: 3, Topic: "a/*"})
+	if f := pub.read(); f.Op != OpError {
+		t.Fatalf("unexpected reply: %+v", f)
+	}
+
+	f := sub.read()
+	if f.Op != OpMessage || f.Topic != "a/b/c" || string(f.Payload) != `{"v":1}` || f.Retain {
+		t.Fatalf("unexpected message: %+v", f)
+	}
+
+	sub.write(Frame{Op: OpUnsubscribe, ID: 3, Topic: "a/*/c"})
+	sub.read()
+	b.Publish("a/b/c", json.RawMessage(`2`), false)
+	sub.write(Frame{Op: OpSubscribe, ID: 4, Topic: "x"})
+	if f := sub.read(); f.Op != OpOK || f.ID != 4 {
+		t.Fatalf("unexpected frame after unsubscribe: %+v", f)
+	}
+}
+
+func TestBrokerRetained(t *testing.T) {
+	b := NewBroker(ms.Noop)
+	c := serveRaw(t, b)
+
+	c.write(Frame{Op: OpPublish, ID: 1, Topic: "a/b", Payload: json.RawMessage(`1`), Retain: true})
+	c.read()
+	c.write(Frame{Op: OpPublish, ID: 2, Topic: "a/c", Payload: json.RawMessage(`2`), Retain: true})
+	c.read()
+	// Empty payload removes the retained message.
+	c.write(Frame{Op: OpPublish, ID: 3, Topic: "a/c", Retain: true})
+	c.read()
+	if b.Retained("a/b") == nil || b.Retained("a/c") != nil {
+		t.Fatal("unexpected retained messages")
+	}
+
+	c.write(Frame{Op: OpSubscribe, ID: 4, Topic: "a/#"})
+	fs := c.readN(2)
+	if fs[0].Op != OpMessage || fs[0].Topic != "a/b" || !fs[0].Retain || string(fs[0].Payload) != "1" {
+		t.Fatalf("unexpected message: %+v", fs[0])
+	}
+	if fs[1].Op != OpOK || fs[1].ID != 4 {
+		t.Fatalf("unexpected reply: %+v", fs[1])
+	}
+}
+
+// expectPaused checks that no message is delivered to c before the replies
+// to a couple of requests.
+func (c *rawClient) expectPaused(id uint64) {
+	c.t.Helper()
+	for _, topic := range []string{"x", "y"} {
+		c.write(Frame{Op: OpSubscribe, ID: id, Topic: topic})
+		if f := c.read(); f.Op != OpOK || f.ID != id {
+			c.t.Fatalf("unexpected frame while delivery is paused: %+v", f)
+		}
+		id++
+	}
+}
+
+func TestBrokerAck(t *testing.T) {
+	b := NewBroker(ms.Noop)
+	b.MaxInFlight = 1
+	c := serveRaw(t, b)
+
+	c.write(Frame{Op: OpSubscribe, ID: 1, Topic: "t"})
+	c.read()
+	b.Publish("t", json.RawMessage("1"), false)
+	b.Publish("t", json.RawMessage("2"), false)
+	first := c.read()
+	if string(first.Payload) != "1" {
+		t.Fatalf("unexpected message: %+v", first)
+	}
+
+	// Acks of unknown messages do not resume the delivery.
+	c.write(Frame{Op: OpAck, ID: first.ID + 1})
+	c.write(Frame{Op: OpAck, ID: 100})
+	c.expectPaused(2)
+
+	c.write(Frame{Op: OpAck, ID: first.ID})
+	if f := c.read(); string(f.Payload) != "2" {
+		t.Fatalf("unexpected message: %+v", f)
+	}
+
+	// Repeated ack is ignored as well.
+	b.Publish("t", json.RawMessage("3"), false)
+	c.write(Frame{Op: OpAck, ID: first.ID})
+	c.expectPaused(4)
+}
+
+func TestBrokerOverflow(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		policy OverflowPolicy
+		exp    []string
+	}{
+		{"drop oldest", DropOldest, []string{"3", "4"}},
+		{"drop newest", DropNewest, []string{"2", "3"}},
+		{"disconnect", Disconnect, nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			b := NewBroker(ms.Noop)
+			b.QueueSize = 2
+			b.MaxInFlight = 1
+			b.Overflow = test.policy
+			c := serveRaw(t, b)
+
+			c.write(Frame{Op: OpSubscribe, ID: 1, Topic: "t"})
+			c.read()
+
+			// The first message is in flight until it is acknowledged, so
+			// next ones are queued.
+			b.Publish("t", json.RawMessage("1"), false)
+			f := c.read()
+			if string(f.Payload) != "1" {
+				t.Fatalf("unexpected message: %+v", f)
+			}
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for _, p := range []string{"2", "3", "4"} {
+					b.Publish("t", json.RawMessage(p), false)
+				}
+			}()
+
+			if test.exp == nil {
+				// Publishers are not blocked by the subscriber which does
+				// not read the close frame.
+				select {
+				case <-done:
+				case <-time.After(time.Second):
+					t.Fatal("publish is blocked by the overflowed subscriber")
+				}
+				h, p := c.ReadFrame()
+				if code, _ := ms.ParseCloseFrameData(p); h.OpCode != ms.OpClose || code != ms.StatusPolicyViolation {
+					t.Fatalf("unexpected frame: %v %v", h.OpCode, code)
+				}
+				return
+			}
+			<-done
+
+			for _, exp := range test.exp {
+				c.write(Frame{Op: OpAck, ID: f.ID})
+				if f = c.read(); string(f.Payload) != exp {
+					t.Fatalf("unexpected message: %s; want %s", f.Payload, exp)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,288 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package pubsub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+
+	ms "github.com/cmacro/mogusocket"
+	"github.com/cmacro/mogusocket/msutil"
+)
+
+// Handler handles messages delivered to a subscription.
+type Handler func(m *Message)
+
+// Client is the pub/sub client. It is connected with msutil.AutoConnectClient
+// and subscribes again to all its topics after each reconnect.
+//
+// Handlers are called sequentially from a separate goroutine, so they could
+// publish and subscribe. Messages are acknowledged after the handler returns.
+type Client struct {
+	log  ms.Logger
+	auto *msutil.AutoConnectClient
+
+	// OnConnect, if set, is called after the client is connected and its
+	// subscriptions are requested again.
+	OnConnect func()
+
+	mu     sync.Mutex
+	subs   map[string]Handler
+	conn   *clientConn
+	nextID uint64
+}
+
+// NewClient returns Client of the broker at addr.
+func NewClient(addr string, log ms.Logger) *Client {
+	c := &Client{
+		log:  log,
+		subs: make(map[string]Handler),
+	}
+	c.auto = msutil.NewAutoConnectClient(c, addr, log)
+	return c
+}
+
+// AutoConnectClient returns the client which connects c. It could be used to
+// set its options before Run() is called.
+func (c *Client) AutoConnectClient() *msutil.AutoConnectClient {
+	return c.auto
+}
+
+// Run connects the client and reconnects it until ctx is done. The cancel is
+// called when the client gives up reconnecting.
+func (c *Client) Run(ctx context.Context, cancel context.CancelFunc) {
+	c.auto.Run(ctx, cancel)
+}
+
+// Subscribe makes h to handle messages of topics matching filter. If the
+// client is connected it waits for the broker confirmation, otherwise the
+// subscription is requested once the client is connected.
+func (c *Client) Subscribe(ctx context.Context, filter string, h Handler) error {
+	if err := ValidFilter(filter); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.subs[filter] = h
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil {
+		return nil
+	}
+	return c.request(ctx, conn, &Frame{Op: OpSubscribe, Topic: filter})
+}
+
+// Unsubscribe removes the subscription of filter.
+func (c *Client) Unsubscribe(ctx context.Context, filter string) error {
+	c.mu.Lock()
+	delete(c.subs, filter)
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil {
+		return nil
+	}
+	return c.request(ctx, conn, &Frame{Op: OpUnsubscribe, Topic: filter})
+}
+
+// Publish publishes payload encoded with encoding/json to topic and waits for
+// the broker confirmation. See Broker.Publish() for retained messages.
+func (c *Client) Publish(ctx context.Context, topic string, payload interface{}, retain bool) error {
+	if err := ValidTopic(topic); err != nil {
+		return err
+	}
+	p, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil {
+		return ErrNotConnected
+	}
+	return c.request(ctx, conn, &Frame{Op: OpPublish, Topic: topic, Payload: p, Retain: retain})
+}
+
+func (c *Client) request(ctx context.Context, conn *clientConn, f *Frame) error {
+	reply := make(chan *Frame, 1)
+	c.mu.Lock()
+	c.nextID++
+	f.ID = c.nextID
+	conn.pending[f.ID] = reply
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(conn.pending, f.ID)
+		c.mu.Unlock()
+	}()
+
+	if err := conn.write(f); err != nil {
+		return err
+	}
+	select {
+	case r := <-reply:
+		if r.Op == OpError {
+			return errors.New("pubsub: " + r.Error)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-conn.ctx.Done():
+		return ErrClosed
+	}
+}
+
+// Connect implements ms.ClientHandler.
+func (c *Client) Connect(ctx context.Context, w ms.SendFunc, cancel func()) error {
+	conn := &clientConn{
+		ctx:     ctx,
+		send:    w,
+		pending: make(map[uint64]chan *Frame),
+		wake:    make(chan struct{}, 1),
+	}
+	c.mu.Lock()
+	c.conn = conn
+	filters := make([]string, 0, len(c.subs))
+	for f := range c.subs {
+		filters = append(filters, f)
+	}
+	c.mu.Unlock()
+
+	go c.dispatch(conn)
+	go func() {
+		// Replies are read by ReadPump(), which is not called until
+		// Connect() returns, so they are not waited for.
+		for _, f := range filters {
+			if err := conn.write(&Frame{Op: OpSubscribe, Topic: f}); err != nil {
+				c.log.Info("resubscribe", f, err)
+				return
+			}
+		}
+		if c.OnConnect != nil {
+			c.OnConnect()
+		}
+	}()
+	return nil
+}
+
+// ReadPump implements ms.ClientHandler.
+func (c *Client) ReadPump(r io.Reader, len int64, isText bool) error {
+	var f Frame
+	if err := msutil.ReadJSON(r, &f); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	conn := c.conn
+	var reply chan *Frame
+	if conn != nil {
+		reply = conn.pending[f.ID]
+	}
+	c.mu.Unlock()
+	if conn == nil {
+		return ErrNotConnected
+	}
+
+	switch f.Op {
+	case OpOK, OpError:
+		if reply != nil {
+			reply <- &f
+		} else if f.Op == OpError {
+			c.log.Info("pubsub error", f.Error)
+		}
+	case OpMessage:
+		conn.push(&f)
+	}
+	return nil
+}
+
+// Close implements ms.ClientHandler.
+func (c *Client) Close() {
+	c.mu.Lock()
+	c.conn = nil
+	c.mu.Unlock()
+}
+
+// dispatch calls handlers of delivered messages until the connection is
+// closed.
+func (c *Client) dispatch(conn *clientConn) {
+	for {
+		f, ok := conn.pop()
+		if !ok {
+			return
+		}
+		m := &Message{Topic: f.Topic, Payload: f.Payload, Retained: f.Retain}
+
+		var hs []Handler
+		c.mu.Lock()
+		for filter, h := range c.subs {
+			if Match(filter, f.Topic) {
+				hs = append(hs, h)
+			}
+		}
+		c.mu.Unlock()
+		for _, h := range hs {
+			h(m)
+		}
+
+		if err := conn.write(&Frame{Op: OpAck, ID: f.ID}); err != nil {
+			c.log.Info("ack", err)
+		}
+	}
+}
+
+// clientConn is a single connection of Client.
+type clientConn struct {
+	ctx     context.Context
+	send    ms.SendFunc
+	pending map[uint64]chan *Frame
+
+	mu    sync.Mutex
+	queue []*Frame
+	wake  chan struct{}
+}
+
+func (c *clientConn) write(f *Frame) error {
+	p, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return c.send(bytes.NewReader(p), true)
+}
+
+// push queues the delivered message. The queue is not bounded, so reading
+// is never blocked by handlers; the broker limits it with MaxInFlight.
+func (c *clientConn) push(f *Frame) {
+	c.mu.Lock()
+	c.queue = append(c.queue, f)
+	c.mu.Unlock()
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// pop waits for the next delivered message. It reports false when the
+// connection is closed.
+func (c *clientConn) pop() (*Frame, bool) {
+	for {
+		c.mu.Lock()
+		if len(c.queue) > 0 {
+			f := c.queue[0]
+			c.queue[0] = nil
+			c.queue = c.queue[1:]
+			c.mu.Unlock()
+			return f, true
+		}
+		c.mu.Unlock()
+		select {
+		case <-c.wake:
+		case <-c.ctx.Done():
+			return nil, false
+		}
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package pubsub
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ms "github.com/cmacro/mogusocket"
+	"github.com/cmacro/mogusocket/msutil"
+)
+
+func TestClientResubscribe(t *testing.T) {
+	b := NewBroker(ms.Noop)
+	addr := "unix://" + filepath.Join(t.TempDir(), "bus.sock")
+	srv := ms.NewServer(addr, msutil.NewConnecter(b, ms.Noop), ms.Noop)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		srv.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	c := NewClient(addr, ms.Noop)
+	connected := make(chan struct{}, 1)
+	c.OnConnect = func() { connected <- struct{}{} }
+	msgs := make(chan *Message, 4)
+	if err := c.Subscribe(ctx, "a/#", func(m *Message) { msgs <- m }); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Publish(ctx, "a/b", 1, false); err != ErrNotConnected {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cctx, ccancel := context.WithCancel(ctx)
+	defer ccancel()
+	c.Run(cctx, ccancel)
+
+	expect := func(topic string) {
+		t.Helper()
+		select {
+		case <-connected:
+		case <-time.After(time.Second):
+			t.Fatal("client is not connected")
+		}
+		if err := c.Publish(ctx, topic, 1, false); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case m := <-msgs:
+			if m.Topic != topic || string(m.Payload) != "1" {
+				t.Fatalf("unexpected message: %+v", m)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("message is not delivered")
+		}
+	}
+	expect("a/b")
+
+	// Drop the connection on the broker side. The client reconnects and
+	// subscribes again.
+	b.mu.Lock()
+	for _, s := range b.subs {
+		msutil.CloseSession(s.ctx, ms.StatusGoingAway, "restart")
+	}
+	b.mu.Unlock()
+	expect("a/c")
+}
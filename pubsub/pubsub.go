@@ -0,0 +1,122 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+// Package pubsub implements a topic based publish/subscribe broker served
+// over mogusocket connections and the matching client.
+//
+// Topics are hierarchical, levels are separated by '/'. Subscription filters
+// could contain wildcards: '*' matches a single level and '#', allowed only
+// as the last level, matches any number of remaining levels including none.
+// So "sensors/*/temp" matches "sensors/kitchen/temp" and "sensors/#" matches
+// both "sensors" and "sensors/kitchen/temp".
+//
+// Each message of the wire protocol is a JSON object sent as a text frame:
+//
+//	{"op":"subscribe","id":1,"topic":"sensors/#"}
+//	{"op":"unsubscribe","id":2,"topic":"sensors/#"}
+//	{"op":"publish","id":3,"topic":"sensors/kitchen/temp","payload":21.5,"retain":true}
+//	{"op":"ack","id":7}
+//
+// The broker answers each request with {"op":"ok","id":N} or
+// {"op":"error","id":N,"error":"..."} and delivers messages as
+// {"op":"message","id":7,"topic":"...","payload":...}. Delivered messages are
+// acknowledged by their id.
+package pubsub
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// Operations of the wire protocol.
+const (
+	OpSubscribe   = "subscribe"
+	OpUnsubscribe = "unsubscribe"
+	OpPublish     = "publish"
+	OpAck         = "ack"
+	OpOK          = "ok"
+	OpError       = "error"
+	OpMessage     = "message"
+)
+
+var (
+	ErrInvalidTopic  = errors.New("pubsub: invalid topic")
+	ErrInvalidFilter = errors.New("pubsub: invalid topic filter")
+	ErrNotConnected  = errors.New("pubsub: not connected")
+	ErrClosed        = errors.New("pubsub: connection closed")
+)
+
+// Frame is a message of the wire protocol.
+type Frame struct {
+	Op      string          `json:"op"`
+	ID      uint64          `json:"id,omitempty"`
+	Topic   string          `json:"topic,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+
+	// Retain asks the broker to keep the published message for future
+	// subscribers. In delivered messages it marks the retained ones.
+	Retain bool   `json:"retain,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Message is a published message.
+type Message struct {
+	Topic   string
+	Payload json.RawMessage
+
+	// Retained reports whether the message was kept by the broker and is
+	// delivered because of a new subscription.
+	Retained bool
+}
+
+// ValidTopic checks the topic name messages are published to. It must be
+// non-empty and must not contain wildcards.
+func ValidTopic(topic string) error {
+	if topic == "" || strings.ContainsAny(topic, "*#") {
+		return ErrInvalidTopic
+	}
+	return nil
+}
+
+// ValidFilter checks the subscription filter.
+func ValidFilter(filter string) error {
+	if filter == "" {
+		return ErrInvalidFilter
+	}
+	levels := strings.Split(filter, "/")
+	for i, l := range levels {
+		switch {
+		case l == "#" && i != len(levels)-1:
+			return ErrInvalidFilter
+		case l != "*" && l != "#" && strings.ContainsAny(l, "*#"):
+			return ErrInvalidFilter
+		}
+	}
+	return nil
+}
+
+// Match reports whether topic matches the subscription filter.
+func Match(filter, topic string) bool {
+	for {
+		f, frest, fmore := strings.Cut(filter, "/")
+		if f == "#" {
+			return true
+		}
+		t, trest, tmore := strings.Cut(topic, "/")
+		if f != "*" && f != t {
+			return false
+		}
+		switch {
+		case !fmore && !tmore:
+			return true
+		case !tmore:
+			// "a/#" matches "a" as well.
+			return frest == "#"
+		case !fmore:
+			return false
+		}
+		filter, topic = frest, trest
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package pubsub
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	for _, test := range []struct {
+		filter string
+		topic  string
+		exp    bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/b", "a/c", false},
+		{"a/b", "a/b/c", false},
+		{"a/b/c", "a/b", false},
+		{"a/*", "a/b", true},
+		{"a/*", "a", false},
+		{"a/*", "a/b/c", false},
+		{"*/b", "a/b", true},
+		{"a/*/c", "a/b/c", true},
+		{"a/*/c", "a/b/d", false},
+		{"#", "a", true},
+		{"#", "a/b/c", true},
+		{"a/#", "a", true},
+		{"a/#", "a/b/c", true},
+		{"a/#", "b/c", false},
+		{"a/*/#", "a/b", true},
+		{"a/*/#", "a", false},
+		{"a//b", "a//b", true},
+	} {
+		if act := Match(test.filter, test.topic); act != test.exp {
+			t.Errorf("Match(%q, %q) = %v; want %v", test.filter, test.topic, act, test.exp)
+		}
+	}
+}
+
+func TestValid(t *testing.T) {
+	for _, f := range []string{"a", "a/b", "*", "#", "a/*/b", "a/#", "*/#"} {
+		if err := ValidFilter(f); err != nil {
+			t.Errorf("ValidFilter(%q) = %v", f, err)
+		}
+	}
+	for _, f := range []string{"", "#/a", "a#", "a/b*", "a/#/#"} {
+		if err := ValidFilter(f); err == nil {
+			t.Errorf("ValidFilter(%q) = nil", f)
+		}
+	}
+	for _, topic := range []string{"", "a/*", "#", "a/b#"} {
+		if err := ValidTopic(topic); err == nil {
+			t.Errorf("ValidTopic(%q) = nil", topic)
+		}
+	}
+	if err := ValidTopic("a/b"); err != nil {
+		t.Errorf("ValidTopic() = %v", err)
+	}
+}
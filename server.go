@@ -10,6 +10,8 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"sync"
+	"time"
 )
 
 func NewServer(addr string, connhandler ConnectHandler, log Logger) *Server {
@@ -20,10 +22,73 @@ func NewServer(addr string, connhandler ConnectHandler, log Logger) *Server {
 	}
 }
 
+// NewPollServer creates a Server that runs in the event loop mode. Instead of
+// holding a goroutine per connection, it registers accepted connections in a
+// Poller and calls handler's session HandleRead() from a worker pool only
+// when a connection becomes readable.
+//
+// Event loop mode is available only on platforms where NewPoller() is
+// supported.
+func NewPollServer(addr string, handler PollHandler, log Logger) *Server {
+	return &Server{
+		addr:        addr,
+		Logger:      log,
+		pollHandler: handler,
+	}
+}
+
 type Server struct {
 	Logger
 	addr        string
 	connHandler ConnectHandler
+
+	// Workers limits the number of goroutines reading from connections in the
+	// event loop mode. If zero, DefaultPollWorkers is used. Readable
+	// connections wait in the queue while all workers are busy.
+	Workers int
+
+	// BufferPool is the pool of read and write buffers shared by connection
+	// handlers. It is passed to handlers through the Run() context, see
+	// BufferPoolFromContext(). If nil, DefaultBufferPool is used.
+	BufferPool *BufferPool
+
+	// ProxyProtocol, if set, makes the server read the PROXY protocol header
+	// of connections accepted from its trusted sources. The client address
+	// of the header is returned by connection's RemoteAddr() and the header
+	// is available from the handshake, see Handshake.Proxy.
+	ProxyProtocol *ProxyProtocol
+
+	// UnixSocket contains options of the socket file used when the server
+	// listens on the unix socket.
+	UnixSocket *UnixSocket
+
+	// TCP contains options of the listening socket and accepted connections
+	// when the server listens on the TCP address. If it is nil, defaults of
+	// the net package are kept. See DefaultServerTCPOptions.
+	TCP *TCPOptions
+
+	pollHandler PollHandler
+	poller      Poller
+	workers     *WorkerPool
+	mu          sync.Mutex
+	pollConns   map[*pollConn]struct{}
+
+	// listener is served by Run. The lock is the lock file of the unix
+	// socket. The activated reports whether the listener is owned by the
+	// service manager and handedOff whether it is passed to the restarted
+	// process. In both cases the socket file is not removed.
+	listener  net.Listener
+	lock      *os.File
+	activated bool
+	handedOff bool
+	closeOnce sync.Once
+	conns     map[net.Conn]struct{}
+
+	initOnce     sync.Once
+	shutdownOnce sync.Once
+	drainedOnce  sync.Once
+	shutdown     chan struct{}
+	drained      chan struct{}
 }
 
 type Addr struct {
@@ -41,13 +106,17 @@ func ParserAddr(a string) (*Addr, error) {
 		return nil, err
 	}
 	if u.Scheme == "unix" {
+		// Names of abstract sockets are parsed as the empty user info.
+		if name, ok := strings.CutPrefix(a, "unix://@"); ok {
+			return &Addr{Network: u.Scheme, Address: "@" + name}, nil
+		}
 		return &Addr{Network: u.Scheme, Address: u.Path}, nil
 	}
 	return &Addr{Network: u.Scheme, Address: u.Host}, nil
 }
 
 func clearEnvConnect(scheme, path string) error {
-	if scheme == "unix" {
+	if scheme == "unix" && !isAbstractSocket(path) {
 		err := os.Remove(path)
 		if err != nil && !os.IsNotExist(err) {
 			return err
@@ -57,35 +126,217 @@ func clearEnvConnect(scheme, path string) error {
 }
 
 func (s *Server) Run(ctx context.Context) {
+	if s.BufferPool != nil {
+		ctx = WithBufferPool(ctx, s.BufferPool)
+	}
 	u, err := ParserAddr(s.addr)
 	if err != nil {
 		s.Error("failed addr parser ", s.addr, err)
 		return
 	}
-	if err := clearEnvConnect(u.Data()); err != nil {
-		s.Error("Error removing socket file", err)
-		return
+	if s.pollHandler != nil {
+		if err := s.startPoll(); err != nil {
+			s.Error("failed start poller", err)
+			return
+		}
+		defer s.stopPoll()
 	}
 
-	listener, err := net.Listen(u.Data())
+	listener, err := s.listen(u)
 	if err != nil {
 		s.Error("failed net listen ", s.addr, err)
 		return
 	}
 	s.Info("listening :", s.addr)
 	defer func() {
-		err := listener.Close()
-		if err != nil {
-			s.Error("listener closed", err)
+		s.stopAccept()
+		s.mu.Lock()
+		remove := !s.activated && !s.handedOff
+		lock := s.lock
+		s.listener, s.lock = nil, nil
+		s.mu.Unlock()
+		if remove {
+			_ = clearEnvConnect(u.Network, u.Address)
+		}
+		if lock != nil {
+			lock.Close()
 		}
-		_ = clearEnvConnect(u.Network, u.Address)
 	}()
+
+	ctx, cancel := context.WithCancel(withShutdown(ctx, s.shutdownCh()))
+	defer cancel()
 	go s.handleAccept(ctx, listener)
 
-	<-ctx.Done()
+	select {
+	case <-ctx.Done():
+	case <-s.drainedCh():
+	}
 	s.Info("Server closed", s.addr)
 }
 
+// listen returns the listener of the server address. The listener inherited
+// from the parent process or the service manager is preferred.
+func (s *Server) listen(u *Addr) (net.Listener, error) {
+	ln, lock, activated, err := inheritedListener(s.addr, u)
+	if err != nil {
+		s.Warn("inherited listener", err)
+	}
+	if ln != nil {
+		s.Info("inherited listener", s.addr, activated)
+	} else {
+		if lock != nil {
+			// The lock is taken again by the new socket file.
+			lock.Close()
+			lock = nil
+		}
+		if u.Network == "unix" {
+			lock, err = s.UnixSocket.lock(u.Address)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if ln, err = s.listenAddr(u); err != nil {
+			if lock != nil {
+				lock.Close()
+			}
+			return nil, err
+		}
+	}
+	if ul, ok := ln.(*net.UnixListener); ok {
+		// The socket file is removed by Run unless it is handed off.
+		ul.SetUnlinkOnClose(false)
+	}
+	s.mu.Lock()
+	s.listener, s.lock, s.activated = ln, lock, activated
+	s.mu.Unlock()
+	return ln, nil
+}
+
+// listenAddr listens on the address u replacing the socket file left by the
+// previous run.
+func (s *Server) listenAddr(u *Addr) (net.Listener, error) {
+	if err := clearEnvConnect(u.Data()); err != nil {
+		return nil, err
+	}
+	if u.Network == "unix" {
+		return s.UnixSocket.listen(u.Address, func(path string) (net.Listener, error) {
+			return net.Listen("unix", path)
+		})
+	}
+	return s.TCP.listen(u.Data())
+}
+
+// Shutdown gracefully shuts the server down. It stops accepting connections
+// and notifies connection handlers, so sessions are closed by the close
+// handshake with StatusGoingAway, see ShutdownFromContext. Then it waits for
+// connections to be closed and makes Run return. If ctx is done before,
+// remaining connections are closed and ctx error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.stopAccept()
+	shutdown := s.shutdownCh()
+	s.shutdownOnce.Do(func() { close(shutdown) })
+
+	var err error
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for s.activeConns() != 0 && err == nil {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			s.closeConns()
+		case <-ticker.C:
+		}
+	}
+	drained := s.drainedCh()
+	s.drainedOnce.Do(func() { close(drained) })
+	return err
+}
+
+// shutdownPollInterval is the interval of checking for connections left
+// during Shutdown.
+const shutdownPollInterval = 10 * time.Millisecond
+
+// stopAccept closes the listener. The socket file is kept.
+func (s *Server) stopAccept() {
+	s.mu.Lock()
+	ln := s.listener
+	s.mu.Unlock()
+	if ln == nil {
+		return
+	}
+	s.closeOnce.Do(func() {
+		if err := ln.Close(); err != nil {
+			s.Error("listener closed", err)
+		}
+	})
+}
+
+func (s *Server) initShutdown() {
+	s.initOnce.Do(func() {
+		s.shutdown = make(chan struct{})
+		s.drained = make(chan struct{})
+	})
+}
+
+func (s *Server) shutdownCh() chan struct{} {
+	s.initShutdown()
+	return s.shutdown
+}
+
+func (s *Server) drainedCh() chan struct{} {
+	s.initShutdown()
+	return s.drained
+}
+
+// trackConn adds conn to the set of served connections or removes it.
+func (s *Server) trackConn(conn net.Conn, add bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if add {
+		if s.conns == nil {
+			s.conns = make(map[net.Conn]struct{})
+		}
+		s.conns[conn] = struct{}{}
+	} else {
+		delete(s.conns, conn)
+	}
+}
+
+func (s *Server) activeConns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// closeConns closes all served connections.
+func (s *Server) closeConns() {
+	s.mu.Lock()
+	conns := make([]net.Conn, 0, len(s.conns))
+	for conn := range s.conns {
+		conns = append(conns, conn)
+	}
+	s.mu.Unlock()
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+type shutdownKey struct{}
+
+// withShutdown returns a copy of ctx that carries the shutdown channel.
+func withShutdown(ctx context.Context, ch <-chan struct{}) context.Context {
+	return context.WithValue(ctx, shutdownKey{}, ch)
+}
+
+// ShutdownFromContext returns the channel which is closed when the server
+// which passed ctx to the connection handler is shut down gracefully.
+// Handlers must close their connections with the close handshake then. It
+// returns nil if ctx is not passed by Server.
+func ShutdownFromContext(ctx context.Context) <-chan struct{} {
+	ch, _ := ctx.Value(shutdownKey{}).(<-chan struct{})
+	return ch
+}
+
 func (s *Server) handleAccept(ctx context.Context, ln net.Listener) {
 	defer s.Info("listener closed.")
 	for {
@@ -98,22 +349,162 @@ func (s *Server) handleAccept(ctx context.Context, ln net.Listener) {
 			if err != nil {
 				if strings.Contains(err.Error(), "use of closed network connection") {
 					s.Info("Accept closed.")
-				} else {
-					s.Warn("handle accept failure", err)
+					return
 				}
+				s.Warn("handle accept failure", err)
 				continue
 			}
-			s.Info("conn open", conn.RemoteAddr().String())
-			go func() {
-				defer func() {
-					if err := conn.Close(); err != nil {
-						s.Error("conn close error.", err)
-					} else {
-						s.Info("conn close", conn.RemoteAddr().String())
-					}
-				}()
-				s.connHandler.Run(ctx, conn)
-			}()
+			s.trackConn(conn, true)
+			go s.handleConn(ctx, conn)
 		}
 	}
 }
+
+func (s *Server) handleConn(ctx context.Context, conn net.Conn) {
+	if err := s.TCP.apply(conn); err != nil {
+		s.Warn("tcp options", conn.RemoteAddr().String(), err)
+	}
+	if p := s.ProxyProtocol; p != nil {
+		pc, err := p.Accept(conn)
+		if err != nil {
+			s.Warn("proxy protocol", conn.RemoteAddr().String(), err)
+			if err := conn.Close(); err != nil {
+				s.Error("conn close error.", err)
+			}
+			s.trackConn(conn, false)
+			return
+		}
+		if pc != conn {
+			s.trackConn(pc, true)
+			s.trackConn(conn, false)
+			conn = pc
+		}
+	}
+	s.Info("conn open", conn.RemoteAddr().String())
+	if s.pollHandler != nil {
+		s.handlePoll(ctx, conn)
+		return
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			s.Error("conn close error.", err)
+		} else {
+			s.Info("conn close", conn.RemoteAddr().String())
+		}
+		s.trackConn(conn, false)
+	}()
+	s.connHandler.Run(ctx, conn)
+}
+
+// pollConn holds the state of a connection served in the event loop mode.
+type pollConn struct {
+	conn    net.Conn
+	session PollSession
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func (s *Server) startPoll() (err error) {
+	s.poller, err = NewPoller()
+	if err != nil {
+		return err
+	}
+	s.workers = NewWorkerPool(s.Workers)
+	s.pollConns = make(map[*pollConn]struct{})
+	return nil
+}
+
+func (s *Server) stopPoll() {
+	s.mu.Lock()
+	conns := make([]*pollConn, 0, len(s.pollConns))
+	for pc := range s.pollConns {
+		conns = append(conns, pc)
+	}
+	s.mu.Unlock()
+	for _, pc := range conns {
+		s.closePoll(pc)
+	}
+	if err := s.poller.Close(); err != nil {
+		s.Error("poller close", err)
+	}
+	s.workers.Close()
+}
+
+func (s *Server) handlePoll(ctx context.Context, conn net.Conn) {
+	pc := &pollConn{conn: conn}
+	// Hold the lock until the session is opened and registered. That is,
+	// done() called by the handler (which closes asynchronously) waits for
+	// registration to complete.
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	session, err := s.pollHandler.Open(ctx, conn, func() { go s.closePoll(pc) })
+	if err != nil {
+		s.Info("poll open refused", err)
+		pc.closed = true
+		if err := conn.Close(); err != nil {
+			s.Error("conn close error.", err)
+		}
+		s.trackConn(conn, false)
+		return
+	}
+	pc.session = session
+
+	s.mu.Lock()
+	s.pollConns[pc] = struct{}{}
+	s.mu.Unlock()
+
+	err = s.poller.Start(conn, func(ev PollEvent) {
+		if !ev.Readable() {
+			go s.closePoll(pc)
+			return
+		}
+		err := s.workers.Schedule(func() {
+			if err := pc.session.HandleRead(); err != nil {
+				s.closePoll(pc)
+				return
+			}
+			pc.mu.Lock()
+			defer pc.mu.Unlock()
+			if pc.closed {
+				return
+			}
+			if err := s.poller.Resume(pc.conn); err != nil {
+				s.Error("poller resume", err)
+				go s.closePoll(pc)
+			}
+		})
+		if err != nil {
+			go s.closePoll(pc)
+		}
+	})
+	if err != nil {
+		s.Error("poller start", err)
+		go s.closePoll(pc)
+	}
+}
+
+func (s *Server) closePoll(pc *pollConn) {
+	pc.mu.Lock()
+	if pc.closed {
+		pc.mu.Unlock()
+		return
+	}
+	pc.closed = true
+	// Stop watching before the descriptor is closed and could be reused.
+	_ = s.poller.Stop(pc.conn)
+	pc.mu.Unlock()
+
+	s.mu.Lock()
+	delete(s.pollConns, pc)
+	s.mu.Unlock()
+
+	pc.session.Close()
+	if err := pc.conn.Close(); err != nil {
+		s.Error("conn close error.", err)
+	} else {
+		s.Info("conn close", pc.conn.RemoteAddr().String())
+	}
+	s.trackConn(pc.conn, false)
+}
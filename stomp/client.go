@@ -0,0 +1,337 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package stomp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	ms "github.com/cmacro/mogusocket"
+	"github.com/cmacro/mogusocket/msutil"
+)
+
+// Error is the ERROR frame received from the server.
+type Error struct {
+	Frame *Frame
+}
+
+func (e *Error) Error() string {
+	msg := "stomp: " + e.Frame.Header.Get(HdrMessage)
+	if len(e.Frame.Body) > 0 {
+		msg += ": " + string(e.Frame.Body)
+	}
+	return msg
+}
+
+// MessageHandler handles MESSAGE frames of a subscription.
+type MessageHandler func(m *Frame)
+
+// Client is the STOMP client connected with msutil.Client. Handlers of
+// subscriptions are called sequentially from a separate goroutine, so they
+// could call other methods of the client.
+type Client struct {
+	log ms.Logger
+	mc  *msutil.Client
+
+	// Login and Passcode are sent in the CONNECT frame if set.
+	Login    string
+	Passcode string
+
+	// Host is the virtual host sent in the CONNECT frame. If it is empty,
+	// "/" is used.
+	Host string
+
+	// HeartBeat is the client heart-beat setting. Zero means no heart-beats.
+	HeartBeat HeartBeat
+
+	hb        heartBeater
+	ready     chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu       sync.Mutex
+	send     ms.SendFunc
+	ctx      context.Context
+	cancel   func()
+	err      error
+	nextID   uint64
+	receipts map[string]chan struct{}
+	subs     map[string]MessageHandler
+	queue    []*Frame
+	wake     chan struct{}
+}
+
+// NewClient returns Client of the server at addr.
+func NewClient(addr string, log ms.Logger) *Client {
+	c := &Client{
+		log:      log,
+		ready:    make(chan struct{}),
+		closed:   make(chan struct{}),
+		receipts: make(map[string]chan struct{}),
+		subs:     make(map[string]MessageHandler),
+		wake:     make(chan struct{}, 1),
+	}
+	c.mc = msutil.NewClient(c, addr, log)
+	return c
+}
+
+// Run connects the client and serves the connection until it is closed or
+// ctx is done. The client could not be run again.
+func (c *Client) Run(ctx context.Context) {
+	c.mc.Run(ctx)
+	// The client is not closed by msutil.Client if it could not connect.
+	c.Close()
+}
+
+// Ready waits until the server accepts the connection. The error is *Error
+// if the server has rejected it.
+func (c *Client) Ready(ctx context.Context) error {
+	select {
+	case <-c.ready:
+		return nil
+	case <-c.closed:
+		return c.closeErr()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Send sends body to the destination and waits for the server receipt.
+// Header pairs are added to the SEND frame.
+func (c *Client) Send(ctx context.Context, destination string, body []byte, kv ...string) error {
+	f := NewFrame(CmdSend, HdrDestination, destination)
+	for i := 0; i+1 < len(kv); i += 2 {
+		f.Header.Add(kv[i], kv[i+1])
+	}
+	f.Body = body
+	return c.request(ctx, f)
+}
+
+// Subscription is the active subscription of the client.
+type Subscription struct {
+	c  *Client
+	ID string
+}
+
+// Subscribe subscribes h to the destination and waits for the server
+// receipt. Messages of subscriptions with ack mode other than AckAuto must
+// be acknowledged with Ack() or Nack().
+func (c *Client) Subscribe(ctx context.Context, destination string, ack AckMode, h MessageHandler) (*Subscription, error) {
+	c.mu.Lock()
+	c.nextID++
+	id := strconv.FormatUint(c.nextID, 10)
+	c.subs[id] = h
+	c.mu.Unlock()
+
+	f := NewFrame(CmdSubscribe, HdrID, id, HdrDestination, destination, HdrAck, string(ack))
+	if err := c.request(ctx, f); err != nil {
+		c.mu.Lock()
+		delete(c.subs, id)
+		c.mu.Unlock()
+		return nil, err
+	}
+	return &Subscription{c: c, ID: id}, nil
+}
+
+// Unsubscribe removes the subscription and waits for the server receipt.
+func (s *Subscription) Unsubscribe(ctx context.Context) error {
+	s.c.mu.Lock()
+	delete(s.c.subs, s.ID)
+	s.c.mu.Unlock()
+	return s.c.request(ctx, NewFrame(CmdUnsubscribe, HdrID, s.ID))
+}
+
+// Ack acknowledges the message.
+func (c *Client) Ack(ctx context.Context, m *Frame) error {
+	return c.request(ctx, NewFrame(CmdAck, HdrID, m.Header.Get(HdrAck)))
+}
+
+// Nack rejects the message.
+func (c *Client) Nack(ctx context.Context, m *Frame) error {
+	return c.request(ctx, NewFrame(CmdNack, HdrID, m.Header.Get(HdrAck)))
+}
+
+// Disconnect gracefully disconnects the client. It waits for the server
+// receipt, so all previous frames are handled by the server.
+func (c *Client) Disconnect(ctx context.Context) error {
+	err := c.request(ctx, NewFrame(CmdDisconnect))
+	c.mu.Lock()
+	cancel := c.cancel
+	c.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	return err
+}
+
+// request sends the frame with receipt header and waits for the receipt.
+func (c *Client) request(ctx context.Context, f *Frame) error {
+	if err := c.Ready(ctx); err != nil {
+		return err
+	}
+	receipt := make(chan struct{})
+	c.mu.Lock()
+	c.nextID++
+	id := strconv.FormatUint(c.nextID, 10)
+	c.receipts[id] = receipt
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.receipts, id)
+		c.mu.Unlock()
+	}()
+
+	f.Header.Add(HdrReceipt, id)
+	if err := c.write(f); err != nil {
+		return err
+	}
+	select {
+	case <-receipt:
+		return nil
+	case <-c.closed:
+		return c.closeErr()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *Client) closeErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	return msutil.ErrClientClosed
+}
+
+// Connect implements ms.ClientHandler. It sends the CONNECT frame.
+func (c *Client) Connect(ctx context.Context, w ms.SendFunc, cancel func()) error {
+	c.mu.Lock()
+	c.ctx, c.send, c.cancel = ctx, w, cancel
+	c.mu.Unlock()
+
+	host := c.Host
+	if host == "" {
+		host = "/"
+	}
+	f := NewFrame(CmdConnect,
+		HdrAcceptVersion, "1.2",
+		HdrHost, host,
+		HdrHeartBeat, c.HeartBeat.String(),
+	)
+	if c.Login != "" {
+		f.Header.Add(HdrLogin, c.Login)
+		f.Header.Add(HdrPasscode, c.Passcode)
+	}
+	go c.dispatch(ctx)
+	return c.write(f)
+}
+
+// ReadPump implements ms.ClientHandler.
+func (c *Client) ReadPump(r io.Reader, len int64, isText bool) error {
+	c.hb.received()
+	dec := NewDecoder(r)
+	for {
+		f, err := dec.Decode()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return msutil.CloseWithError{Code: ms.StatusProtocolError, Reason: "malformed frame", Err: err}
+		}
+		if err := c.handle(f); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Client) handle(f *Frame) error {
+	switch f.Command {
+	case CmdConnected:
+		select {
+		case <-c.ready:
+			return msutil.CloseWithError{Code: ms.StatusProtocolError, Reason: "unexpected CONNECTED frame"}
+		default:
+		}
+		hb, err := ParseHeartBeat(f.Header.Get(HdrHeartBeat))
+		if err != nil {
+			return msutil.CloseWithError{Code: ms.StatusProtocolError, Reason: "invalid heart-beat header"}
+		}
+		send, recv := negotiate(c.HeartBeat, hb)
+		go c.hb.run(c.ctx, send, recv, func() error {
+			return c.send(strings.NewReader("\n"), true)
+		}, func() {
+			c.log.Info("stomp heart-beat timeout")
+			msutil.CloseSession(c.ctx, ms.StatusGoingAway, "heart-beat timeout")
+		})
+		close(c.ready)
+
+	case CmdReceipt:
+		c.mu.Lock()
+		receipt, ok := c.receipts[f.Header.Get(HdrReceiptID)]
+		delete(c.receipts, f.Header.Get(HdrReceiptID))
+		c.mu.Unlock()
+		if ok {
+			close(receipt)
+		}
+
+	case CmdMessage:
+		c.mu.Lock()
+		c.queue = append(c.queue, f)
+		c.mu.Unlock()
+		select {
+		case c.wake <- struct{}{}:
+		default:
+		}
+
+	case CmdError:
+		err := &Error{Frame: f}
+		c.log.Info("stomp error", err)
+		c.mu.Lock()
+		c.err = err
+		c.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Close implements ms.ClientHandler.
+func (c *Client) Close() {
+	c.closeOnce.Do(func() { close(c.closed) })
+}
+
+// dispatch calls handlers of received messages until ctx is done.
+func (c *Client) dispatch(ctx context.Context) {
+	for {
+		c.mu.Lock()
+		if len(c.queue) == 0 {
+			c.mu.Unlock()
+			select {
+			case <-c.wake:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		m := c.queue[0]
+		c.queue[0] = nil
+		c.queue = c.queue[1:]
+		h := c.subs[m.Header.Get(HdrSubscription)]
+		c.mu.Unlock()
+		if h != nil {
+			h(m)
+		}
+	}
+}
+
+func (c *Client) write(f *Frame) error {
+	p := f.Bytes()
+	return c.send(bytes.NewReader(p), utf8.Valid(p))
+}
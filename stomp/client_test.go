@@ -0,0 +1,141 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package stomp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ms "github.com/cmacro/mogusocket"
+	"github.com/cmacro/mogusocket/msutil"
+)
+
+// runServer runs s on a unix socket and returns its address.
+func runServer(t *testing.T, s *Server) string {
+	t.Helper()
+	addr := "unix://" + filepath.Join(t.TempDir(), "stomp.sock")
+	srv := ms.NewServer(addr, msutil.NewConnecter(s, ms.Noop), ms.Noop)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		srv.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	time.Sleep(50 * time.Millisecond)
+	return addr
+}
+
+func runClient(t *testing.T, c *Client) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestClient(t *testing.T) {
+	s := NewServer(ms.Noop)
+	s.HeartBeat = HeartBeat{Send: 10 * time.Millisecond, Receive: 10 * time.Millisecond}
+	addr := runServer(t, s)
+
+	c := NewClient(addr, ms.Noop)
+	c.HeartBeat = HeartBeat{Send: 10 * time.Millisecond, Receive: 10 * time.Millisecond}
+	runClient(t, c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := c.Ready(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := make(chan *Frame, 4)
+	sub, err := c.Subscribe(ctx, "/queue/a", AckClientIndividual, func(m *Frame) {
+		msgs <- m
+		if err := c.Ack(ctx, m); err != nil {
+			t.Error(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Send(ctx, "/queue/a", []byte("hello"), HdrContentType, "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case m := <-msgs:
+		if string(m.Body) != "hello" || m.Header.Get(HdrContentType) != "text/plain" {
+			t.Fatalf("unexpected message: %v %q", m.Header, m.Body)
+		}
+	case <-ctx.Done():
+		t.Fatal("message is not delivered")
+	}
+
+	// Heart-beats keep the idle connection alive.
+	time.Sleep(100 * time.Millisecond)
+
+	if err := sub.Unsubscribe(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Send(ctx, "/queue/a", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Disconnect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 0 {
+		t.Fatal("message is delivered after unsubscribe")
+	}
+}
+
+func TestClientRejected(t *testing.T) {
+	s := NewServer(ms.Noop)
+	s.Authenticate = func(login, passcode string) error {
+		return errors.New("bad credentials")
+	}
+	c := NewClient(runServer(t, s), ms.Noop)
+	c.Login = "user"
+	runClient(t, c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err := c.Ready(ctx)
+	var serr *Error
+	if !errors.As(err, &serr) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if msg := serr.Frame.Header.Get(HdrMessage); msg != "authentication failed" {
+		t.Fatalf("unexpected error message: %q", msg)
+	}
+}
+
+func TestClientConnectedTwice(t *testing.T) {
+	c := NewClient("", ms.Noop)
+	c.ctx = context.Background()
+	c.send = func(io.Reader, bool) error { return nil }
+
+	f := NewFrame(CmdConnected, HdrVersion, "1.2")
+	if err := c.handle(f); err != nil {
+		t.Fatal(err)
+	}
+	var cerr msutil.CloseWithError
+	if err := c.handle(f); !errors.As(err, &cerr) || cerr.Code != ms.StatusProtocolError {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
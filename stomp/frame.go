@@ -0,0 +1,341 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+// Package stomp implements STOMP 1.2 over mogusocket connections: the frame
+// codec, a server side SessionsHandler and a client.
+//
+// See https://stomp.github.io/stomp-specification-1.2.html
+package stomp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Protocol is the WebSocket subprotocol name of STOMP 1.2.
+const Protocol = "v12.stomp"
+
+// AcceptProtocol reports whether p is the STOMP 1.2 subprotocol. It could be
+// used as ms.Upgrader.Protocol.
+func AcceptProtocol(p []byte) bool {
+	return string(p) == Protocol
+}
+
+// Frame commands.
+const (
+	CmdConnect     = "CONNECT"
+	CmdStomp       = "STOMP"
+	CmdConnected   = "CONNECTED"
+	CmdSend        = "SEND"
+	CmdSubscribe   = "SUBSCRIBE"
+	CmdUnsubscribe = "UNSUBSCRIBE"
+	CmdAck         = "ACK"
+	CmdNack        = "NACK"
+	CmdBegin       = "BEGIN"
+	CmdCommit      = "COMMIT"
+	CmdAbort       = "ABORT"
+	CmdDisconnect  = "DISCONNECT"
+	CmdMessage     = "MESSAGE"
+	CmdReceipt     = "RECEIPT"
+	CmdError       = "ERROR"
+)
+
+// Frame headers.
+const (
+	HdrAcceptVersion = "accept-version"
+	HdrVersion       = "version"
+	HdrHost          = "host"
+	HdrLogin         = "login"
+	HdrPasscode      = "passcode"
+	HdrHeartBeat     = "heart-beat"
+	HdrSession       = "session"
+	HdrServer        = "server"
+	HdrDestination   = "destination"
+	HdrID            = "id"
+	HdrAck           = "ack"
+	HdrSubscription  = "subscription"
+	HdrMessageID     = "message-id"
+	HdrReceipt       = "receipt"
+	HdrReceiptID     = "receipt-id"
+	HdrMessage       = "message"
+	HdrContentType   = "content-type"
+	HdrContentLength = "content-length"
+	HdrTransaction   = "transaction"
+)
+
+var (
+	ErrInvalidFrame  = errors.New("stomp: invalid frame")
+	ErrInvalidHeader = errors.New("stomp: invalid header")
+	ErrFrameTooLarge = errors.New("stomp: frame is too large")
+)
+
+// HeaderField is a single frame header.
+type HeaderField struct {
+	Key, Value string
+}
+
+// Header is the list of frame headers in order of appearance. When a header
+// is repeated, only the first value is significant.
+type Header []HeaderField
+
+// Get returns the value of the first header with the key or an empty string.
+func (h Header) Get(key string) string {
+	v, _ := h.Lookup(key)
+	return v
+}
+
+// Lookup returns the value of the first header with the key.
+func (h Header) Lookup(key string) (string, bool) {
+	for _, f := range h {
+		if f.Key == key {
+			return f.Value, true
+		}
+	}
+	return "", false
+}
+
+// Add appends the header.
+func (h *Header) Add(key, value string) {
+	*h = append(*h, HeaderField{key, value})
+}
+
+// Set replaces all headers with the key by a single one.
+func (h *Header) Set(key, value string) {
+	h.Del(key)
+	h.Add(key, value)
+}
+
+// Del removes all headers with the key.
+func (h *Header) Del(key string) {
+	fs := (*h)[:0]
+	for _, f := range *h {
+		if f.Key != key {
+			fs = append(fs, f)
+		}
+	}
+	*h = fs
+}
+
+// Frame is the STOMP frame.
+type Frame struct {
+	Command string
+	Header  Header
+	Body    []byte
+}
+
+// NewFrame returns frame with the command and headers given as key-value
+// pairs.
+func NewFrame(command string, kv ...string) *Frame {
+	f := &Frame{Command: command}
+	for i := 0; i+1 < len(kv); i += 2 {
+		f.Header.Add(kv[i], kv[i+1])
+	}
+	return f
+}
+
+// escaped reports whether header of the command frames are escaped.
+func escaped(command string) bool {
+	return command != CmdConnect && command != CmdConnected
+}
+
+var (
+	escaper   = strings.NewReplacer("\\", "\\\\", "\r", "\\r", "\n", "\\n", ":", "\\c")
+	unescaper = strings.NewReplacer("\\\\", "\\", "\\r", "\r", "\\n", "\n", "\\c", ":")
+)
+
+// Bytes returns the encoded frame. The content-length header is added for a
+// non-empty body if it is not set.
+func (f *Frame) Bytes() []byte {
+	var buf bytes.Buffer
+	f.WriteTo(&buf)
+	return buf.Bytes()
+}
+
+// WriteTo writes the encoded frame to w.
+func (f *Frame) WriteTo(w io.Writer) (int64, error) {
+	var (
+		buf bytes.Buffer
+		esc = escaped(f.Command)
+	)
+	buf.WriteString(f.Command)
+	buf.WriteByte('\n')
+	for _, h := range f.Header {
+		if esc {
+			escaper.WriteString(&buf, h.Key)
+			buf.WriteByte(':')
+			escaper.WriteString(&buf, h.Value)
+		} else {
+			buf.WriteString(h.Key)
+			buf.WriteByte(':')
+			buf.WriteString(h.Value)
+		}
+		buf.WriteByte('\n')
+	}
+	if _, ok := f.Header.Lookup(HdrContentLength); !ok && len(f.Body) > 0 {
+		buf.WriteString(HdrContentLength + ":" + strconv.Itoa(len(f.Body)) + "\n")
+	}
+	buf.WriteByte('\n')
+	buf.Write(f.Body)
+	buf.WriteByte(0)
+	return buf.WriteTo(w)
+}
+
+// DefaultMaxFrameSize is the frame size limit used by Decoder when
+// MaxFrameSize is zero.
+const DefaultMaxFrameSize = 1 << 20
+
+// Decoder reads frames from the stream. Heart-beats, end of lines between
+// frames, are skipped.
+type Decoder struct {
+	r *bufio.Reader
+
+	// MaxFrameSize limits the size of headers and body of a frame.
+	MaxFrameSize int
+}
+
+// NewDecoder returns Decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &Decoder{r: br}
+}
+
+// Decode reads the next frame. It returns io.EOF if the stream ends between
+// frames.
+func (d *Decoder) Decode() (*Frame, error) {
+	limit := d.MaxFrameSize
+	if limit <= 0 {
+		limit = DefaultMaxFrameSize
+	}
+
+	var line string
+	for line == "" {
+		l, err := d.readLine(&limit)
+		if err != nil {
+			return nil, err
+		}
+		line = l
+	}
+	f := &Frame{Command: line}
+	esc := escaped(f.Command)
+	for {
+		l, err := d.readLine(&limit)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		if l == "" {
+			break
+		}
+		k, v, ok := strings.Cut(l, ":")
+		if !ok {
+			return nil, ErrInvalidHeader
+		}
+		if esc {
+			if k, ok = unescape(k); !ok {
+				return nil, ErrInvalidHeader
+			}
+			if v, ok = unescape(v); !ok {
+				return nil, ErrInvalidHeader
+			}
+		}
+		f.Header.Add(k, v)
+	}
+
+	if s, ok := f.Header.Lookup(HdrContentLength); ok {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return nil, ErrInvalidHeader
+		}
+		if n > limit {
+			return nil, ErrFrameTooLarge
+		}
+		f.Body = make([]byte, n)
+		if _, err := io.ReadFull(d.r, f.Body); err != nil {
+			return nil, unexpected(err)
+		}
+		if b, err := d.r.ReadByte(); err != nil {
+			return nil, unexpected(err)
+		} else if b != 0 {
+			return nil, ErrInvalidFrame
+		}
+		return f, nil
+	}
+
+	var body []byte
+	for {
+		b, err := d.r.ReadByte()
+		if err != nil {
+			return nil, unexpected(err)
+		}
+		if b == 0 {
+			break
+		}
+		if limit--; limit < 0 {
+			return nil, ErrFrameTooLarge
+		}
+		body = append(body, b)
+	}
+	f.Body = body
+	return f, nil
+}
+
+// readLine reads a line without the end of line, which is either "\n" or
+// "\r\n".
+func (d *Decoder) readLine(limit *int) (string, error) {
+	var line []byte
+	for {
+		b, err := d.r.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		if b == '\n' {
+			break
+		}
+		if *limit--; *limit < 0 {
+			return "", ErrFrameTooLarge
+		}
+		line = append(line, b)
+	}
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	return string(line), nil
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// unescape decodes header escape sequences. It reports false on undefined
+// sequences, which are fatal protocol errors.
+func unescape(s string) (string, bool) {
+	if !strings.Contains(s, "\\") {
+		return s, true
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			continue
+		}
+		if i+1 == len(s) || !strings.ContainsRune(`\rnc`, rune(s[i+1])) {
+			return "", false
+		}
+		i++
+	}
+	return unescaper.Replace(s), true
+}
@@ -0,0 +1,138 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package stomp
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFrameBytes(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		frame *Frame
+		exp   string
+	}{
+		{
+			name:  "escaped",
+			frame: &Frame{Command: CmdSend, Header: Header{{"a:b", "c\nd\\"}}, Body: []byte("x")},
+			exp:   "SEND\na\\cb:c\\nd\\\\\ncontent-length:1\n\nx\x00",
+		},
+		{
+			name:  "connect",
+			frame: NewFrame(CmdConnect, HdrLogin, "a:b"),
+			exp:   "CONNECT\nlogin:a:b\n\n\x00",
+		},
+		{
+			name:  "content length",
+			frame: &Frame{Command: CmdSend, Header: Header{{HdrContentLength, "2"}}, Body: []byte("ab")},
+			exp:   "SEND\ncontent-length:2\n\nab\x00",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if act := string(test.frame.Bytes()); act != test.exp {
+				t.Fatalf("unexpected bytes: %q; want %q", act, test.exp)
+			}
+			f, err := NewDecoder(strings.NewReader(test.exp)).Decode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(f.Header[:len(test.frame.Header)], test.frame.Header) ||
+				string(f.Body) != string(test.frame.Body) {
+				t.Fatalf("unexpected frame: %+v", f)
+			}
+		})
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	in := "\n\r\nMESSAGE\r\nk:v\r\nk:w\r\n\r\nhello\x00\n\n" +
+		"SEND\ncontent-length:3\n\na\x00b\x00\n" +
+		"SEND\n\n\x00"
+	dec := NewDecoder(strings.NewReader(in))
+
+	f, err := dec.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Command != CmdMessage || f.Header.Get("k") != "v" || string(f.Body) != "hello" {
+		t.Fatalf("unexpected frame: %+v", f)
+	}
+	if f, err = dec.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	if string(f.Body) != "a\x00b" {
+		t.Fatalf("unexpected body: %q", f.Body)
+	}
+	if f, err = dec.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	if f.Command != CmdSend || len(f.Body) != 0 {
+		t.Fatalf("unexpected frame: %+v", f)
+	}
+	if _, err = dec.Decode(); err != io.EOF {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestDecoderErrors(t *testing.T) {
+	for _, test := range []struct {
+		in  string
+		max int
+		err error
+	}{
+		{in: "SEND\nkey\n\n\x00", err: ErrInvalidHeader},
+		{in: "SEND\nk:a\\tb\n\n\x00", err: ErrInvalidHeader},
+		{in: "SEND\nk:a\\\n\n\x00", err: ErrInvalidHeader},
+		{in: "SEND\ncontent-length:x\n\n\x00", err: ErrInvalidHeader},
+		{in: "SEND\ncontent-length:1\n\nab\x00", err: ErrInvalidFrame},
+		{in: "SEND\nk:v\n", err: io.ErrUnexpectedEOF},
+		{in: "SEND\n\nbody", err: io.ErrUnexpectedEOF},
+		{in: "SEND", err: io.ErrUnexpectedEOF},
+		{in: "SEND\n\n0123456789\x00", max: 12, err: ErrFrameTooLarge},
+		{in: "SEND\ncontent-length:100\n\n", max: 50, err: ErrFrameTooLarge},
+	} {
+		dec := NewDecoder(strings.NewReader(test.in))
+		dec.MaxFrameSize = test.max
+		if _, err := dec.Decode(); err != test.err {
+			t.Errorf("%q: unexpected error: %v; want %v", test.in, err, test.err)
+		}
+	}
+}
+
+func TestHeartBeat(t *testing.T) {
+	hb, err := ParseHeartBeat("100,250")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hb != (HeartBeat{100 * time.Millisecond, 250 * time.Millisecond}) || hb.String() != "100,250" {
+		t.Fatalf("unexpected heart-beat: %+v", hb)
+	}
+	for _, s := range []string{"1", "a,1", "1,-1"} {
+		if _, err := ParseHeartBeat(s); err == nil {
+			t.Errorf("ParseHeartBeat(%q): no error", s)
+		}
+	}
+
+	for _, test := range []struct {
+		local, remote HeartBeat
+		send, recv    time.Duration
+	}{
+		{HeartBeat{}, HeartBeat{10, 10}, 0, 0},
+		{HeartBeat{10, 0}, HeartBeat{0, 20}, 20, 0},
+		{HeartBeat{30, 0}, HeartBeat{0, 20}, 30, 0},
+		{HeartBeat{0, 10}, HeartBeat{20, 0}, 0, 20},
+		{HeartBeat{10, 50}, HeartBeat{20, 5}, 10, 50},
+	} {
+		send, recv := negotiate(test.local, test.remote)
+		if send != test.send || recv != test.recv {
+			t.Errorf("negotiate(%v, %v) = %v, %v; want %v, %v",
+				test.local, test.remote, send, recv, test.send, test.recv)
+		}
+	}
+}
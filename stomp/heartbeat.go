@@ -0,0 +1,126 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package stomp
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// HeartBeat is the heart-beat setting of one side of the connection.
+type HeartBeat struct {
+	// Send is the smallest interval this side could send heart-beats at.
+	// Zero means it could not send them.
+	Send time.Duration
+
+	// Receive is the desired interval of heart-beats from the peer. Zero
+	// means they are not wanted.
+	Receive time.Duration
+}
+
+// String returns the heart-beat header value.
+func (h HeartBeat) String() string {
+	return strconv.FormatInt(h.Send.Milliseconds(), 10) + "," +
+		strconv.FormatInt(h.Receive.Milliseconds(), 10)
+}
+
+// ParseHeartBeat parses the heart-beat header value. An empty value means no
+// heart-beats.
+func ParseHeartBeat(s string) (HeartBeat, error) {
+	if s == "" {
+		return HeartBeat{}, nil
+	}
+	a, b, ok := strings.Cut(s, ",")
+	if !ok {
+		return HeartBeat{}, ErrInvalidHeader
+	}
+	x, err := strconv.ParseUint(a, 10, 31)
+	if err != nil {
+		return HeartBeat{}, ErrInvalidHeader
+	}
+	y, err := strconv.ParseUint(b, 10, 31)
+	if err != nil {
+		return HeartBeat{}, ErrInvalidHeader
+	}
+	return HeartBeat{
+		Send:    time.Duration(x) * time.Millisecond,
+		Receive: time.Duration(y) * time.Millisecond,
+	}, nil
+}
+
+// negotiate returns intervals of sending and receiving heart-beats agreed by
+// local and remote settings. Zero interval means no heart-beats.
+func negotiate(local, remote HeartBeat) (send, recv time.Duration) {
+	if local.Send > 0 && remote.Receive > 0 {
+		send = maxDuration(local.Send, remote.Receive)
+	}
+	if local.Receive > 0 && remote.Send > 0 {
+		recv = maxDuration(local.Receive, remote.Send)
+	}
+	return send, recv
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// heartBeatTolerance is the factor of the receive interval after which the
+// silent peer is considered dead.
+const heartBeatTolerance = 2
+
+// heartBeater keeps the connection alive sending heart-beats, which are
+// empty lines, and watches the data received from the peer.
+type heartBeater struct {
+	last int64 // unix nano time of the last received data
+}
+
+// received marks the connection alive. It is called for any received
+// message.
+func (h *heartBeater) received() {
+	atomic.StoreInt64(&h.last, time.Now().UnixNano())
+}
+
+// run sends heart-beats with write every send interval and calls dead if
+// nothing is received for too long. It returns when ctx is done.
+func (h *heartBeater) run(ctx context.Context, send, recv time.Duration, write func() error, dead func()) {
+	if send <= 0 && recv <= 0 {
+		return
+	}
+	h.received()
+
+	var sendc, recvc <-chan time.Time
+	if send > 0 {
+		t := time.NewTicker(send)
+		defer t.Stop()
+		sendc = t.C
+	}
+	if recv > 0 {
+		t := time.NewTicker(recv)
+		defer t.Stop()
+		recvc = t.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sendc:
+			if write() != nil {
+				return
+			}
+		case <-recvc:
+			last := time.Unix(0, atomic.LoadInt64(&h.last))
+			if time.Since(last) > heartBeatTolerance*recv {
+				dead()
+				return
+			}
+		}
+	}
+}
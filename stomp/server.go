@@ -0,0 +1,436 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package stomp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+
+	ms "github.com/cmacro/mogusocket"
+	"github.com/cmacro/mogusocket/msutil"
+)
+
+// AckMode is the acknowledgement mode of a subscription.
+type AckMode string
+
+const (
+	AckAuto             AckMode = "auto"
+	AckClient           AckMode = "client"
+	AckClientIndividual AckMode = "client-individual"
+)
+
+func (m AckMode) valid() bool {
+	return m == AckAuto || m == AckClient || m == AckClientIndividual
+}
+
+// Server is the ms.SessionsHandler of STOMP connections. It delivers frames
+// sent to a destination to all subscriptions of the destination. To serve
+// STOMP over WebSocket set Upgrader.Protocol of the connecter to
+// AcceptProtocol.
+type Server struct {
+	log ms.Logger
+
+	// Name is sent in the server header of CONNECTED frames if set.
+	Name string
+
+	// HeartBeat is the server heart-beat setting. Zero means no heart-beats.
+	HeartBeat HeartBeat
+
+	// Authenticate, if set, checks credentials of CONNECT frames. The error
+	// is sent to the client in ERROR frame.
+	Authenticate func(login, passcode string) error
+
+	// OnSend, if set, is called with each SEND frame before it is delivered.
+	// The error is sent to the client in ERROR frame and the frame is not
+	// delivered.
+	OnSend func(ctx context.Context, f *Frame) error
+
+	// OnNack, if set, is called with each MESSAGE frame the client has
+	// rejected with NACK. Rejected messages are dropped otherwise.
+	OnNack func(ctx context.Context, m *Frame)
+
+	lastID    int64
+	messageID uint64
+	mu        sync.Mutex
+	sessions  map[int64]*serverSession
+}
+
+// NewServer returns Server with default options.
+func NewServer(log ms.Logger) *Server {
+	return &Server{
+		log:      log,
+		sessions: make(map[int64]*serverSession),
+	}
+}
+
+// Connect implements ms.SessionsHandler. If the connection has made a
+// handshake, the STOMP subprotocol must be negotiated.
+func (s *Server) Connect(ctx context.Context, w ms.SendFunc, c func()) (ms.SessionHandler, error) {
+	if hs, ok := msutil.HandshakeFromContext(ctx); ok && hs.Protocol != Protocol {
+		return nil, errors.New("stomp: subprotocol is not negotiated")
+	}
+	sess := &serverSession{
+		id:      atomic.AddInt64(&s.lastID, 1),
+		srv:     s,
+		ctx:     ctx,
+		send:    w,
+		cancel:  c,
+		subs:    make(map[string]*subscription),
+		pending: make(map[string]*pendingMessage),
+	}
+	s.mu.Lock()
+	s.sessions[sess.id] = sess
+	s.mu.Unlock()
+	return sess, nil
+}
+
+// Close implements ms.SessionsHandler.
+func (s *Server) Close(session ms.SessionHandler) error {
+	sess, ok := session.(*serverSession)
+	if !ok {
+		return nil
+	}
+	s.mu.Lock()
+	delete(s.sessions, sess.id)
+	s.mu.Unlock()
+	sess.Close()
+	return nil
+}
+
+// Send delivers body to all subscriptions of the destination. Headers are
+// copied to MESSAGE frames.
+func (s *Server) Send(destination string, header Header, body []byte) {
+	s.mu.Lock()
+	sessions := make([]*serverSession, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	s.mu.Unlock()
+
+	for _, sess := range sessions {
+		sess.deliver(destination, header, body)
+	}
+}
+
+type subscription struct {
+	id          string
+	destination string
+	ack         AckMode
+}
+
+type pendingMessage struct {
+	sub *subscription
+	seq uint64
+	msg *Frame
+}
+
+// serverSession is the server side of a STOMP connection.
+type serverSession struct {
+	id     int64
+	srv    *Server
+	ctx    context.Context
+	send   ms.SendFunc
+	cancel func()
+	hb     heartBeater
+
+	mu        sync.Mutex
+	connected bool
+	subs      map[string]*subscription
+	pending   map[string]*pendingMessage
+	seq       uint64
+}
+
+func (s *serverSession) GetId() int64 { return s.id }
+
+func (s *serverSession) Close() { s.cancel() }
+
+func (s *serverSession) ReadPump(r io.Reader, len int64, isText bool) error {
+	s.hb.received()
+	dec := NewDecoder(r)
+	for {
+		f, err := dec.Decode()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return s.fail(nil, "malformed frame", err.Error())
+		}
+		if err := s.handle(f); err != nil {
+			return err
+		}
+	}
+}
+
+// protocolError is sent to the client in ERROR frame.
+type protocolError struct {
+	message string
+	details string
+}
+
+func (e *protocolError) Error() string { return e.message }
+
+func errorf(message string, details ...string) error {
+	return &protocolError{message: message, details: strings.Join(details, "\n")}
+}
+
+func (s *serverSession) handle(f *Frame) error {
+	s.mu.Lock()
+	connected := s.connected
+	s.mu.Unlock()
+
+	var err error
+	switch {
+	case f.Command == CmdConnect || f.Command == CmdStomp:
+		if connected {
+			err = errorf("already connected")
+		} else {
+			err = s.connect(f)
+		}
+	case !connected:
+		err = errorf("not connected")
+	case f.Command == CmdSend:
+		err = s.handleSend(f)
+	case f.Command == CmdSubscribe:
+		err = s.subscribe(f)
+	case f.Command == CmdUnsubscribe:
+		err = s.unsubscribe(f)
+	case f.Command == CmdAck || f.Command == CmdNack:
+		err = s.ack(f)
+	case f.Command == CmdDisconnect:
+		if err = s.receipt(f); err != nil {
+			return err
+		}
+		return msutil.CloseWithError{Code: ms.StatusNormalClosure}
+	case f.Command == CmdBegin || f.Command == CmdCommit || f.Command == CmdAbort:
+		err = errorf("transactions are not supported")
+	default:
+		err = errorf("unknown command", f.Command)
+	}
+	if err != nil {
+		var pe *protocolError
+		if !errors.As(err, &pe) {
+			pe = &protocolError{message: err.Error()}
+		}
+		return s.fail(f, pe.message, pe.details)
+	}
+	return s.receipt(f)
+}
+
+func (s *serverSession) connect(f *Frame) error {
+	versions := strings.Split(f.Header.Get(HdrAcceptVersion), ",")
+	supported := false
+	for _, v := range versions {
+		supported = supported || v == "1.2"
+	}
+	if !supported {
+		return errorf("supported protocol versions are 1.2")
+	}
+	hb, err := ParseHeartBeat(f.Header.Get(HdrHeartBeat))
+	if err != nil {
+		return errorf("invalid heart-beat header")
+	}
+	if auth := s.srv.Authenticate; auth != nil {
+		if err := auth(f.Header.Get(HdrLogin), f.Header.Get(HdrPasscode)); err != nil {
+			return errorf("authentication failed", err.Error())
+		}
+	}
+
+	send, recv := negotiate(s.srv.HeartBeat, hb)
+	resp := NewFrame(CmdConnected,
+		HdrVersion, "1.2",
+		HdrHeartBeat, s.srv.HeartBeat.String(),
+		HdrSession, strconv.FormatInt(s.id, 10),
+	)
+	if s.srv.Name != "" {
+		resp.Header.Add(HdrServer, s.srv.Name)
+	}
+	if err := s.write(resp); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.connected = true
+	s.mu.Unlock()
+
+	go s.hb.run(s.ctx, send, recv, s.heartBeat, func() {
+		s.srv.log.Info("stomp heart-beat timeout", s.id)
+		msutil.CloseSession(s.ctx, ms.StatusGoingAway, "heart-beat timeout")
+	})
+	return nil
+}
+
+func (s *serverSession) handleSend(f *Frame) error {
+	dest := f.Header.Get(HdrDestination)
+	if dest == "" {
+		return errorf("missing destination header")
+	}
+	if _, ok := f.Header.Lookup(HdrTransaction); ok {
+		return errorf("transactions are not supported")
+	}
+	if h := s.srv.OnSend; h != nil {
+		if err := h(s.ctx, f); err != nil {
+			return err
+		}
+	}
+	var header Header
+	for _, h := range f.Header {
+		switch h.Key {
+		case HdrDestination, HdrReceipt, HdrContentLength:
+		default:
+			header = append(header, h)
+		}
+	}
+	s.srv.Send(dest, header, f.Body)
+	return nil
+}
+
+func (s *serverSession) subscribe(f *Frame) error {
+	id, dest := f.Header.Get(HdrID), f.Header.Get(HdrDestination)
+	if id == "" || dest == "" {
+		return errorf("missing id or destination header")
+	}
+	ack := AckMode(f.Header.Get(HdrAck))
+	if ack == "" {
+		ack = AckAuto
+	}
+	if !ack.valid() {
+		return errorf("invalid ack header", string(ack))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subs[id]; ok {
+		return errorf("duplicate subscription id", id)
+	}
+	s.subs[id] = &subscription{id: id, destination: dest, ack: ack}
+	return nil
+}
+
+func (s *serverSession) unsubscribe(f *Frame) error {
+	id := f.Header.Get(HdrID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.subs[id]
+	if !ok {
+		return errorf("unknown subscription id", id)
+	}
+	delete(s.subs, id)
+	for k, p := range s.pending {
+		if p.sub == sub {
+			delete(s.pending, k)
+		}
+	}
+	return nil
+}
+
+func (s *serverSession) ack(f *Frame) error {
+	id := f.Header.Get(HdrID)
+
+	s.mu.Lock()
+	p, ok := s.pending[id]
+	if !ok {
+		s.mu.Unlock()
+		return errorf("unknown ack id", id)
+	}
+	acked := []*Frame{p.msg}
+	delete(s.pending, id)
+	if p.sub.ack == AckClient {
+		// Acknowledgement is cumulative in client mode.
+		for k, x := range s.pending {
+			if x.sub == p.sub && x.seq < p.seq {
+				acked = append(acked, x.msg)
+				delete(s.pending, k)
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	if h := s.srv.OnNack; f.Command == CmdNack && h != nil {
+		for _, m := range acked {
+			h(s.ctx, m)
+		}
+	}
+	return nil
+}
+
+func (s *serverSession) deliver(dest string, header Header, body []byte) {
+	var frames []*Frame
+	s.mu.Lock()
+	if !s.connected {
+		s.mu.Unlock()
+		return
+	}
+	for _, sub := range s.subs {
+		if sub.destination != dest {
+			continue
+		}
+		msgID := strconv.FormatUint(atomic.AddUint64(&s.srv.messageID, 1), 10)
+		m := NewFrame(CmdMessage,
+			HdrSubscription, sub.id,
+			HdrMessageID, msgID,
+			HdrDestination, dest,
+		)
+		if sub.ack != AckAuto {
+			s.seq++
+			ackID := strconv.FormatUint(s.seq, 10)
+			m.Header.Add(HdrAck, ackID)
+			s.pending[ackID] = &pendingMessage{sub: sub, seq: s.seq, msg: m}
+		}
+		m.Header = append(m.Header, header...)
+		m.Body = body
+		frames = append(frames, m)
+	}
+	s.mu.Unlock()
+
+	for _, m := range frames {
+		if err := s.write(m); err != nil {
+			s.srv.log.Info("stomp deliver", s.id, err)
+			return
+		}
+	}
+}
+
+// receipt sends RECEIPT frame if the client has requested it.
+func (s *serverSession) receipt(f *Frame) error {
+	id, ok := f.Header.Lookup(HdrReceipt)
+	if !ok {
+		return nil
+	}
+	return s.write(NewFrame(CmdReceipt, HdrReceiptID, id))
+}
+
+// fail sends ERROR frame and closes the connection.
+func (s *serverSession) fail(f *Frame, message, details string) error {
+	e := NewFrame(CmdError, HdrMessage, message)
+	if f != nil {
+		if id, ok := f.Header.Lookup(HdrReceipt); ok {
+			e.Header.Add(HdrReceiptID, id)
+		}
+	}
+	if details != "" {
+		e.Header.Add(HdrContentType, "text/plain")
+		e.Body = []byte(details)
+	}
+	if err := s.write(e); err != nil {
+		return err
+	}
+	return msutil.CloseWithError{Code: ms.StatusNormalClosure, Reason: message}
+}
+
+func (s *serverSession) heartBeat() error {
+	return s.send(strings.NewReader("\n"), true)
+}
+
+// write sends f in a text message unless its body is not UTF-8 text.
+func (s *serverSession) write(f *Frame) error {
+	p := f.Bytes()
+	return s.send(bytes.NewReader(p), utf8.Valid(p))
+}
@@ -0,0 +1,246 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package stomp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	ms "github.com/cmacro/mogusocket"
+	"github.com/cmacro/mogusocket/internal/mstest"
+)
+
+type rawClient struct {
+	*mstest.Conn
+	t *testing.T
+
+	// op is the opcode of the last read message.
+	op ms.OpCode
+}
+
+// serveRaw serves s on one end of a pipe and returns a client speaking raw
+// frames on the other end.
+func serveRaw(t *testing.T, s *Server) *rawClient {
+	t.Helper()
+	return &rawClient{Conn: mstest.Serve(t, s), t: t}
+}
+
+func (c *rawClient) write(f *Frame) {
+	c.t.Helper()
+	c.WriteText(f.Bytes())
+}
+
+func (c *rawClient) read() *Frame {
+	c.t.Helper()
+	for {
+		p, op := c.ReadMessage()
+		c.op = op
+		f, err := NewDecoder(bytes.NewReader(p)).Decode()
+		if err == io.EOF {
+			continue // heart-beat
+		}
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		return f
+	}
+}
+
+func (c *rawClient) expect(command string, kv ...string) *Frame {
+	c.t.Helper()
+	f := c.read()
+	if f.Command != command {
+		c.t.Fatalf("unexpected frame: %s %v %q; want %s", f.Command, f.Header, f.Body, command)
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		if v := f.Header.Get(kv[i]); v != kv[i+1] {
+			c.t.Fatalf("unexpected %s header: %q; want %q", kv[i], v, kv[i+1])
+		}
+	}
+	return f
+}
+
+func (c *rawClient) connect() {
+	c.t.Helper()
+	c.write(NewFrame(CmdConnect, HdrAcceptVersion, "1.1,1.2", HdrHost, "/"))
+	c.expect(CmdConnected, HdrVersion, "1.2", HdrHeartBeat, "0,0")
+}
+
+func TestServerConnect(t *testing.T) {
+	s := NewServer(ms.Noop)
+	s.Authenticate = func(login, passcode string) error {
+		if login != "user" || passcode != "secret" {
+			return errors.New("bad credentials")
+		}
+		return nil
+	}
+	for _, test := range []struct {
+		name  string
+		frame *Frame
+		exp   string
+	}{
+		{
+			name:  "not connected",
+			frame: NewFrame(CmdSend, HdrDestination, "/q"),
+			exp:   "not connected",
+		},
+		{
+			name:  "version",
+			frame: NewFrame(CmdConnect, HdrAcceptVersion, "1.0,1.1"),
+			exp:   "supported protocol versions are 1.2",
+		},
+		{
+			name:  "credentials",
+			frame: NewFrame(CmdConnect, HdrAcceptVersion, "1.2", HdrLogin, "user", HdrPasscode, "x"),
+			exp:   "authentication failed",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c := serveRaw(t, s)
+			c.write(test.frame)
+			c.expect(CmdError, HdrMessage, test.exp)
+			if h, _ := c.ReadFrame(); h.OpCode != ms.OpClose {
+				t.Fatalf("connection is not closed: %v", h.OpCode)
+			}
+		})
+	}
+
+	c := serveRaw(t, s)
+	c.write(NewFrame(CmdStomp, HdrAcceptVersion, "1.2", HdrLogin, "user", HdrPasscode, "secret"))
+	c.expect(CmdConnected, HdrVersion, "1.2", HdrSession, "4")
+}
+
+func TestServerMessages(t *testing.T) {
+	s := NewServer(ms.Noop)
+	sub := serveRaw(t, s)
+	pub := serveRaw(t, s)
+	sub.connect()
+	pub.connect()
+
+	sub.write(NewFrame(CmdSubscribe, HdrID, "0", HdrDestination, "/queue/a", HdrReceipt, "r1"))
+	sub.expect(CmdReceipt, HdrReceiptID, "r1")
+
+	send := NewFrame(CmdSend, HdrDestination, "/queue/a", HdrContentType, "text/plain", "x-key", "a:b", HdrReceipt, "r2")
+	send.Body = []byte("hello\x00world")
+	pub.write(send)
+
+	// Frames are delivered before the receipt is sent.
+	m := sub.expect(CmdMessage,
+		HdrSubscription, "0",
+		HdrDestination, "/queue/a",
+		HdrContentType, "text/plain",
+		"x-key", "a:b",
+	)
+	if string(m.Body) != "hello\x00world" || m.Header.Get(HdrMessageID) == "" {
+		t.Fatalf("unexpected message: %v %q", m.Header, m.Body)
+	}
+	if _, ok := m.Header.Lookup(HdrAck); ok {
+		t.Fatal("unexpected ack header in auto mode")
+	}
+	pub.expect(CmdReceipt, HdrReceiptID, "r2")
+
+	// Unsubscribed frames are not delivered.
+	sub.write(NewFrame(CmdUnsubscribe, HdrID, "0", HdrReceipt, "u"))
+	sub.expect(CmdReceipt, HdrReceiptID, "u")
+	pub.write(NewFrame(CmdSend, HdrDestination, "/queue/a", HdrReceipt, "r3"))
+	pub.expect(CmdReceipt)
+	sub.write(NewFrame(CmdDisconnect, HdrReceipt, "bye"))
+	sub.expect(CmdReceipt, HdrReceiptID, "bye")
+}
+
+func TestServerBinaryBody(t *testing.T) {
+	s := NewServer(ms.Noop)
+	sub := serveRaw(t, s)
+	pub := serveRaw(t, s)
+	sub.connect()
+	pub.connect()
+	if sub.op != ms.OpText {
+		t.Fatalf("CONNECTED frame is sent in %v message", sub.op)
+	}
+
+	sub.write(NewFrame(CmdSubscribe, HdrID, "0", HdrDestination, "/queue/a", HdrReceipt, "r1"))
+	sub.expect(CmdReceipt, HdrReceiptID, "r1")
+	send := NewFrame(CmdSend, HdrDestination, "/queue/a")
+	send.Body = []byte{0xff, 0x00, 0xfe}
+	pub.WriteBinary(send.Bytes())
+
+	m := sub.expect(CmdMessage, HdrDestination, "/queue/a")
+	if !bytes.Equal(m.Body, send.Body) {
+		t.Fatalf("unexpected body: %q", m.Body)
+	}
+	if sub.op != ms.OpBinary {
+		t.Fatalf("non UTF-8 body is sent in %v message", sub.op)
+	}
+}
+
+func TestServerAck(t *testing.T) {
+	nacked := make(chan *Frame, 4)
+	s := NewServer(ms.Noop)
+	s.OnNack = func(ctx context.Context, m *Frame) { nacked <- m }
+
+	c := serveRaw(t, s)
+	c.connect()
+	c.write(NewFrame(CmdSubscribe, HdrID, "c", HdrDestination, "/a", HdrAck, "client"))
+	c.write(NewFrame(CmdSubscribe, HdrID, "i", HdrDestination, "/b", HdrAck, "client-individual", HdrReceipt, "r"))
+	c.expect(CmdReceipt)
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		go s.Send("/a", nil, nil)
+		ids = append(ids, c.expect(CmdMessage, HdrSubscription, "c").Header.Get(HdrAck))
+	}
+	for i := 0; i < 2; i++ {
+		go s.Send("/b", nil, nil)
+		ids = append(ids, c.expect(CmdMessage, HdrSubscription, "i").Header.Get(HdrAck))
+	}
+
+	// Client mode acknowledges all previous messages of the subscription.
+	c.write(NewFrame(CmdNack, HdrID, ids[1], HdrReceipt, "r1"))
+	c.expect(CmdReceipt, HdrReceiptID, "r1")
+	if n := len(nacked); n != 2 {
+		t.Fatalf("unexpected nacked messages: %d", n)
+	}
+	// Individual mode acknowledges the single message.
+	c.write(NewFrame(CmdAck, HdrID, ids[4], HdrReceipt, "r2"))
+	c.expect(CmdReceipt, HdrReceiptID, "r2")
+	c.write(NewFrame(CmdAck, HdrID, ids[3], HdrReceipt, "r3"))
+	c.expect(CmdReceipt, HdrReceiptID, "r3")
+	c.write(NewFrame(CmdAck, HdrID, ids[2]))
+
+	c.write(NewFrame(CmdAck, HdrID, ids[0], HdrReceipt, "r4"))
+	c.expect(CmdError, HdrMessage, "unknown ack id", HdrReceiptID, "r4")
+}
+
+func TestServerHeartBeat(t *testing.T) {
+	s := NewServer(ms.Noop)
+	s.HeartBeat = HeartBeat{Send: 10 * time.Millisecond, Receive: 20 * time.Millisecond}
+	c := serveRaw(t, s)
+
+	c.write(NewFrame(CmdConnect, HdrAcceptVersion, "1.2", HdrHeartBeat, "10,10"))
+	c.expect(CmdConnected, HdrHeartBeat, "10,20")
+
+	// Server sends heart-beats and closes the silent connection.
+	beats := 0
+	for {
+		h, p := c.ReadFrame()
+		if h.OpCode == ms.OpClose {
+			if code, _ := ms.ParseCloseFrameData(p); code != ms.StatusGoingAway {
+				t.Fatalf("unexpected close code: %v", code)
+			}
+			break
+		}
+		if string(p) != "\n" {
+			t.Fatalf("unexpected heart-beat: %q", p)
+		}
+		beats++
+	}
+	if beats == 0 {
+		t.Fatal("no heart-beats")
+	}
+}
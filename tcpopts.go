@@ -0,0 +1,153 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package mogusocket
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrTCPOptionUnsupported is returned when the TCP socket option is not
+// supported on this platform.
+var ErrTCPOptionUnsupported = errors.New("tcp socket option is not supported on this platform")
+
+// TCPOptions contains options of TCP sockets. They are applied to
+// connections accepted by Server and dialed with Dial(). Zero fields keep
+// the defaults of the system and the net package.
+type TCPOptions struct {
+	// Delay enables the Nagle's algorithm, that is, TCP_NODELAY is cleared.
+	// By default small frames are sent without delay.
+	Delay bool
+
+	// KeepAlive is the period of TCP keepalive probes. If it is zero, the
+	// net package default is used. If it is negative, keepalive is disabled.
+	KeepAlive time.Duration
+
+	// ReadBuffer and WriteBuffer, if non-zero, are the sizes of the socket
+	// receive and send buffers, SO_RCVBUF and SO_SNDBUF.
+	ReadBuffer, WriteBuffer int
+
+	// UserTimeout, if non-zero, is the maximum time written data may remain
+	// unacknowledged before the connection is closed, TCP_USER_TIMEOUT. It is
+	// supported only on Linux and ignored on other platforms.
+	UserTimeout time.Duration
+
+	// ReusePort sets SO_REUSEPORT on the listening socket, so several
+	// servers could accept connections on the same port. It is supported
+	// only on Linux.
+	ReusePort bool
+}
+
+var (
+	// DefaultServerTCPOptions suit servers with many long living connections.
+	// Dead peers are detected by keepalive probes on idle connections and by
+	// the user timeout on connections with pending writes.
+	DefaultServerTCPOptions = TCPOptions{
+		KeepAlive:   30 * time.Second,
+		UserTimeout: time.Minute,
+	}
+
+	// DefaultClientTCPOptions suit clients which reconnect on failures, so
+	// the broken connection is detected sooner.
+	DefaultClientTCPOptions = TCPOptions{
+		KeepAlive:   15 * time.Second,
+		UserTimeout: 30 * time.Second,
+	}
+)
+
+// Dial connects to the address on the named network and applies options to
+// TCP connections. It could be used as Dialer.NetDial. Nil options keep the
+// defaults.
+func (o *TCPOptions) Dial(ctx context.Context, network, address string) (net.Conn, error) {
+	var d net.Dialer
+	if o != nil && isTCP(network) {
+		d.KeepAlive = o.KeepAlive
+		d.Control = func(_, _ string, c syscall.RawConn) error {
+			return o.control(c, false)
+		}
+	}
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.apply(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// listen announces on the address. The options of the listening socket are
+// set before it is bound.
+func (o *TCPOptions) listen(network, address string) (net.Listener, error) {
+	if o == nil || !isTCP(network) {
+		return net.Listen(network, address)
+	}
+	lc := net.ListenConfig{
+		KeepAlive: o.KeepAlive,
+		Control: func(_, _ string, c syscall.RawConn) error {
+			return o.control(c, true)
+		},
+	}
+	return lc.Listen(context.Background(), network, address)
+}
+
+// apply sets options of the TCP connection conn. Other connections are left
+// as is.
+func (o *TCPOptions) apply(conn net.Conn) error {
+	tc, ok := conn.(*net.TCPConn)
+	if o == nil || !ok {
+		return nil
+	}
+	if err := tc.SetNoDelay(!o.Delay); err != nil {
+		return err
+	}
+	if o.KeepAlive > 0 {
+		if err := tc.SetKeepAlive(true); err != nil {
+			return err
+		}
+		if err := tc.SetKeepAlivePeriod(o.KeepAlive); err != nil {
+			return err
+		}
+	} else if o.KeepAlive < 0 {
+		if err := tc.SetKeepAlive(false); err != nil {
+			return err
+		}
+	}
+	if o.ReadBuffer > 0 {
+		if err := tc.SetReadBuffer(o.ReadBuffer); err != nil {
+			return err
+		}
+	}
+	if o.WriteBuffer > 0 {
+		if err := tc.SetWriteBuffer(o.WriteBuffer); err != nil {
+			return err
+		}
+	}
+	rc, err := tc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	return o.control(rc, false)
+}
+
+// control sets socket options which are not provided by the net package.
+func (o *TCPOptions) control(c syscall.RawConn, listen bool) error {
+	var err error
+	if cerr := c.Control(func(fd uintptr) {
+		err = setSockOpts(fd, o, listen)
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+func isTCP(network string) bool {
+	return strings.HasPrefix(network, "tcp")
+}
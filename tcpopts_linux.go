@@ -0,0 +1,44 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+//go:build linux
+
+package mogusocket
+
+import (
+	"syscall"
+)
+
+// Options missing in the syscall package.
+const (
+	soReusePort    = 0xf
+	tcpUserTimeout = 0x12
+)
+
+// setSockOpts sets options of the socket fd. The buffer sizes are set before
+// the socket is connected or bound, so the window scale is chosen for them.
+func setSockOpts(fd uintptr, o *TCPOptions, listen bool) error {
+	if listen && o.ReusePort {
+		if err := syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1); err != nil {
+			return err
+		}
+	}
+	if o.ReadBuffer > 0 {
+		if err := syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF, o.ReadBuffer); err != nil {
+			return err
+		}
+	}
+	if o.WriteBuffer > 0 {
+		if err := syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF, o.WriteBuffer); err != nil {
+			return err
+		}
+	}
+	if o.UserTimeout > 0 {
+		ms := int(o.UserTimeout.Milliseconds())
+		if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpUserTimeout, ms); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+//go:build linux
+
+package mogusocket
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// sockOpts contains options read back from the socket.
+type sockOpts struct {
+	noDelay     int
+	keepAlive   int
+	keepIdle    int
+	readBuffer  int
+	writeBuffer int
+	userTimeout int
+	reusePort   int
+}
+
+func readSockOpts(t *testing.T, c syscall.Conn) sockOpts {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		opts sockOpts
+		errs []error
+	)
+	get := func(fd uintptr, level, opt int, v *int) {
+		var err error
+		if *v, err = syscall.GetsockoptInt(int(fd), level, opt); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	err = rc.Control(func(fd uintptr) {
+		get(fd, syscall.IPPROTO_TCP, syscall.TCP_NODELAY, &opts.noDelay)
+		get(fd, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, &opts.keepAlive)
+		get(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE, &opts.keepIdle)
+		get(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, &opts.readBuffer)
+		get(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF, &opts.writeBuffer)
+		get(fd, syscall.IPPROTO_TCP, tcpUserTimeout, &opts.userTimeout)
+		get(fd, syscall.SOL_SOCKET, soReusePort, &opts.reusePort)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	return opts
+}
+
+func checkSockOpts(t *testing.T, name string, act sockOpts, o *TCPOptions) {
+	t.Helper()
+	if exp := boolOpt(!o.Delay); act.noDelay != exp {
+		t.Errorf("%s: TCP_NODELAY = %d; want %d", name, act.noDelay, exp)
+	}
+	if exp := boolOpt(o.KeepAlive >= 0); act.keepAlive != exp {
+		t.Errorf("%s: SO_KEEPALIVE = %d; want %d", name, act.keepAlive, exp)
+	}
+	if o.KeepAlive > 0 && act.keepIdle != int(o.KeepAlive/time.Second) {
+		t.Errorf("%s: TCP_KEEPIDLE = %d; want %v", name, act.keepIdle, o.KeepAlive)
+	}
+	// The kernel doubles buffer sizes to leave space for bookkeeping.
+	if act.readBuffer < o.ReadBuffer {
+		t.Errorf("%s: SO_RCVBUF = %d; want at least %d", name, act.readBuffer, o.ReadBuffer)
+	}
+	if act.writeBuffer < o.WriteBuffer {
+		t.Errorf("%s: SO_SNDBUF = %d; want at least %d", name, act.writeBuffer, o.WriteBuffer)
+	}
+	if exp := int(o.UserTimeout.Milliseconds()); act.userTimeout != exp {
+		t.Errorf("%s: TCP_USER_TIMEOUT = %d; want %d", name, act.userTimeout, exp)
+	}
+}
+
+func boolOpt(v bool) int {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+func TestTCPOptions(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		opts TCPOptions
+	}{
+		{"server", DefaultServerTCPOptions},
+		{"client", DefaultClientTCPOptions},
+		{"tuned", TCPOptions{
+			Delay:       true,
+			KeepAlive:   -1,
+			ReadBuffer:  64 << 10,
+			WriteBuffer: 128 << 10,
+			UserTimeout: 5 * time.Second,
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			o := &test.opts
+			conns := make(acceptHandler, 1)
+			srv := NewServer("tcp://127.0.0.1:0", conns, Noop)
+			srv.TCP = o
+			ln := mustListenServer(t, srv)
+			defer ln.Close()
+
+			client, err := o.Dial(context.Background(), "tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			server := <-conns
+
+			checkSockOpts(t, "client", readSockOpts(t, client.(*net.TCPConn)), o)
+			checkSockOpts(t, "server", readSockOpts(t, server.(*net.TCPConn)), o)
+		})
+	}
+}
+
+func TestTCPOptionsReusePort(t *testing.T) {
+	o := &TCPOptions{ReusePort: true}
+	first, err := o.listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	if act := readSockOpts(t, first.(*net.TCPListener)); act.reusePort != 1 {
+		t.Errorf("SO_REUSEPORT = %d; want 1", act.reusePort)
+	}
+
+	second, err := o.listen("tcp", first.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second.Close()
+	if _, err := (&TCPOptions{}).listen("tcp", first.Addr().String()); err == nil {
+		t.Errorf("listen without SO_REUSEPORT succeeded")
+	}
+}
+
+// mustListenServer makes srv accept connections from the returned listener.
+func mustListenServer(t *testing.T, srv *Server) net.Listener {
+	u, err := ParserAddr(srv.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := srv.listenAddr(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go srv.handleAccept(ctx, ln)
+	return ln
+}
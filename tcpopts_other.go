@@ -0,0 +1,16 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+//go:build !linux
+
+package mogusocket
+
+// setSockOpts sets options of the socket fd. Buffer sizes are set with the
+// net package after the connection is made and the user timeout is ignored.
+func setSockOpts(fd uintptr, o *TCPOptions, listen bool) error {
+	if listen && o.ReusePort {
+		return ErrTCPOptionUnsupported
+	}
+	return nil
+}
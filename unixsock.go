@@ -0,0 +1,193 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package mogusocket
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Errors used by unix socket options.
+var (
+	ErrSocketInUse         = errors.New("unix socket is in use by another server")
+	ErrPeerCredUnsupported = errors.New("peer credentials are not supported on this platform")
+)
+
+// UnixSocket contains options of the unix socket file of Server. They are
+// ignored for abstract sockets, which addresses look like "unix://@name".
+// The socket file appears at its path with Mode and the owner already set.
+type UnixSocket struct {
+	// Mode, if non-zero, is set as the permissions of the socket file, e.g.
+	// 0660 to allow only the owner and the group to connect.
+	Mode os.FileMode
+
+	// Owner and Group, if set, are the user and group names or ids the
+	// socket file is owned by.
+	Owner, Group string
+
+	// Lock makes the server hold an exclusive lock of the file with ".lock"
+	// suffix added to the socket path while it runs. The server started
+	// with the path which is locked fails with ErrSocketInUse instead of
+	// removing the socket of the running one.
+	Lock bool
+}
+
+// PeerCred contains credentials of the process connected to a unix socket.
+type PeerCred struct {
+	PID int
+	UID int
+	GID int
+}
+
+// PeerCredentials returns credentials of the process connected with conn.
+// It is supported only for unix socket connections on Linux.
+func PeerCredentials(conn net.Conn) (*PeerCred, error) {
+	if pc, ok := conn.(*ProxyConn); ok {
+		conn = pc.Conn
+	}
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, ErrPeerCredUnsupported
+	}
+	return peerCred(uc)
+}
+
+// PeerAuthenticator returns Authenticator which accepts requests made over
+// unix socket connections by processes allowed by allow. The principal
+// subject is the user id and the claims are the *PeerCred.
+func PeerAuthenticator(allow func(*PeerCred) bool) Authenticator {
+	return AuthenticatorFunc(func(r *HandshakeRequest) (*Principal, error) {
+		if r.Peer == nil {
+			return nil, ErrHandshakeUnauthorized
+		}
+		if !allow(r.Peer) {
+			return nil, ErrHandshakeForbidden
+		}
+		return &Principal{Subject: strconv.Itoa(r.Peer.UID), Claims: r.Peer}, nil
+	})
+}
+
+// peerCredMaybe returns credentials of the peer of conn if it is a unix
+// socket connection.
+func peerCredMaybe(conn io.ReadWriter) *PeerCred {
+	c, ok := conn.(net.Conn)
+	if !ok {
+		return nil
+	}
+	cred, err := PeerCredentials(c)
+	if err != nil {
+		return nil
+	}
+	return cred
+}
+
+// isAbstractSocket reports whether path is the name of the Linux abstract
+// socket.
+func isAbstractSocket(path string) bool {
+	return strings.HasPrefix(path, "@")
+}
+
+// lock takes the lock of the socket file path if it is enabled. The lock is
+// held until the returned file is closed. It returns nil file if the lock is
+// disabled.
+func (o *UnixSocket) lock(path string) (*os.File, error) {
+	if o == nil || !o.Lock || isAbstractSocket(path) {
+		return nil, nil
+	}
+	return lockFile(path + ".lock")
+}
+
+// listen listens on the socket file at path using listen. If the mode or the
+// owner is set, the socket is created in a private directory next to path
+// and renamed into place after they are applied, so clients could not
+// connect to the socket with default permissions.
+func (o *UnixSocket) listen(path string, listen func(path string) (net.Listener, error)) (net.Listener, error) {
+	if o == nil || o.Mode == 0 && o.Owner == "" && o.Group == "" || isAbstractSocket(path) {
+		return listen(path)
+	}
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".ms")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "s")
+	ln, err := listen(tmp)
+	if err != nil {
+		return nil, err
+	}
+	if ul, ok := ln.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(false)
+	}
+	if err = o.apply(tmp); err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// apply sets the mode and the owner of the socket file at path.
+func (o *UnixSocket) apply(path string) error {
+	if o == nil || isAbstractSocket(path) {
+		return nil
+	}
+	if o.Mode != 0 {
+		if err := os.Chmod(path, o.Mode); err != nil {
+			return err
+		}
+	}
+	if o.Owner == "" && o.Group == "" {
+		return nil
+	}
+	uid, gid := -1, -1
+	if o.Owner != "" {
+		u, err := lookupID(o.Owner, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		if err != nil {
+			return err
+		}
+		uid = u
+	}
+	if o.Group != "" {
+		g, err := lookupID(o.Group, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			return err
+		}
+		gid = g
+	}
+	return os.Chown(path, uid, gid)
+}
+
+// lookupID returns the numeric id s or the id of the name s.
+func lookupID(s string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(s); err == nil {
+		return id, nil
+	}
+	id, err := lookup(s)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(id)
+}
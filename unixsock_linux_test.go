@@ -0,0 +1,230 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+//go:build linux
+
+package mogusocket
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type acceptHandler chan net.Conn
+
+func (h acceptHandler) Run(ctx context.Context, conn io.ReadWriter) {
+	h <- conn.(net.Conn)
+	<-ctx.Done()
+}
+
+func runServer(t *testing.T, srv *Server) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		srv.Run(ctx)
+		close(done)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func mustDialUnix(t *testing.T, addr string) net.Conn {
+	var (
+		conn net.Conn
+		err  error
+	)
+	for i := 0; i < 100; i++ {
+		if conn, err = net.Dial("unix", addr); err == nil {
+			return conn
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal(err)
+	return nil
+}
+
+func TestServerUnixSocket(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	conns := make(acceptHandler, 1)
+	srv := NewServer("unix://"+sock, conns, Noop)
+	srv.UnixSocket = &UnixSocket{
+		Mode:  0600,
+		Owner: strconv.Itoa(os.Getuid()),
+		Group: strconv.Itoa(os.Getgid()),
+		Lock:  true,
+	}
+	stop := runServer(t, srv)
+	defer stop()
+
+	conn := mustDialUnix(t, sock)
+	defer conn.Close()
+	server := <-conns
+
+	fi, err := os.Stat(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := fi.Mode().Perm(); mode != 0600 {
+		t.Errorf("unexpected socket mode: %v", mode)
+	}
+
+	cred, err := PeerCredentials(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cred.PID != os.Getpid() || cred.UID != os.Getuid() || cred.GID != os.Getgid() {
+		t.Errorf("unexpected credentials: %+v", cred)
+	}
+
+	// The second server must not remove the socket of the running one.
+	second := NewServer("unix://"+sock, conns, Noop)
+	second.UnixSocket = &UnixSocket{Lock: true}
+	second.Run(context.Background())
+	if _, err := os.Stat(sock); err != nil {
+		t.Fatalf("socket is removed: %v", err)
+	}
+	mustDialUnix(t, sock).Close()
+	(<-conns).Close()
+}
+
+func TestUnixSocketListen(t *testing.T) {
+	dir := t.TempDir()
+	sock := filepath.Join(dir, "agent.sock")
+	listen := func(path string) (net.Listener, error) {
+		// The socket is not created at its place before permissions are
+		// applied.
+		if path == sock {
+			t.Errorf("socket is created at %s", path)
+		}
+		return net.Listen("unix", path)
+	}
+
+	o := &UnixSocket{Mode: 0600}
+	ln, err := o.listen(sock, listen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	fi, err := os.Stat(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := fi.Mode().Perm(); mode != 0600 {
+		t.Errorf("unexpected socket mode: %v", mode)
+	}
+	mustDialUnix(t, sock).Close()
+
+	// Nothing is left if the owner could not be set.
+	o = &UnixSocket{Mode: 0600, Owner: "no-such-user-for-mogusocket"}
+	other := filepath.Join(dir, "other.sock")
+	if _, err := o.listen(other, listen); err == nil {
+		t.Fatal("unexpected success")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "agent.sock" {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("unexpected files: %q", names)
+	}
+}
+
+func TestLockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.sock.lock")
+	f, err := lockFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lockFile(path); err != ErrSocketInUse {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	f.Close()
+	f, err = lockFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+}
+
+func TestServerAbstractSocket(t *testing.T) {
+	name := "@mogusocket-test-" + strconv.Itoa(os.Getpid())
+	conns := make(acceptHandler, 1)
+	stop := runServer(t, NewServer("unix://"+name, conns, Noop))
+	defer stop()
+
+	conn := mustDialUnix(t, name)
+	defer conn.Close()
+	(<-conns).Close()
+}
+
+func TestPeerAuthenticator(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	for _, test := range []struct {
+		name    string
+		allow   func(*PeerCred) bool
+		subject string
+		err     error
+	}{
+		{
+			name:    "allowed",
+			allow:   func(c *PeerCred) bool { return c.UID == os.Getuid() },
+			subject: strconv.Itoa(os.Getuid()),
+		},
+		{
+			name:  "forbidden",
+			allow: func(c *PeerCred) bool { return false },
+			err:   ErrHandshakeForbidden,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			client, err := net.Dial("unix", sock)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			server, err := ln.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer server.Close()
+
+			go func() {
+				u, _ := url.Parse("ws://localhost/")
+				Dialer{}.Upgrade(client, u)
+			}()
+			hs, err := Upgrader{Authenticator: PeerAuthenticator(test.allow)}.Upgrade(server)
+			if err != test.err {
+				t.Fatalf("unexpected error: %v; want %v", err, test.err)
+			}
+			if err != nil {
+				return
+			}
+			if hs.Peer == nil || hs.Peer.PID != os.Getpid() {
+				t.Errorf("unexpected peer: %+v", hs.Peer)
+			}
+			if hs.Principal.Subject != test.subject {
+				t.Errorf("unexpected subject: %q; want %q", hs.Principal.Subject, test.subject)
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+//go:build !linux
+
+package mogusocket
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+func peerCred(conn *net.UnixConn) (*PeerCred, error) {
+	return nil, ErrPeerCredUnsupported
+}
+
+func lockFile(path string) (*os.File, error) {
+	return nil, errors.New("socket lock is not supported on this platform")
+}
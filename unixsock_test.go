@@ -0,0 +1,58 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package mogusocket
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestParserAddrAbstract(t *testing.T) {
+	u, err := ParserAddr("unix://@agent.sock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, a := u.Data(); n != "unix" || a != "@agent.sock" {
+		t.Errorf("unexpected address: %q %q", n, a)
+	}
+	if err := clearEnvConnect(u.Data()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestLookupID(t *testing.T) {
+	names := map[string]string{"agent": "1001"}
+	lookup := func(name string) (string, error) {
+		if id, ok := names[name]; ok {
+			return id, nil
+		}
+		return "", errors.New("unknown name")
+	}
+	for _, test := range []struct {
+		in  string
+		exp int
+		err bool
+	}{
+		{in: "0", exp: 0},
+		{in: "1000", exp: 1000},
+		{in: "agent", exp: 1001},
+		{in: "nobody", err: true},
+	} {
+		id, err := lookupID(test.in, lookup)
+		if (err != nil) != test.err || (err == nil && id != test.exp) {
+			t.Errorf("lookupID(%q) = %d, %v; want %d", test.in, id, err, test.exp)
+		}
+	}
+}
+
+func TestPeerCredentialsUnsupported(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	if _, err := PeerCredentials(server); err != ErrPeerCredUnsupported {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -149,6 +149,29 @@ type HTTPUpgrader struct {
 	//
 	// RejectConnectionError could be used to get more control on response.
 	Negotiate func(httphead.Option) (httphead.Option, error)
+
+	// RecordRequest enables recording of the request line and headers in
+	// Handshake.Request.
+	RecordRequest bool
+
+	// RecordHeaders limits headers recorded with RecordRequest. If it is
+	// nil, all headers are recorded.
+	RecordHeaders []string
+
+	// Origin is the policy of the Origin header check. By default only the
+	// same origin is allowed and other origins are rejected with 403 status.
+	Origin OriginPolicy
+
+	// Authenticator, if set, authenticates the request. The principal is
+	// stored in Handshake.Principal. Headers are recorded for the
+	// Authenticator even if RecordRequest is not set, but only the ones
+	// listed in RecordHeaders if it is non-nil.
+	Authenticator Authenticator
+
+	// TrustedProxies lists IP addresses and CIDRs of proxies which
+	// X-Forwarded-For header is trusted. The client address taken from it
+	// is stored in Handshake.RemoteAddr.
+	TrustedProxies []string
 }
 
 // Upgrade upgrades http connection to the websocket connection.
@@ -209,6 +232,18 @@ func (u HTTPUpgrader) Upgrade(r *http.Request, w http.ResponseWriter) (conn net.
 			err = ErrHandshakeBadSecVersion
 		}
 	}
+	hs.RemoteAddr, hs.Proxy = remoteAddr(conn)
+	hs.Peer = peerCredMaybe(conn)
+	if len(u.TrustedProxies) != 0 {
+		xff := strings.Join(r.Header.Values(headerXForwardedForCanonical), ",")
+		hs.RemoteAddr = forwardedFor(hs.RemoteAddr, xff, u.TrustedProxies)
+	}
+	if err == nil {
+		err = u.Origin.check(r.Header.Get(headerOriginCanonical), r.Host)
+	}
+	if err == nil && u.Authenticator != nil {
+		hs.Principal, err = authenticate(u.Authenticator, hs.recordRequestWithConn(r, u.RecordHeaders))
+	}
 	if check := u.Protocol; err == nil && check != nil {
 		ps := r.Header[headerSecProtocolCanonical]
 		for i := 0; i < len(ps) && err == nil && hs.Protocol == ""; i++ {
@@ -239,6 +274,10 @@ func (u HTTPUpgrader) Upgrade(r *http.Request, w http.ResponseWriter) (conn net.
 		}
 	}
 
+	if u.RecordRequest {
+		hs.Request = hs.recordRequestWithConn(r, u.RecordHeaders)
+	}
+
 	// Clear deadlines set by server.
 	conn.SetDeadline(noDeadline)
 	if t := u.Timeout; t != 0 {
@@ -408,6 +447,29 @@ type Upgrader struct {
 	//
 	// RejectConnectionError could be used to get more control on response.
 	OnBeforeUpgrade func() (header HandshakeHeader, err error)
+
+	// RecordRequest enables recording of the request line and headers in
+	// Handshake.Request.
+	RecordRequest bool
+
+	// RecordHeaders limits headers recorded with RecordRequest. If it is
+	// nil, all headers are recorded.
+	RecordHeaders []string
+
+	// Origin is the policy of the Origin header check. By default only the
+	// same origin is allowed and other origins are rejected with 403 status.
+	Origin OriginPolicy
+
+	// Authenticator, if set, authenticates the request. The principal is
+	// stored in Handshake.Principal. Headers are recorded for the
+	// Authenticator even if RecordRequest is not set, but only the ones
+	// listed in RecordHeaders if it is non-nil.
+	Authenticator Authenticator
+
+	// TrustedProxies lists IP addresses and CIDRs of proxies which
+	// X-Forwarded-For header is trusted. The client address taken from it
+	// is stored in Handshake.RemoteAddr.
+	TrustedProxies []string
 }
 
 // Upgrade zero-copy upgrades connection to WebSocket. It interprets given conn
@@ -419,7 +481,26 @@ type Upgrader struct {
 // malformed and usually connection should be closed.
 // Even when error is non-nil Upgrade will write appropriate response into
 // connection in compliance with RFC.
+//
+// Note that bytes sent by the client right after the request and caught
+// during buffered read of the request are dropped. Use UpgradeBuffered() to
+// serve clients which do not wait for the response before sending frames.
 func (u Upgrader) Upgrade(conn io.ReadWriter) (hs Handshake, err error) {
+	br, hs, err := u.UpgradeBuffered(conn)
+	if br != nil {
+		pbufio.PutReader(br)
+	}
+	return hs, err
+}
+
+// UpgradeBuffered is like Upgrade() but it also returns bytes which could be
+// sent by the client right after the request and be caught during buffered
+// read of the request.
+//
+// If br is non-nil, the frames must be read from br until it has buffered
+// bytes and only then from conn. The br could be returned to the inner reuse
+// pool with PutReader() after use.
+func (u Upgrader) UpgradeBuffered(conn io.ReadWriter) (br *bufio.Reader, hs Handshake, err error) {
 	// headerSeen constants helps to report whether or not some header was seen
 	// during reading request bytes.
 	const (
@@ -441,32 +522,38 @@ func (u Upgrader) Upgrade(conn io.ReadWriter) (hs Handshake, err error) {
 
 	// Prepare I/O buffers.
 	// TODO(gobwas): make it configurable.
-	br := pbufio.GetReader(conn,
+	br = pbufio.GetReader(conn,
 		nonZero(u.ReadBufferSize, DefaultServerReadBufferSize),
 	)
 	bw := pbufio.GetWriter(conn,
 		nonZero(u.WriteBufferSize, DefaultServerWriteBufferSize),
 	)
 	defer func() {
-		pbufio.PutReader(br)
 		pbufio.PutWriter(bw)
+		if br.Buffered() == 0 || err != nil {
+			pbufio.PutReader(br)
+			br = nil
+		}
 	}()
 
 	// Read HTTP request line like "GET /ws HTTP/1.1".
 	rl, err := readLine(br)
 	if err != nil {
-		return hs, err
+		return br, hs, err
 	}
 	// Parse request line data like HTTP version, uri and method.
 	req, err := httpParseRequestLine(rl)
 	if err != nil {
-		return hs, err
+		return br, hs, err
 	}
 
 	// Prepare stack-based handshake header list.
 	header := handshakeHeader{
 		0: u.Header,
 	}
+	if u.RecordRequest || u.Authenticator != nil {
+		hs.Request = newHandshakeRequest(req.method, req.uri, req.major, req.minor)
+	}
 
 	// Parse and check HTTP request.
 	// As RFC6455 says:
@@ -505,12 +592,19 @@ func (u Upgrader) Upgrade(conn io.ReadWriter) (hs Handshake, err error) {
 		// bit on.
 		headerSeen byte
 
+		// origin and host are kept for the origin check.
+		origin, host string
+
+		// forwarded is the X-Forwarded-For header value kept if
+		// TrustedProxies are set.
+		forwarded string
+
 		nonce = make([]byte, nonceSize)
 	)
 	for err == nil {
 		line, e := readLine(br)
 		if e != nil {
-			return hs, e
+			return br, hs, e
 		}
 		if len(line) == 0 {
 			// Blank line, no more lines to read.
@@ -522,10 +616,20 @@ func (u Upgrader) Upgrade(conn io.ReadWriter) (hs Handshake, err error) {
 			err = ErrMalformedRequest
 			break
 		}
+		if r := hs.Request; r != nil {
+			if btsToString(k) == headerHostCanonical {
+				r.Host = string(v)
+			} else {
+				recordHeader(r.Header, u.RecordHeaders, string(k), string(v))
+			}
+		}
 
 		switch btsToString(k) {
 		case headerHostCanonical:
 			headerSeen |= headerSeenHost
+			if !u.Origin.AllowAll {
+				host = string(v)
+			}
 			if onHost := u.OnHost; onHost != nil {
 				err = onHost(v)
 			}
@@ -587,11 +691,34 @@ func (u Upgrader) Upgrade(conn io.ReadWriter) (hs Handshake, err error) {
 			}
 
 		default:
+			if btsToString(k) == headerOriginCanonical && !u.Origin.AllowAll {
+				origin = string(v)
+			}
+			if btsToString(k) == headerXForwardedForCanonical && len(u.TrustedProxies) != 0 {
+				forwarded = joinForwarded(forwarded, string(v))
+			}
 			if onHeader := u.OnHeader; onHeader != nil {
 				err = onHeader(k, v)
 			}
 		}
 	}
+	hs.RemoteAddr, hs.Proxy = remoteAddr(conn)
+	hs.Peer = peerCredMaybe(conn)
+	if len(u.TrustedProxies) != 0 {
+		hs.RemoteAddr = forwardedFor(hs.RemoteAddr, forwarded, u.TrustedProxies)
+	}
+	if hs.Request != nil {
+		hs.Request.RemoteAddr, hs.Request.Peer = hs.RemoteAddr, hs.Peer
+	}
+	if err == nil && headerSeen == headerSeenAll {
+		err = u.Origin.check(origin, host)
+	}
+	if err == nil && headerSeen == headerSeenAll && u.Authenticator != nil {
+		hs.Principal, err = authenticate(u.Authenticator, hs.Request)
+	}
+	if !u.RecordRequest {
+		hs.Request = nil
+	}
 	switch {
 	case err == nil && headerSeen != headerSeenAll:
 		switch {
@@ -644,13 +771,13 @@ func (u Upgrader) Upgrade(conn io.ReadWriter) (hs Handshake, err error) {
 		httpWriteResponseError(bw, err, code, header.WriteTo)
 		// Do not store Flush() error to not override already existing one.
 		_ = bw.Flush()
-		return hs, err
+		return br, hs, err
 	}
 
 	httpWriteResponseUpgrade(bw, nonce, hs, header.WriteTo)
 	err = bw.Flush()
 
-	return hs, err
+	return br, hs, err
 }
 
 type handshakeHeader [2]HandshakeHeader
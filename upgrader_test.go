@@ -493,6 +493,56 @@ func TestUpgrader(t *testing.T) {
 	}
 }
 
+func TestUpgradeBuffered(t *testing.T) {
+	req := mustMakeRequest("GET", "ws://example.org/", http.Header{
+		headerUpgrade:    []string{"websocket"},
+		headerConnection: []string{"Upgrade"},
+		headerSecVersion: []string{"13"},
+		headerSecKey:     []string{string(mustMakeNonce())},
+	})
+	frame := MustCompileFrame(MaskFrame(NewTextFrame([]byte("hello"))))
+
+	for _, test := range []struct {
+		name  string
+		extra []byte
+	}{
+		{"pipelined frame", frame},
+		{"no extra bytes", nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			in := append(dumpRequest(req), test.extra...)
+			var out bytes.Buffer
+			conn := struct {
+				io.Reader
+				io.Writer
+			}{bytes.NewReader(in), &out}
+
+			br, _, err := Upgrader{}.UpgradeBuffered(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.extra == nil {
+				if br != nil {
+					t.Fatalf("unexpected reader with %d buffered bytes", br.Buffered())
+				}
+				return
+			}
+			if br == nil {
+				t.Fatal("over-read bytes are lost")
+			}
+			defer PutReader(br)
+
+			act, err := io.ReadAll(br)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(act, test.extra) {
+				t.Errorf("unexpected over-read bytes: %q; want %q", act, test.extra)
+			}
+		})
+	}
+}
+
 func BenchmarkHTTPUpgrader(b *testing.B) {
 	for _, bench := range upgradeCases {
 		bench.req.Header.Set(headerSecKey, string(bench.nonce[:]))
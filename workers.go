@@ -0,0 +1,93 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package mogusocket
+
+import (
+	"errors"
+	"sync"
+)
+
+// DefaultPollWorkers is the size of the worker pool used by Server in event
+// loop mode when Server.Workers is zero.
+const DefaultPollWorkers = 256
+
+// ErrWorkerPoolClosed is returned by WorkerPool.Schedule() after Close().
+var ErrWorkerPoolClosed = errors.New("worker pool closed")
+
+// WorkerPool is a fixed size pool of goroutines. Goroutines are spawned
+// lazily and are reused for next tasks once they finish the current one.
+//
+// Tasks scheduled while all workers are busy are queued, so Schedule never
+// blocks. It could be called from the Poller callback.
+type WorkerPool struct {
+	size int
+
+	mu      sync.Mutex
+	cond    sync.Cond
+	queue   []func()
+	running int
+	idle    int
+	closed  bool
+}
+
+// NewWorkerPool creates new WorkerPool which runs at most size tasks
+// concurrently. If size is less than 1 then DefaultPollWorkers is used.
+func NewWorkerPool(size int) *WorkerPool {
+	if size < 1 {
+		size = DefaultPollWorkers
+	}
+	p := &WorkerPool{size: size}
+	p.cond.L = &p.mu
+	return p
+}
+
+// Schedule runs task in one of the pool goroutines. If there is no free
+// worker, task is queued and run in order once a worker is free.
+func (p *WorkerPool) Schedule(task func()) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrWorkerPoolClosed
+	}
+	p.queue = append(p.queue, task)
+	if p.idle > 0 {
+		p.cond.Signal()
+	} else if p.running < p.size {
+		p.running++
+		go p.worker()
+	}
+	return nil
+}
+
+// Close makes all workers exit once the queued tasks are done. Tasks which
+// are already running are not interrupted.
+func (p *WorkerPool) Close() {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	p.cond.Broadcast()
+}
+
+func (p *WorkerPool) worker() {
+	for {
+		p.mu.Lock()
+		for len(p.queue) == 0 && !p.closed {
+			p.idle++
+			p.cond.Wait()
+			p.idle--
+		}
+		if len(p.queue) == 0 {
+			p.running--
+			p.mu.Unlock()
+			return
+		}
+		task := p.queue[0]
+		p.queue[0] = nil
+		p.queue = p.queue[1:]
+		p.mu.Unlock()
+
+		task()
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2023 @moguf.com All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file
+
+package mogusocket
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestWorkerPool(t *testing.T) {
+	const (
+		size  = 4
+		tasks = 100
+	)
+	p := NewWorkerPool(size)
+	defer p.Close()
+
+	var (
+		wg      sync.WaitGroup
+		running int32
+		peak    int32
+	)
+	wg.Add(tasks)
+	for i := 0; i < tasks; i++ {
+		err := p.Schedule(func() {
+			defer wg.Done()
+			n := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&peak)
+				if n <= m || atomic.CompareAndSwapInt32(&peak, m, n) {
+					break
+				}
+			}
+			atomic.AddInt32(&running, -1)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+	if peak > size {
+		t.Errorf("%d tasks were running concurrently; want at most %d", peak, size)
+	}
+
+	p.Close()
+	if err := p.Schedule(func() {}); err != ErrWorkerPoolClosed {
+		t.Errorf("unexpected error: %v; want %v", err, ErrWorkerPoolClosed)
+	}
+}
+
+func TestWorkerPoolScheduleNoBlock(t *testing.T) {
+	p := NewWorkerPool(1)
+	defer p.Close()
+
+	release := make(chan struct{})
+	done := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		i := i
+		// Tasks are queued while the only worker is busy.
+		err := p.Schedule(func() {
+			<-release
+			done <- i
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(release)
+	for exp := 0; exp < 3; exp++ {
+		if act := <-done; act != exp {
+			t.Errorf("task %d is run; want %d", act, exp)
+		}
+	}
+}